@@ -0,0 +1,102 @@
+package backup
+
+import (
+	"errors"
+	"os"
+)
+
+const (
+	suffix        = ".spegel-backup"
+	missingSuffix = ".spegel-missing"
+)
+
+// Path returns the path of the backup for the file.
+func Path(path string) string {
+	return path + suffix
+}
+
+// missingPath returns the path of the marker recording that the file did not exist before it was backed up.
+func missingPath(path string) string {
+	return path + missingSuffix
+}
+
+// File backs up the file unless a backup already exists, so that the backup always contains the original file.
+// A missing file is recorded with a marker which removes the file when restored.
+func File(path string) error {
+	for _, p := range []string{Path(path), missingPath(path)} {
+		_, err := os.Stat(p)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+	fi, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return os.WriteFile(missingPath(path), nil, 0o644)
+	}
+	if err != nil {
+		return err
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(Path(path), b, fi.Mode().Perm())
+}
+
+// Original returns the content of the file before it was backed up, or the current content if it has not been backed up.
+// False is returned if the original file does not exist.
+func Original(path string) ([]byte, bool, error) {
+	_, err := os.Stat(missingPath(path))
+	if err == nil {
+		return nil, false, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, false, err
+	}
+	b, err := os.ReadFile(Path(path))
+	if err == nil {
+		return b, true, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, false, err
+	}
+	b, err = os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	return b, true, nil
+}
+
+// Restore replaces the file with its backup and removes the backup.
+// False is returned if no backup exists.
+func Restore(path string) (bool, error) {
+	_, err := os.Stat(missingPath(path))
+	if err == nil {
+		err := os.Remove(path)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return false, err
+		}
+		err = os.Remove(missingPath(path))
+		if err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+	err = os.Rename(Path(path), path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,97 @@
+package backup
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-openapi/testify/v2/require"
+)
+
+func TestBackupExistingFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "daemon.json")
+	err := os.WriteFile(path, []byte("original"), 0o600)
+	require.NoError(t, err)
+
+	err = File(path)
+	require.NoError(t, err)
+	err = os.WriteFile(path, []byte("modified"), 0o600)
+	require.NoError(t, err)
+	// A second backup should keep the original content.
+	err = File(path)
+	require.NoError(t, err)
+	b, ok, err := Original(path)
+	require.NoError(t, err)
+	require.TrueT(t, ok)
+	require.EqualT(t, "original", string(b))
+
+	ok, err = Restore(path)
+	require.NoError(t, err)
+	require.TrueT(t, ok)
+	b, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.EqualT(t, "original", string(b))
+	fi, err := os.Stat(path)
+	require.NoError(t, err)
+	require.EqualT(t, os.FileMode(0o600), fi.Mode().Perm())
+	_, err = os.Stat(Path(path))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	ok, err = Restore(path)
+	require.NoError(t, err)
+	require.FalseT(t, ok)
+}
+
+func TestBackupMissingFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "daemon.json")
+	b, ok, err := Original(path)
+	require.NoError(t, err)
+	require.FalseT(t, ok)
+	require.Empty(t, b)
+
+	err = File(path)
+	require.NoError(t, err)
+	err = os.WriteFile(path, []byte("modified"), 0o644)
+	require.NoError(t, err)
+	_, ok, err = Original(path)
+	require.NoError(t, err)
+	require.FalseT(t, ok)
+
+	ok, err = Restore(path)
+	require.NoError(t, err)
+	require.TrueT(t, ok)
+	_, err = os.Stat(path)
+	require.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(Path(path))
+	require.ErrorIs(t, err, os.ErrNotExist)
+	_, err = os.Stat(missingPath(path))
+	require.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestBackupEmptyFile(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "daemon.json")
+	err := os.WriteFile(path, nil, 0o644)
+	require.NoError(t, err)
+
+	err = File(path)
+	require.NoError(t, err)
+	err = os.WriteFile(path, []byte("modified"), 0o644)
+	require.NoError(t, err)
+	b, ok, err := Original(path)
+	require.NoError(t, err)
+	require.TrueT(t, ok)
+	require.Empty(t, b)
+
+	ok, err = Restore(path)
+	require.NoError(t, err)
+	require.TrueT(t, ok)
+	b, err = os.ReadFile(path)
+	require.NoError(t, err)
+	require.Empty(t, b)
+}
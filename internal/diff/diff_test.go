@@ -0,0 +1,72 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/go-openapi/testify/v2/require"
+)
+
+func TestUnified(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		oldName    string
+		newName    string
+		oldContent string
+		newContent string
+		expected   string
+	}{
+		{
+			name:       "equal",
+			oldName:    "a/foo",
+			newName:    "b/foo",
+			oldContent: "foo\nbar\n",
+			newContent: "foo\nbar\n",
+			expected:   "",
+		},
+		{
+			name:       "created",
+			newName:    "b/foo",
+			newContent: "foo\nbar\n",
+			expected:   "--- /dev/null\n+++ b/foo\n@@ -0,0 +1,2 @@\n+foo\n+bar\n",
+		},
+		{
+			name:       "removed",
+			oldName:    "a/foo",
+			oldContent: "foo",
+			expected:   "--- a/foo\n+++ /dev/null\n@@ -1 +0,0 @@\n-foo\n\\ No newline at end of file\n",
+		},
+		{
+			name:       "changed line with context",
+			oldName:    "a/foo",
+			newName:    "b/foo",
+			oldContent: "1\n2\n3\n4\n5\n6\n7\n8\n9\n",
+			newContent: "1\n2\n3\n4\nfive\n6\n7\n8\n9\n",
+			expected:   "--- a/foo\n+++ b/foo\n@@ -2,7 +2,7 @@\n 2\n 3\n 4\n-5\n+five\n 6\n 7\n 8\n",
+		},
+		{
+			name:       "separate hunks",
+			oldName:    "a/foo",
+			newName:    "b/foo",
+			oldContent: "1\n2\n3\n4\n5\n6\n7\n8\n9\n10\n",
+			newContent: "one\n2\n3\n4\n5\n6\n7\n8\n9\nten\neleven\n",
+			expected:   "--- a/foo\n+++ b/foo\n@@ -1,4 +1,4 @@\n-1\n+one\n 2\n 3\n 4\n@@ -7,4 +7,5 @@\n 7\n 8\n 9\n-10\n+ten\n+eleven\n",
+		},
+		{
+			name:       "missing trailing newline",
+			oldName:    "a/foo",
+			newName:    "b/foo",
+			oldContent: "foo\nbar",
+			newContent: "foo\nbar\n",
+			expected:   "--- a/foo\n+++ b/foo\n@@ -1,2 +1,2 @@\n foo\n-bar\n\\ No newline at end of file\n+bar\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.EqualT(t, tt.expected, Unified(tt.oldName, tt.newName, tt.oldContent, tt.newContent))
+		})
+	}
+}
@@ -12,10 +12,12 @@ import (
 	"os"
 	"os/signal"
 	"regexp"
+	"strings"
 	"syscall"
 	"time"
 
 	"github.com/alexflint/go-arg"
+	"github.com/containerd/platforms"
 	"github.com/go-logr/logr"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
@@ -26,11 +28,20 @@ import (
 	"github.com/spegel-org/spegel/pkg/httpx"
 	"github.com/spegel-org/spegel/pkg/metrics"
 	"github.com/spegel-org/spegel/pkg/oci"
+	"github.com/spegel-org/spegel/pkg/oci/archive"
+	"github.com/spegel-org/spegel/pkg/oci/composite"
 	"github.com/spegel-org/spegel/pkg/oci/containerd"
+	"github.com/spegel-org/spegel/pkg/oci/containerstorage"
+	"github.com/spegel-org/spegel/pkg/oci/crio"
+	"github.com/spegel-org/spegel/pkg/oci/docker"
+	"github.com/spegel-org/spegel/pkg/oci/layout"
+	"github.com/spegel-org/spegel/pkg/oci/signature"
 	"github.com/spegel-org/spegel/pkg/preflight"
 	"github.com/spegel-org/spegel/pkg/registry"
 	"github.com/spegel-org/spegel/pkg/routing"
 	"github.com/spegel-org/spegel/pkg/routing/libp2p"
+	"github.com/spegel-org/spegel/pkg/store"
+	"github.com/spegel-org/spegel/pkg/store/scrub"
 	"github.com/spegel-org/spegel/pkg/web"
 )
 
@@ -41,9 +52,14 @@ type VersionCmd struct {
 type ConfigurationCmd struct {
 	ContainerdRegistryConfigPath string   `arg:"--containerd-registry-config-path,env:CONTAINERD_REGISTRY_CONFIG_PATH" default:"/etc/containerd/certs.d" help:"Directory where mirror configuration is written."`
 	MirroredRegistries           []string `arg:"--mirrored-registries,env:MIRRORED_REGISTRIES" help:"Registries that are configured to be mirrored, if slice is empty all registires are mirrored."`
-	MirrorTargets                []string `arg:"--mirror-targets,env:MIRROR_TARGETS,required" help:"registries that are configured to act as mirrors."`
+	MirrorTargets                []string `arg:"--mirror-targets,env:MIRROR_TARGETS" help:"registries that are configured to act as mirrors."`
+	MirrorConfigPath             string   `arg:"--mirror-config-path,env:MIRROR_CONFIG_PATH" help:"Path to a YAML file describing the mirror configuration of each registry, replaces mirrored registries, mirror targets and resolve tags."`
 	ResolveTags                  bool     `arg:"--resolve-tags,env:RESOLVE_TAGS" default:"true" help:"When true Spegel will resolve tags to digests."`
 	PrependExisting              bool     `arg:"--prepend-existing,env:PREPEND_EXISTING" default:"false" help:"When true existing mirror configuration will be kept and Spegel will prepend it's configuration."`
+	Runtimes                     []string `arg:"--runtimes,env:RUNTIMES" help:"Container runtimes to write mirror configuration for, defaults to containerd. Values should be containerd, docker or crio."`
+	DockerDaemonConfigPath       string   `arg:"--docker-daemon-config-path,env:DOCKER_DAEMON_CONFIG_PATH" default:"/etc/docker/daemon.json" help:"Path to the Docker daemon configuration where registry mirrors are written."`
+	CrioRegistriesConfigPath     string   `arg:"--crio-registries-config-path,env:CRIO_REGISTRIES_CONFIG_PATH" default:"/etc/containers/registries.conf.d" help:"Directory where the CRI-O registries configuration drop-in is written."`
+	DryRun                       bool     `arg:"--dry-run,env:DRY_RUN" default:"false" help:"When true the configuration is not written, instead a diff against the current configuration is printed and the command fails if they differ."`
 }
 
 type BootstrapConfig struct {
@@ -57,23 +73,47 @@ type BootstrapConfig struct {
 
 type RegistryCmd struct {
 	BootstrapConfig
-	MetricsAddr           string           `arg:"--metrics-addr,env:METRICS_ADDR" default:":9090" help:"address to serve metrics."`
-	ContainerdSock        string           `arg:"--containerd-sock,env:CONTAINERD_SOCK" default:"/run/containerd/containerd.sock" help:"Endpoint of containerd service."`
-	ContainerdNamespace   string           `arg:"--containerd-namespace,env:CONTAINERD_NAMESPACE" default:"k8s.io" help:"Containerd namespace to fetch images from."`
-	ContainerdContentPath string           `arg:"--containerd-content-path,env:CONTAINERD_CONTENT_PATH" default:"/var/lib/containerd/io.containerd.content.v1.content" help:"Path to Containerd content store."`
-	DataDir               string           `arg:"--data-dir,env:DATA_DIR" default:"" help:"Directory where data is persisted."`
-	RouterAddr            string           `arg:"--router-addr,env:ROUTER_ADDR" default:":5001" help:"address to serve router."`
-	RegistryAddr          string           `arg:"--registry-addr,env:REGISTRY_ADDR" default:":5000" help:"address to server image registry."`
-	MirroredRegistries    []string         `arg:"--mirrored-registries,env:MIRRORED_REGISTRIES" help:"Registries that are configured to be mirrored, if slice is empty all registries are mirrored."`
-	RegistryFilters       []*regexp.Regexp `arg:"--registry-filters,env:REGISTRY_FILTERS" help:"Regular expressions to filter out tags/registries, if slice is empty all registries/tags are resolved."`
-	MirrorResolveTimeout  time.Duration    `arg:"--mirror-resolve-timeout,env:MIRROR_RESOLVE_TIMEOUT" default:"20ms" help:"Max duration spent finding a mirror."`
-	MirrorResolveRetries  int              `arg:"--mirror-resolve-retries,env:MIRROR_RESOLVE_RETRIES" default:"3" help:"Max amount of mirrors to attempt."`
-	DebugWebEnabled       bool             `arg:"--debug-web-enabled,env:DEBUG_WEB_ENABLED" default:"true" help:"When true enables debug web page."`
+	MetricsAddr                    string           `arg:"--metrics-addr,env:METRICS_ADDR" default:":9090" help:"address to serve metrics."`
+	Stores                         []string         `arg:"--store,env:STORE" help:"Store to serve content from, multiple stores are merged in priority order. Defaults to containerd. Values should be containerd, oci-layout or containers-storage."`
+	OCILayoutPath                  string           `arg:"--oci-layout-path,env:OCI_LAYOUT_PATH" help:"Path to the OCI image layout directory used by the oci-layout store."`
+	StoragePath                    string           `arg:"--storage-path,env:STORAGE_PATH" default:"/var/lib/containers/storage" help:"Path to the containers storage root used by the containers-storage store."`
+	StorageDriver                  string           `arg:"--storage-driver,env:STORAGE_DRIVER" default:"overlay" help:"Graph driver of the containers storage used by the containers-storage store."`
+	ContainerdSock                 string           `arg:"--containerd-sock,env:CONTAINERD_SOCK" default:"/run/containerd/containerd.sock" help:"Endpoint of containerd service."`
+	ContainerdNamespace            string           `arg:"--containerd-namespace,env:CONTAINERD_NAMESPACE" default:"k8s.io" help:"Containerd namespace to fetch images from."`
+	ContainerdAdditionalNamespaces []string         `arg:"--containerd-additional-namespaces,env:CONTAINERD_ADDITIONAL_NAMESPACES" help:"Containerd namespaces to fetch images from in addition to the containerd namespace."`
+	ContainerdContentPath          string           `arg:"--containerd-content-path,env:CONTAINERD_CONTENT_PATH" default:"/var/lib/containerd/io.containerd.content.v1.content" help:"Path to Containerd content store."`
+	ContainerdRetentionGrace       time.Duration    `arg:"--containerd-retention-grace,env:CONTAINERD_RETENTION_GRACE" default:"0s" help:"Duration served content is protected from containerd garbage collection after it has been read. Zero only protects content while it is read."`
+	DataDir                        string           `arg:"--data-dir,env:DATA_DIR" default:"" help:"Directory where data is persisted."`
+	DockerConfigPath               string           `arg:"--docker-config-path,env:DOCKER_CONFIG_PATH" default:"" help:"Path to a Docker config.json with registry credentials used by the debug web, leave empty to not use credentials."`
+	ScrubInterval                  time.Duration    `arg:"--scrub-interval,env:SCRUB_INTERVAL" default:"0s" help:"Interval between verifying local blobs against their digest, blobs which do not match are no longer served. Zero disables scrubbing."`
+	RouterAddr                     string           `arg:"--router-addr,env:ROUTER_ADDR" default:":5001" help:"address to serve router."`
+	Network                        string           `arg:"--network,env:NETWORK" default:"" help:"Name of the network scoping peer discovery and advertised keys, peers only exchange content within the same network."`
+	RegistryAddr                   string           `arg:"--registry-addr,env:REGISTRY_ADDR" default:":5000" help:"address to server image registry."`
+	MirroredRegistries             []string         `arg:"--mirrored-registries,env:MIRRORED_REGISTRIES" help:"Registries that are configured to be mirrored, if slice is empty all registries are mirrored."`
+	RegistryFilters                []*regexp.Regexp `arg:"--registry-filters,env:REGISTRY_FILTERS" help:"Regular expressions to filter out tags/registries, if slice is empty all registries/tags are resolved."`
+	RegistryAliases                []string         `arg:"--registry-aliases,env:REGISTRY_ALIASES" help:"Registries that are equivalent in the format alias=canonical, content pulled under any name is served for all of them."`
+	RegistryFilterPath             string           `arg:"--registry-filter-path,env:REGISTRY_FILTER_PATH" help:"Path to a JSON file with allow and deny rule expressions deciding which images are mirrored."`
+	SignaturePolicyPath            string           `arg:"--signature-policy-path,env:SIGNATURE_POLICY_PATH" help:"Path to a JSON signature policy, tags in repositories matching a rule are only served if they resolve to a signed manifest."`
+	MirrorResolveTimeout           time.Duration    `arg:"--mirror-resolve-timeout,env:MIRROR_RESOLVE_TIMEOUT" default:"20ms" help:"Max duration spent finding a mirror."`
+	MirrorResolveRetries           int              `arg:"--mirror-resolve-retries,env:MIRROR_RESOLVE_RETRIES" default:"3" help:"Max amount of mirrors to attempt."`
+	NegativeLookupTTL              time.Duration    `arg:"--negative-lookup-ttl,env:NEGATIVE_LOOKUP_TTL" default:"10s" help:"Duration to cache lookups that found no peers, zero disables the cache."`
+	StreamMode                     string           `arg:"--stream-mode,env:STREAM_MODE" default:"fallback" help:"How libp2p streams are used to fetch content from peers. Value should be disabled, fallback, or prefer."`
+	AdvertiseImagesOnly            bool             `arg:"--advertise-images-only,env:ADVERTISE_IMAGES_ONLY" default:"false" help:"When true only tags and manifests are advertised, blobs are resolved through peers holding the same repository. All peers should use the same value."`
+	MirrorReconcileInterval        time.Duration    `arg:"--mirror-reconcile-interval,env:MIRROR_RECONCILE_INTERVAL" default:"0s" help:"Interval between checking that the mirror configuration written by the configuration command has not been modified, modified configuration is rewritten. Zero disables reconciliation."`
+	ContainerdRegistryConfigPath   string           `arg:"--containerd-registry-config-path,env:CONTAINERD_REGISTRY_CONFIG_PATH" default:"/etc/containerd/certs.d" help:"Directory where mirror configuration is written."`
+	MirrorTargets                  []string         `arg:"--mirror-targets,env:MIRROR_TARGETS" help:"registries that are configured to act as mirrors, used when reconciling mirror configuration."`
+	MirrorConfigPath               string           `arg:"--mirror-config-path,env:MIRROR_CONFIG_PATH" help:"Path to a YAML file describing the mirror configuration of each registry, used when reconciling mirror configuration."`
+	ResolveTags                    bool             `arg:"--resolve-tags,env:RESOLVE_TAGS" default:"true" help:"When true Spegel will resolve tags to digests."`
+	PrependExisting                bool             `arg:"--prepend-existing,env:PREPEND_EXISTING" default:"false" help:"When true existing mirror configuration will be kept and Spegel will prepend it's configuration."`
+	DebugWebEnabled                bool             `arg:"--debug-web-enabled,env:DEBUG_WEB_ENABLED" default:"true" help:"When true enables debug web page."`
 }
 
 type CleanupCmd struct {
-	Addr                         string `arg:"--addr,required,env:ADDR" help:"address to run readiness probe on."`
-	ContainerdRegistryConfigPath string `arg:"--containerd-registry-config-path,env:CONTAINERD_REGISTRY_CONFIG_PATH" default:"/etc/containerd/certs.d" help:"Directory where mirror configuration is written."`
+	Addr                         string   `arg:"--addr,required,env:ADDR" help:"address to run readiness probe on."`
+	ContainerdRegistryConfigPath string   `arg:"--containerd-registry-config-path,env:CONTAINERD_REGISTRY_CONFIG_PATH" default:"/etc/containerd/certs.d" help:"Directory where mirror configuration is written."`
+	Runtimes                     []string `arg:"--runtimes,env:RUNTIMES" help:"Container runtimes to clean up mirror configuration for, containerd is always cleaned up. Values should be containerd, docker or crio."`
+	DockerDaemonConfigPath       string   `arg:"--docker-daemon-config-path,env:DOCKER_DAEMON_CONFIG_PATH" default:"/etc/docker/daemon.json" help:"Path to the Docker daemon configuration where registry mirrors are written."`
+	CrioRegistriesConfigPath     string   `arg:"--crio-registries-config-path,env:CRIO_REGISTRIES_CONFIG_PATH" default:"/etc/containers/registries.conf.d" help:"Directory where the CRI-O registries configuration drop-in is written."`
 }
 
 type CleanupWaitCmd struct {
@@ -82,12 +122,22 @@ type CleanupWaitCmd struct {
 	Period        time.Duration `arg:"--period,env:PERIOD" default:"2s" help:"address to run readiness probe on."`
 }
 
+type PullCmd struct {
+	Image            string  `arg:"positional,required" help:"Image to pull."`
+	Output           string  `arg:"--output,required" help:"Path to write the image to."`
+	Format           string  `arg:"--format" default:"oci-layout" help:"Format to write the image in. Value should be oci-layout, oci-archive, or docker-archive."`
+	Mirror           url.URL `arg:"--mirror,env:MIRROR" default:"http://127.0.0.1:5000" help:"Address of the Spegel registry to pull through."`
+	Platform         string  `arg:"--platform" help:"Platform to pull, defaults to the current platform."`
+	DockerConfigPath string  `arg:"--docker-config-path,env:DOCKER_CONFIG_PATH" help:"Path to a Docker config.json with registry credentials, defaults to the Docker CLI config. Credentials are only sent when the mirror is the registry itself over HTTPS."`
+}
+
 type Arguments struct {
 	Version       *VersionCmd       `arg:"subcommand:version"`
 	Configuration *ConfigurationCmd `arg:"subcommand:configuration"`
 	Registry      *RegistryCmd      `arg:"subcommand:registry"`
 	Cleanup       *CleanupCmd       `arg:"subcommand:cleanup"`
 	CleanupWait   *CleanupWaitCmd   `arg:"subcommand:cleanup-wait"`
+	Pull          *PullCmd          `arg:"subcommand:pull"`
 	LogLevel      slog.Level        `arg:"--log-level,env:LOG_LEVEL" default:"INFO" help:"Minimum log level to output. Value should be DEBUG, INFO, WARN, or ERROR."`
 }
 
@@ -125,6 +175,8 @@ func run(args *Arguments) error {
 			return cleanupCommand(ctx, args.Cleanup)
 		case args.CleanupWait != nil:
 			return cleanupWaitCommand(ctx, args.CleanupWait)
+		case args.Pull != nil:
+			return pullCommand(ctx, args.Pull)
 		default:
 			return errors.New("unknown subcommand")
 		}
@@ -152,18 +204,79 @@ func versionCommand(_ context.Context, args *VersionCmd) error {
 }
 
 func configurationCommand(ctx context.Context, args *ConfigurationCmd) error {
-	args.MirrorTargets[0] = httpx.EncapsulateIPv6Host(args.MirrorTargets[0])
-	userinfo, err := httpx.LoadUserinfo("/etc/secrets/basic-auth")
+	mirrorCfg, err := loadMirrorConfig(args.MirrorConfigPath, args.MirroredRegistries, args.MirrorTargets, args.ResolveTags)
 	if err != nil {
 		return err
 	}
-	err = containerd.AddMirrorConfiguration(ctx, args.ContainerdRegistryConfigPath, args.MirroredRegistries, args.MirrorTargets, args.ResolveTags, args.PrependExisting, userinfo)
+	userinfo, err := httpx.LoadUserinfo("/etc/secrets/basic-auth")
 	if err != nil {
 		return err
 	}
+	runtimes := args.Runtimes
+	if len(runtimes) == 0 {
+		runtimes = []string{"containerd"}
+	}
+	if args.DryRun {
+		sb := &strings.Builder{}
+		for _, runtime := range runtimes {
+			var diff string
+			switch runtime {
+			case "containerd":
+				diff, err = containerd.DiffMirrorConfiguration(ctx, args.ContainerdRegistryConfigPath, mirrorCfg, args.PrependExisting, userinfo)
+			case "docker":
+				diff, err = docker.DiffMirrorConfiguration(ctx, args.DockerDaemonConfigPath, mirrorCfg, args.PrependExisting, userinfo)
+			case "crio":
+				diff, err = crio.DiffMirrorConfiguration(ctx, args.CrioRegistriesConfigPath, mirrorCfg, userinfo)
+			default:
+				return fmt.Errorf("unknown runtime %s", runtime)
+			}
+			if err != nil {
+				return err
+			}
+			sb.WriteString(diff)
+		}
+		if sb.Len() > 0 {
+			_, err = fmt.Fprint(os.Stdout, sb.String())
+			if err != nil {
+				return err
+			}
+			return errors.New("mirror configuration has drifted from the current configuration")
+		}
+		return nil
+	}
+	for _, runtime := range runtimes {
+		switch runtime {
+		case "containerd":
+			err = containerd.WriteMirrorConfiguration(ctx, args.ContainerdRegistryConfigPath, mirrorCfg, args.PrependExisting, userinfo)
+		case "docker":
+			err = docker.WriteMirrorConfiguration(ctx, args.DockerDaemonConfigPath, mirrorCfg, args.PrependExisting, userinfo)
+		case "crio":
+			err = crio.WriteMirrorConfiguration(ctx, args.CrioRegistriesConfigPath, mirrorCfg, userinfo)
+		default:
+			return fmt.Errorf("unknown runtime %s", runtime)
+		}
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
+func loadMirrorConfig(mirrorConfigPath string, mirroredRegistries, mirrorTargets []string, resolveTags bool) (containerd.MirrorConfig, error) {
+	switch {
+	case mirrorConfigPath != "":
+		if len(mirroredRegistries) > 0 || len(mirrorTargets) > 0 {
+			return containerd.MirrorConfig{}, errors.New("mirror config path cannot be combined with mirrored registries or mirror targets")
+		}
+		return containerd.LoadMirrorConfig(mirrorConfigPath)
+	case len(mirrorTargets) > 0:
+		mirrorTargets[0] = httpx.EncapsulateIPv6Host(mirrorTargets[0])
+		return containerd.NewMirrorConfig(mirroredRegistries, mirrorTargets, resolveTags), nil
+	default:
+		return containerd.MirrorConfig{}, errors.New("either mirror targets or mirror config path has to be set")
+	}
+}
+
 func registryCommand(ctx context.Context, args *RegistryCmd) error {
 	log := logr.FromContextOrDiscard(ctx)
 	group := errgroup.WithContext(ctx)
@@ -182,6 +295,11 @@ func registryCommand(ctx context.Context, args *RegistryCmd) error {
 		return err
 	}
 
+	aliases, err := oci.ParseRegistryAliases(args.RegistryAliases)
+	if err != nil {
+		return err
+	}
+
 	// OCI Filters.
 	filters := []oci.Filter{}
 	regFilter, err := oci.FilterForMirroredRegistries(args.MirroredRegistries)
@@ -194,13 +312,61 @@ func registryCommand(ctx context.Context, args *RegistryCmd) error {
 	for _, r := range args.RegistryFilters {
 		filters = append(filters, oci.RegexFilter{Regex: r})
 	}
+	if args.RegistryFilterPath != "" {
+		exprFilter, err := oci.LoadExpressionFilter(args.RegistryFilterPath)
+		if err != nil {
+			return err
+		}
+		filters = append(filters, exprFilter)
+	}
 
-	// Containerd store.
-	ctrd, err := containerd.NewContainerd(ctx, args.ContainerdSock, args.ContainerdNamespace, containerd.WithContentPath(args.ContainerdContentPath), containerd.WithFilters(filters))
-	if err != nil {
-		return err
+	// Content store.
+	storeNames := args.Stores
+	if len(storeNames) == 0 {
+		storeNames = []string{"containerd"}
+	}
+	stores := []composite.Store{}
+	readinessChecks := []store.ReadinessChecker{}
+	for _, storeName := range storeNames {
+		switch storeName {
+		case "containerd":
+			ctrd, err := containerd.NewContainerd(ctx, args.ContainerdSock, args.ContainerdNamespace, containerd.WithContentPath(args.ContainerdContentPath), containerd.WithDataDir(args.DataDir), containerd.WithFilters(filters), containerd.WithAdditionalNamespaces(args.ContainerdAdditionalNamespaces), containerd.WithRetentionGrace(args.ContainerdRetentionGrace))
+			if err != nil {
+				return err
+			}
+			defer ctrd.Close()
+			stores = append(stores, ctrd)
+			readinessChecks = append(readinessChecks, ctrd)
+		case "oci-layout":
+			ociLayout, err := layout.NewLayout(args.OCILayoutPath, layout.WithFilters(filters))
+			if err != nil {
+				return err
+			}
+			stores = append(stores, ociLayout)
+		case "containers-storage":
+			containerStorage, err := containerstorage.NewContainerStorage(args.StoragePath, containerstorage.WithDriver(args.StorageDriver), containerstorage.WithLayerCacheDir(args.DataDir), containerstorage.WithFilters(filters))
+			if err != nil {
+				return err
+			}
+			stores = append(stores, containerStorage)
+		default:
+			return fmt.Errorf("unknown store %s", storeName)
+		}
+	}
+	var contentStore composite.Store = stores[0]
+	if len(stores) > 1 {
+		contentStore, err = composite.NewComposite(stores...)
+		if err != nil {
+			return err
+		}
+	}
+	var servedStore scrub.Store = contentStore
+	if args.ScrubInterval > 0 {
+		servedStore, err = scrub.NewScrubber(contentStore, scrub.WithInterval(args.ScrubInterval))
+		if err != nil {
+			return err
+		}
 	}
-	defer ctrd.Close()
 
 	// Routing and state tracking.
 	_, registryPort, err := net.SplitHostPort(args.RegistryAddr)
@@ -213,6 +379,8 @@ func registryCommand(ctx context.Context, args *RegistryCmd) error {
 	}
 	routerOpts := []libp2p.RouterOption{
 		libp2p.WithDataDir(args.DataDir),
+		libp2p.WithNegativeLookupTTL(args.NegativeLookupTTL),
+		libp2p.WithNetwork(args.Network),
 	}
 	router, err := libp2p.NewRouter(ctx, args.RouterAddr, bootstrapper, registryPort, routerOpts...)
 	if err != nil {
@@ -226,7 +394,7 @@ func registryCommand(ctx context.Context, args *RegistryCmd) error {
 		return nil
 	})
 	group.Go(func(ctx context.Context) error {
-		err := routing.Sync(ctx, router, ctrd)
+		err := routing.Sync(ctx, router, servedStore, routing.WithImagesOnly(args.AdvertiseImagesOnly), routing.WithRegistryAliases(aliases), routing.WithFilters(filters))
 		if err != nil && !errors.Is(err, context.Canceled) {
 			return err
 		}
@@ -244,8 +412,19 @@ func registryCommand(ctx context.Context, args *RegistryCmd) error {
 		registry.WithResolveTimeout(args.MirrorResolveTimeout),
 		registry.WithUserinfo(userinfo),
 		registry.WithOCIClient(ociClient),
+		registry.WithStreamTransport(router.Transport(), registry.StreamMode(args.StreamMode)),
+		registry.WithRepositoryLookup(args.AdvertiseImagesOnly),
+		registry.WithRegistryAliases(aliases),
+		registry.WithReadinessChecks(readinessChecks),
+	}
+	if args.SignaturePolicyPath != "" {
+		policy, err := signature.LoadPolicy(args.SignaturePolicyPath)
+		if err != nil {
+			return err
+		}
+		registryOpts = append(registryOpts, registry.WithSignaturePolicy(policy))
 	}
-	reg, err := registry.NewRegistry(ctrd, router, registryOpts...)
+	reg, err := registry.NewRegistry(servedStore, router, registryOpts...)
 	if err != nil {
 		return err
 	}
@@ -266,6 +445,45 @@ func registryCommand(ctx context.Context, args *RegistryCmd) error {
 		return regSrv.Shutdown(shutdownCtx)
 	})
 
+	// OCI registry served over libp2p streams.
+	if registry.StreamMode(args.StreamMode) != registry.StreamModeDisabled {
+		streamListener, err := router.Listen()
+		if err != nil {
+			return err
+		}
+		streamSrv := &http.Server{
+			Handler: reg.Handler(log),
+		}
+		group.Go(func(ctx context.Context) error {
+			if err := streamSrv.Serve(streamListener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				return err
+			}
+			return nil
+		})
+		group.Go(func(ctx context.Context) error {
+			<-ctx.Done()
+			shutdownCtx, shutdownCancel := signal.NotifyContext(context.WithoutCancel(ctx), syscall.SIGINT)
+			defer shutdownCancel()
+			return streamSrv.Shutdown(shutdownCtx)
+		})
+	}
+
+	// Mirror configuration reconciliation.
+	if args.MirrorReconcileInterval > 0 {
+		// Mirrored registries also filter served content so they are ignored when a mirror config file is used.
+		mirroredRegistries := args.MirroredRegistries
+		if args.MirrorConfigPath != "" {
+			mirroredRegistries = nil
+		}
+		mirrorCfg, err := loadMirrorConfig(args.MirrorConfigPath, mirroredRegistries, args.MirrorTargets, args.ResolveTags)
+		if err != nil {
+			return err
+		}
+		group.Go(func(ctx context.Context) error {
+			return containerd.ReconcileMirrorConfiguration(ctx, args.ContainerdRegistryConfigPath, mirrorCfg, args.PrependExisting, userinfo, args.MirrorReconcileInterval)
+		})
+	}
+
 	// Metrics, pprof, and debug web
 	metrics.Register()
 	mux := http.NewServeMux()
@@ -281,14 +499,26 @@ func registryCommand(ctx context.Context, args *RegistryCmd) error {
 	mux.Handle("/debug/pprof/block", pprof.Handler("block"))
 	mux.Handle("/debug/pprof/mutex", pprof.Handler("mutex"))
 	if args.DebugWebEnabled {
+		webOCIClient := ociClient
+		if args.DockerConfigPath != "" {
+			dockerConfig, err := oci.LoadDockerConfig(args.DockerConfigPath)
+			if err != nil {
+				return err
+			}
+			webOCIClient, err = oci.NewClient(oci.WithCredentials(dockerConfig))
+			if err != nil {
+				return err
+			}
+		}
 		webOpts := []web.WebOption{
-			web.WithOCIClient(ociClient),
+			web.WithOCIClient(webOCIClient),
+			web.WithRegistryAliases(aliases),
 		}
 		mirror := &url.URL{
 			Scheme: "http",
 			Host:   args.RegistryAddr,
 		}
-		web, err := web.NewWeb(router, ctrd, reg, mirror, webOpts...)
+		web, err := web.NewWeb(router, contentStore, reg, mirror, webOpts...)
 		if err != nil {
 			return err
 		}
@@ -320,6 +550,22 @@ func registryCommand(ctx context.Context, args *RegistryCmd) error {
 }
 
 func cleanupCommand(ctx context.Context, args *CleanupCmd) error {
+	// Containerd configuration is always cleaned up by the cleanup probe server.
+	for _, runtime := range args.Runtimes {
+		var err error
+		switch runtime {
+		case "containerd":
+		case "docker":
+			err = docker.CleanupMirrorConfiguration(ctx, args.DockerDaemonConfigPath)
+		case "crio":
+			err = crio.CleanupMirrorConfiguration(ctx, args.CrioRegistriesConfigPath)
+		default:
+			return fmt.Errorf("unknown runtime %s", runtime)
+		}
+		if err != nil {
+			return err
+		}
+	}
 	err := cleanup.Run(ctx, args.Addr, args.ContainerdRegistryConfigPath)
 	if err != nil {
 		return err
@@ -335,6 +581,73 @@ func cleanupWaitCommand(ctx context.Context, args *CleanupWaitCmd) error {
 	return nil
 }
 
+func pullCommand(ctx context.Context, args *PullCmd) (err error) {
+	img, err := oci.ParseImage(args.Image, oci.AllowTagOnly(), oci.AllowDefaults())
+	if err != nil {
+		return err
+	}
+	pullOpts := []oci.PullOption{
+		oci.WithPullMirror(&args.Mirror),
+	}
+	if args.Platform != "" {
+		platform, err := platforms.Parse(args.Platform)
+		if err != nil {
+			return err
+		}
+		pullOpts = append(pullOpts, oci.WithPullPlatform(platform))
+	}
+
+	var writer oci.PullWriter
+	switch args.Format {
+	case "oci-layout":
+		writer, err = layout.NewWriter(args.Output)
+		if err != nil {
+			return err
+		}
+	case string(archive.FormatOCI), string(archive.FormatDocker):
+		var file *os.File
+		file, err = os.Create(args.Output)
+		if err != nil {
+			return err
+		}
+		defer func() {
+			err = errors.Join(err, file.Close())
+			// Remove the incomplete archive so that a failed pull does not leave a truncated file.
+			if err != nil {
+				err = errors.Join(err, os.Remove(args.Output))
+			}
+		}()
+		writer, err = archive.NewWriter(file, archive.Format(args.Format))
+		if err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("unknown output format %s", args.Format)
+	}
+	pullOpts = append(pullOpts, oci.WithPullWriter(writer))
+
+	dockerConfigPath := args.DockerConfigPath
+	if dockerConfigPath == "" {
+		dockerConfigPath, err = oci.DefaultDockerConfigPath()
+		if err != nil {
+			return err
+		}
+	}
+	dockerConfig, err := oci.LoadDockerConfig(dockerConfigPath)
+	if err != nil {
+		return err
+	}
+	ociClient, err := oci.NewClient(oci.WithCredentials(dockerConfig))
+	if err != nil {
+		return err
+	}
+	_, err = ociClient.Pull(ctx, img, pullOpts...)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
 func getBootstrapper(cfg BootstrapConfig) (libp2p.Bootstrapper, error) { //nolint: ireturn // Return type can be different structs.
 	switch cfg.BootstrapKind {
 	case "dns":
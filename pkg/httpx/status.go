@@ -10,6 +10,7 @@ import (
 )
 
 type StatusError struct {
+	Header        http.Header
 	Message       string
 	ExpectedCodes []int
 	StatusCode    int
@@ -36,6 +37,7 @@ func CheckResponseStatus(resp *http.Response, expectedCodes ...int) error {
 	}
 	message, messageErr := getErrorMessage(resp)
 	statusErr := &StatusError{
+		Header:        resp.Header,
 		Message:       message,
 		ExpectedCodes: expectedCodes,
 		StatusCode:    resp.StatusCode,
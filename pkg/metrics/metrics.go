@@ -25,10 +25,38 @@ var (
 		Name: "spegel_mirror_last_success_timestamp_seconds",
 		Help: "The timestamp of the last successful mirror request.",
 	})
+	MirrorMissingTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "spegel_mirror_missing_total",
+		Help: "Total number of mirror requests for content that no peer in the cluster has.",
+	}, []string{"registry", "repository"})
 	ResolveDurHistogram = prometheus.NewHistogramVec(prometheus.HistogramOpts{
 		Name: "spegel_resolve_duration_seconds",
 		Help: "The duration for router to resolve a peer.",
 	}, []string{"router"})
+	ResolveNegativeCacheHitsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "spegel_resolve_negative_cache_hits_total",
+		Help: "Total number of lookups answered by the negative cache without resolving peers.",
+	}, []string{"router"})
+	IncompatiblePeersTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "spegel_incompatible_peers_total",
+		Help: "Total number of times a peer running an incompatible protocol version was encountered.",
+	}, []string{"transport", "version"})
+	ScrubbedBlobsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "spegel_scrubbed_blobs_total",
+		Help: "Total number of local blobs re-hashed by the scrubber.",
+	}, []string{"result"})
+	QuarantinedBlobs = prometheus.NewGauge(prometheus.GaugeOpts{
+		Name: "spegel_quarantined_blobs",
+		Help: "Number of local blobs quarantined because their content does not match their digest.",
+	})
+	SignatureVerificationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "spegel_signature_verifications_total",
+		Help: "Total number of tags verified against the signature policy.",
+	}, []string{"registry", "result"})
+	MirrorConfigurationCorrectionsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "spegel_mirror_configuration_corrections_total",
+		Help: "Total number of times modified mirror configuration was rewritten.",
+	}, []string{"registry"})
 	AdvertisedImageTags = prometheus.NewGaugeVec(prometheus.GaugeOpts{
 		Name: "spegel_advertised_image_tags",
 		Help: "Number of image tags advertised to be available.",
@@ -46,7 +74,14 @@ var (
 func Register() {
 	DefaultRegisterer.MustRegister(MirrorRequestsTotal)
 	DefaultRegisterer.MustRegister(MirrorLastSuccessTimestamp)
+	DefaultRegisterer.MustRegister(MirrorMissingTotal)
 	DefaultRegisterer.MustRegister(ResolveDurHistogram)
+	DefaultRegisterer.MustRegister(ResolveNegativeCacheHitsTotal)
+	DefaultRegisterer.MustRegister(IncompatiblePeersTotal)
+	DefaultRegisterer.MustRegister(ScrubbedBlobsTotal)
+	DefaultRegisterer.MustRegister(QuarantinedBlobs)
+	DefaultRegisterer.MustRegister(SignatureVerificationsTotal)
+	DefaultRegisterer.MustRegister(MirrorConfigurationCorrectionsTotal)
 	DefaultRegisterer.MustRegister(AdvertisedImageTags)
 	DefaultRegisterer.MustRegister(AdvertisedImageDigests)
 	DefaultRegisterer.MustRegister(AdvertisedContentDigests)
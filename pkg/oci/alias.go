@@ -0,0 +1,76 @@
+package oci
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// RegistryAliases maps registry names to the canonical registry they are equivalent to.
+// Content stored under any of the names is treated as belonging to the canonical registry.
+type RegistryAliases map[string]string
+
+// ParseRegistryAliases parses aliases in the format alias=canonical.
+func ParseRegistryAliases(aliases []string) (RegistryAliases, error) {
+	ra := RegistryAliases{}
+	for _, alias := range aliases {
+		name, canonical, ok := strings.Cut(alias, "=")
+		if !ok || name == "" || canonical == "" {
+			return nil, fmt.Errorf("invalid registry alias %s, expected format alias=canonical", alias)
+		}
+		if name == canonical {
+			continue
+		}
+		if existing, ok := ra[name]; ok && existing != canonical {
+			return nil, fmt.Errorf("registry %s cannot be an alias of both %s and %s", name, existing, canonical)
+		}
+		ra[name] = canonical
+	}
+	for name, canonical := range ra {
+		if _, ok := ra[canonical]; ok {
+			return nil, fmt.Errorf("registry %s cannot be an alias of %s which is itself an alias", name, canonical)
+		}
+	}
+	return ra, nil
+}
+
+// Canonical returns the canonical name of the registry.
+func (ra RegistryAliases) Canonical(registry string) string {
+	if canonical, ok := ra[registry]; ok {
+		return canonical
+	}
+	return registry
+}
+
+// Equivalent returns all names of the registry, starting with the given name.
+func (ra RegistryAliases) Equivalent(registry string) []string {
+	canonical := ra.Canonical(registry)
+	names := []string{registry}
+	if canonical != registry {
+		names = append(names, canonical)
+	}
+	aliases := []string{}
+	for name, c := range ra {
+		if c == canonical && name != registry {
+			aliases = append(aliases, name)
+		}
+	}
+	slices.Sort(aliases)
+	return append(names, aliases...)
+}
+
+// Reference returns the reference with the registry replaced by its canonical name.
+func (ra RegistryAliases) Reference(ref Reference) Reference {
+	ref.Registry = ra.Canonical(ref.Registry)
+	return ref
+}
+
+// Key returns the canonical form of a tag key or repository name prefixed with a registry.
+// Digest keys are returned unchanged.
+func (ra RegistryAliases) Key(key string) string {
+	registry, rest, ok := strings.Cut(key, "/")
+	if !ok {
+		return key
+	}
+	return ra.Canonical(registry) + "/" + rest
+}
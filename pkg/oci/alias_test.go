@@ -0,0 +1,92 @@
+package oci
+
+import (
+	"testing"
+
+	"github.com/go-openapi/testify/v2/require"
+)
+
+func TestParseRegistryAliases(t *testing.T) {
+	t.Parallel()
+
+	aliases, err := ParseRegistryAliases([]string{"mirror.gcr.io=docker.io", "index.docker.io=docker.io", "proxy.internal=ghcr.io", "ghcr.io=ghcr.io"})
+	require.NoError(t, err)
+	require.Len(t, aliases, 3)
+
+	require.EqualT(t, "docker.io", aliases.Canonical("mirror.gcr.io"))
+	require.EqualT(t, "docker.io", aliases.Canonical("docker.io"))
+	require.EqualT(t, "quay.io", aliases.Canonical("quay.io"))
+
+	require.SliceEqualT(t, []string{"docker.io", "index.docker.io", "mirror.gcr.io"}, aliases.Equivalent("docker.io"))
+	require.SliceEqualT(t, []string{"mirror.gcr.io", "docker.io", "index.docker.io"}, aliases.Equivalent("mirror.gcr.io"))
+	require.SliceEqualT(t, []string{"quay.io"}, aliases.Equivalent("quay.io"))
+
+	require.EqualT(t, "ghcr.io/foo/bar:v1", aliases.Key("proxy.internal/foo/bar:v1"))
+	require.EqualT(t, "docker.io/library/ubuntu", aliases.Key("index.docker.io/library/ubuntu"))
+	require.EqualT(t, "sha256:b6d6089ca6c395fd563c2084f5dd7bc56a2f5e6a81413558c5be0083287a77e9", aliases.Key("sha256:b6d6089ca6c395fd563c2084f5dd7bc56a2f5e6a81413558c5be0083287a77e9"))
+
+	ref := aliases.Reference(Reference{Registry: "mirror.gcr.io", Repository: "library/ubuntu", Tag: "24.04"})
+	require.EqualT(t, "docker.io/library/ubuntu:24.04", ref.Identifier())
+
+	var empty RegistryAliases
+	require.EqualT(t, "docker.io", empty.Canonical("docker.io"))
+	require.EqualT(t, "docker.io/library/ubuntu", empty.Key("docker.io/library/ubuntu"))
+}
+
+func TestParseRegistryAliasesErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		aliases  []string
+		expected string
+	}{
+		{
+			name:     "missing separator",
+			aliases:  []string{"mirror.gcr.io"},
+			expected: "invalid registry alias mirror.gcr.io, expected format alias=canonical",
+		},
+		{
+			name:     "empty canonical",
+			aliases:  []string{"mirror.gcr.io="},
+			expected: "invalid registry alias mirror.gcr.io=, expected format alias=canonical",
+		},
+		{
+			name:     "conflicting canonical",
+			aliases:  []string{"mirror.gcr.io=docker.io", "mirror.gcr.io=ghcr.io"},
+			expected: "registry mirror.gcr.io cannot be an alias of both docker.io and ghcr.io",
+		},
+		{
+			name:     "chained alias",
+			aliases:  []string{"mirror.gcr.io=index.docker.io", "index.docker.io=docker.io"},
+			expected: "registry mirror.gcr.io cannot be an alias of index.docker.io which is itself an alias",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := ParseRegistryAliases(tt.aliases)
+			require.EqualError(t, err, tt.expected)
+		})
+	}
+}
+
+func TestParseImageRegistryAliases(t *testing.T) {
+	t.Parallel()
+
+	aliases, err := ParseRegistryAliases([]string{"mirror.gcr.io=docker.io"})
+	require.NoError(t, err)
+
+	img, err := ParseImage("mirror.gcr.io/library/ubuntu:24.04", AllowTagOnly(), WithRegistryAliases(aliases))
+	require.NoError(t, err)
+	require.EqualT(t, "docker.io/library/ubuntu:24.04", img.String())
+
+	img, err = ParseImage("ubuntu", AllowDefaults(), AllowTagOnly(), WithRegistryAliases(aliases))
+	require.NoError(t, err)
+	require.EqualT(t, "docker.io/library/ubuntu:latest", img.String())
+
+	img, err = ParseImage("mirror.gcr.io/library/ubuntu:24.04", AllowTagOnly())
+	require.NoError(t, err)
+	require.EqualT(t, "mirror.gcr.io/library/ubuntu:24.04", img.String())
+}
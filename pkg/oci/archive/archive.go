@@ -0,0 +1,189 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/spegel-org/spegel/pkg/oci"
+)
+
+type Format string
+
+const (
+	// FormatOCI is an OCI image layout stored in a tar.
+	FormatOCI Format = "oci-archive"
+	// FormatDocker is an OCI archive with an additional manifest.json which can be loaded by Docker.
+	FormatDocker Format = "docker-archive"
+)
+
+var _ oci.PullWriter = &Writer{}
+
+// Writer streams a single pulled image to a tar archive.
+type Writer struct {
+	tw        *tar.Writer
+	written   map[digest.Digest]struct{}
+	manifests map[digest.Digest][]byte
+	format    Format
+	committed bool
+}
+
+func NewWriter(w io.Writer, format Format) (*Writer, error) {
+	switch format {
+	case FormatOCI, FormatDocker:
+	default:
+		return nil, fmt.Errorf("unknown archive format %s", format)
+	}
+	return &Writer{
+		tw:        tar.NewWriter(w),
+		written:   map[digest.Digest]struct{}{},
+		manifests: map[digest.Digest][]byte{},
+		format:    format,
+	}, nil
+}
+
+func (w *Writer) Write(ctx context.Context, desc ocispec.Descriptor, r io.Reader) error {
+	if w.committed {
+		return errors.New("archive has already been committed")
+	}
+	err := desc.Digest.Validate()
+	if err != nil {
+		return err
+	}
+	if _, ok := w.written[desc.Digest]; ok {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	}
+
+	// Manifests are kept to build the Docker manifest on commit.
+	if oci.IsManifestsMediatype(desc.MediaType) {
+		buf := &bytes.Buffer{}
+		r = io.TeeReader(r, buf)
+		defer func() {
+			w.manifests[desc.Digest] = buf.Bytes()
+		}()
+	}
+	hdr := &tar.Header{
+		Name:     blobPath(desc.Digest),
+		Mode:     0o644,
+		Size:     desc.Size,
+		Typeflag: tar.TypeReg,
+		ModTime:  time.Unix(0, 0),
+	}
+	err = w.tw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w.tw, r)
+	if err != nil {
+		return err
+	}
+	w.written[desc.Digest] = struct{}{}
+	return nil
+}
+
+// Commit writes the image index and finishes the archive.
+func (w *Writer) Commit(ctx context.Context, img oci.Image, desc ocispec.Descriptor) error {
+	if w.committed {
+		return errors.New("archive has already been committed")
+	}
+	w.committed = true
+
+	err := w.writeJSON(ocispec.ImageLayoutFile, ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+	if err != nil {
+		return err
+	}
+	desc.Annotations = img.IndexAnnotations()
+	idx := ocispec.Index{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{desc},
+	}
+	idx.SchemaVersion = 2
+	err = w.writeJSON(ocispec.ImageIndexFile, idx)
+	if err != nil {
+		return err
+	}
+	if w.format == FormatDocker {
+		dm, err := w.dockerManifest(img)
+		if err != nil {
+			return err
+		}
+		err = w.writeJSON("manifest.json", []dockerManifest{dm})
+		if err != nil {
+			return err
+		}
+	}
+	return w.tw.Close()
+}
+
+// dockerManifest describes an image in the manifest.json of a Docker archive.
+type dockerManifest struct {
+	Config   string   `json:"Config"`
+	RepoTags []string `json:"RepoTags"`
+	Layers   []string `json:"Layers"`
+}
+
+// dockerManifest returns the Docker manifest for the single image manifest written.
+func (w *Writer) dockerManifest(img oci.Image) (dockerManifest, error) {
+	imageManifests := []ocispec.Manifest{}
+	for _, b := range w.manifests {
+		manifest := ocispec.Manifest{}
+		err := json.Unmarshal(b, &manifest)
+		if err != nil {
+			return dockerManifest{}, err
+		}
+		if manifest.Config.Digest == "" {
+			continue
+		}
+		imageManifests = append(imageManifests, manifest)
+	}
+	if len(imageManifests) != 1 {
+		return dockerManifest{}, fmt.Errorf("docker archive requires exactly one image manifest but %d were pulled", len(imageManifests))
+	}
+	manifest := imageManifests[0]
+	dm := dockerManifest{
+		Config:   blobPath(manifest.Config.Digest),
+		RepoTags: []string{},
+		Layers:   []string{},
+	}
+	if tagName, ok := img.TagName(); ok {
+		dm.RepoTags = append(dm.RepoTags, tagName)
+	}
+	for _, layer := range manifest.Layers {
+		dm.Layers = append(dm.Layers, blobPath(layer.Digest))
+	}
+	return dm, nil
+}
+
+func (w *Writer) writeJSON(name string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	hdr := &tar.Header{
+		Name:     name,
+		Mode:     0o644,
+		Size:     int64(len(b)),
+		Typeflag: tar.TypeReg,
+		ModTime:  time.Unix(0, 0),
+	}
+	err = w.tw.WriteHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = w.tw.Write(b)
+	return err
+}
+
+func blobPath(dgst digest.Digest) string {
+	return path.Join(ocispec.ImageBlobsDir, dgst.Algorithm().String(), dgst.Encoded())
+}
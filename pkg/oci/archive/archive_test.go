@@ -0,0 +1,134 @@
+package archive
+
+import (
+	"archive/tar"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/go-openapi/testify/v2/require"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/spegel-org/spegel/pkg/oci"
+)
+
+func TestWriter(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewWriter(io.Discard, "foo")
+	require.EqualError(t, err, "unknown archive format foo")
+
+	configB := []byte(`{}`)
+	config := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig, Digest: digest.FromBytes(configB), Size: int64(len(configB))}
+	layerB := []byte("layer")
+	layer := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromBytes(layerB), Size: int64(len(layerB))}
+	manifestB, err := json.Marshal(ocispec.Manifest{MediaType: ocispec.MediaTypeImageManifest, Config: config, Layers: []ocispec.Descriptor{layer}})
+	require.NoError(t, err)
+	manifest := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromBytes(manifestB), Size: int64(len(manifestB))}
+	img, err := oci.ParseImage("docker.io/library/foo:1.0", oci.AllowTagOnly())
+	require.NoError(t, err)
+
+	tests := []struct {
+		name     string
+		format   Format
+		expected []string
+	}{
+		{
+			name:     "oci archive",
+			format:   FormatOCI,
+			expected: []string{ocispec.ImageLayoutFile, ocispec.ImageIndexFile},
+		},
+		{
+			name:     "docker archive",
+			format:   FormatDocker,
+			expected: []string{ocispec.ImageLayoutFile, ocispec.ImageIndexFile, "manifest.json"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			buf := &bytes.Buffer{}
+			w, err := NewWriter(buf, tt.format)
+			require.NoError(t, err)
+			err = w.Write(t.Context(), manifest, bytes.NewReader(manifestB))
+			require.NoError(t, err)
+			err = w.Write(t.Context(), config, bytes.NewReader(configB))
+			require.NoError(t, err)
+			err = w.Write(t.Context(), layer, bytes.NewReader(layerB))
+			require.NoError(t, err)
+			// Duplicate content should only be written once.
+			err = w.Write(t.Context(), layer, bytes.NewReader(layerB))
+			require.NoError(t, err)
+			err = w.Commit(t.Context(), img, manifest)
+			require.NoError(t, err)
+			err = w.Write(t.Context(), layer, bytes.NewReader(layerB))
+			require.EqualError(t, err, "archive has already been committed")
+
+			files := readArchive(t, buf)
+			require.Len(t, files, 3+len(tt.expected))
+			for _, name := range tt.expected {
+				require.Contains(t, files, name)
+			}
+			idx := ocispec.Index{}
+			err = json.Unmarshal(files[ocispec.ImageIndexFile], &idx)
+			require.NoError(t, err)
+			require.Len(t, idx.Manifests, 1)
+			require.EqualT(t, manifest.Digest, idx.Manifests[0].Digest)
+			require.Equal(t, img.IndexAnnotations(), idx.Manifests[0].Annotations)
+
+			if tt.format != FormatDocker {
+				return
+			}
+			dockerManifests := []dockerManifest{}
+			err = json.Unmarshal(files["manifest.json"], &dockerManifests)
+			require.NoError(t, err)
+			expected := []dockerManifest{
+				{
+					Config:   "blobs/sha256/" + config.Digest.Encoded(),
+					RepoTags: []string{"docker.io/library/foo:1.0"},
+					Layers:   []string{"blobs/sha256/" + layer.Digest.Encoded()},
+				},
+			}
+			require.Equal(t, expected, dockerManifests)
+		})
+	}
+}
+
+func TestWriterDockerMultipleManifests(t *testing.T) {
+	t.Parallel()
+
+	w, err := NewWriter(io.Discard, FormatDocker)
+	require.NoError(t, err)
+	for _, layerDgst := range []digest.Digest{digest.FromString("amd64"), digest.FromString("arm64")} {
+		b, err := json.Marshal(ocispec.Manifest{Config: ocispec.Descriptor{Digest: digest.FromString("config")}, Layers: []ocispec.Descriptor{{Digest: layerDgst}}})
+		require.NoError(t, err)
+		desc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromBytes(b), Size: int64(len(b))}
+		err = w.Write(t.Context(), desc, bytes.NewReader(b))
+		require.NoError(t, err)
+	}
+	img, err := oci.ParseImage("docker.io/library/foo:1.0", oci.AllowTagOnly())
+	require.NoError(t, err)
+	err = w.Commit(t.Context(), img, ocispec.Descriptor{Digest: digest.FromString("index")})
+	require.EqualError(t, err, "docker archive requires exactly one image manifest but 2 were pulled")
+}
+
+func readArchive(t *testing.T, r io.Reader) map[string][]byte {
+	t.Helper()
+
+	files := map[string][]byte{}
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if errors.Is(err, io.EOF) {
+			return files
+		}
+		require.NoError(t, err)
+		b, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		files[hdr.Name] = b
+	}
+}
@@ -1,6 +1,7 @@
 package oci
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"crypto/x509"
@@ -13,7 +14,6 @@ import (
 	"path"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
 	"github.com/containerd/containerd/v2/core/images"
@@ -33,6 +33,8 @@ const (
 
 type ClientConfig struct {
 	TLSClientConfig *tls.Config
+	Transport       http.RoundTripper
+	Credentials     Credentials
 }
 
 type ClientOption = option.Option[ClientConfig]
@@ -47,9 +49,26 @@ func WithTLS(rootCAs *x509.CertPool, certificates []tls.Certificate) ClientOptio
 	}
 }
 
+// WithTransport sets the round tripper used for requests, overriding any TLS configuration.
+func WithTransport(transport http.RoundTripper) ClientOption {
+	return func(cfg *ClientConfig) error {
+		cfg.Transport = transport
+		return nil
+	}
+}
+
+// WithCredentials sets the credentials used when a request has no explicit userinfo.
+func WithCredentials(credentials Credentials) ClientOption {
+	return func(cfg *ClientConfig) error {
+		cfg.Credentials = credentials
+		return nil
+	}
+}
+
 type Client struct {
-	httpClient *http.Client
-	tokenCache sync.Map
+	httpClient  *http.Client
+	credentials Credentials
+	tokens      *tokenManager
 }
 
 func NewClient(opts ...ClientOption) (*Client, error) {
@@ -68,10 +87,14 @@ func NewClient(opts ...ClientOption) (*Client, error) {
 	transport.MaxConnsPerHost = 100
 	transport.MaxIdleConnsPerHost = 100
 	httpClient.Transport = transport
+	if cfg.Transport != nil {
+		httpClient.Transport = cfg.Transport
+	}
 
 	ociClient := &Client{
-		httpClient: httpClient,
-		tokenCache: sync.Map{},
+		httpClient:  httpClient,
+		credentials: cfg.Credentials,
+		tokens:      newTokenManager(httpClient),
 	}
 	return ociClient, nil
 }
@@ -84,9 +107,18 @@ type CommonConfig struct {
 
 type PullConfig struct {
 	CommonConfig
+	Writer   PullWriter
 	Platform ocispec.Platform
 }
 
+// PullWriter receives the content fetched when pulling an image.
+type PullWriter interface {
+	// Write stores the content described by the descriptor.
+	Write(ctx context.Context, desc ocispec.Descriptor, r io.Reader) error
+	// Commit is called once all content has been written with the descriptor of the pulled image.
+	Commit(ctx context.Context, img Image, desc ocispec.Descriptor) error
+}
+
 type PullOption = option.Option[PullConfig]
 
 func WithPullMirror(mirror *url.URL) PullOption {
@@ -110,6 +142,14 @@ func WithPullUserinfo(userinfo *url.Userinfo) PullOption {
 	}
 }
 
+// WithPullWriter writes the pulled content to the writer instead of discarding it.
+func WithPullWriter(writer PullWriter) PullOption {
+	return func(cfg *PullConfig) error {
+		cfg.Writer = writer
+		return nil
+	}
+}
+
 func WithPullPlatform(platform ocispec.Platform) PullOption {
 	return func(cfg *PullConfig) error {
 		cfg.Platform = platform
@@ -173,6 +213,9 @@ func (c *Client) Pull(ctx context.Context, img Image, opts ...PullOption) ([]Pul
 		imgDist,
 	}
 	pullMetrics := []PullMetric{}
+	// The image descriptor is the platform manifest, as only the children of an index matching the platform are pulled.
+	var imgDesc, firstDesc ocispec.Descriptor
+	platformManifests := map[digest.Digest]*ocispec.Platform{}
 	for len(queue) > 0 {
 		dist := queue[0]
 		queue = queue[1:]
@@ -187,8 +230,13 @@ func (c *Client) Pull(ctx context.Context, img Image, opts ...PullOption) ([]Pul
 
 			switch dist.Kind {
 			case DistributionKindBlob:
-				// Right now we are just discarding the contents because we do not have a writable store.
-				_, copyErr := io.Copy(io.Discard, rc)
+				// Contents are discarded unless a writer is set.
+				var copyErr error
+				if cfg.Writer != nil {
+					copyErr = writeVerified(ctx, cfg.Writer, desc, rc)
+				} else {
+					_, copyErr = io.Copy(io.Discard, rc)
+				}
 				closeErr := rc.Close()
 				err := errors.Join(copyErr, closeErr)
 				if err != nil {
@@ -201,6 +249,12 @@ func (c *Client) Pull(ctx context.Context, img Image, opts ...PullOption) ([]Pul
 				if err != nil {
 					return ocispec.Descriptor{}, err
 				}
+				if cfg.Writer != nil {
+					err := writeVerified(ctx, cfg.Writer, desc, bytes.NewReader(b))
+					if err != nil {
+						return ocispec.Descriptor{}, err
+					}
+				}
 				switch desc.MediaType {
 				case images.MediaTypeDockerSchema2ManifestList, ocispec.MediaTypeImageIndex:
 					var idx ocispec.Index
@@ -211,6 +265,7 @@ func (c *Client) Pull(ctx context.Context, img Image, opts ...PullOption) ([]Pul
 						if !platforms.Only(cfg.Platform).Match(*m.Platform) {
 							continue
 						}
+						platformManifests[m.Digest] = m.Platform
 						nextRef := Reference{
 							Registry:   dist.Registry,
 							Repository: dist.Repository,
@@ -228,6 +283,10 @@ func (c *Client) Pull(ctx context.Context, img Image, opts ...PullOption) ([]Pul
 					if err != nil {
 						return ocispec.Descriptor{}, err
 					}
+					if imgDesc.Digest == "" {
+						imgDesc = desc
+						imgDesc.Platform = platformManifests[desc.Digest]
+					}
 					nextRef := Reference{
 						Registry:   dist.Registry,
 						Repository: dist.Repository,
@@ -257,6 +316,9 @@ func (c *Client) Pull(ctx context.Context, img Image, opts ...PullOption) ([]Pul
 		if err != nil {
 			return nil, err
 		}
+		if firstDesc.Digest == "" {
+			firstDesc = desc
+		}
 
 		metric := PullMetric{
 			Digest:        desc.Digest,
@@ -267,9 +329,31 @@ func (c *Client) Pull(ctx context.Context, img Image, opts ...PullOption) ([]Pul
 		pullMetrics = append(pullMetrics, metric)
 	}
 
+	if imgDesc.Digest == "" {
+		imgDesc = firstDesc
+	}
+	if cfg.Writer != nil {
+		err := cfg.Writer.Commit(ctx, img, imgDesc)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return pullMetrics, nil
 }
 
+// writeVerified writes the content to the writer and verifies that it matches the descriptor digest.
+func writeVerified(ctx context.Context, writer PullWriter, desc ocispec.Descriptor, r io.Reader) error {
+	verifier := desc.Digest.Verifier()
+	err := writer.Write(ctx, desc, io.TeeReader(r, verifier))
+	if err != nil {
+		return err
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("content does not match digest %s", desc.Digest)
+	}
+	return nil
+}
+
 func (c *Client) Fetch(ctx context.Context, dist DistributionPath, opts ...FetchOption) (io.ReadCloser, ocispec.Descriptor, error) {
 	if err := dist.Validate(); err != nil {
 		return nil, ocispec.Descriptor{}, err
@@ -281,9 +365,9 @@ func (c *Client) Fetch(ctx context.Context, dist DistributionPath, opts ...Fetch
 		return nil, ocispec.Descriptor{}, err
 	}
 
-	tcKey := dist.Registry + dist.Repository
-
 	u := dist.URL()
+	// Upstream credentials are never sent to mirrors, unless the mirror is the registry itself over HTTPS.
+	sendCredentials := cfg.Mirror == nil || (cfg.Mirror.Scheme == "https" && normalizeCredentialHost(cfg.Mirror.Host) == normalizeCredentialHost(u.Host))
 	if cfg.Mirror != nil {
 		u.Scheme = cfg.Mirror.Scheme
 		u.Host = cfg.Mirror.Host
@@ -292,6 +376,17 @@ func (c *Client) Fetch(ctx context.Context, dist DistributionPath, opts ...Fetch
 	if u.Host == "docker.io" {
 		u.Host = "registry-1.docker.io"
 	}
+	challengeKey := u.Host + "/" + dist.Repository
+
+	userinfo := cfg.Userinfo
+	storedCredentials := false
+	if userinfo == nil && c.credentials != nil && sendCredentials {
+		userinfo, err = c.credentials.Get(ctx, dist.Registry)
+		if err != nil {
+			return nil, ocispec.Descriptor{}, err
+		}
+		storedCredentials = userinfo != nil
+	}
 
 	var body io.ReadCloser
 	var desc ocispec.Descriptor
@@ -306,29 +401,34 @@ func (c *Client) Fetch(ctx context.Context, dist DistributionPath, opts ...Fetch
 		req.Header.Add(httpx.HeaderAccept, images.MediaTypeDockerSchema2Manifest)
 		req.Header.Add(httpx.HeaderAccept, ocispec.MediaTypeImageIndex)
 		req.Header.Add(httpx.HeaderAccept, images.MediaTypeDockerSchema2ManifestList)
-		if cfg.Userinfo != nil {
-			req.Header.Set(httpx.HeaderAuthorization, httpx.UserinfoHeaderValue(*cfg.Userinfo))
+		if userinfo != nil {
+			req.Header.Set(httpx.HeaderAuthorization, httpx.UserinfoHeaderValue(*userinfo))
 		}
 		if dist.Range != nil {
 			req.Header.Add(httpx.HeaderRange, dist.Range.String())
 		}
-		token, ok := c.tokenCache.Load(tcKey)
+		token, ok, err := c.tokens.Token(ctx, challengeKey, userinfo)
+		if err != nil {
+			return resilient.Unrecoverable(err)
+		}
 		if ok {
-			//nolint: errcheck // We know it will be a string.
-			req.Header.Set(httpx.HeaderAuthorization, "Bearer "+token.(string))
+			req.Header.Set(httpx.HeaderAuthorization, "Bearer "+token)
 		}
 		resp, err := c.httpClient.Do(req)
 		if err != nil {
 			return resilient.Unrecoverable(err)
 		}
 		if resp.StatusCode == http.StatusUnauthorized {
-			c.tokenCache.Delete(tcKey)
+			httpx.DrainAndClose(resp.Body)
 			wwwAuth := resp.Header.Get(httpx.HeaderWWWAuthenticate)
-			token, err = getBearerToken(ctx, c.httpClient, dist.Repository, wwwAuth)
+			err = c.tokens.Challenge(ctx, challengeKey, dist.Repository, wwwAuth, userinfo)
 			if err != nil {
+				// Rejected credentials are read again on the next request, as they may have been rotated.
+				if storedCredentials {
+					c.credentials.Invalidate(dist.Registry)
+				}
 				return resilient.Unrecoverable(err)
 			}
-			c.tokenCache.Store(tcKey, token)
 			return errors.New("token refresh")
 		}
 		err = httpx.CheckResponseStatus(resp, http.StatusOK, http.StatusPartialContent)
@@ -362,61 +462,6 @@ func (c *Client) Fetch(ctx context.Context, dist DistributionPath, opts ...Fetch
 	return body, desc, nil
 }
 
-func getBearerToken(ctx context.Context, client *http.Client, repository, wwwAuth string) (string, error) {
-	if !strings.HasPrefix(wwwAuth, "Bearer ") {
-		return "", errors.New("unsupported auth scheme")
-	}
-
-	params := map[string]string{}
-	for part := range strings.SplitSeq(wwwAuth[len("Bearer "):], ",") {
-		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
-		if len(kv) == 2 {
-			params[kv[0]] = strings.Trim(kv[1], `"`)
-		}
-	}
-	authURL, err := url.Parse(params["realm"])
-	if err != nil {
-		return "", err
-	}
-	q := authURL.Query()
-	if service, ok := params["service"]; ok {
-		q.Set("service", service)
-	}
-	if scope, ok := params["scope"]; ok {
-		if authURL.Host == "ghcr.io" {
-			scope = strings.ReplaceAll(scope, "user/image", repository)
-		}
-		q.Set("scope", scope)
-	}
-	authURL.RawQuery = q.Encode()
-
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, authURL.String(), nil)
-	if err != nil {
-		return "", err
-	}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer httpx.DrainAndClose(resp.Body)
-	err = httpx.CheckResponseStatus(resp, http.StatusOK)
-	if err != nil {
-		return "", err
-	}
-	b, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
-	tokenResp := struct {
-		Token string `json:"token"`
-	}{}
-	err = json.Unmarshal(b, &tokenResp)
-	if err != nil {
-		return "", err
-	}
-	return tokenResp.Token, nil
-}
-
 func DescriptorFromHeader(header http.Header) (ocispec.Descriptor, error) {
 	desc := ocispec.Descriptor{}
 
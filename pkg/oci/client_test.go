@@ -1,8 +1,11 @@
 package oci
 
 import (
+	"context"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -28,15 +31,18 @@ func TestClient(t *testing.T) {
 
 		certPool := x509.NewCertPool()
 		certificates := []tls.Certificate{{Certificate: [][]byte{{1}}}}
+		transport := &http.Transport{}
 
 		opts := []ClientOption{
 			WithTLS(certPool, certificates),
+			WithTransport(transport),
 		}
 		cfg := ClientConfig{}
 		err := option.Apply(&cfg, opts...)
 		require.NoError(t, err)
 		require.Equal(t, certPool, cfg.TLSClientConfig.RootCAs)
 		require.Equal(t, certificates, cfg.TLSClientConfig.Certificates)
+		require.Equal(t, http.RoundTripper(transport), cfg.Transport)
 	})
 
 	img, err := ParseImage("docker.io/test/image:latest", AllowTagOnly())
@@ -89,6 +95,60 @@ func TestClient(t *testing.T) {
 	require.NoError(t, err)
 	require.Len(t, pullResults, 3)
 
+	writer := &memoryWriter{content: map[digest.Digest][]byte{}}
+	_, err = ociClient.Pull(t.Context(), img, WithPullMirror(mirror), WithPullWriter(writer))
+	require.NoError(t, err)
+	require.Len(t, writer.content, 3)
+	for dgst, b := range writer.content {
+		require.EqualT(t, dgst, digest.FromBytes(b))
+	}
+	require.Equal(t, img, writer.img)
+	require.EqualT(t, manifests[0].Digest, writer.desc.Digest)
+	require.EqualT(t, ocispec.MediaTypeImageManifest, writer.desc.MediaType)
+
+	// Pulling an index commits the manifest of the selected platform, as other platforms are not written.
+	armManifest := ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    blobs[0],
+		Layers:    []ocispec.Descriptor{},
+	}
+	armManifest.SchemaVersion = 2
+	armBytes, err := json.Marshal(armManifest)
+	require.NoError(t, err)
+	_, err = mem.PushManifest(t.Context(), img.Repository, "arm64", armBytes, ocispec.MediaTypeImageManifest)
+	require.NoError(t, err)
+	idx := ocispec.Index{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{
+			{
+				MediaType: ocispec.MediaTypeImageManifest,
+				Digest:    digest.FromBytes(armBytes),
+				Size:      int64(len(armBytes)),
+				Platform:  &ocispec.Platform{OS: "linux", Architecture: "arm64"},
+			},
+			{
+				MediaType: ocispec.MediaTypeImageManifest,
+				Digest:    manifests[0].Digest,
+				Size:      int64(len(writer.content[manifests[0].Digest])),
+				Platform:  &ocispec.Platform{OS: "linux", Architecture: "amd64"},
+			},
+		},
+	}
+	idx.SchemaVersion = 2
+	b, err := json.Marshal(idx)
+	require.NoError(t, err)
+	_, err = mem.PushManifest(t.Context(), img.Repository, "multi", b, ocispec.MediaTypeImageIndex)
+	require.NoError(t, err)
+	idxImg, err := ParseImage("docker.io/test/image:multi", AllowTagOnly())
+	require.NoError(t, err)
+	writer = &memoryWriter{content: map[digest.Digest][]byte{}}
+	_, err = ociClient.Pull(t.Context(), idxImg, WithPullMirror(mirror), WithPullWriter(writer), WithPullPlatform(ocispec.Platform{OS: "linux", Architecture: "amd64"}))
+	require.NoError(t, err)
+	require.Len(t, writer.content, 4)
+	require.EqualT(t, manifests[0].Digest, writer.desc.Digest)
+	require.EqualT(t, ocispec.MediaTypeImageManifest, writer.desc.MediaType)
+	require.Equal(t, &ocispec.Platform{OS: "linux", Architecture: "amd64"}, writer.desc.Platform)
+
 	ref := Reference{
 		Registry:   img.Registry,
 		Repository: img.Repository,
@@ -102,6 +162,27 @@ func TestClient(t *testing.T) {
 	require.EqualT(t, httpx.ContentTypeBinary, desc.MediaType)
 }
 
+type memoryWriter struct {
+	content map[digest.Digest][]byte
+	img     Image
+	desc    ocispec.Descriptor
+}
+
+func (m *memoryWriter) Write(ctx context.Context, desc ocispec.Descriptor, r io.Reader) error {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	m.content[desc.Digest] = b
+	return nil
+}
+
+func (m *memoryWriter) Commit(ctx context.Context, img Image, desc ocispec.Descriptor) error {
+	m.img = img
+	m.desc = desc
+	return nil
+}
+
 func TestDescriptorHeader(t *testing.T) {
 	t.Parallel()
 
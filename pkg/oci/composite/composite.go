@@ -0,0 +1,236 @@
+package composite
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/spegel-org/spegel/pkg/oci"
+	"github.com/spegel-org/spegel/pkg/store"
+)
+
+var _ oci.ImageLister = &Composite{}
+var _ store.Provider = &Composite{}
+var _ store.Watcher = &Composite{}
+
+// Store is a child store of the composite.
+type Store interface {
+	store.Provider
+	store.Watcher
+	oci.ImageLister
+}
+
+// Composite serves content from multiple stores.
+// Stores are queried in the order they are given, the first store to have the content is used.
+type Composite struct {
+	stores []Store
+}
+
+func NewComposite(stores ...Store) (*Composite, error) {
+	if len(stores) == 0 {
+		return nil, errors.New("at least one store is required")
+	}
+	return &Composite{
+		stores: stores,
+	}, nil
+}
+
+func (c *Composite) Name() string {
+	return "composite"
+}
+
+func (c *Composite) ListImages(ctx context.Context) ([]oci.Image, error) {
+	seen := map[string]struct{}{}
+	imgs := []oci.Image{}
+	for _, s := range c.stores {
+		storeImgs, err := s.ListImages(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, img := range storeImgs {
+			if _, ok := seen[img.String()]; ok {
+				continue
+			}
+			seen[img.String()] = struct{}{}
+			imgs = append(imgs, img)
+		}
+	}
+	return imgs, nil
+}
+
+func (c *Composite) Resolve(ctx context.Context, ref string) (digest.Digest, error) {
+	errs := []error{}
+	for _, s := range c.stores {
+		dgst, err := s.Resolve(ctx, ref)
+		if errors.Is(err, store.ErrNotFound) {
+			errs = append(errs, err)
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return dgst, nil
+	}
+	return "", errors.Join(errs...)
+}
+
+func (c *Composite) Descriptor(ctx context.Context, dgst digest.Digest) (store.Descriptor, error) {
+	errs := []error{}
+	for _, s := range c.stores {
+		desc, err := s.Descriptor(ctx, dgst)
+		if errors.Is(err, store.ErrNotFound) {
+			errs = append(errs, err)
+			continue
+		}
+		if err != nil {
+			return store.Descriptor{}, err
+		}
+		return desc, nil
+	}
+	return store.Descriptor{}, errors.Join(errs...)
+}
+
+func (c *Composite) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
+	errs := []error{}
+	for _, s := range c.stores {
+		rc, err := s.Open(ctx, dgst)
+		if errors.Is(err, store.ErrNotFound) {
+			errs = append(errs, err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return rc, nil
+	}
+	return nil, errors.Join(errs...)
+}
+
+// Watch merges the events of all stores. Content present in multiple stores is only
+// created by the first store to add it and deleted when the last store removes it.
+func (c *Composite) Watch(ctx context.Context) ([]store.Event, <-chan store.Event, error) {
+	ctx, cancel := context.WithCancel(ctx)
+
+	tracker := newEventTracker()
+	initial := []store.Event{}
+	storeEventChs := []<-chan store.Event{}
+	for i, s := range c.stores {
+		storeInitial, storeEventCh, err := s.Watch(ctx)
+		if err != nil {
+			cancel()
+			return nil, nil, err
+		}
+		for _, event := range storeInitial {
+			event, ok := tracker.apply(i, event)
+			if !ok {
+				continue
+			}
+			initial = append(initial, event)
+		}
+		storeEventChs = append(storeEventChs, storeEventCh)
+	}
+
+	eventCh := make(chan store.Event)
+	wg := sync.WaitGroup{}
+	for i, storeEventCh := range storeEventChs {
+		wg.Go(func() {
+			// Stop all stores once one of them stops, as the merged stream would otherwise be incomplete.
+			defer cancel()
+
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case event, ok := <-storeEventCh:
+					if !ok {
+						return
+					}
+					event, ok = tracker.apply(i, event)
+					if !ok {
+						continue
+					}
+					select {
+					case <-ctx.Done():
+						return
+					case eventCh <- event:
+					}
+				}
+			}
+		})
+	}
+	go func() {
+		wg.Wait()
+		cancel()
+		close(eventCh)
+	}()
+
+	return initial, eventCh, nil
+}
+
+// eventTracker tracks which stores hold references and digests.
+type eventTracker struct {
+	refs    map[string]map[int]struct{}
+	digests map[digest.Digest]map[int]struct{}
+	mx      sync.Mutex
+}
+
+func newEventTracker() *eventTracker {
+	return &eventTracker{
+		refs:    map[string]map[int]struct{}{},
+		digests: map[digest.Digest]map[int]struct{}{},
+	}
+}
+
+// apply records the event for the store and returns the event with only the parts
+// which change the merged state. False is returned if nothing changed.
+func (e *eventTracker) apply(storeIdx int, event store.Event) (store.Event, bool) {
+	e.mx.Lock()
+	defer e.mx.Unlock()
+
+	if event.Reference != "" && !track(e.refs, event.Reference, storeIdx, event.Type) {
+		event.Reference = ""
+	}
+	if event.Digest != "" && !track(e.digests, event.Digest, storeIdx, event.Type) {
+		event.Digest = ""
+		event.MediaType = ""
+		event.Repository = ""
+	}
+	if event.Reference == "" && event.Digest == "" {
+		return store.Event{}, false
+	}
+	return event, true
+}
+
+// track updates the stores holding the key, returning true if the key was added or removed from all stores.
+func track[K comparable](holders map[K]map[int]struct{}, key K, storeIdx int, eventType store.EventType) bool {
+	switch eventType {
+	case store.CreateEvent:
+		stores, ok := holders[key]
+		if !ok {
+			stores = map[int]struct{}{}
+			holders[key] = stores
+		}
+		stores[storeIdx] = struct{}{}
+		return !ok
+	case store.DeleteEvent:
+		// Deletes for unknown keys are passed through as no store holds them.
+		stores, ok := holders[key]
+		if !ok {
+			return true
+		}
+		if _, ok := stores[storeIdx]; !ok {
+			return false
+		}
+		delete(stores, storeIdx)
+		if len(stores) > 0 {
+			return false
+		}
+		delete(holders, key)
+		return true
+	default:
+		return false
+	}
+}
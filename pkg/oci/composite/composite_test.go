@@ -0,0 +1,131 @@
+package composite
+
+import (
+	"context"
+	"testing"
+	"testing/synctest"
+
+	"github.com/go-openapi/testify/v2/require"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/spegel-org/spegel/pkg/oci"
+	"github.com/spegel-org/spegel/pkg/store"
+	"github.com/spegel-org/spegel/pkg/store/storetest"
+)
+
+type testStore struct {
+	*storetest.Provider
+	*storetest.Watcher
+	imgs []oci.Image
+}
+
+func (t testStore) ListImages(ctx context.Context) ([]oci.Image, error) {
+	return t.imgs, nil
+}
+
+func TestComposite(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewComposite()
+	require.EqualError(t, err, "at least one store is required")
+
+	shared := storetest.Content{MediaType: ocispec.MediaTypeImageManifest, Data: []byte(`{"mediaType":"application/vnd.oci.image.manifest.v1+json"}`)}
+	seeded := storetest.Content{MediaType: ocispec.MediaTypeImageLayerGzip, Data: []byte("seed")}
+	img, err := oci.ParseImage("docker.io/library/foo:1.0", oci.WithDigest(shared.Digest()))
+	require.NoError(t, err)
+	primary := testStore{
+		Provider: storetest.NewProvider([]storetest.Content{shared}, map[string]digest.Digest{"docker.io/library/foo:1.0": shared.Digest()}),
+		Watcher:  storetest.NewWatcher(nil),
+		imgs:     []oci.Image{img},
+	}
+	secondary := testStore{
+		Provider: storetest.NewProvider([]storetest.Content{shared, seeded}, map[string]digest.Digest{"docker.io/library/foo:1.0": digest.FromString("other"), "docker.io/library/bar:1.0": seeded.Digest()}),
+		Watcher:  storetest.NewWatcher(nil),
+		imgs:     []oci.Image{img},
+	}
+	c, err := NewComposite(primary, secondary)
+	require.NoError(t, err)
+
+	cfg := storetest.ProviderConfig{
+		Name:               "composite",
+		NotFoundRef:        "docker.io/library/foo:missing",
+		ExistingRef:        "docker.io/library/foo:1.0",
+		ExistingRefDigest:  shared.Digest(),
+		NotFoundDigest:     digest.FromString("missing"),
+		ExistingDescriptor: shared.Descriptor(),
+	}
+	storetest.ProviderConformance(t, c, cfg)
+
+	// Content missing in the first store should be served by the next.
+	dgst, err := c.Resolve(t.Context(), "docker.io/library/bar:1.0")
+	require.NoError(t, err)
+	require.EqualT(t, seeded.Digest(), dgst)
+	desc, err := c.Descriptor(t.Context(), seeded.Digest())
+	require.NoError(t, err)
+	require.EqualT(t, seeded.Descriptor(), desc)
+	rc, err := c.Open(t.Context(), seeded.Digest())
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+
+	imgs, err := c.ListImages(t.Context())
+	require.NoError(t, err)
+	require.Equal(t, []oci.Image{img}, imgs)
+}
+
+func TestCompositeWatch(t *testing.T) {
+	t.Parallel()
+
+	sharedDgst := digest.FromString("shared")
+	seededDgst := digest.FromString("seeded")
+	tagName := "docker.io/library/foo:1.0"
+
+	synctest.Test(t, func(t *testing.T) {
+		primary := testStore{
+			Watcher: storetest.NewWatcher([]store.Event{
+				{Type: store.CreateEvent, Reference: tagName, Digest: sharedDgst, MediaType: ocispec.MediaTypeImageManifest},
+			}),
+		}
+		secondary := testStore{
+			Watcher: storetest.NewWatcher([]store.Event{
+				{Type: store.CreateEvent, Reference: tagName, Digest: sharedDgst, MediaType: ocispec.MediaTypeImageManifest},
+				{Type: store.CreateEvent, Digest: seededDgst},
+			}),
+		}
+		c, err := NewComposite(primary, secondary)
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(t.Context())
+		initial, eventCh, err := c.Watch(ctx)
+		require.NoError(t, err)
+		expected := []store.Event{
+			{Type: store.CreateEvent, Reference: tagName, Digest: sharedDgst, MediaType: ocispec.MediaTypeImageManifest},
+			{Type: store.CreateEvent, Digest: seededDgst},
+		}
+		require.Equal(t, expected, initial)
+
+		// Content should only be deleted once no store holds it.
+		go primary.Add(t.Context(), store.Event{Type: store.DeleteEvent, Reference: tagName, Digest: sharedDgst})
+		synctest.Wait()
+		select {
+		case event := <-eventCh:
+			t.Fatalf("unexpected event %v", event)
+		default:
+		}
+		go secondary.Add(t.Context(), store.Event{Type: store.DeleteEvent, Digest: sharedDgst})
+		event := <-eventCh
+		require.Equal(t, store.Event{Type: store.DeleteEvent, Digest: sharedDgst}, event)
+		go secondary.Add(t.Context(), store.Event{Type: store.DeleteEvent, Reference: tagName})
+		event = <-eventCh
+		require.Equal(t, store.Event{Type: store.DeleteEvent, Reference: tagName}, event)
+
+		// Partially shared events should only include what changed.
+		go primary.Add(t.Context(), store.Event{Type: store.CreateEvent, Reference: "docker.io/library/bar:1.0", Digest: seededDgst})
+		event = <-eventCh
+		require.Equal(t, store.Event{Type: store.CreateEvent, Reference: "docker.io/library/bar:1.0"}, event)
+
+		cancel()
+		_, ok := <-eventCh
+		require.FalseT(t, ok)
+	})
+}
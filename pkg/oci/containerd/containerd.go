@@ -10,18 +10,20 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	eventtypes "github.com/containerd/containerd/api/events"
 	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/content"
 	"github.com/containerd/containerd/v2/core/events"
 	"github.com/containerd/containerd/v2/core/images"
 	"github.com/containerd/containerd/v2/pkg/labels"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
 	"github.com/containerd/containerd/v2/plugins"
 	"github.com/containerd/errdefs"
 	"github.com/containerd/typeurl/v2"
 	"github.com/go-logr/logr"
-	lru "github.com/hashicorp/golang-lru/v2"
 	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"google.golang.org/grpc"
@@ -36,11 +38,15 @@ import (
 var _ oci.ImageLister = &Containerd{}
 var _ store.Provider = &Containerd{}
 var _ store.Watcher = &Containerd{}
+var _ store.ReadinessChecker = &Containerd{}
 
 type ContainerdConfig struct {
-	Conn        net.Conn
-	ContentPath string
-	Filters     []oci.Filter
+	Conn                 net.Conn
+	ContentPath          string
+	DataDir              string
+	Filters              []oci.Filter
+	AdditionalNamespaces []string
+	RetentionGrace       time.Duration
 }
 
 type ContainerdOption = option.Option[ContainerdConfig]
@@ -52,6 +58,14 @@ func WithContentPath(path string) ContainerdOption {
 	}
 }
 
+// WithDataDir persists the descriptor index in the directory.
+func WithDataDir(dataDir string) ContainerdOption {
+	return func(cfg *ContainerdConfig) error {
+		cfg.DataDir = dataDir
+		return nil
+	}
+}
+
 func WithConnection(conn net.Conn) ContainerdOption {
 	return func(cfg *ContainerdConfig) error {
 		cfg.Conn = conn
@@ -66,11 +80,49 @@ func WithFilters(filters []oci.Filter) ContainerdOption {
 	}
 }
 
+// WithRetentionGrace retains served content for the duration after it has been read, protecting it from garbage collection.
+func WithRetentionGrace(grace time.Duration) ContainerdOption {
+	return func(cfg *ContainerdConfig) error {
+		if grace < 0 {
+			return errors.New("retention grace cannot be negative")
+		}
+		cfg.RetentionGrace = grace
+		return nil
+	}
+}
+
+// WithAdditionalNamespaces watches and serves images from the namespaces in addition to the default namespace.
+func WithAdditionalNamespaces(namespaces []string) ContainerdOption {
+	return func(cfg *ContainerdConfig) error {
+		cfg.AdditionalNamespaces = namespaces
+		return nil
+	}
+}
+
 type Containerd struct {
-	client       *client.Client
-	mediaTypeIdx *lru.Cache[digest.Digest, string]
-	contentPath  string
-	filters      []oci.Filter
+	ctrdClient     atomic.Pointer[client.Client]
+	descIdx        *descriptorIndex
+	socketPath     string
+	contentPath    string
+	clientOpts     []client.Opt
+	namespaces     []string
+	filters        []oci.Filter
+	reconnectDelay time.Duration
+	retentionGrace time.Duration
+	ready          atomic.Bool
+	leaseFailing   atomic.Bool
+}
+
+// namespacedImage is an image and the namespace it exists in.
+type namespacedImage struct {
+	namespace string
+	img       oci.Image
+}
+
+// contentKey identifies the content of an image in a namespace.
+type contentKey struct {
+	namespace string
+	dgst      digest.Digest
 }
 
 func NewContainerd(ctx context.Context, socketPath, namespace string, opts ...ContainerdOption) (*Containerd, error) {
@@ -89,34 +141,64 @@ func NewContainerd(ctx context.Context, socketPath, namespace string, opts ...Co
 		})
 		clientOpts = append(clientOpts, client.WithExtraDialOpts([]grpc.DialOption{dialOpt}))
 	}
-	client, err := client.New(socketPath, clientOpts...)
+	ctrdClient, err := client.New(socketPath, clientOpts...)
 	if err != nil {
 		return nil, err
 	}
 
 	contentPath := cfg.ContentPath
 	if contentPath == "" {
-		contentPath, err = getContentPath(ctx, client)
+		contentPath, err = getContentPath(ctx, ctrdClient)
 		if err != nil {
 			return nil, err
 		}
 	}
 
-	mediaTypeIdx, err := lru.New[digest.Digest, string](100)
+	descIdxPath := ""
+	if cfg.DataDir != "" {
+		descIdxPath = filepath.Join(cfg.DataDir, descriptorIndexFile)
+	}
+	descIdx := newDescriptorIndex(descIdxPath)
+	err = descIdx.Load()
 	if err != nil {
-		return nil, err
+		// The index is rebuilt when watching so a broken index should not stop startup.
+		logr.FromContextOrDiscard(ctx).Error(err, "could not load descriptor index", "path", descIdxPath)
 	}
 
-	c := &Containerd{
-		client:       client,
-		mediaTypeIdx: mediaTypeIdx,
-		contentPath:  contentPath,
+	ctrdNamespaces := []string{namespace}
+	for _, ns := range cfg.AdditionalNamespaces {
+		if slices.Contains(ctrdNamespaces, ns) {
+			continue
+		}
+		ctrdNamespaces = append(ctrdNamespaces, ns)
 	}
+
+	c := &Containerd{
+		descIdx:        descIdx,
+		socketPath:     socketPath,
+		contentPath:    contentPath,
+		clientOpts:     clientOpts,
+		namespaces:     ctrdNamespaces,
+		filters:        cfg.Filters,
+		reconnectDelay: time.Second,
+		retentionGrace: cfg.RetentionGrace,
+	}
+	c.ctrdClient.Store(ctrdClient)
+	c.ready.Store(true)
 	return c, nil
 }
 
+// client returns the current containerd client, which is replaced when reconnecting.
+func (c *Containerd) client() *client.Client {
+	return c.ctrdClient.Load()
+}
+
 func (c *Containerd) Close() error {
-	err := c.client.Close()
+	err := c.descIdx.Flush()
+	if err != nil {
+		return err
+	}
+	err = c.client().Close()
 	if err != nil {
 		return err
 	}
@@ -128,51 +210,77 @@ func (c *Containerd) Name() string {
 }
 
 func (c *Containerd) ListImages(ctx context.Context) ([]oci.Image, error) {
-	cImgs, err := c.client.ImageService().List(ctx, `name~="^.+/"`)
+	nsImgs, err := c.listImages(ctx)
 	if err != nil {
 		return nil, err
 	}
-	tagDgsts := map[digest.Digest]string{}
+	// Images existing in multiple namespaces are only listed once.
+	seen := map[string]struct{}{}
 	imgs := []oci.Image{}
-	for _, cImg := range cImgs {
-		img, err := oci.ParseImage(cImg.Name, oci.WithDigest(cImg.Target.Digest))
+	for _, nsImg := range nsImgs {
+		if _, ok := seen[nsImg.img.String()]; ok {
+			continue
+		}
+		seen[nsImg.img.String()] = struct{}{}
+		imgs = append(imgs, nsImg.img)
+	}
+	return imgs, nil
+}
+
+// listImages returns the images in all namespaces, images existing in multiple namespaces are returned once per namespace.
+func (c *Containerd) listImages(ctx context.Context) ([]namespacedImage, error) {
+	tagDgsts := map[digest.Digest]string{}
+	nsImgs := []namespacedImage{}
+	for _, ns := range c.namespaces {
+		cImgs, err := c.client().ImageService().List(namespaces.WithNamespace(ctx, ns), `name~="^.+/"`)
 		if err != nil {
 			return nil, err
 		}
-		if img.Tag != "" {
-			tagDgsts[img.Digest] = img.Tag
-		}
-		if oci.MatchesFilter(img.Reference, c.filters) {
-			continue
+		for _, cImg := range cImgs {
+			img, err := oci.ParseImage(cImg.Name, oci.WithDigest(cImg.Target.Digest))
+			if err != nil {
+				return nil, err
+			}
+			if img.Tag != "" {
+				tagDgsts[img.Digest] = img.Tag
+			}
+			if oci.MatchesFilter(img.Reference, c.filters) {
+				continue
+			}
+			nsImgs = append(nsImgs, namespacedImage{namespace: ns, img: img})
 		}
-		imgs = append(imgs, img)
 	}
 	// Remove duplicate digest images that already have tags.
-	imgs = slices.DeleteFunc(imgs, func(img oci.Image) bool {
-		if img.Tag != "" {
+	nsImgs = slices.DeleteFunc(nsImgs, func(nsImg namespacedImage) bool {
+		if nsImg.img.Tag != "" {
 			return false
 		}
-		if _, ok := tagDgsts[img.Digest]; ok {
+		if _, ok := tagDgsts[nsImg.img.Digest]; ok {
 			return true
 		}
 		return false
 	})
-	return imgs, nil
+	return nsImgs, nil
 }
 
 func (c *Containerd) Resolve(ctx context.Context, ref string) (digest.Digest, error) {
-	cImg, err := c.client.ImageService().Get(ctx, ref)
-	if errors.Is(err, errdefs.ErrNotFound) {
-		return "", errors.Join(store.ErrNotFound, err)
-	}
-	if err != nil {
-		return "", err
+	var err error
+	for _, ns := range c.namespaces {
+		var cImg images.Image
+		cImg, err = c.client().ImageService().Get(namespaces.WithNamespace(ctx, ns), ref)
+		if errors.Is(err, errdefs.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return cImg.Target.Digest, nil
 	}
-	return cImg.Target.Digest, nil
+	return "", errors.Join(store.ErrNotFound, err)
 }
 
 func (c *Containerd) Descriptor(ctx context.Context, dgst digest.Digest) (store.Descriptor, error) {
-	info, err := c.client.ContentStore().Info(ctx, dgst)
+	info, _, err := c.info(ctx, dgst)
 	if errors.Is(err, errdefs.ErrNotFound) {
 		return store.Descriptor{}, errors.Join(store.ErrNotFound, err)
 	}
@@ -180,13 +288,15 @@ func (c *Containerd) Descriptor(ctx context.Context, dgst digest.Digest) (store.
 		return store.Descriptor{}, err
 	}
 
-	mt, ok := c.mediaTypeIdx.Get(dgst)
+	// Media types are indexed when walking images, only unknown content needs to be fingerprinted.
+	mt, ok := c.descIdx.Get(dgst)
 	if !ok {
 		mt, err = func() (string, error) {
 			if info.Size > oci.ManifestMaxSize {
 				return httpx.ContentTypeBinary, nil
 			}
-			rc, err := c.Open(ctx, dgst)
+			// Fingerprinting only reads the start of the content so it does not need to be leased.
+			rc, err := c.open(ctx, dgst)
 			if err != nil {
 				return "", err
 			}
@@ -200,7 +310,7 @@ func (c *Containerd) Descriptor(ctx context.Context, dgst digest.Digest) (store.
 		if err != nil {
 			return store.Descriptor{}, err
 		}
-		c.mediaTypeIdx.Add(dgst, mt)
+		c.descIdx.Add(dgst, mt)
 	}
 
 	desc := store.Descriptor{
@@ -211,7 +321,41 @@ func (c *Containerd) Descriptor(ctx context.Context, dgst digest.Digest) (store.
 	return desc, nil
 }
 
+// Open returns the content protected by a lease until closed, so that it is not garbage collected while being read.
 func (c *Containerd) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	// Background reads are not served so the content does not have to be protected or retained.
+	if store.IsBackgroundRead(ctx) {
+		return c.open(ctx, dgst)
+	}
+	// Leasing is best effort, content which is readable is served even when containerd is unavailable.
+	_, ns, err := c.info(ctx, dgst)
+	if errors.Is(err, errdefs.ErrNotFound) {
+		return c.open(ctx, dgst)
+	}
+	var release func(retain bool) error
+	if err == nil {
+		release, err = c.lease(ctx, ns, dgst)
+	}
+	if err != nil {
+		// Only the first failure is logged as every request would fail while containerd is unavailable.
+		if !c.leaseFailing.Swap(true) {
+			log.Error(err, "could not lease content, reading without lease until leasing succeeds again", "digest", dgst)
+		}
+		return c.open(ctx, dgst)
+	}
+	if c.leaseFailing.Swap(false) {
+		log.Info("leasing content succeeded again")
+	}
+	rc, err := c.open(ctx, dgst)
+	if err != nil {
+		return nil, errors.Join(err, release(false))
+	}
+	return &leasedReadSeekCloser{ReadSeekCloser: rc, release: release}, nil
+}
+
+func (c *Containerd) open(ctx context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
 	if c.contentPath != "" {
 		path := filepath.Join(c.contentPath, "blobs", dgst.Algorithm().String(), dgst.Encoded())
 		file, err := os.Open(path)
@@ -223,92 +367,298 @@ func (c *Containerd) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekC
 		}
 		return file, nil
 	}
-	ra, err := c.client.ContentStore().ReaderAt(ctx, ocispec.Descriptor{Digest: dgst})
+	var err error
+	for _, ns := range c.namespaces {
+		var ra content.ReaderAt
+		ra, err = c.client().ContentStore().ReaderAt(namespaces.WithNamespace(ctx, ns), ocispec.Descriptor{Digest: dgst})
+		if errors.Is(err, errdefs.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return struct {
+			io.ReadSeeker
+			io.Closer
+		}{
+			ReadSeeker: io.NewSectionReader(ra, 0, ra.Size()),
+			Closer:     ra,
+		}, nil
+	}
+	return nil, errors.Join(store.ErrNotFound, err)
+}
+
+// info returns the content info and the first namespace that contains the digest.
+func (c *Containerd) info(ctx context.Context, dgst digest.Digest) (content.Info, string, error) {
+	var err error
+	for _, ns := range c.namespaces {
+		var info content.Info
+		info, err = c.client().ContentStore().Info(namespaces.WithNamespace(ctx, ns), dgst)
+		if errors.Is(err, errdefs.ErrNotFound) {
+			continue
+		}
+		if err != nil {
+			return content.Info{}, "", err
+		}
+		return info, ns, nil
+	}
+	return content.Info{}, "", err
+}
+
+// contentExists returns true if the digest exists in any namespace.
+func (c *Containerd) contentExists(ctx context.Context, dgst digest.Digest) (bool, error) {
+	_, _, err := c.info(ctx, dgst)
 	if errors.Is(err, errdefs.ErrNotFound) {
-		return nil, errors.Join(store.ErrNotFound, err)
+		return false, nil
 	}
 	if err != nil {
-		return nil, err
+		return false, err
+	}
+	return true, nil
+}
+
+// imageExists returns true if the image name exists in any namespace.
+func (c *Containerd) imageExists(ctx context.Context, name string) (bool, error) {
+	_, err := c.Resolve(ctx, name)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// eventFilters returns the event subscription filters for all namespaces.
+func (c *Containerd) eventFilters() []string {
+	eventFilters := []string{}
+	for _, ns := range c.namespaces {
+		eventFilters = append(eventFilters,
+			fmt.Sprintf(`namespace==%q,topic~="/images/create|/images/delete",event.name~="^.+/"`, ns),
+			fmt.Sprintf(`namespace==%q,topic~="/content/create"`, ns),
+		)
 	}
-	return struct {
-		io.ReadSeeker
-		io.Closer
-	}{
-		ReadSeeker: io.NewSectionReader(ra, 0, ra.Size()),
-		Closer:     ra,
-	}, nil
+	return eventFilters
 }
 
 func (c *Containerd) Watch(ctx context.Context) ([]store.Event, <-chan store.Event, error) {
 	log := logr.FromContextOrDiscard(ctx)
 
+	sub, initial, err := c.subscribe(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	advertised := store.NewSnapshot()
+	trackEvents(advertised, initial)
+
 	eventCh := make(chan store.Event)
+	go func() {
+		defer close(eventCh)
+		for {
+			err := c.handleSubscription(ctx, sub, advertised, eventCh)
+			sub.cancel()
+			if ctx.Err() != nil {
+				return
+			}
+
+			// Containerd is no longer reachable, the store is not ready until content has been resynchronized.
+			log.Error(err, "lost connection to containerd, reconnecting")
+			c.ready.Store(false)
+			var resynced []store.Event
+			sub, resynced, err = c.reconnect(ctx)
+			if err != nil {
+				return
+			}
+			curr := store.NewSnapshot()
+			trackEvents(curr, resynced)
+			for _, event := range store.DiffSnapshots(advertised, curr) {
+				if event.Type != store.DeleteEvent {
+					continue
+				}
+				resynced = append(resynced, event)
+			}
+			for _, event := range resynced {
+				select {
+				case <-ctx.Done():
+					sub.cancel()
+					return
+				case eventCh <- event:
+				}
+			}
+			advertised = curr
+			c.ready.Store(true)
+			log.Info("resynchronized content after reconnecting to containerd")
+		}
+	}()
+	return initial, eventCh, nil
+}
+
+func (c *Containerd) Ready(ctx context.Context) (bool, error) {
+	return c.ready.Load(), nil
+}
+
+// subscription is an event subscription and the content index of images at the time of subscribing.
+type subscription struct {
+	envelopeCh <-chan *events.Envelope
+	errCh      <-chan error
+	contentIdx map[contentKey][]ocispec.Descriptor
+	cancel     context.CancelFunc
+}
+
+// subscribe subscribes to events and indexes all existing content, returning events for the existing content.
+func (c *Containerd) subscribe(ctx context.Context) (subscription, []store.Event, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
 	subCtx, subCancel := context.WithCancel(ctx)
-	eventFilters := []string{`topic~="/images/create|/images/delete",event.name~="^.+/"`, `topic~="/content/create"`}
-	envelopeCh, cErrCh := c.client.EventService().Subscribe(subCtx, eventFilters...)
+	envelopeCh, errCh := c.client().EventService().Subscribe(subCtx, c.eventFilters()...)
 
 	// Populate the content index.
-	contentIdx := map[digest.Digest][]oci.Reference{}
+	contentIdx := map[contentKey][]ocispec.Descriptor{}
 	initial := []store.Event{}
 
-	imgs, err := c.ListImages(ctx)
+	mediaTypes := map[digest.Digest]string{}
+	nsImgs, err := c.listImages(ctx)
 	if err != nil {
 		subCancel()
-		return nil, nil, err
+		return subscription{}, nil, err
 	}
-	for _, img := range imgs {
-		refs, err := walkImage(ctx, c.client, img)
+	seen := map[string]struct{}{}
+	for _, nsImg := range nsImgs {
+		img := nsImg.img
+		descs, err := walkImage(namespaces.WithNamespace(ctx, nsImg.namespace), c.client(), img)
 		if err != nil {
 			subCancel()
-			return nil, nil, err
+			return subscription{}, nil, err
 		}
-		contentIdx[img.Digest] = refs
-		for i, ref := range refs {
-			event := store.Event{Type: store.CreateEvent, Digest: ref.Digest}
+		contentIdx[contentKey{namespace: nsImg.namespace, dgst: img.Digest}] = descs
+		// Content of images existing in multiple namespaces is only advertised once.
+		if _, ok := seen[img.String()]; ok {
+			continue
+		}
+		seen[img.String()] = struct{}{}
+		for i, desc := range descs {
+			mediaTypes[desc.Digest] = desc.MediaType
+			event := store.Event{Type: store.CreateEvent, Digest: desc.Digest, MediaType: desc.MediaType, Size: desc.Size, Repository: img.Name()}
 			if tagName, ok := img.TagName(); ok && i == 0 {
 				event.Reference = tagName
 			}
 			initial = append(initial, event)
 		}
 	}
+	c.descIdx.Replace(mediaTypes)
+	err = c.descIdx.Flush()
+	if err != nil {
+		log.Error(err, "could not persist descriptor index")
+	}
 
-	go func() {
-		defer close(eventCh)
-		for {
-			select {
-			case <-subCtx.Done():
-				return
-			case envelope := <-envelopeCh:
-				events, err := c.handleEvent(subCtx, *envelope, contentIdx)
-				if err != nil {
-					log.Error(err, "error when handling containerd event")
-					continue
-				}
-				for _, event := range events {
-					eventCh <- event
+	sub := subscription{
+		envelopeCh: envelopeCh,
+		errCh:      errCh,
+		contentIdx: contentIdx,
+		cancel:     subCancel,
+	}
+	return sub, initial, nil
+}
+
+// handleSubscription sends events for received containerd events until the subscription fails or the context is cancelled.
+func (c *Containerd) handleSubscription(ctx context.Context, sub subscription, advertised store.Snapshot, eventCh chan<- store.Event) error {
+	log := logr.FromContextOrDiscard(ctx)
+
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case err, ok := <-sub.errCh:
+			if !ok || err == nil {
+				return errors.New("containerd event subscription closed")
+			}
+			return err
+		case envelope := <-sub.envelopeCh:
+			events, err := c.handleEvent(ctx, *envelope, sub.contentIdx)
+			if err != nil {
+				log.Error(err, "error when handling containerd event")
+				continue
+			}
+			err = c.descIdx.Flush()
+			if err != nil {
+				log.Error(err, "could not persist descriptor index")
+			}
+			trackEvents(advertised, events)
+			for _, event := range events {
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case eventCh <- event:
 				}
 			}
 		}
-	}()
+	}
+}
 
-	go func() {
-		// Required so that the event channel closes in case Containerd is restarted.
-		defer subCancel()
-		for err := range cErrCh {
-			if errors.Is(err, context.Canceled) {
-				return
+// reconnect replaces the containerd client and subscribes again, retrying with back-off until successful or the context is cancelled.
+func (c *Containerd) reconnect(ctx context.Context) (subscription, []store.Event, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	type resync struct {
+		sub    subscription
+		events []store.Event
+	}
+	onRetry := func(attempt int, err error) {
+		log.Error(err, "could not reconnect to containerd", "attempt", attempt)
+	}
+	result, err := resilient.RetryValue(ctx, 0, resilient.BackoffDelay(c.reconnectDelay, 30*time.Second), func(ctx context.Context) (resync, error) {
+		cl, err := client.New(c.socketPath, c.clientOpts...)
+		if err != nil {
+			return resync{}, err
+		}
+		prev := c.ctrdClient.Swap(cl)
+		if prev != nil {
+			err := prev.Close()
+			if err != nil {
+				log.Error(err, "could not close previous containerd client")
 			}
-			log.Error(err, "received containerd event error")
 		}
-	}()
+		sub, events, err := c.subscribe(ctx)
+		if err != nil {
+			return resync{}, err
+		}
+		return resync{sub: sub, events: events}, nil
+	}, resilient.WithOnRetry(onRetry), resilient.WithLastErrorOnly())
+	if err != nil {
+		return subscription{}, nil, err
+	}
+	return result.sub, result.events, nil
+}
 
-	return initial, eventCh, nil
+// trackEvents updates the snapshot with the tags and content created or deleted by the events.
+func trackEvents(snapshot store.Snapshot, events []store.Event) {
+	for _, event := range events {
+		switch event.Type {
+		case store.CreateEvent:
+			if event.Reference != "" {
+				snapshot.Tags[event.Reference] = event.Digest
+			}
+			if event.Digest != "" {
+				snapshot.Content[event.Digest] = store.Event{Type: store.CreateEvent, Digest: event.Digest, MediaType: event.MediaType, Size: event.Size, Repository: event.Repository}
+			}
+		case store.DeleteEvent:
+			if event.Reference != "" {
+				delete(snapshot.Tags, event.Reference)
+			}
+			if event.Digest != "" {
+				delete(snapshot.Content, event.Digest)
+			}
+		}
+	}
 }
 
-func (c *Containerd) handleEvent(ctx context.Context, envelope events.Envelope, contentIdx map[digest.Digest][]oci.Reference) ([]store.Event, error) {
+func (c *Containerd) handleEvent(ctx context.Context, envelope events.Envelope, contentIdx map[contentKey][]ocispec.Descriptor) ([]store.Event, error) {
 	if envelope.Event == nil {
 		return nil, errors.New("envelope event cannot be nil")
 	}
+	if envelope.Namespace != "" {
+		ctx = namespaces.WithNamespace(ctx, envelope.Namespace)
+	}
 	evt, err := typeurl.UnmarshalAny(envelope.Event)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal envelope event: %w", err)
@@ -318,7 +668,7 @@ func (c *Containerd) handleEvent(ctx context.Context, envelope events.Envelope,
 	case *eventtypes.ContentCreate:
 		dgst := digest.Digest(e.GetDigest())
 		refs, err := resilient.RetryValue(ctx, 10, resilient.BackoffDelay(10*time.Millisecond, 100*time.Millisecond), func(ctx context.Context) ([]oci.Reference, error) {
-			info, err := c.client.ContentStore().Info(ctx, dgst)
+			info, err := c.client().ContentStore().Info(ctx, dgst)
 			if err != nil {
 				return nil, resilient.Unrecoverable(err)
 			}
@@ -333,7 +683,7 @@ func (c *Containerd) handleEvent(ctx context.Context, envelope events.Envelope,
 		}
 		events := []store.Event{}
 		for _, ref := range refs {
-			events = append(events, store.Event{Type: store.CreateEvent, Digest: ref.Digest})
+			events = append(events, store.Event{Type: store.CreateEvent, Digest: ref.Digest, Repository: ref.Name()})
 		}
 		return events, nil
 	case *eventtypes.ImageCreate:
@@ -349,12 +699,23 @@ func (c *Containerd) handleEvent(ctx context.Context, envelope events.Envelope,
 			return []store.Event{{Type: store.CreateEvent, Reference: tagName}}, nil
 		}
 		// Walk the image to index its content.
-		refs, err := walkImage(ctx, c.client, img)
+		descs, err := walkImage(ctx, c.client(), img)
 		if err != nil {
 			return nil, err
 		}
-		contentIdx[img.Digest] = refs
-		return nil, nil
+		contentIdx[contentKey{namespace: envelope.Namespace, dgst: img.Digest}] = descs
+		for _, desc := range descs {
+			c.descIdx.Add(desc.Digest, desc.MediaType)
+		}
+		// Content is advertised when created but media types are only known after walking the image.
+		events := []store.Event{}
+		for _, desc := range descs {
+			if !oci.IsManifestsMediatype(desc.MediaType) {
+				continue
+			}
+			events = append(events, store.Event{Type: store.CreateEvent, Digest: desc.Digest, MediaType: desc.MediaType, Size: desc.Size, Repository: img.Name()})
+		}
+		return events, nil
 	case *eventtypes.ImageDelete:
 		img, err := oci.ParseImage(e.GetName(), oci.AllowTagOnly())
 		if err != nil {
@@ -363,20 +724,33 @@ func (c *Containerd) handleEvent(ctx context.Context, envelope events.Envelope,
 		if oci.MatchesFilter(img.Reference, c.filters) {
 			return nil, nil
 		}
-		// Just advertise the image if it is a tag reference.
+		// Just advertise the image if it is a tag reference that does not exist in another namespace.
 		if tagName, ok := img.TagName(); ok {
+			exists, err := c.imageExists(ctx, e.GetName())
+			if err != nil {
+				return nil, err
+			}
+			if exists {
+				return nil, nil
+			}
 			return []store.Event{{Type: store.DeleteEvent, Reference: tagName}}, nil
 		}
 		// Advertise deletion of images content if it no longer exists.
-		refs, ok := contentIdx[img.Digest]
+		key := contentKey{namespace: envelope.Namespace, dgst: img.Digest}
+		descs, ok := contentIdx[key]
 		if !ok {
 			logr.FromContextOrDiscard(ctx).Info("delete event with missing content index entry")
+			for k := range contentIdx {
+				if k.dgst == img.Digest {
+					return nil, nil
+				}
+			}
 			return []store.Event{{Type: store.DeleteEvent, Digest: img.Digest}}, nil
 		}
-		delete(contentIdx, img.Digest)
+		delete(contentIdx, key)
 		// Delete events are sent before garbage collection is run.
 		err = resilient.Retry(ctx, 10, resilient.BackoffDelay(10*time.Millisecond, 100*time.Millisecond), func(ctx context.Context) error {
-			_, err := c.client.ContentStore().Info(ctx, img.Digest)
+			_, err := c.client().ContentStore().Info(ctx, img.Digest)
 			if errors.Is(err, errdefs.ErrNotFound) {
 				return nil
 			}
@@ -388,17 +762,18 @@ func (c *Containerd) handleEvent(ctx context.Context, envelope events.Envelope,
 		if err != nil {
 			return nil, fmt.Errorf("image manifest has not been deleted: %w", err)
 		}
-		// Create delete events for contents that has been removed.
+		// Create delete events for contents that has been removed from all namespaces.
 		events := []store.Event{}
-		for _, ref := range refs {
-			_, err := c.client.ContentStore().Info(ctx, ref.Digest)
-			if err == nil {
-				continue
-			}
-			if !errors.Is(err, errdefs.ErrNotFound) {
+		for _, desc := range descs {
+			exists, err := c.contentExists(ctx, desc.Digest)
+			if err != nil {
 				return nil, err
 			}
-			events = append(events, store.Event{Type: store.DeleteEvent, Digest: ref.Digest})
+			if exists {
+				continue
+			}
+			c.descIdx.Remove(desc.Digest)
+			events = append(events, store.Event{Type: store.DeleteEvent, Digest: desc.Digest, MediaType: desc.MediaType})
 		}
 		return events, nil
 	default:
@@ -406,7 +781,7 @@ func (c *Containerd) handleEvent(ctx context.Context, envelope events.Envelope,
 	}
 }
 
-func walkImage(ctx context.Context, client *client.Client, img oci.Image) ([]oci.Reference, error) {
+func walkImage(ctx context.Context, client *client.Client, img oci.Image) ([]ocispec.Descriptor, error) {
 	cImgs, err := client.ImageService().List(ctx, fmt.Sprintf(`target.digest==%q`, img.Digest.String()))
 	if err != nil {
 		return nil, err
@@ -414,7 +789,7 @@ func walkImage(ctx context.Context, client *client.Client, img oci.Image) ([]oci
 	if len(cImgs) == 0 {
 		return nil, fmt.Errorf("image %s not found", img.String())
 	}
-	refs := []oci.Reference{}
+	descs := []ocispec.Descriptor{}
 	handler := images.HandlerFunc(func(ctx context.Context, desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
 		_, err := client.ContentStore().Info(ctx, desc.Digest)
 		if errors.Is(err, errdefs.ErrNotFound) {
@@ -423,19 +798,14 @@ func walkImage(ctx context.Context, client *client.Client, img oci.Image) ([]oci
 		if err != nil {
 			return nil, err
 		}
-		ref := oci.Reference{
-			Registry:   img.Registry,
-			Repository: img.Repository,
-			Digest:     desc.Digest,
-		}
-		refs = append(refs, ref)
+		descs = append(descs, desc)
 		return nil, nil
 	})
 	err = images.Walk(ctx, images.Handlers(handler, images.ChildrenHandler(client.ContentStore())), cImgs[0].Target)
 	if err != nil {
 		return nil, err
 	}
-	return refs, nil
+	return descs, nil
 }
 
 func contentLabelsToReferences(l map[string]string, dgst digest.Digest) ([]oci.Reference, error) {
@@ -1,18 +1,33 @@
 package containerd
 
 import (
+	"context"
+	"errors"
 	"io"
 	"net"
 	"os"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	eventtypes "github.com/containerd/containerd/api/events"
+	"github.com/containerd/containerd/v2/client"
+	"github.com/containerd/containerd/v2/core/content"
 	"github.com/containerd/containerd/v2/core/events"
+	"github.com/containerd/containerd/v2/core/leases"
+	"github.com/containerd/containerd/v2/pkg/filters"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+	"github.com/containerd/errdefs"
 	"github.com/containerd/typeurl/v2"
 	"github.com/go-openapi/testify/v2/require"
 	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 
+	"github.com/spegel-org/spegel/pkg/httpx"
 	"github.com/spegel-org/spegel/pkg/oci"
 	"github.com/spegel-org/spegel/pkg/store"
 )
@@ -23,6 +38,18 @@ func TestContainerd(t *testing.T) {
 	ctrd, err := NewContainerd(t.Context(), "test.sock", "", WithContentPath("foobar"), WithConnection(&net.UnixConn{}))
 	require.NoError(t, err)
 	require.EqualT(t, "foobar", ctrd.contentPath)
+	require.SliceEqualT(t, []string{""}, ctrd.namespaces)
+	ready, err := ctrd.Ready(t.Context())
+	require.NoError(t, err)
+	require.TrueT(t, ready)
+
+	ociFilters := []oci.Filter{oci.RegexFilter{Regex: regexp.MustCompile("foo")}}
+	ctrd, err = NewContainerd(t.Context(), "test.sock", "k8s.io", WithContentPath("foobar"), WithConnection(&net.UnixConn{}), WithFilters(ociFilters), WithAdditionalNamespaces([]string{"moby", "k8s.io", "build"}))
+	require.NoError(t, err)
+	require.SliceEqualT(t, []string{"k8s.io", "moby", "build"}, ctrd.namespaces)
+	require.Len(t, ctrd.filters, 1)
+	_, err = NewContainerd(t.Context(), "test.sock", "k8s.io", WithContentPath("foobar"), WithConnection(&net.UnixConn{}), WithRetentionGrace(-time.Second))
+	require.EqualError(t, err, "retention grace cannot be negative")
 
 	contentPath := t.TempDir()
 	data := []byte("Hello World")
@@ -32,19 +59,185 @@ func TestContainerd(t *testing.T) {
 	require.NoError(t, err)
 	err = os.WriteFile(fp, data, 0o644)
 	require.NoError(t, err)
+	// Content only exists in the second namespace, which is the only namespace it is leased in.
+	contentStore := &testContentStore{
+		infos: map[string]content.Info{"moby/" + dgst.String(): {Digest: dgst, Size: int64(len(data))}},
+	}
+	leaseManager := newTestLeaseManager()
 	ctrd = &Containerd{
-		contentPath: contentPath,
+		descIdx:        newDescriptorIndex(""),
+		contentPath:    contentPath,
+		namespaces:     []string{"k8s.io", "moby"},
+		retentionGrace: time.Minute,
 	}
+	storeTestClient(t, ctrd, leaseManager, contentStore)
 	rc, err := ctrd.Open(t.Context(), digest.FromBytes(nil))
 	require.ErrorIs(t, err, store.ErrNotFound)
 	require.Nil(t, rc)
+	require.Empty(t, leaseManager.resources)
 	rc, err = ctrd.Open(t.Context(), dgst)
 	require.NoError(t, err)
+	require.SliceEqualT(t, []string{"moby/" + dgst.String()}, leaseManager.leasedContent())
 	b, err := io.ReadAll(rc)
 	require.NoError(t, err)
 	err = rc.Close()
 	require.NoError(t, err)
 	require.EqualT(t, dgst, digest.FromBytes(b))
+
+	// Read leases are replaced by leases expiring after the retention grace.
+	require.SliceEqualT(t, []string{"moby/" + dgst.String()}, leaseManager.leasedContent())
+	for _, l := range leaseManager.leases {
+		expire, err := time.Parse(time.RFC3339, l.Labels["containerd.io/gc.expire"])
+		require.NoError(t, err)
+		require.LessOrEqualT(t, expire, time.Now().Add(time.Minute))
+	}
+	err = rc.Close()
+	require.Error(t, err)
+	require.Len(t, leaseManager.leases, 1)
+
+	// Background reads and fingerprinting are not leased or retained.
+	leaseManager = newTestLeaseManager()
+	storeTestClient(t, ctrd, leaseManager, contentStore)
+	rc, err = ctrd.Open(store.WithBackgroundRead(t.Context()), dgst)
+	require.NoError(t, err)
+	err = rc.Close()
+	require.NoError(t, err)
+	desc, err := ctrd.Descriptor(t.Context(), dgst)
+	require.NoError(t, err)
+	require.EqualT(t, httpx.ContentTypeBinary, desc.MediaType)
+	require.Empty(t, leaseManager.leases)
+
+	// Content is read without a lease when leasing fails.
+	leaseManager.createErr = errors.New("connection refused")
+	rc, err = ctrd.Open(t.Context(), dgst)
+	require.NoError(t, err)
+	b, err = io.ReadAll(rc)
+	require.NoError(t, err)
+	require.SliceEqualT(t, data, b)
+	err = rc.Close()
+	require.NoError(t, err)
+	require.Empty(t, leaseManager.leases)
+	require.TrueT(t, ctrd.leaseFailing.Load())
+	leaseManager.createErr = nil
+	rc, err = ctrd.Open(t.Context(), dgst)
+	require.NoError(t, err)
+	err = rc.Close()
+	require.NoError(t, err)
+	require.FalseT(t, ctrd.leaseFailing.Load())
+}
+
+// storeTestClient replaces the containerd client with one backed by the lease manager and content store.
+func storeTestClient(t *testing.T, ctrd *Containerd, leaseManager leases.Manager, contentStore content.Store) {
+	t.Helper()
+
+	ctrdClient, err := client.New("", client.WithServices(client.WithLeasesService(leaseManager), client.WithContentStore(contentStore)))
+	require.NoError(t, err)
+	ctrd.ctrdClient.Store(ctrdClient)
+}
+
+// testContentStore returns content info for namespaced digests.
+type testContentStore struct {
+	content.Store
+	infos map[string]content.Info
+}
+
+func (s *testContentStore) Info(ctx context.Context, dgst digest.Digest) (content.Info, error) {
+	ns, _ := namespaces.Namespace(ctx)
+	info, ok := s.infos[ns+"/"+dgst.String()]
+	if !ok {
+		return content.Info{}, errdefs.ErrNotFound
+	}
+	return info, nil
+}
+
+type testLeaseManager struct {
+	createErr error
+	leases    map[string]leases.Lease
+	resources map[string][]leases.Resource
+	mx        sync.Mutex
+}
+
+func newTestLeaseManager() *testLeaseManager {
+	return &testLeaseManager{
+		leases:    map[string]leases.Lease{},
+		resources: map[string][]leases.Resource{},
+	}
+}
+
+func leaseKey(ctx context.Context, l leases.Lease) string {
+	ns, _ := namespaces.Namespace(ctx)
+	return ns + "/" + l.ID
+}
+
+// leasedContent returns the namespaced content referenced by any lease.
+func (m *testLeaseManager) leasedContent() []string {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	leased := []string{}
+	for key, resources := range m.resources {
+		ns, _, _ := strings.Cut(key, "/")
+		for _, r := range resources {
+			leased = append(leased, ns+"/"+r.ID)
+		}
+	}
+	slices.Sort(leased)
+	return leased
+}
+
+func (m *testLeaseManager) Create(ctx context.Context, opts ...leases.Opt) (leases.Lease, error) {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	if m.createErr != nil {
+		return leases.Lease{}, m.createErr
+	}
+	l := leases.Lease{CreatedAt: time.Now()}
+	for _, opt := range opts {
+		err := opt(&l)
+		if err != nil {
+			return leases.Lease{}, err
+		}
+	}
+	m.leases[leaseKey(ctx, l)] = l
+	return l, nil
+}
+
+func (m *testLeaseManager) Delete(ctx context.Context, l leases.Lease, _ ...leases.DeleteOpt) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	key := leaseKey(ctx, l)
+	if _, ok := m.leases[key]; !ok {
+		return errdefs.ErrNotFound
+	}
+	delete(m.leases, key)
+	delete(m.resources, key)
+	return nil
+}
+
+func (m *testLeaseManager) List(ctx context.Context, _ ...string) ([]leases.Lease, error) {
+	return nil, errdefs.ErrNotImplemented
+}
+
+func (m *testLeaseManager) AddResource(ctx context.Context, l leases.Lease, r leases.Resource) error {
+	m.mx.Lock()
+	defer m.mx.Unlock()
+
+	key := leaseKey(ctx, l)
+	if _, ok := m.leases[key]; !ok {
+		return errdefs.ErrNotFound
+	}
+	m.resources[key] = append(m.resources[key], r)
+	return nil
+}
+
+func (m *testLeaseManager) DeleteResource(ctx context.Context, l leases.Lease, r leases.Resource) error {
+	return errdefs.ErrNotImplemented
+}
+
+func (m *testLeaseManager) ListResources(ctx context.Context, l leases.Lease) ([]leases.Resource, error) {
+	return nil, errdefs.ErrNotImplemented
 }
 
 func TestHandleEvent(t *testing.T) {
@@ -62,6 +255,110 @@ func TestHandleEvent(t *testing.T) {
 	require.Empty(t, storeEvts)
 }
 
+func TestHandleEventNamespaces(t *testing.T) {
+	t.Parallel()
+
+	dgst := digest.Digest("sha256:b6d6089ca6c395fd563c2084f5dd7bc56a2f5e6a81413558c5be0083287a77e9")
+	ctrd := Containerd{namespaces: []string{"k8s.io", "moby"}}
+	event, err := typeurl.MarshalAny(&eventtypes.ImageDelete{Name: "docker.io/library/alpine@" + dgst.String()})
+	require.NoError(t, err)
+
+	// Content still indexed in another namespace should not be advertised as deleted.
+	contentIdx := map[contentKey][]ocispec.Descriptor{
+		{namespace: "moby", dgst: dgst}: {{Digest: dgst}},
+	}
+	storeEvts, err := ctrd.handleEvent(t.Context(), events.Envelope{Namespace: "k8s.io", Event: event}, contentIdx)
+	require.NoError(t, err)
+	require.Empty(t, storeEvts)
+
+	contentIdx = map[contentKey][]ocispec.Descriptor{}
+	storeEvts, err = ctrd.handleEvent(t.Context(), events.Envelope{Namespace: "k8s.io", Event: event}, contentIdx)
+	require.NoError(t, err)
+	require.SliceEqualT(t, []store.Event{{Type: store.DeleteEvent, Digest: dgst}}, storeEvts)
+}
+
+func TestHandleSubscription(t *testing.T) {
+	t.Parallel()
+
+	ctrd := Containerd{}
+	errCh := make(chan error, 1)
+	errCh <- errors.New("connection reset")
+	sub := subscription{
+		envelopeCh: make(chan *events.Envelope),
+		errCh:      errCh,
+		contentIdx: map[contentKey][]ocispec.Descriptor{},
+	}
+	err := ctrd.handleSubscription(t.Context(), sub, store.NewSnapshot(), make(chan store.Event))
+	require.EqualError(t, err, "connection reset")
+
+	close(errCh)
+	err = ctrd.handleSubscription(t.Context(), sub, store.NewSnapshot(), make(chan store.Event))
+	require.EqualError(t, err, "containerd event subscription closed")
+
+	ctx, cancel := context.WithCancel(t.Context())
+	cancel()
+	sub.errCh = make(chan error)
+	err = ctrd.handleSubscription(ctx, sub, store.NewSnapshot(), make(chan store.Event))
+	require.ErrorIs(t, err, context.Canceled)
+}
+
+func TestTrackEvents(t *testing.T) {
+	t.Parallel()
+
+	manifestDgst := digest.Digest("sha256:b6d6089ca6c395fd563c2084f5dd7bc56a2f5e6a81413558c5be0083287a77e9")
+	layerDgst := digest.Digest("sha256:c6d6089ca6c395fd563c2084f5dd7bc56a2f5e6a81413558c5be0083287a77e9")
+	snapshot := store.NewSnapshot()
+	trackEvents(snapshot, []store.Event{
+		{Type: store.CreateEvent, Reference: "docker.io/library/alpine:3.20", Digest: manifestDgst, MediaType: ocispec.MediaTypeImageManifest, Repository: "docker.io/library/alpine"},
+		{Type: store.CreateEvent, Digest: layerDgst, MediaType: ocispec.MediaTypeImageLayerGzip, Repository: "docker.io/library/alpine"},
+	})
+	require.Len(t, snapshot.Tags, 1)
+	require.EqualT(t, manifestDgst, snapshot.Tags["docker.io/library/alpine:3.20"])
+	require.Len(t, snapshot.Content, 2)
+	require.EqualT(t, "", snapshot.Content[manifestDgst].Reference)
+
+	// Content removed while disconnected is deleted when resynchronizing.
+	curr := store.NewSnapshot()
+	trackEvents(curr, []store.Event{
+		{Type: store.CreateEvent, Digest: layerDgst, MediaType: ocispec.MediaTypeImageLayerGzip, Repository: "docker.io/library/alpine"},
+	})
+	deleted := []store.Event{}
+	for _, event := range store.DiffSnapshots(snapshot, curr) {
+		if event.Type == store.DeleteEvent {
+			deleted = append(deleted, event)
+		}
+	}
+	expected := []store.Event{
+		{Type: store.DeleteEvent, Reference: "docker.io/library/alpine:3.20"},
+		{Type: store.DeleteEvent, Digest: manifestDgst, MediaType: ocispec.MediaTypeImageManifest},
+	}
+	require.SliceEqualT(t, expected, deleted)
+
+	trackEvents(snapshot, []store.Event{
+		{Type: store.DeleteEvent, Reference: "docker.io/library/alpine:3.20"},
+		{Type: store.DeleteEvent, Digest: manifestDgst},
+	})
+	require.Empty(t, snapshot.Tags)
+	require.Len(t, snapshot.Content, 1)
+}
+
+func TestEventFilters(t *testing.T) {
+	t.Parallel()
+
+	ctrd := Containerd{namespaces: []string{"k8s.io", "moby"}}
+	expected := []string{
+		`namespace=="k8s.io",topic~="/images/create|/images/delete",event.name~="^.+/"`,
+		`namespace=="k8s.io",topic~="/content/create"`,
+		`namespace=="moby",topic~="/images/create|/images/delete",event.name~="^.+/"`,
+		`namespace=="moby",topic~="/content/create"`,
+	}
+	require.SliceEqualT(t, expected, ctrd.eventFilters())
+	for _, f := range ctrd.eventFilters() {
+		_, err := filters.Parse(f)
+		require.NoError(t, err)
+	}
+}
+
 func TestContentLabelsToReferences(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,131 @@
+package containerd
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+)
+
+const descriptorIndexFile = "containerd-descriptors.json"
+
+// descriptorIndex maps content digests to their media types.
+// The index is persisted to disk when a path is set so that media types are known after restarts.
+type descriptorIndex struct {
+	mediaTypes map[digest.Digest]string
+	path       string
+	mx         sync.RWMutex
+	dirty      bool
+}
+
+// newDescriptorIndex creates an index persisted to the path. An empty path results in an in memory index.
+func newDescriptorIndex(path string) *descriptorIndex {
+	return &descriptorIndex{
+		mediaTypes: map[digest.Digest]string{},
+		path:       path,
+	}
+}
+
+// Load reads the persisted index from disk.
+func (d *descriptorIndex) Load() error {
+	if d.path == "" {
+		return nil
+	}
+	b, err := os.ReadFile(d.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	mediaTypes := map[digest.Digest]string{}
+	err = json.Unmarshal(b, &mediaTypes)
+	if err != nil {
+		return err
+	}
+
+	d.mx.Lock()
+	defer d.mx.Unlock()
+	d.mediaTypes = mediaTypes
+	return nil
+}
+
+func (d *descriptorIndex) Get(dgst digest.Digest) (string, bool) {
+	d.mx.RLock()
+	defer d.mx.RUnlock()
+
+	mt, ok := d.mediaTypes[dgst]
+	return mt, ok
+}
+
+func (d *descriptorIndex) Add(dgst digest.Digest, mt string) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	if d.mediaTypes[dgst] == mt {
+		return
+	}
+	d.mediaTypes[dgst] = mt
+	d.dirty = true
+}
+
+func (d *descriptorIndex) Remove(dgst digest.Digest) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	if _, ok := d.mediaTypes[dgst]; !ok {
+		return
+	}
+	delete(d.mediaTypes, dgst)
+	d.dirty = true
+}
+
+// Replace sets the content of the index, dropping entries for content which no longer exists.
+func (d *descriptorIndex) Replace(mediaTypes map[digest.Digest]string) {
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	if maps.Equal(d.mediaTypes, mediaTypes) {
+		return
+	}
+	d.mediaTypes = maps.Clone(mediaTypes)
+	d.dirty = true
+}
+
+// Flush writes the index to disk if it has been modified since it was last written.
+func (d *descriptorIndex) Flush() error {
+	if d.path == "" {
+		return nil
+	}
+
+	d.mx.Lock()
+	defer d.mx.Unlock()
+
+	if !d.dirty {
+		return nil
+	}
+	b, err := json.Marshal(d.mediaTypes)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(d.path), 0o755)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so that a partially written index is never read.
+	tmpPath := d.path + ".tmp"
+	err = os.WriteFile(tmpPath, b, 0o644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpPath, d.path)
+	if err != nil {
+		return err
+	}
+	d.dirty = false
+	return nil
+}
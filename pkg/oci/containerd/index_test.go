@@ -0,0 +1,69 @@
+package containerd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-openapi/testify/v2/require"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestDescriptorIndex(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "data", descriptorIndexFile)
+	manifestDgst := digest.FromString("manifest")
+	layerDgst := digest.FromString("layer")
+
+	idx := newDescriptorIndex(path)
+	err := idx.Load()
+	require.NoError(t, err)
+	_, ok := idx.Get(manifestDgst)
+	require.FalseT(t, ok)
+
+	// Nothing should be written until the index is modified.
+	err = idx.Flush()
+	require.NoError(t, err)
+	_, err = os.Stat(path)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	idx.Replace(map[digest.Digest]string{
+		manifestDgst: ocispec.MediaTypeImageManifest,
+		layerDgst:    ocispec.MediaTypeImageLayerGzip,
+	})
+	idx.Remove(layerDgst)
+	err = idx.Flush()
+	require.NoError(t, err)
+
+	// Media types should be known after a restart.
+	idx = newDescriptorIndex(path)
+	err = idx.Load()
+	require.NoError(t, err)
+	mt, ok := idx.Get(manifestDgst)
+	require.TrueT(t, ok)
+	require.EqualT(t, ocispec.MediaTypeImageManifest, mt)
+	_, ok = idx.Get(layerDgst)
+	require.FalseT(t, ok)
+
+	err = os.WriteFile(path, []byte("foobar"), 0o644)
+	require.NoError(t, err)
+	err = newDescriptorIndex(path).Load()
+	require.Error(t, err)
+}
+
+func TestDescriptorIndexInMemory(t *testing.T) {
+	t.Parallel()
+
+	idx := newDescriptorIndex("")
+	err := idx.Load()
+	require.NoError(t, err)
+	dgst := digest.FromString("config")
+	idx.Add(dgst, ocispec.MediaTypeImageConfig)
+	err = idx.Flush()
+	require.NoError(t, err)
+	mt, ok := idx.Get(dgst)
+	require.TrueT(t, ok)
+	require.EqualT(t, ocispec.MediaTypeImageConfig, mt)
+}
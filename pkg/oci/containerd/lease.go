@@ -0,0 +1,75 @@
+package containerd
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/leases"
+	"github.com/containerd/containerd/v2/pkg/namespaces"
+	"github.com/opencontainers/go-digest"
+)
+
+const (
+	// readLeaseExpiration limits how long content is retained if a read lease is never released.
+	readLeaseExpiration  = time.Hour
+	leaseResourceContent = "content"
+)
+
+// lease protects the content in the namespace from garbage collection until the returned release function is called.
+// Content is only garbage collected once it is unreferenced in all namespaces, so a single lease in a namespace holding it is enough.
+// When released with retain the content is protected for the retention grace period.
+func (c *Containerd) lease(ctx context.Context, ns string, dgst digest.Digest) (func(retain bool) error, error) {
+	lm := c.client().LeasesService()
+	resource := leases.Resource{ID: dgst.String(), Type: leaseResourceContent}
+
+	nsCtx := namespaces.WithNamespace(ctx, ns)
+	l, err := lm.Create(nsCtx, leases.WithRandomID(), leases.WithExpiration(readLeaseExpiration))
+	if err != nil {
+		return nil, err
+	}
+	releaseCtx := namespaces.WithNamespace(context.WithoutCancel(ctx), ns)
+	release := func(retain bool) error {
+		errs := []error{}
+		if retain && c.retentionGrace > 0 {
+			err := func() error {
+				graceLease, err := lm.Create(releaseCtx, leases.WithRandomID(), leases.WithExpiration(c.retentionGrace))
+				if err != nil {
+					return err
+				}
+				return lm.AddResource(releaseCtx, graceLease, resource)
+			}()
+			if err != nil {
+				errs = append(errs, err)
+			}
+		}
+		err := lm.Delete(releaseCtx, l)
+		if err != nil {
+			errs = append(errs, err)
+		}
+		return errors.Join(errs...)
+	}
+	err = lm.AddResource(nsCtx, l, resource)
+	if err != nil {
+		return nil, errors.Join(err, release(false))
+	}
+	return release, nil
+}
+
+// leasedReadSeekCloser releases the content lease when closed.
+type leasedReadSeekCloser struct {
+	io.ReadSeekCloser
+	release     func(retain bool) error
+	releaseOnce sync.Once
+}
+
+func (l *leasedReadSeekCloser) Close() error {
+	err := l.ReadSeekCloser.Close()
+	var releaseErr error
+	l.releaseOnce.Do(func() {
+		releaseErr = l.release(true)
+	})
+	return errors.Join(err, releaseErr)
+}
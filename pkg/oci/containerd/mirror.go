@@ -5,12 +5,13 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"io"
 	"io/fs"
+	"maps"
 	"net/url"
 	"os"
 	"path"
 	"path/filepath"
+	"slices"
 	"strings"
 	"text/template"
 
@@ -18,6 +19,7 @@ import (
 	"github.com/pelletier/go-toml/v2"
 	tomlu "github.com/pelletier/go-toml/v2/unstable"
 
+	"github.com/spegel-org/spegel/internal/diff"
 	"github.com/spegel-org/spegel/pkg/httpx"
 	"github.com/spegel-org/spegel/pkg/oci"
 )
@@ -26,18 +28,19 @@ const (
 	backupDir = "_backup"
 )
 
+// AddMirrorConfiguration mirrors all registries to the same targets.
+func AddMirrorConfiguration(ctx context.Context, configPath string, mirroredRegistries, mirrorTargets []string, resolveTags, prependExisting bool, userinfo *url.Userinfo) error {
+	cfg := NewMirrorConfig(mirroredRegistries, mirrorTargets, resolveTags)
+	return WriteMirrorConfiguration(ctx, configPath, cfg, prependExisting, userinfo)
+}
+
 // Refer to containerd registry configuration documentation for more information about required configuration.
 // https://github.com/containerd/containerd/blob/main/docs/cri/config.md#registry-configuration
 // https://github.com/containerd/containerd/blob/main/docs/hosts.md#registry-configuration---examples
-func AddMirrorConfiguration(ctx context.Context, configPath string, mirroredRegistries, mirrorTargets []string, resolveTags, prependExisting bool, userinfo *url.Userinfo) error {
+func WriteMirrorConfiguration(ctx context.Context, configPath string, cfg MirrorConfig, prependExisting bool, userinfo *url.Userinfo) error {
 	log := logr.FromContextOrDiscard(ctx)
 
-	// Parse and verify mirror urls.
-	parsedMirroredRegistries, err := oci.ParseRegistries(mirroredRegistries, true)
-	if err != nil {
-		return err
-	}
-	parsedMirrorTargets, err := oci.ParseRegistries(mirrorTargets, false)
+	files, err := renderMirrorConfiguration(ctx, configPath, cfg, prependExisting, userinfo)
 	if err != nil {
 		return err
 	}
@@ -57,23 +60,89 @@ func AddMirrorConfiguration(ctx context.Context, configPath string, mirroredRegi
 	}
 
 	// Write mirror configuration
-	capabilities := []string{"pull"}
-	if resolveTags {
-		capabilities = append(capabilities, "resolve")
-	}
-	for _, mr := range parsedMirroredRegistries {
-		templatedHosts, err := templateHosts(mr, parsedMirrorTargets, capabilities, userinfo)
+	for _, relPath := range slices.Sorted(maps.Keys(files)) {
+		fp := filepath.Join(configPath, relPath)
+		err = os.MkdirAll(filepath.Dir(fp), 0o755)
+		if err != nil {
+			return err
+		}
+		err = os.WriteFile(fp, files[relPath], 0o644)
 		if err != nil {
 			return err
 		}
+		if filepath.Base(fp) == "hosts.toml" {
+			log.Info("added containerd mirror configuration", "registry", filepath.Dir(relPath), "path", fp)
+		}
+	}
+	return nil
+}
+
+// DiffMirrorConfiguration returns a unified diff between the current configuration and the configuration that would be written.
+// An empty diff means that the configuration has not drifted.
+func DiffMirrorConfiguration(ctx context.Context, configPath string, cfg MirrorConfig, prependExisting bool, userinfo *url.Userinfo) (string, error) {
+	expected, err := renderMirrorConfiguration(ctx, configPath, cfg, prependExisting, userinfo)
+	if err != nil {
+		return "", err
+	}
+	current, err := readConfigFiles(configPath)
+	if err != nil {
+		return "", err
+	}
+	relPaths := slices.Collect(maps.Keys(expected))
+	for relPath := range current {
+		if _, ok := expected[relPath]; !ok {
+			relPaths = append(relPaths, relPath)
+		}
+	}
+	slices.Sort(relPaths)
+	sb := &strings.Builder{}
+	for _, relPath := range relPaths {
+		oldName, newName := "a/"+relPath, "b/"+relPath
+		oldContent, ok := current[relPath]
+		if !ok {
+			oldName = ""
+		}
+		newContent, ok := expected[relPath]
+		if !ok {
+			newName = ""
+		}
+		sb.WriteString(diff.Unified(oldName, newName, string(oldContent), string(newContent)))
+	}
+	return sb.String(), nil
+}
+
+// renderMirrorConfiguration returns the content of all files in the config path after the configuration is written.
+// Existing configuration is read from the backup directory, or from the config path if it has not been backed up yet.
+func renderMirrorConfiguration(ctx context.Context, configPath string, cfg MirrorConfig, prependExisting bool, userinfo *url.Userinfo) (map[string][]byte, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+	existingDir, err := existingConfigDir(configPath)
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string][]byte{}
+	for _, regCfg := range cfg.Registries {
+		mr, _, err := regCfg.Parse()
+		if err != nil {
+			return nil, err
+		}
+		templatedHosts, err := templateHosts(regCfg, userinfo)
+		if err != nil {
+			return nil, err
+		}
 		if prependExisting {
 			existingHosts, err := existingHosts(configPath, mr)
 			if err != nil {
-				return err
+				return nil, err
 			}
 			if existingHosts != "" {
 				// If we are prepending we also want to keep files like certificates that may be referenced.
-				backupRegDir := path.Join(configPath, backupDir, mr.Host)
+				backupRegDir := filepath.Join(existingDir, mr.Host)
 				err = filepath.WalkDir(backupRegDir, func(path string, d fs.DirEntry, err error) error {
 					if err != nil {
 						return err
@@ -84,52 +153,74 @@ func AddMirrorConfiguration(ctx context.Context, configPath string, mirroredRegi
 					if d.Name() == "hosts.toml" {
 						return nil
 					}
-					src, err := os.Open(path)
-					if err != nil {
-						return err
-					}
-					defer src.Close()
 					relPath, err := filepath.Rel(backupRegDir, path)
 					if err != nil {
 						return err
 					}
-					dstPath := filepath.Join(configPath, mr.Host, relPath)
-					err = os.MkdirAll(filepath.Dir(dstPath), 0o755)
-					if err != nil {
-						return err
-					}
-					dst, err := os.OpenFile(dstPath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
-					if err != nil {
-						return err
-					}
-					defer dst.Close()
-					_, err = io.Copy(dst, src)
+					b, err := os.ReadFile(path)
 					if err != nil {
 						return err
 					}
+					files[filepath.Join(mr.Host, relPath)] = b
 					return nil
 				})
 				if err != nil {
-					return err
+					return nil, err
 				}
 
 				templatedHosts = templatedHosts + "\n\n" + existingHosts
 				log.Info("prepending to existing containerd mirror configuration", "registry", mr.String())
 			}
+		}
+		files[filepath.Join(mr.Host, "hosts.toml")] = []byte(templatedHosts)
+	}
+	return files, nil
+}
+
+// existingConfigDir returns the directory containing the configuration that existed before mirrors were configured.
+func existingConfigDir(configPath string) (string, error) {
+	backupDirPath := filepath.Join(configPath, backupDir)
+	ok, err := dirExists(backupDirPath)
+	if err != nil {
+		return "", err
+	}
+	if ok {
+		return backupDirPath, nil
+	}
+	return configPath, nil
+}
 
+// readConfigFiles returns the content of all files in the config path except for backups, keyed by relative path.
+func readConfigFiles(configPath string) (map[string][]byte, error) {
+	files := map[string][]byte{}
+	err := filepath.WalkDir(configPath, func(path string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && path == configPath {
+			return fs.SkipAll
 		}
-		fp := path.Join(configPath, mr.Host, "hosts.toml")
-		err = os.MkdirAll(filepath.Dir(fp), 0o755)
 		if err != nil {
 			return err
 		}
-		err = os.WriteFile(fp, []byte(templatedHosts), 0o644)
+		relPath, err := filepath.Rel(configPath, path)
 		if err != nil {
 			return err
 		}
-		log.Info("added containerd mirror configuration", "registry", mr.String(), "path", fp)
+		if d.IsDir() {
+			if relPath == backupDir {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		files[relPath] = b
+		return nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	return nil
+	return files, nil
 }
 
 func CleanupMirrorConfiguration(ctx context.Context, configPath string) error {
@@ -223,7 +314,11 @@ func clearConfig(configPath string) error {
 	return nil
 }
 
-func templateHosts(parsedMirrorRegistry url.URL, parsedMirrorTargets []url.URL, capabilities []string, userinfo *url.Userinfo) (string, error) {
+func templateHosts(regCfg RegistryMirrorConfig, userinfo *url.Userinfo) (string, error) {
+	parsedMirrorRegistry, parsedMirrorTargets, err := regCfg.Parse()
+	if err != nil {
+		return "", err
+	}
 	server := parsedMirrorRegistry.String()
 	if parsedMirrorRegistry.String() == "https://docker.io" {
 		server = "https://registry-1.docker.io"
@@ -231,31 +326,62 @@ func templateHosts(parsedMirrorRegistry url.URL, parsedMirrorTargets []url.URL,
 	if parsedMirrorRegistry == oci.WildcardRegistryURL {
 		server = ""
 	}
-	authorization := ""
+	headers := maps.Clone(regCfg.Headers)
 	if userinfo != nil {
-		authorization = httpx.UserinfoHeaderValue(*userinfo)
+		if headers == nil {
+			headers = map[string]string{}
+		}
+		if _, ok := headers[httpx.HeaderAuthorization]; !ok {
+			headers[httpx.HeaderAuthorization] = httpx.UserinfoHeaderValue(*userinfo)
+		}
+	}
+	dialTimeout := regCfg.DialTimeout
+	if dialTimeout == 0 {
+		dialTimeout = defaultDialTimeout
+	}
+	ca := ""
+	if len(regCfg.CAFiles) > 0 {
+		ca = fmt.Sprintf("['%s']", strings.Join(regCfg.CAFiles, "', '"))
 	}
 
 	hc := struct {
-		Authorization string
+		Headers       map[string]string
 		Server        string
 		Capabilities  string
+		DialTimeout   string
+		CA            string
 		MirrorTargets []url.URL
+		SkipVerify    bool
+		OverridePath  bool
 	}{
+		Headers:       headers,
 		Server:        server,
-		Capabilities:  fmt.Sprintf("['%s']", strings.Join(capabilities, "', '")),
+		Capabilities:  fmt.Sprintf("['%s']", strings.Join(regCfg.Capabilities, "', '")),
+		DialTimeout:   dialTimeout.String(),
+		CA:            ca,
 		MirrorTargets: parsedMirrorTargets,
-		Authorization: authorization,
+		SkipVerify:    regCfg.SkipVerify,
+		OverridePath:  regCfg.OverridePath,
 	}
 	tmpl, err := template.New("").Parse(`{{- with .Server }}server = '{{ . }}'{{ end }}
-{{- $authorization := .Authorization }}
 {{ range .MirrorTargets }}
 [host.'{{ .String }}']
 capabilities = {{ $.Capabilities }}
-dial_timeout = '200ms'
-{{- if $authorization }}
+dial_timeout = '{{ $.DialTimeout }}'
+{{- if $.SkipVerify }}
+skip_verify = true
+{{- end }}
+{{- with $.CA }}
+ca = {{ . }}
+{{- end }}
+{{- if $.OverridePath }}
+override_path = true
+{{- end }}
+{{- if $.Headers }}
 [host.'{{ .String }}'.header]
-Authorization = '{{ $authorization }}'
+{{- range $name, $value := $.Headers }}
+{{ $name }} = '{{ $value }}'
+{{- end }}
 {{- end }}
 {{ end }}`)
 	if err != nil {
@@ -270,7 +396,11 @@ Authorization = '{{ $authorization }}'
 }
 
 func existingHosts(configPath string, parsedMirrorRegistry url.URL) (string, error) {
-	fp := path.Join(configPath, backupDir, parsedMirrorRegistry.Host, "hosts.toml")
+	existingDir, err := existingConfigDir(configPath)
+	if err != nil {
+		return "", err
+	}
+	fp := filepath.Join(existingDir, parsedMirrorRegistry.Host, "hosts.toml")
 	b, err := os.ReadFile(fp)
 	if errors.Is(err, os.ErrNotExist) {
 		return "", nil
@@ -278,13 +408,17 @@ func existingHosts(configPath string, parsedMirrorRegistry url.URL) (string, err
 	if err != nil {
 		return "", err
 	}
+	return parseHosts(b, nil)
+}
 
+// parseHosts returns the host sections of a hosts file, excluding the given hosts.
+func parseHosts(b []byte, exclude []string) (string, error) {
 	type hostFile struct {
 		Hosts map[string]any `toml:"host"`
 	}
 
 	var hf hostFile
-	err = toml.Unmarshal(b, &hf)
+	err := toml.Unmarshal(b, &hf)
 	if err != nil {
 		return "", err
 	}
@@ -306,7 +440,11 @@ func existingHosts(configPath string, parsedMirrorRegistry url.URL) (string, err
 		}
 		ki := e.Key()
 		if ki.Next() && string(ki.Node().Data) == "host" && ki.Next() && ki.IsLast() {
-			hosts = append(hosts, string(ki.Node().Data))
+			host := string(ki.Node().Data)
+			if slices.Contains(exclude, host) {
+				continue
+			}
+			hosts = append(hosts, host)
 		}
 	}
 
@@ -502,3 +502,60 @@ func TestCleanupMirrorConfiguration(t *testing.T) {
 		require.EqualT(t, "data.txt", files[0].Name())
 	}
 }
+
+func TestDiffMirrorConfiguration(t *testing.T) {
+	t.Parallel()
+
+	configPath := filepath.Join(t.TempDir(), "certs.d")
+	cfg := NewMirrorConfig([]string{"https://docker.io"}, []string{"http://127.0.0.1:5000"}, true)
+
+	// Missing configuration is reported as created files.
+	diff, err := DiffMirrorConfiguration(t.Context(), configPath, cfg, false, nil)
+	require.NoError(t, err)
+	expected := `--- /dev/null
++++ b/docker.io/hosts.toml
+@@ -0,0 +1,5 @@
++server = 'https://registry-1.docker.io'
++
++[host.'http://127.0.0.1:5000']
++capabilities = ['pull', 'resolve']
++dial_timeout = '200ms'
+\ No newline at end of file
+`
+	require.EqualT(t, expected, diff)
+	_, err = os.Stat(configPath)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	// Written configuration has no drift.
+	err = WriteMirrorConfiguration(t.Context(), configPath, cfg, false, nil)
+	require.NoError(t, err)
+	diff, err = DiffMirrorConfiguration(t.Context(), configPath, cfg, false, nil)
+	require.NoError(t, err)
+	require.Empty(t, diff)
+
+	// Modified and unexpected files are reported.
+	err = os.WriteFile(filepath.Join(configPath, "docker.io", "hosts.toml"), []byte("server = 'https://registry-1.docker.io'\n\n[host.'http://127.0.0.1:5000']\ncapabilities = ['pull']\ndial_timeout = '200ms'"), 0o644)
+	require.NoError(t, err)
+	err = os.MkdirAll(filepath.Join(configPath, "ghcr.io"), 0o755)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(configPath, "ghcr.io", "hosts.toml"), []byte("server = 'https://ghcr.io'\n"), 0o644)
+	require.NoError(t, err)
+	diff, err = DiffMirrorConfiguration(t.Context(), configPath, cfg, false, nil)
+	require.NoError(t, err)
+	expected = `--- a/docker.io/hosts.toml
++++ b/docker.io/hosts.toml
+@@ -1,5 +1,5 @@
+ server = 'https://registry-1.docker.io'
+ 
+ [host.'http://127.0.0.1:5000']
+-capabilities = ['pull']
++capabilities = ['pull', 'resolve']
+ dial_timeout = '200ms'
+\ No newline at end of file
+--- a/ghcr.io/hosts.toml
++++ /dev/null
+@@ -1 +0,0 @@
+-server = 'https://ghcr.io'
+`
+	require.EqualT(t, expected, diff)
+}
@@ -0,0 +1,165 @@
+package containerd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/spegel-org/spegel/pkg/oci"
+)
+
+const defaultDialTimeout = 200 * time.Millisecond
+
+var (
+	validCapabilities = []string{"pull", "resolve", "push"}
+	headerNameRegex   = regexp.MustCompile(`^[A-Za-z0-9-]+$`)
+)
+
+// MirrorConfig describes the hosts configuration written for each mirrored registry.
+type MirrorConfig struct {
+	Registries []RegistryMirrorConfig `yaml:"registries"`
+}
+
+// RegistryMirrorConfig configures the mirror targets of a single registry.
+type RegistryMirrorConfig struct {
+	// Headers are added to all requests sent to the mirror targets.
+	Headers map[string]string `yaml:"headers"`
+	// Registry is the URL of the mirrored registry or _default to mirror all registries.
+	Registry     string   `yaml:"registry"`
+	Targets      []string `yaml:"targets"`
+	Capabilities []string `yaml:"capabilities"`
+	// CAFiles are paths to certificate authorities used to verify the mirror targets.
+	CAFiles      []string      `yaml:"caFiles"`
+	DialTimeout  time.Duration `yaml:"dialTimeout"`
+	SkipVerify   bool          `yaml:"skipVerify"`
+	OverridePath bool          `yaml:"overridePath"`
+}
+
+// NewMirrorConfig creates a configuration mirroring all registries to the same targets.
+func NewMirrorConfig(mirroredRegistries, mirrorTargets []string, resolveTags bool) MirrorConfig {
+	capabilities := []string{"pull"}
+	if resolveTags {
+		capabilities = append(capabilities, "resolve")
+	}
+	if len(mirroredRegistries) == 0 {
+		mirroredRegistries = []string{oci.WildcardRegistries[0]}
+	}
+	cfg := MirrorConfig{}
+	for _, registry := range mirroredRegistries {
+		cfg.Registries = append(cfg.Registries, RegistryMirrorConfig{
+			Registry:     registry,
+			Targets:      slices.Clone(mirrorTargets),
+			Capabilities: slices.Clone(capabilities),
+		})
+	}
+	return cfg
+}
+
+// LoadMirrorConfig reads and validates a YAML encoded mirror configuration.
+func LoadMirrorConfig(path string) (MirrorConfig, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return MirrorConfig{}, err
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	cfg := MirrorConfig{}
+	err = dec.Decode(&cfg)
+	if err != nil {
+		return MirrorConfig{}, fmt.Errorf("could not decode mirror configuration %s: %w", path, err)
+	}
+	err = cfg.Validate()
+	if err != nil {
+		return MirrorConfig{}, fmt.Errorf("invalid mirror configuration %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// Validate returns all problems with the configuration.
+func (c MirrorConfig) Validate() error {
+	if len(c.Registries) == 0 {
+		return errors.New("at least one registry has to be configured")
+	}
+	errs := []error{}
+	seen := map[string]int{}
+	for i, reg := range c.Registries {
+		mr, _, err := reg.Parse()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("registries[%d]: %w", i, err))
+			continue
+		}
+		if j, ok := seen[mr.Host]; ok {
+			errs = append(errs, fmt.Errorf("registries[%d]: registry %s is already configured by registries[%d]", i, reg.Registry, j))
+			continue
+		}
+		seen[mr.Host] = i
+	}
+	return errors.Join(errs...)
+}
+
+// Parse validates the registry configuration and returns the parsed registry and targets.
+func (c RegistryMirrorConfig) Parse() (url.URL, []url.URL, error) {
+	errs := []error{}
+	var mr url.URL
+	if c.Registry == "" {
+		errs = append(errs, errors.New("registry cannot be empty"))
+	} else {
+		mrs, err := oci.ParseRegistries([]string{c.Registry}, true)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid registry %s: %w", c.Registry, err))
+		} else {
+			mr = mrs[0]
+		}
+	}
+	if len(c.Targets) == 0 {
+		errs = append(errs, errors.New("at least one target has to be configured"))
+	}
+	targets := []url.URL{}
+	for _, target := range c.Targets {
+		parsed, err := oci.ParseRegistries([]string{target}, false)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid target %s: %w", target, err))
+			continue
+		}
+		targets = append(targets, parsed[0])
+	}
+	if len(c.Capabilities) == 0 {
+		errs = append(errs, errors.New("at least one capability has to be configured"))
+	}
+	for _, capability := range c.Capabilities {
+		if !slices.Contains(validCapabilities, capability) {
+			errs = append(errs, fmt.Errorf("unknown capability %s, expected one of %s", capability, strings.Join(validCapabilities, ", ")))
+		}
+	}
+	if c.DialTimeout < 0 {
+		errs = append(errs, fmt.Errorf("dial timeout %s cannot be negative", c.DialTimeout))
+	}
+	for _, caFile := range c.CAFiles {
+		if caFile == "" || strings.ContainsAny(caFile, "'\n") {
+			errs = append(errs, fmt.Errorf("invalid CA file path %q", caFile))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(c.Headers)) {
+		value := c.Headers[name]
+		if !headerNameRegex.MatchString(name) {
+			errs = append(errs, fmt.Errorf("invalid header name %q", name))
+		}
+		if strings.ContainsAny(value, "'\r\n") {
+			errs = append(errs, fmt.Errorf("header %s value cannot contain single quotes or line breaks", name))
+		}
+	}
+	err := errors.Join(errs...)
+	if err != nil {
+		return url.URL{}, nil, err
+	}
+	return mr, targets, nil
+}
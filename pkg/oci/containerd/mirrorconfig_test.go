@@ -0,0 +1,126 @@
+package containerd
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/testify/v2/require"
+)
+
+func TestLoadMirrorConfig(t *testing.T) {
+	t.Parallel()
+
+	cfgPath := filepath.Join(t.TempDir(), "mirrors.yaml")
+	err := os.WriteFile(cfgPath, []byte(`registries:
+  - registry: https://docker.io
+    targets:
+      - http://127.0.0.1:5000
+      - http://127.0.0.1:5001
+    capabilities: [pull, resolve]
+    dialTimeout: 1s
+    skipVerify: true
+    caFiles:
+      - /etc/certs/ca.pem
+    overridePath: true
+    headers:
+      X-Spegel-Node: node-1
+  - registry: _default
+    targets:
+      - http://127.0.0.1:5000
+    capabilities: [pull]
+`), 0o644)
+	require.NoError(t, err)
+	cfg, err := LoadMirrorConfig(cfgPath)
+	require.NoError(t, err)
+	require.Len(t, cfg.Registries, 2)
+	require.EqualT(t, time.Second, cfg.Registries[0].DialTimeout)
+
+	configPath := filepath.Join(t.TempDir(), "certs.d")
+	err = WriteMirrorConfiguration(t.Context(), configPath, cfg, false, url.UserPassword("hello", "world"))
+	require.NoError(t, err)
+
+	b, err := os.ReadFile(filepath.Join(configPath, "docker.io", "hosts.toml"))
+	require.NoError(t, err)
+	expected := `server = 'https://registry-1.docker.io'
+
+[host.'http://127.0.0.1:5000']
+capabilities = ['pull', 'resolve']
+dial_timeout = '1s'
+skip_verify = true
+ca = ['/etc/certs/ca.pem']
+override_path = true
+[host.'http://127.0.0.1:5000'.header]
+Authorization = 'Basic aGVsbG86d29ybGQ='
+X-Spegel-Node = 'node-1'
+
+[host.'http://127.0.0.1:5001']
+capabilities = ['pull', 'resolve']
+dial_timeout = '1s'
+skip_verify = true
+ca = ['/etc/certs/ca.pem']
+override_path = true
+[host.'http://127.0.0.1:5001'.header]
+Authorization = 'Basic aGVsbG86d29ybGQ='
+X-Spegel-Node = 'node-1'`
+	require.EqualT(t, expected, string(b))
+
+	b, err = os.ReadFile(filepath.Join(configPath, "_default", "hosts.toml"))
+	require.NoError(t, err)
+	expected = `[host.'http://127.0.0.1:5000']
+capabilities = ['pull']
+dial_timeout = '200ms'
+[host.'http://127.0.0.1:5000'.header]
+Authorization = 'Basic aGVsbG86d29ybGQ='`
+	require.EqualT(t, expected, string(b))
+}
+
+func TestLoadMirrorConfigErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		content  string
+		expected string
+	}{
+		{
+			name:     "unknown field",
+			content:  "registries:\n  - registry: https://docker.io\n    skip_verify: true\n",
+			expected: "could not decode mirror configuration %s: yaml: unmarshal errors:\n  line 3: field skip_verify not found in type containerd.RegistryMirrorConfig",
+		},
+		{
+			name:     "no registries",
+			content:  "registries: []\n",
+			expected: "invalid mirror configuration %s: at least one registry has to be configured",
+		},
+		{
+			name:     "invalid registry",
+			content:  "registries:\n  - registry: ftp://docker.io\n    targets: [http://127.0.0.1:5000]\n    capabilities: [pull]\n",
+			expected: "invalid mirror configuration %s: registries[0]: invalid registry ftp://docker.io: invalid registry url scheme must be http or https: ftp://docker.io",
+		},
+		{
+			name:     "all problems are reported",
+			content:  "registries:\n  - registry: ''\n    capabilities: [fetch]\n    dialTimeout: -1s\n    caFiles: ['']\n    headers:\n      'X Foo': bar\n      X-Bar: \"it's\"\n",
+			expected: "invalid mirror configuration %s: registries[0]: registry cannot be empty\nat least one target has to be configured\nunknown capability fetch, expected one of pull, resolve, push\ndial timeout -1s cannot be negative\ninvalid CA file path \"\"\ninvalid header name \"X Foo\"\nheader X-Bar value cannot contain single quotes or line breaks",
+		},
+		{
+			name:     "duplicate registry",
+			content:  "registries:\n  - registry: https://docker.io\n    targets: [http://127.0.0.1:5000]\n    capabilities: [pull]\n  - registry: https://docker.io\n    targets: [http://127.0.0.1:5001]\n    capabilities: [pull]\n",
+			expected: "invalid mirror configuration %s: registries[1]: registry https://docker.io is already configured by registries[0]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			cfgPath := filepath.Join(t.TempDir(), "mirrors.yaml")
+			err := os.WriteFile(cfgPath, []byte(tt.content), 0o644)
+			require.NoError(t, err)
+			_, err = LoadMirrorConfig(cfgPath)
+			require.EqualError(t, err, fmt.Sprintf(tt.expected, cfgPath))
+		})
+	}
+}
@@ -0,0 +1,163 @@
+package containerd
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+
+	"github.com/spegel-org/spegel/pkg/metrics"
+)
+
+const reconcileDelay = 100 * time.Millisecond
+
+// ReconcileMirrorConfiguration watches the mirror configuration and rewrites the Spegel managed sections when they are modified.
+// Changes are detected through file system events and by checking the configuration at every interval.
+func ReconcileMirrorConfiguration(ctx context.Context, configPath string, cfg MirrorConfig, prependExisting bool, userinfo *url.Userinfo, interval time.Duration) error {
+	log := logr.FromContextOrDiscard(ctx).WithName("mirror-reconciler")
+
+	err := cfg.Validate()
+	if err != nil {
+		return err
+	}
+	watchDirs := []string{configPath}
+	for _, regCfg := range cfg.Registries {
+		mr, _, err := regCfg.Parse()
+		if err != nil {
+			return err
+		}
+		watchDirs = append(watchDirs, filepath.Join(configPath, mr.Host))
+	}
+
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer fsWatcher.Close()
+	watch := func() {
+		for _, dir := range watchDirs {
+			if slices.Contains(fsWatcher.WatchList(), dir) {
+				continue
+			}
+			err := fsWatcher.Add(dir)
+			if err != nil && !errors.Is(err, os.ErrNotExist) {
+				log.Error(err, "could not watch mirror configuration directory", "path", dir)
+			}
+		}
+	}
+	reconcile := func() {
+		watch()
+		_, err := reconcileMirrorConfiguration(ctx, configPath, cfg, prependExisting, userinfo)
+		if err != nil {
+			log.Error(err, "could not reconcile mirror configuration")
+		}
+	}
+
+	reconcile()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var reconcileCh <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case _, ok := <-fsWatcher.Events:
+			if !ok {
+				return nil
+			}
+			if reconcileCh == nil {
+				reconcileCh = time.After(reconcileDelay)
+			}
+		case err, ok := <-fsWatcher.Errors:
+			if !ok {
+				return nil
+			}
+			log.Error(err, "received mirror configuration watch error")
+		case <-reconcileCh:
+			reconcileCh = nil
+			reconcile()
+		case <-ticker.C:
+			reconcile()
+		}
+	}
+}
+
+// reconcileMirrorConfiguration rewrites the hosts files that no longer contain the Spegel managed sections and returns the amount of corrected files.
+// When prepending, hosts added by others after the configuration was written are kept after the Spegel managed sections.
+func reconcileMirrorConfiguration(ctx context.Context, configPath string, cfg MirrorConfig, prependExisting bool, userinfo *url.Userinfo) (int, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	files, err := renderMirrorConfiguration(logr.NewContext(ctx, logr.Discard()), configPath, cfg, prependExisting, userinfo)
+	if err != nil {
+		return 0, err
+	}
+
+	corrections := 0
+	errs := []error{}
+	for _, regCfg := range cfg.Registries {
+		mr, mts, err := regCfg.Parse()
+		if err != nil {
+			return 0, err
+		}
+		templatedHosts, err := templateHosts(regCfg, userinfo)
+		if err != nil {
+			return 0, err
+		}
+		fp := filepath.Join(configPath, mr.Host, "hosts.toml")
+		expected := string(files[filepath.Join(mr.Host, "hosts.toml")])
+
+		b, err := os.ReadFile(fp)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			errs = append(errs, err)
+			continue
+		}
+		current := string(b)
+		if current == expected {
+			continue
+		}
+		if prependExisting && err == nil {
+			rest, ok := strings.CutPrefix(current, templatedHosts)
+			if ok && (rest == "" || strings.HasPrefix(rest, "\n\n")) {
+				continue
+			}
+			exclude := []string{}
+			for _, mt := range mts {
+				exclude = append(exclude, mt.String())
+			}
+			// Hosts files that can no longer be parsed are replaced with the rendered configuration.
+			foreignHosts, err := parseHosts(b, exclude)
+			if err == nil && foreignHosts != "" {
+				expected = templatedHosts + "\n\n" + foreignHosts
+			}
+		}
+
+		err = os.MkdirAll(filepath.Dir(fp), 0o755)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		// Write to a temporary file first so that containerd never reads a partially written hosts file.
+		tmpPath := fp + ".tmp"
+		err = os.WriteFile(tmpPath, []byte(expected), 0o644)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		err = os.Rename(tmpPath, fp)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		corrections++
+		metrics.MirrorConfigurationCorrectionsTotal.WithLabelValues(mr.Host).Inc()
+		log.Info("corrected modified containerd mirror configuration", "registry", mr.String(), "path", fp)
+	}
+	return corrections, errors.Join(errs...)
+}
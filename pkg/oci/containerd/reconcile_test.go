@@ -0,0 +1,162 @@
+package containerd
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/testify/v2/require"
+)
+
+func TestReconcileMirrorConfiguration(t *testing.T) {
+	t.Parallel()
+
+	spegelHosts := `server = 'https://registry-1.docker.io'
+
+[host.'http://127.0.0.1:5000']
+capabilities = ['pull', 'resolve']
+dial_timeout = '200ms'`
+
+	tests := []struct {
+		name            string
+		existing        string
+		current         string
+		expected        string
+		prependExisting bool
+		corrections     int
+	}{
+		{
+			name:        "unmodified",
+			current:     spegelHosts,
+			expected:    spegelHosts,
+			corrections: 0,
+		},
+		{
+			name:        "removed",
+			expected:    spegelHosts,
+			corrections: 1,
+		},
+		{
+			name: "overwritten",
+			current: `server = 'https://registry-1.docker.io'
+
+[host.'http://example.com']
+capabilities = ['pull']`,
+			expected:    spegelHosts,
+			corrections: 1,
+		},
+		{
+			name:            "unmodified with prepend",
+			existing:        "[host.'http://example.com']\ncapabilities = ['pull']\n",
+			current:         spegelHosts + "\n\n[host.'http://example.com']\ncapabilities = ['pull']",
+			expected:        spegelHosts + "\n\n[host.'http://example.com']\ncapabilities = ['pull']",
+			prependExisting: true,
+			corrections:     0,
+		},
+		{
+			name:            "appended with prepend",
+			current:         spegelHosts + "\n\n[host.'http://example.com']\ncapabilities = ['pull']",
+			expected:        spegelHosts + "\n\n[host.'http://example.com']\ncapabilities = ['pull']",
+			prependExisting: true,
+			corrections:     0,
+		},
+		{
+			name: "overwritten with prepend",
+			current: `server = 'https://registry-1.docker.io'
+
+[host.'http://example.com']
+capabilities = ['pull']
+
+[host.'http://127.0.0.1:5000']
+capabilities = ['pull']`,
+			expected:        spegelHosts + "\n\n[host.'http://example.com']\ncapabilities = ['pull']",
+			prependExisting: true,
+			corrections:     1,
+		},
+		{
+			name:            "removed with prepend",
+			existing:        "[host.'http://example.com']\ncapabilities = ['pull']\n",
+			expected:        spegelHosts + "\n\n[host.'http://example.com']\ncapabilities = ['pull']",
+			prependExisting: true,
+			corrections:     1,
+		},
+		{
+			name:            "invalid with prepend",
+			current:         "[host.'http://example.com'",
+			expected:        spegelHosts,
+			prependExisting: true,
+			corrections:     1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			configPath := t.TempDir()
+			err := os.MkdirAll(filepath.Join(configPath, backupDir, "docker.io"), 0o755)
+			require.NoError(t, err)
+			if tt.existing != "" {
+				err = os.WriteFile(filepath.Join(configPath, backupDir, "docker.io", "hosts.toml"), []byte(tt.existing), 0o644)
+				require.NoError(t, err)
+			}
+			fp := filepath.Join(configPath, "docker.io", "hosts.toml")
+			if tt.current != "" {
+				err = os.MkdirAll(filepath.Dir(fp), 0o755)
+				require.NoError(t, err)
+				err = os.WriteFile(fp, []byte(tt.current), 0o644)
+				require.NoError(t, err)
+			}
+
+			cfg := NewMirrorConfig([]string{"https://docker.io"}, []string{"http://127.0.0.1:5000"}, true)
+			corrections, err := reconcileMirrorConfiguration(t.Context(), configPath, cfg, tt.prependExisting, nil)
+			require.NoError(t, err)
+			require.EqualT(t, tt.corrections, corrections)
+			b, err := os.ReadFile(fp)
+			require.NoError(t, err)
+			require.EqualT(t, tt.expected, string(b))
+			_, err = os.Stat(fp + ".tmp")
+			require.ErrorIs(t, err, os.ErrNotExist)
+
+			// A second pass should not correct anything.
+			corrections, err = reconcileMirrorConfiguration(t.Context(), configPath, cfg, tt.prependExisting, nil)
+			require.NoError(t, err)
+			require.EqualT(t, 0, corrections)
+		})
+	}
+}
+
+func TestReconcileMirrorConfigurationWatch(t *testing.T) {
+	t.Parallel()
+
+	configPath := t.TempDir()
+	cfg := NewMirrorConfig([]string{"https://docker.io"}, []string{"http://127.0.0.1:5000"}, true)
+	err := WriteMirrorConfiguration(t.Context(), configPath, cfg, false, nil)
+	require.NoError(t, err)
+	fp := filepath.Join(configPath, "docker.io", "hosts.toml")
+	expected, err := os.ReadFile(fp)
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	errCh := make(chan error)
+	go func() {
+		errCh <- ReconcileMirrorConfiguration(ctx, configPath, cfg, false, nil, time.Hour)
+	}()
+
+	// Modifications are detected through file system events long before the interval.
+	require.Eventually(t, func() bool {
+		err := os.WriteFile(fp, []byte("server = 'https://example.com'"), 0o644)
+		if err != nil {
+			return false
+		}
+		time.Sleep(2 * reconcileDelay)
+		b, err := os.ReadFile(fp)
+		if err != nil {
+			return false
+		}
+		return string(b) == string(expected)
+	}, 5*time.Second, 10*time.Millisecond)
+	cancel()
+	require.NoError(t, <-errCh)
+}
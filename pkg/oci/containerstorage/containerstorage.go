@@ -0,0 +1,819 @@
+package containerstorage
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/vbatts/tar-split/tar/asm"
+	"github.com/vbatts/tar-split/tar/storage"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/spegel-org/spegel/internal/option"
+	"github.com/spegel-org/spegel/pkg/httpx"
+	"github.com/spegel-org/spegel/pkg/oci"
+	"github.com/spegel-org/spegel/pkg/store"
+)
+
+const (
+	// Delay before rescanning the storage after a change, allowing writes to settle.
+	rescanDelay = 200 * time.Millisecond
+	// Big data key used for the manifest the image was pulled with.
+	manifestKey = "manifest"
+	// Prefix of big data keys for manifests stored by digest.
+	manifestKeyPrefix = "manifest-"
+	// Amount of reassembled layers kept in the layer cache directory.
+	layerCacheSize = 20
+	// Amount of layers waiting to be checked for reproducibility, more layers are queued on the next rescan.
+	layerCheckQueueSize = 100
+	// Attempts to open a reassembled layer which is evicted from the layer cache before it is opened.
+	openLayerAttempts = 3
+	// Directory created in the layer cache directory, which is owned by the store.
+	layerCacheDirName = "containers-storage-layers"
+)
+
+// Layer compression types as stored by containers/storage.
+const (
+	compressionNone = 0
+	compressionGzip = 2
+	compressionZstd = 4
+)
+
+var _ oci.ImageLister = &ContainerStorage{}
+var _ store.Provider = &ContainerStorage{}
+var _ store.Watcher = &ContainerStorage{}
+
+type ContainerStorageConfig struct {
+	Driver        string
+	LayerCacheDir string
+	Filters       []oci.Filter
+}
+
+type ContainerStorageOption = option.Option[ContainerStorageConfig]
+
+// WithDriver sets the graph driver whose image and layer stores are read.
+func WithDriver(driver string) ContainerStorageOption {
+	return func(cfg *ContainerStorageConfig) error {
+		cfg.Driver = driver
+		return nil
+	}
+}
+
+// WithLayerCacheDir sets the directory in which reassembled layers are cached and their reproducibility is persisted.
+// A temporary directory is used if not set.
+func WithLayerCacheDir(dir string) ContainerStorageOption {
+	return func(cfg *ContainerStorageConfig) error {
+		cfg.LayerCacheDir = dir
+		return nil
+	}
+}
+
+func WithFilters(filters []oci.Filter) ContainerStorageOption {
+	return func(cfg *ContainerStorageConfig) error {
+		cfg.Filters = filters
+		return nil
+	}
+}
+
+// ContainerStorage serves content from a containers/storage directory as used by CRI-O and Podman.
+// Layers are only stored as extracted diffs, so layer content is reassembled from the tar-split
+// metadata and re-compressed when requested by its compressed digest. Reassembled layers are
+// cached on disk. Layers which cannot be reproduced byte for byte are neither advertised nor served.
+// Layers are only advertised by their compressed digest once they have been checked in the background,
+// the results are persisted so that layers are not checked again.
+type ContainerStorage struct {
+	mediaTypeIdx    *lru.Cache[digest.Digest, string]
+	reproducibleIdx *reproducibilityIndex
+	layerCache      *lru.Cache[digest.Digest, string]
+	layerGroup      *singleflight.Group
+	checkCh         chan layer
+	idx             *index
+	root            string
+	driver          string
+	layerCacheDir   string
+	filters         []oci.Filter
+	mx              sync.Mutex
+}
+
+func NewContainerStorage(root string, opts ...ContainerStorageOption) (*ContainerStorage, error) {
+	cfg := ContainerStorageConfig{
+		Driver: "overlay",
+	}
+	err := option.Apply(&cfg, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Driver == "" {
+		return nil, errors.New("driver cannot be empty")
+	}
+
+	mediaTypeIdx, err := lru.New[digest.Digest, string](1000)
+	if err != nil {
+		return nil, err
+	}
+	layerCache, err := lru.NewWithEvict(layerCacheSize, func(_ digest.Digest, path string) {
+		//nolint: errcheck // Ignore error.
+		os.Remove(path)
+	})
+	if err != nil {
+		return nil, err
+	}
+	layerCacheDir := ""
+	reproducibleIdxPath := ""
+	if cfg.LayerCacheDir == "" {
+		layerCacheDir, err = os.MkdirTemp("", "spegel-layers-")
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		// Layers cached by a previous run are removed as they have not been tracked.
+		layerCacheDir = filepath.Join(cfg.LayerCacheDir, layerCacheDirName)
+		err = os.RemoveAll(layerCacheDir)
+		if err != nil {
+			return nil, err
+		}
+		err = os.MkdirAll(layerCacheDir, 0o755)
+		if err != nil {
+			return nil, err
+		}
+		reproducibleIdxPath = filepath.Join(cfg.LayerCacheDir, reproducibilityIndexFile)
+	}
+	reproducibleIdx := newReproducibilityIndex(reproducibleIdxPath)
+	//nolint: errcheck // Layers are checked again if the index cannot be read.
+	reproducibleIdx.Load()
+	c := &ContainerStorage{
+		mediaTypeIdx:    mediaTypeIdx,
+		reproducibleIdx: reproducibleIdx,
+		layerCache:      layerCache,
+		layerGroup:      &singleflight.Group{},
+		checkCh:         make(chan layer, layerCheckQueueSize),
+		root:            root,
+		driver:          cfg.Driver,
+		layerCacheDir:   layerCacheDir,
+		filters:         cfg.Filters,
+	}
+	_, err = c.index()
+	if err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+func (c *ContainerStorage) Name() string {
+	return "containers-storage"
+}
+
+func (c *ContainerStorage) ListImages(ctx context.Context) ([]oci.Image, error) {
+	idx, err := c.index()
+	if err != nil {
+		return nil, err
+	}
+	imgs := []oci.Image{}
+	for _, image := range idx.images {
+		filteredImgs, _ := c.filteredImages(image)
+		imgs = append(imgs, filteredImgs...)
+	}
+	return imgs, nil
+}
+
+func (c *ContainerStorage) Resolve(ctx context.Context, ref string) (digest.Digest, error) {
+	idx, err := c.index()
+	if err != nil {
+		return "", err
+	}
+	for _, image := range idx.images {
+		for _, img := range image.ociImages() {
+			tagName, ok := img.TagName()
+			if !ok || tagName != ref {
+				continue
+			}
+			return img.Digest, nil
+		}
+	}
+	return "", errors.Join(store.ErrNotFound, fmt.Errorf("reference %s not found in image store", ref))
+}
+
+func (c *ContainerStorage) Descriptor(ctx context.Context, dgst digest.Digest) (store.Descriptor, error) {
+	idx, err := c.index()
+	if err != nil {
+		return store.Descriptor{}, err
+	}
+	if ref, ok := idx.bigData[dgst]; ok {
+		mt, err := c.bigDataMediaType(ref)
+		if err != nil {
+			return store.Descriptor{}, err
+		}
+		desc := store.Descriptor{
+			MediaType: mt,
+			Digest:    dgst,
+			Size:      ref.size,
+		}
+		return desc, nil
+	}
+	if l, ok := idx.layers[dgst]; ok {
+		err := c.reproduce(l, dgst)
+		if err != nil {
+			return store.Descriptor{}, err
+		}
+		desc := store.Descriptor{
+			MediaType: l.mediaType(dgst),
+			Digest:    dgst,
+			Size:      l.size(dgst),
+		}
+		return desc, nil
+	}
+	return store.Descriptor{}, errors.Join(store.ErrNotFound, fmt.Errorf("digest %s not found in image or layer store", dgst))
+}
+
+func (c *ContainerStorage) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
+	idx, err := c.index()
+	if err != nil {
+		return nil, err
+	}
+	if ref, ok := idx.bigData[dgst]; ok {
+		file, err := os.Open(c.bigDataPath(ref))
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, errors.Join(store.ErrNotFound, err)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return file, nil
+	}
+	if l, ok := idx.layers[dgst]; ok {
+		return c.openLayer(l, dgst)
+	}
+	return nil, errors.Join(store.ErrNotFound, fmt.Errorf("digest %s not found in image or layer store", dgst))
+}
+
+func (c *ContainerStorage) Watch(ctx context.Context) ([]store.Event, <-chan store.Event, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, dir := range []string{c.imagesDir(), c.layersDir()} {
+		err := fsWatcher.Add(dir)
+		if err != nil {
+			fsWatcher.Close()
+			return nil, nil, err
+		}
+	}
+
+	prev, err := c.snapshot()
+	if err != nil {
+		fsWatcher.Close()
+		return nil, nil, err
+	}
+	initial := store.DiffSnapshots(store.Snapshot{}, prev)
+	err = c.reproducibleIdx.Flush()
+	if err != nil {
+		log.Error(err, "could not persist layer reproducibility")
+	}
+
+	// Layers are checked separately so that reassembling them does not block events.
+	checkedCh := make(chan struct{}, 1)
+	go c.checkLayers(ctx, checkedCh)
+
+	eventCh := make(chan store.Event)
+	go func() {
+		defer close(eventCh)
+		defer fsWatcher.Close()
+
+		var rescanCh <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-checkedCh:
+				// Layers which have been checked are advertised when rescanning.
+				if rescanCh == nil {
+					rescanCh = time.After(rescanDelay)
+				}
+			case fsEvent, ok := <-fsWatcher.Events:
+				if !ok {
+					return
+				}
+				// Lock files are modified on every access so only changes to the stores trigger a rescan.
+				switch filepath.Base(fsEvent.Name) {
+				case "images.json", "layers.json":
+				default:
+					continue
+				}
+				if rescanCh == nil {
+					rescanCh = time.After(rescanDelay)
+				}
+			case err, ok := <-fsWatcher.Errors:
+				if !ok {
+					return
+				}
+				log.Error(err, "received containers storage watch error")
+			case <-rescanCh:
+				rescanCh = nil
+				curr, err := c.snapshot()
+				if err != nil {
+					log.Error(err, "could not scan containers storage")
+					continue
+				}
+				err = c.reproducibleIdx.Flush()
+				if err != nil {
+					log.Error(err, "could not persist layer reproducibility")
+				}
+				for _, event := range store.DiffSnapshots(prev, curr) {
+					select {
+					case <-ctx.Done():
+						return
+					case eventCh <- event:
+					}
+				}
+				prev = curr
+			}
+		}
+	}()
+
+	return initial, eventCh, nil
+}
+
+// snapshot returns the tags and content currently referenced by the image store.
+func (c *ContainerStorage) snapshot() (store.Snapshot, error) {
+	idx, err := c.index()
+	if err != nil {
+		return store.Snapshot{}, err
+	}
+	s := store.NewSnapshot()
+	for _, image := range idx.images {
+		imgs, ok := c.filteredImages(image)
+		if !ok {
+			continue
+		}
+		repository := ""
+		if len(imgs) > 0 {
+			repository = imgs[0].Name()
+		}
+		for _, img := range imgs {
+			if tagName, ok := img.TagName(); ok {
+				s.Tags[tagName] = img.Digest
+			}
+		}
+
+		for key, dgst := range image.BigDataDigests {
+			if !isBigDataBlob(key) {
+				continue
+			}
+			if _, ok := s.Content[dgst]; ok {
+				continue
+			}
+			mt, err := c.bigDataMediaType(bigDataRef{imageID: image.ID, key: key, digest: dgst, size: image.BigDataSizes[key]})
+			if err != nil {
+				return store.Snapshot{}, err
+			}
+			s.Content[dgst] = store.Event{Type: store.CreateEvent, Digest: dgst, MediaType: mt, Size: image.BigDataSizes[key], Repository: repository}
+		}
+
+		// Layers are shared between images through their parent chain.
+		layerID := image.TopLayer
+		for layerID != "" {
+			l, ok := idx.layerIDs[layerID]
+			if !ok {
+				break
+			}
+			layerID = l.Parent
+			dgst := l.blobDigest()
+			if dgst == "" {
+				continue
+			}
+			if _, ok := s.Content[dgst]; ok {
+				continue
+			}
+			// Layers are only advertised if they can be served.
+			if !c.reproducible(l, dgst) {
+				continue
+			}
+			s.Content[dgst] = store.Event{Type: store.CreateEvent, Digest: dgst, MediaType: l.mediaType(dgst), Size: l.size(dgst), Repository: repository}
+		}
+	}
+	c.reproducibleIdx.Prune(func(layerID string) bool {
+		_, ok := idx.layerIDs[layerID]
+		return ok
+	})
+	return s, nil
+}
+
+// filteredImages returns the images for the names which are not filtered.
+// False is returned if the image has names and all of them are filtered.
+func (c *ContainerStorage) filteredImages(image image) ([]oci.Image, bool) {
+	imgs := image.ociImages()
+	if len(imgs) == 0 {
+		return imgs, true
+	}
+	imgs = slices.DeleteFunc(imgs, func(img oci.Image) bool {
+		return oci.MatchesFilter(img.Reference, c.filters)
+	})
+	return imgs, len(imgs) > 0
+}
+
+// openLayer opens the reassembled layer from the layer cache.
+func (c *ContainerStorage) openLayer(l layer, dgst digest.Digest) (io.ReadSeekCloser, error) {
+	for range openLayerAttempts {
+		path, err := c.layerPath(l, dgst)
+		if err != nil {
+			return nil, err
+		}
+		file, err := os.Open(path)
+		if errors.Is(err, os.ErrNotExist) {
+			// The layer was evicted after the path was returned, so it has to be reassembled again.
+			c.layerCache.Remove(dgst)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		return file, nil
+	}
+	return nil, fmt.Errorf("reassembled layer %s was evicted from the layer cache before it could be opened", l.ID)
+}
+
+// reproduce returns an error if the layer cannot be reassembled matching the digest.
+// The result is recorded so that layers are only reassembled when not known before.
+func (c *ContainerStorage) reproduce(l layer, dgst digest.Digest) error {
+	if dgst == l.UncompressedDigest {
+		return nil
+	}
+	if reproducible, ok := c.reproducibleIdx.Get(l.ID, dgst); ok && reproducible {
+		return nil
+	}
+	_, err := c.layerPath(l, dgst)
+	return err
+}
+
+// reproducible returns true if the layer is known to be reassembled matching the digest.
+// Layers which have not been checked are queued to be checked in the background.
+func (c *ContainerStorage) reproducible(l layer, dgst digest.Digest) bool {
+	if dgst == l.UncompressedDigest {
+		return true
+	}
+	if l.CompressionType != compressionGzip {
+		return false
+	}
+	reproducible, ok := c.reproducibleIdx.Get(l.ID, dgst)
+	if ok {
+		return reproducible
+	}
+	select {
+	case c.checkCh <- l:
+	default:
+	}
+	return false
+}
+
+// checkLayers checks queued layers one at a time, signalling once a result has been recorded.
+func (c *ContainerStorage) checkLayers(ctx context.Context, checkedCh chan<- struct{}) {
+	log := logr.FromContextOrDiscard(ctx)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case l := <-c.checkCh:
+			// Layers are queued on every scan until they have been checked.
+			if _, ok := c.reproducibleIdx.Get(l.ID, l.CompressedDigest); ok {
+				continue
+			}
+			verifier := l.CompressedDigest.Verifier()
+			err := c.writeLayer(l, l.CompressedDigest, verifier)
+			if err != nil {
+				log.Error(err, "could not check layer reproducibility", "layer", l.ID)
+				continue
+			}
+			c.reproducibleIdx.Add(l.ID, l.CompressedDigest, verifier.Verified())
+			err = c.reproducibleIdx.Flush()
+			if err != nil {
+				log.Error(err, "could not persist layer reproducibility")
+			}
+			select {
+			case checkedCh <- struct{}{}:
+			default:
+			}
+		}
+	}
+}
+
+// layerPath returns the path of the reassembled layer in the layer cache, reassembling it if not cached.
+func (c *ContainerStorage) layerPath(l layer, dgst digest.Digest) (string, error) {
+	if dgst != l.UncompressedDigest && l.CompressionType != compressionGzip {
+		return "", errors.Join(store.ErrNotFound, fmt.Errorf("layer %s with compression type %d cannot be reproduced", l.ID, l.CompressionType))
+	}
+	if reproducible, ok := c.reproducibleIdx.Get(l.ID, dgst); ok && !reproducible {
+		return "", errors.Join(store.ErrNotFound, fmt.Errorf("reassembled layer %s does not match digest %s", l.ID, dgst))
+	}
+	if path, ok := c.layerCache.Get(dgst); ok {
+		return path, nil
+	}
+	v, err, _ := c.layerGroup.Do(dgst.String(), func() (any, error) {
+		if path, ok := c.layerCache.Get(dgst); ok {
+			return path, nil
+		}
+		path, err := c.reassembleLayer(l, dgst)
+		if err != nil {
+			return "", err
+		}
+		c.layerCache.Add(dgst, path)
+		return path, nil
+	})
+	if err != nil {
+		return "", err
+	}
+	//nolint: errcheck // Result is always a string.
+	return v.(string), nil
+}
+
+// reassembleLayer writes the layer tar from the extracted diff to the layer cache directory, compressing it if required.
+// The output is verified against the requested digest as compression is not guaranteed to be reproducible.
+func (c *ContainerStorage) reassembleLayer(l layer, dgst digest.Digest) (string, error) {
+	file, err := os.CreateTemp(c.layerCacheDir, "tmp-")
+	if err != nil {
+		return "", err
+	}
+	err = func() error {
+		defer file.Close()
+
+		verifier := dgst.Verifier()
+		err := c.writeLayer(l, dgst, io.MultiWriter(file, verifier))
+		if err != nil {
+			return err
+		}
+		if dgst != l.UncompressedDigest {
+			c.reproducibleIdx.Add(l.ID, dgst, verifier.Verified())
+		}
+		if !verifier.Verified() {
+			return errors.Join(store.ErrNotFound, fmt.Errorf("reassembled layer %s does not match digest %s", l.ID, dgst))
+		}
+		return nil
+	}()
+	if err != nil {
+		return "", errors.Join(err, os.Remove(file.Name()))
+	}
+	path := filepath.Join(c.layerCacheDir, dgst.Encoded())
+	err = os.Rename(file.Name(), path)
+	if err != nil {
+		return "", errors.Join(err, os.Remove(file.Name()))
+	}
+	return path, nil
+}
+
+// writeLayer writes the layer tar from the extracted diff, compressing it unless the digest is the uncompressed digest.
+func (c *ContainerStorage) writeLayer(l layer, dgst digest.Digest, w io.Writer) error {
+	tarSplitFile, err := os.Open(filepath.Join(c.layersDir(), l.ID+".tar-split.gz"))
+	if errors.Is(err, os.ErrNotExist) {
+		return errors.Join(store.ErrNotFound, err)
+	}
+	if err != nil {
+		return err
+	}
+	defer tarSplitFile.Close()
+	gzr, err := gzip.NewReader(tarSplitFile)
+	if err != nil {
+		return err
+	}
+	defer gzr.Close()
+
+	fileGetter := storage.NewPathFileGetter(filepath.Join(c.root, c.driver, l.ID, "diff"))
+	unpacker := storage.NewJSONUnpacker(gzr)
+	if dgst == l.UncompressedDigest {
+		return asm.WriteOutputTarStream(fileGetter, unpacker, w)
+	}
+	gzw := gzip.NewWriter(w)
+	err = asm.WriteOutputTarStream(fileGetter, unpacker, gzw)
+	if err != nil {
+		return err
+	}
+	return gzw.Close()
+}
+
+func (c *ContainerStorage) bigDataMediaType(ref bigDataRef) (string, error) {
+	dgst := ref.digest
+	if dgst != "" {
+		if mt, ok := c.mediaTypeIdx.Get(dgst); ok {
+			return mt, nil
+		}
+	}
+	if ref.size > oci.ManifestMaxSize {
+		return httpx.ContentTypeBinary, nil
+	}
+	file, err := os.Open(c.bigDataPath(ref))
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	mt, err := oci.FingerprintMediaType(file)
+	if err != nil {
+		return "", err
+	}
+	if dgst != "" {
+		c.mediaTypeIdx.Add(dgst, mt)
+	}
+	return mt, nil
+}
+
+func (c *ContainerStorage) imagesDir() string {
+	return filepath.Join(c.root, c.driver+"-images")
+}
+
+func (c *ContainerStorage) layersDir() string {
+	return filepath.Join(c.root, c.driver+"-layers")
+}
+
+func (c *ContainerStorage) bigDataPath(ref bigDataRef) string {
+	return filepath.Join(c.imagesDir(), ref.imageID, bigDataFileName(ref.key))
+}
+
+// index returns the image and layer index, reading the stores again only if they have been modified.
+func (c *ContainerStorage) index() (*index, error) {
+	c.mx.Lock()
+	defer c.mx.Unlock()
+
+	imagesPath := filepath.Join(c.imagesDir(), "images.json")
+	layersPath := filepath.Join(c.layersDir(), "layers.json")
+	imagesFi, err := os.Stat(imagesPath)
+	if err != nil {
+		return nil, err
+	}
+	layersFi, err := os.Stat(layersPath)
+	if err != nil {
+		return nil, err
+	}
+	if c.idx != nil && unchanged(c.idx.imagesFi, imagesFi) && unchanged(c.idx.layersFi, layersFi) {
+		return c.idx, nil
+	}
+
+	images := []image{}
+	err = readJSON(imagesPath, &images)
+	if err != nil {
+		return nil, err
+	}
+	layers := []layer{}
+	err = readJSON(layersPath, &layers)
+	if err != nil {
+		return nil, err
+	}
+	idx := &index{
+		images:   images,
+		bigData:  map[digest.Digest]bigDataRef{},
+		layers:   map[digest.Digest]layer{},
+		layerIDs: map[string]layer{},
+		imagesFi: imagesFi,
+		layersFi: layersFi,
+	}
+	for _, image := range images {
+		for key, dgst := range image.BigDataDigests {
+			if !isBigDataBlob(key) {
+				continue
+			}
+			idx.bigData[dgst] = bigDataRef{imageID: image.ID, key: key, digest: dgst, size: image.BigDataSizes[key]}
+		}
+	}
+	for _, l := range layers {
+		idx.layerIDs[l.ID] = l
+		if l.UncompressedDigest != "" {
+			idx.layers[l.UncompressedDigest] = l
+		}
+		if l.CompressedDigest != "" {
+			idx.layers[l.CompressedDigest] = l
+		}
+	}
+	c.idx = idx
+	return idx, nil
+}
+
+type index struct {
+	imagesFi os.FileInfo
+	layersFi os.FileInfo
+	bigData  map[digest.Digest]bigDataRef
+	layers   map[digest.Digest]layer
+	layerIDs map[string]layer
+	images   []image
+}
+
+type bigDataRef struct {
+	imageID string
+	key     string
+	digest  digest.Digest
+	size    int64
+}
+
+// image is an entry in the images.json file of the image store.
+type image struct {
+	BigDataSizes   map[string]int64         `json:"big-data-sizes,omitempty"`
+	BigDataDigests map[string]digest.Digest `json:"big-data-digests,omitempty"`
+	ID             string                   `json:"id"`
+	Digest         digest.Digest            `json:"digest,omitempty"`
+	TopLayer       string                   `json:"layer,omitempty"`
+	Names          []string                 `json:"names,omitempty"`
+}
+
+// ociImages returns the images for all names which can be parsed.
+func (i image) ociImages() []oci.Image {
+	dgst := i.Digest
+	if dgst == "" {
+		dgst = i.BigDataDigests[manifestKey]
+	}
+	imgs := []oci.Image{}
+	for _, name := range i.Names {
+		img, err := oci.ParseImage(name, oci.WithDigest(dgst))
+		if err != nil {
+			continue
+		}
+		imgs = append(imgs, img)
+	}
+	return imgs
+}
+
+// layer is an entry in the layers.json file of the layer store.
+type layer struct {
+	ID                 string        `json:"id"`
+	Parent             string        `json:"parent,omitempty"`
+	CompressedDigest   digest.Digest `json:"compressed-diff-digest,omitempty"`
+	UncompressedDigest digest.Digest `json:"diff-digest,omitempty"`
+	CompressedSize     int64         `json:"compressed-size,omitempty"`
+	UncompressedSize   int64         `json:"diff-size,omitempty"`
+	CompressionType    int           `json:"compression,omitempty"`
+}
+
+// blobDigest returns the digest the layer was pulled with.
+func (l layer) blobDigest() digest.Digest {
+	if l.CompressedDigest != "" {
+		return l.CompressedDigest
+	}
+	return l.UncompressedDigest
+}
+
+func (l layer) size(dgst digest.Digest) int64 {
+	if dgst == l.UncompressedDigest {
+		return l.UncompressedSize
+	}
+	return l.CompressedSize
+}
+
+func (l layer) mediaType(dgst digest.Digest) string {
+	if dgst == l.UncompressedDigest {
+		return ocispec.MediaTypeImageLayer
+	}
+	switch l.CompressionType {
+	case compressionNone:
+		return ocispec.MediaTypeImageLayer
+	case compressionGzip:
+		return ocispec.MediaTypeImageLayerGzip
+	case compressionZstd:
+		return ocispec.MediaTypeImageLayerZstd
+	default:
+		return httpx.ContentTypeBinary
+	}
+}
+
+// isBigDataBlob returns true if the big data key is stored by digest, which are manifests and configs.
+func isBigDataBlob(key string) bool {
+	if key == manifestKey || strings.HasPrefix(key, manifestKeyPrefix) {
+		return true
+	}
+	_, err := digest.Parse(key)
+	return err == nil
+}
+
+// bigDataFileName returns the file name used by containers/storage for a big data key.
+// Keys containing characters other than lowercase letters, digits and dots are base64 encoded.
+func bigDataFileName(key string) string {
+	for _, r := range key {
+		if r != '.' && (r < '0' || r > '9') && (r < 'a' || r > 'z') {
+			return "=" + base64.StdEncoding.EncodeToString([]byte(key))
+		}
+	}
+	return key
+}
+
+// unchanged returns true if the file has not been modified since it was last read.
+func unchanged(prev, curr os.FileInfo) bool {
+	return prev.ModTime().Equal(curr.ModTime()) && prev.Size() == curr.Size()
+}
+
+func readJSON(path string, v any) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
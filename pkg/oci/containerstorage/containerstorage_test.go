@@ -0,0 +1,404 @@
+package containerstorage
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/testify/v2/require"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/vbatts/tar-split/tar/asm"
+	"github.com/vbatts/tar-split/tar/storage"
+
+	"github.com/spegel-org/spegel/pkg/oci"
+	"github.com/spegel-org/spegel/pkg/store"
+	"github.com/spegel-org/spegel/pkg/store/storetest"
+)
+
+func TestContainerStorage(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	_, err := NewContainerStorage(root, WithLayerCacheDir(t.TempDir()))
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	fixture := writeStorage(t, root)
+	c, err := NewContainerStorage(root, WithLayerCacheDir(t.TempDir()))
+	require.NoError(t, err)
+
+	cfg := storetest.ProviderConfig{
+		Name:              "containers-storage",
+		NotFoundRef:       "docker.io/library/foo:missing",
+		ExistingRef:       "docker.io/library/foo:1.0",
+		ExistingRefDigest: fixture.manifest.Digest,
+		NotFoundDigest:    digest.FromString("missing"),
+		ExistingDescriptor: store.Descriptor{
+			MediaType: ocispec.MediaTypeImageManifest,
+			Digest:    fixture.manifest.Digest,
+			Size:      fixture.manifest.Size,
+		},
+	}
+	storetest.ProviderConformance(t, c, cfg)
+
+	imgs, err := c.ListImages(t.Context())
+	require.NoError(t, err)
+	require.Len(t, imgs, 1)
+	require.EqualT(t, "docker.io/library/foo:1.0@"+fixture.manifest.Digest.String(), imgs[0].String())
+
+	desc, err := c.Descriptor(t.Context(), fixture.config.Digest)
+	require.NoError(t, err)
+	require.EqualT(t, ocispec.MediaTypeImageConfig, desc.MediaType)
+	require.EqualT(t, fixture.config.Size, desc.Size)
+
+	// Layers should be reassembled by both their compressed and uncompressed digest.
+	for _, layerDesc := range []ocispec.Descriptor{fixture.layer, fixture.diff} {
+		desc, err := c.Descriptor(t.Context(), layerDesc.Digest)
+		require.NoError(t, err)
+		require.EqualT(t, layerDesc.MediaType, desc.MediaType)
+		require.EqualT(t, layerDesc.Size, desc.Size)
+		rc, err := c.Open(t.Context(), layerDesc.Digest)
+		require.NoError(t, err)
+		b, err := io.ReadAll(rc)
+		require.NoError(t, err)
+		require.NoError(t, rc.Close())
+		require.EqualT(t, layerDesc.Digest, digest.FromBytes(b))
+	}
+
+	// Reassembled layers are served from the cache.
+	err = os.RemoveAll(filepath.Join(root, "overlay", fixture.diff.Digest.Encoded()))
+	require.NoError(t, err)
+	rc, err := c.Open(t.Context(), fixture.layer.Digest)
+	require.NoError(t, err)
+	b, err := io.ReadAll(rc)
+	require.NoError(t, err)
+	require.NoError(t, rc.Close())
+	require.EqualT(t, fixture.layer.Digest, digest.FromBytes(b))
+}
+
+func TestContainerStorageUnreproducibleLayer(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	writeStorage(t, root)
+	layersPath := filepath.Join(root, "overlay-layers", "layers.json")
+	layers := []layer{}
+	err := readJSON(layersPath, &layers)
+	require.NoError(t, err)
+	layers[0].CompressedDigest = digest.FromString("other compressor")
+	writeJSON(t, layersPath, layers)
+
+	c, err := NewContainerStorage(root, WithLayerCacheDir(t.TempDir()))
+	require.NoError(t, err)
+	_, err = c.Open(t.Context(), layers[0].CompressedDigest)
+	require.ErrorIs(t, err, store.ErrNotFound)
+	require.ErrorContains(t, err, "does not match digest")
+	_, err = c.Descriptor(t.Context(), layers[0].CompressedDigest)
+	require.ErrorIs(t, err, store.ErrNotFound)
+	initial, _, err := c.Watch(t.Context())
+	require.NoError(t, err)
+	for _, event := range initial {
+		require.NotEqual(t, layers[0].CompressedDigest, event.Digest)
+	}
+
+	layers[0].CompressionType = compressionZstd
+	writeJSON(t, layersPath, layers)
+	// Make sure the modification time changes so the index is read again.
+	future := time.Now().Add(time.Minute)
+	err = os.Chtimes(layersPath, future, future)
+	require.NoError(t, err)
+	_, err = c.Open(t.Context(), layers[0].CompressedDigest)
+	require.ErrorIs(t, err, store.ErrNotFound)
+	require.ErrorContains(t, err, "cannot be reproduced")
+	_, err = c.Descriptor(t.Context(), layers[0].CompressedDigest)
+	require.ErrorIs(t, err, store.ErrNotFound)
+
+	// The uncompressed layer can always be reproduced.
+	_, err = c.Descriptor(t.Context(), layers[0].UncompressedDigest)
+	require.NoError(t, err)
+}
+
+func TestContainerStorageWatch(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	fixture := writeStorage(t, root)
+	layerCacheDir := t.TempDir()
+	c, err := NewContainerStorage(root, WithLayerCacheDir(layerCacheDir))
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	initial, eventCh, err := c.Watch(ctx)
+	require.NoError(t, err)
+	require.Len(t, initial, 3)
+	require.Contains(t, initial, store.Event{Type: store.CreateEvent, Reference: "docker.io/library/foo:1.0"})
+	require.Contains(t, initial, store.Event{Type: store.CreateEvent, Digest: fixture.manifest.Digest, MediaType: ocispec.MediaTypeImageManifest, Size: fixture.manifest.Size, Repository: "docker.io/library/foo"})
+
+	// Compressed layers are advertised once they have been checked in the background.
+	layerEvent := store.Event{Type: store.CreateEvent, Digest: fixture.layer.Digest, MediaType: ocispec.MediaTypeImageLayerGzip, Size: fixture.layer.Size, Repository: "docker.io/library/foo"}
+	event := receiveEvent(t, eventCh)
+	require.Equal(t, layerEvent, event)
+
+	// The result is persisted so that layers are advertised immediately after restarts.
+	cancel()
+	c, err = NewContainerStorage(root, WithLayerCacheDir(layerCacheDir))
+	require.NoError(t, err)
+	initial, eventCh, err = c.Watch(t.Context())
+	require.NoError(t, err)
+	require.Len(t, initial, 4)
+	require.Contains(t, initial, layerEvent)
+
+	// Tagging the image again should only advertise the new tag.
+	imagesPath := filepath.Join(root, "overlay-images", "images.json")
+	images := []image{}
+	err = readJSON(imagesPath, &images)
+	require.NoError(t, err)
+	images[0].Names = append(images[0].Names, "docker.io/library/foo:2.0")
+	writeJSON(t, imagesPath, images)
+	event = receiveEvent(t, eventCh)
+	require.Equal(t, store.Event{Type: store.CreateEvent, Reference: "docker.io/library/foo:2.0"}, event)
+
+	// Removing the image should delete all content and tags.
+	writeJSON(t, imagesPath, []image{})
+	events := []store.Event{}
+	for range 5 {
+		events = append(events, receiveEvent(t, eventCh))
+	}
+	for _, event := range events {
+		require.EqualT(t, store.DeleteEvent, event.Type)
+	}
+	require.Contains(t, events, store.Event{Type: store.DeleteEvent, Reference: "docker.io/library/foo:2.0"})
+	require.Contains(t, events, store.Event{Type: store.DeleteEvent, Digest: fixture.layer.Digest, MediaType: ocispec.MediaTypeImageLayerGzip})
+}
+
+func TestContainerStorageWatcherConformance(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	for _, dir := range []string{"overlay-layers", "overlay-images"} {
+		err := os.MkdirAll(filepath.Join(root, dir), 0o755)
+		require.NoError(t, err)
+	}
+	writeJSON(t, filepath.Join(root, "overlay-layers", "layers.json"), []layer{})
+	writeJSON(t, filepath.Join(root, "overlay-images", "images.json"), []image{})
+	c, err := NewContainerStorage(root, WithLayerCacheDir(t.TempDir()))
+	require.NoError(t, err)
+
+	cfg := storetest.WatcherConfig{
+		CreateImage: func(t *testing.T, ref string) digest.Digest {
+			names := []string{}
+			if ref != "" {
+				names = append(names, ref)
+			}
+			fixture := addImage(t, root, names, []byte("content of "+ref))
+			return fixture.manifest.Digest
+		},
+		DeleteImage: func(t *testing.T, ref string, dgst digest.Digest) {
+			imagesPath := filepath.Join(root, "overlay-images", "images.json")
+			images := []image{}
+			err := readJSON(imagesPath, &images)
+			require.NoError(t, err)
+			images = slices.DeleteFunc(images, func(img image) bool {
+				return img.Digest == dgst
+			})
+			writeJSON(t, imagesPath, images)
+		},
+	}
+	storetest.WatcherConformance(t, c, cfg)
+}
+
+func TestContainerStorageFilters(t *testing.T) {
+	t.Parallel()
+
+	root := t.TempDir()
+	writeStorage(t, root)
+	filters, err := oci.FilterForMirroredRegistries([]string{"https://ghcr.io"})
+	require.NoError(t, err)
+	c, err := NewContainerStorage(root, WithLayerCacheDir(t.TempDir()), WithFilters([]oci.Filter{*filters}))
+	require.NoError(t, err)
+
+	imgs, err := c.ListImages(t.Context())
+	require.NoError(t, err)
+	require.Empty(t, imgs)
+	initial, _, err := c.Watch(t.Context())
+	require.NoError(t, err)
+	require.Empty(t, initial)
+
+	// Images are filtered by each of their names.
+	imagesPath := filepath.Join(root, "overlay-images", "images.json")
+	images := []image{}
+	err = readJSON(imagesPath, &images)
+	require.NoError(t, err)
+	images[0].Names = append(images[0].Names, "ghcr.io/foo/bar:1.0")
+	writeJSON(t, imagesPath, images)
+	future := time.Now().Add(time.Minute)
+	err = os.Chtimes(imagesPath, future, future)
+	require.NoError(t, err)
+	imgs, err = c.ListImages(t.Context())
+	require.NoError(t, err)
+	require.Len(t, imgs, 1)
+	require.EqualT(t, "ghcr.io/foo/bar", imgs[0].Name())
+	initial, _, err = c.Watch(t.Context())
+	require.NoError(t, err)
+	require.Contains(t, initial, store.Event{Type: store.CreateEvent, Reference: "ghcr.io/foo/bar:1.0"})
+	require.NotContains(t, initial, store.Event{Type: store.CreateEvent, Reference: "docker.io/library/foo:1.0"})
+	for _, event := range initial {
+		if event.Digest != "" {
+			require.EqualT(t, "ghcr.io/foo/bar", event.Repository)
+		}
+	}
+}
+
+func TestBigDataFileName(t *testing.T) {
+	t.Parallel()
+
+	require.EqualT(t, "manifest", bigDataFileName("manifest"))
+	require.EqualT(t, "=bWFuaWZlc3Qtc2hhMjU2OmFiYw==", bigDataFileName("manifest-sha256:abc"))
+}
+
+type storageFixture struct {
+	manifest ocispec.Descriptor
+	config   ocispec.Descriptor
+	layer    ocispec.Descriptor
+	diff     ocispec.Descriptor
+}
+
+// writeStorage writes a single image with one layer to an overlay containers storage.
+func writeStorage(t *testing.T, root string) storageFixture {
+	t.Helper()
+
+	return addImage(t, root, []string{"docker.io/library/foo:1.0"}, []byte("hello world"))
+}
+
+// addImage adds an image with a single layer containing a file with the content to the storage.
+func addImage(t *testing.T, root string, names []string, content []byte) storageFixture {
+	t.Helper()
+
+	// Build the layer tar and record its tar-split metadata as done when the layer is applied.
+	tarBuf := &bytes.Buffer{}
+	tw := tar.NewWriter(tarBuf)
+	err := tw.WriteHeader(&tar.Header{Name: "hello.txt", Mode: 0o644, Size: int64(len(content)), Typeflag: tar.TypeReg})
+	require.NoError(t, err)
+	_, err = tw.Write(content)
+	require.NoError(t, err)
+	require.NoError(t, tw.Close())
+	diffDesc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayer, Digest: digest.FromBytes(tarBuf.Bytes()), Size: int64(tarBuf.Len())}
+	layerID := diffDesc.Digest.Encoded()
+	diffDir := filepath.Join(root, "overlay", layerID, "diff")
+	err = os.MkdirAll(diffDir, 0o755)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(diffDir, "hello.txt"), content, 0o644)
+	require.NoError(t, err)
+	tarSplitBuf := &bytes.Buffer{}
+	tarSplitGzw := gzip.NewWriter(tarSplitBuf)
+	rdr, err := asm.NewInputTarStream(bytes.NewReader(tarBuf.Bytes()), storage.NewJSONPacker(tarSplitGzw), storage.NewDiscardFilePutter())
+	require.NoError(t, err)
+	_, err = io.Copy(io.Discard, rdr)
+	require.NoError(t, err)
+	require.NoError(t, tarSplitGzw.Close())
+	err = os.MkdirAll(filepath.Join(root, "overlay-layers"), 0o755)
+	require.NoError(t, err)
+	err = os.WriteFile(filepath.Join(root, "overlay-layers", layerID+".tar-split.gz"), tarSplitBuf.Bytes(), 0o644)
+	require.NoError(t, err)
+
+	compressedBuf := &bytes.Buffer{}
+	gzw := gzip.NewWriter(compressedBuf)
+	_, err = gzw.Write(tarBuf.Bytes())
+	require.NoError(t, err)
+	require.NoError(t, gzw.Close())
+	layerDesc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: digest.FromBytes(compressedBuf.Bytes()), Size: int64(compressedBuf.Len())}
+	layersPath := filepath.Join(root, "overlay-layers", "layers.json")
+	layers := []layer{}
+	if _, err := os.Stat(layersPath); err == nil {
+		err := readJSON(layersPath, &layers)
+		require.NoError(t, err)
+	}
+	layers = append(layers, layer{
+		ID:                 layerID,
+		CompressedDigest:   layerDesc.Digest,
+		CompressedSize:     layerDesc.Size,
+		UncompressedDigest: diffDesc.Digest,
+		UncompressedSize:   diffDesc.Size,
+		CompressionType:    compressionGzip,
+	})
+	writeJSON(t, layersPath, layers)
+
+	configB := []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":["` + diffDesc.Digest.String() + `"]}}`)
+	configDesc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig, Digest: digest.FromBytes(configB), Size: int64(len(configB))}
+	manifestB, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    configDesc,
+		Layers:    []ocispec.Descriptor{layerDesc},
+	})
+	require.NoError(t, err)
+	manifestDesc := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromBytes(manifestB), Size: int64(len(manifestB))}
+
+	bigData := map[string][]byte{
+		manifestKey: manifestB,
+		manifestKeyPrefix + manifestDesc.Digest.String(): manifestB,
+		configDesc.Digest.String():                       configB,
+	}
+	img := image{
+		ID:             configDesc.Digest.Encoded(),
+		Digest:         manifestDesc.Digest,
+		TopLayer:       layerID,
+		Names:          names,
+		BigDataSizes:   map[string]int64{},
+		BigDataDigests: map[string]digest.Digest{},
+	}
+	imageDir := filepath.Join(root, "overlay-images", img.ID)
+	err = os.MkdirAll(imageDir, 0o755)
+	require.NoError(t, err)
+	for key, b := range bigData {
+		err := os.WriteFile(filepath.Join(imageDir, bigDataFileName(key)), b, 0o644)
+		require.NoError(t, err)
+		img.BigDataSizes[key] = int64(len(b))
+		img.BigDataDigests[key] = digest.FromBytes(b)
+	}
+	imagesPath := filepath.Join(root, "overlay-images", "images.json")
+	images := []image{}
+	if _, err := os.Stat(imagesPath); err == nil {
+		err := readJSON(imagesPath, &images)
+		require.NoError(t, err)
+	}
+	images = append(images, img)
+	writeJSON(t, imagesPath, images)
+
+	return storageFixture{
+		manifest: manifestDesc,
+		config:   configDesc,
+		layer:    layerDesc,
+		diff:     diffDesc,
+	}
+}
+
+func writeJSON(t *testing.T, path string, v any) {
+	t.Helper()
+
+	b, err := json.Marshal(v)
+	require.NoError(t, err)
+	err = os.WriteFile(path, b, 0o644)
+	require.NoError(t, err)
+}
+
+func receiveEvent(t *testing.T, eventCh <-chan store.Event) store.Event {
+	t.Helper()
+
+	select {
+	case event := <-eventCh:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+		return store.Event{}
+	}
+}
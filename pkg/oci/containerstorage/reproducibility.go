@@ -0,0 +1,129 @@
+package containerstorage
+
+import (
+	"encoding/json"
+	"errors"
+	"maps"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/opencontainers/go-digest"
+)
+
+const reproducibilityIndexFile = "containers-storage-reproducibility.json"
+
+// reproducibilityIndex records if layers reassembled by their compressed digest match the digest.
+// Results are keyed by layer ID and digest, and persisted to disk when a path is set so that
+// layers are not reassembled again to check them after restarts.
+type reproducibilityIndex struct {
+	layers map[string]map[digest.Digest]bool
+	path   string
+	mx     sync.RWMutex
+	dirty  bool
+}
+
+// newReproducibilityIndex creates an index persisted to the path. An empty path results in an in memory index.
+func newReproducibilityIndex(path string) *reproducibilityIndex {
+	return &reproducibilityIndex{
+		layers: map[string]map[digest.Digest]bool{},
+		path:   path,
+	}
+}
+
+// Load reads the persisted index from disk.
+func (r *reproducibilityIndex) Load() error {
+	if r.path == "" {
+		return nil
+	}
+	b, err := os.ReadFile(r.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	layers := map[string]map[digest.Digest]bool{}
+	err = json.Unmarshal(b, &layers)
+	if err != nil {
+		return err
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+	r.layers = layers
+	return nil
+}
+
+// Get returns if the layer is reproducible by the digest, and false if it has not been checked.
+func (r *reproducibilityIndex) Get(layerID string, dgst digest.Digest) (bool, bool) {
+	r.mx.RLock()
+	defer r.mx.RUnlock()
+
+	reproducible, ok := r.layers[layerID][dgst]
+	return reproducible, ok
+}
+
+func (r *reproducibilityIndex) Add(layerID string, dgst digest.Digest, reproducible bool) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	digests, ok := r.layers[layerID]
+	if !ok {
+		digests = map[digest.Digest]bool{}
+		r.layers[layerID] = digests
+	}
+	if prev, ok := digests[dgst]; ok && prev == reproducible {
+		return
+	}
+	digests[dgst] = reproducible
+	r.dirty = true
+}
+
+// Prune drops the results of layers which no longer exist.
+func (r *reproducibilityIndex) Prune(exists func(layerID string) bool) {
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	maps.DeleteFunc(r.layers, func(layerID string, _ map[digest.Digest]bool) bool {
+		if exists(layerID) {
+			return false
+		}
+		r.dirty = true
+		return true
+	})
+}
+
+// Flush writes the index to disk if it has been modified since it was last written.
+func (r *reproducibilityIndex) Flush() error {
+	if r.path == "" {
+		return nil
+	}
+
+	r.mx.Lock()
+	defer r.mx.Unlock()
+
+	if !r.dirty {
+		return nil
+	}
+	b, err := json.Marshal(r.layers)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(r.path), 0o755)
+	if err != nil {
+		return err
+	}
+	// Write to a temporary file first so that a partially written index is never read.
+	tmpPath := r.path + ".tmp"
+	err = os.WriteFile(tmpPath, b, 0o644)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmpPath, r.path)
+	if err != nil {
+		return err
+	}
+	r.dirty = false
+	return nil
+}
@@ -0,0 +1,72 @@
+package containerstorage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-openapi/testify/v2/require"
+	"github.com/opencontainers/go-digest"
+)
+
+func TestReproducibilityIndex(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "data", reproducibilityIndexFile)
+	dgst := digest.FromString("layer")
+	otherDgst := digest.FromString("other")
+
+	idx := newReproducibilityIndex(path)
+	err := idx.Load()
+	require.NoError(t, err)
+	_, ok := idx.Get("foo", dgst)
+	require.FalseT(t, ok)
+
+	// Nothing should be written until the index is modified.
+	err = idx.Flush()
+	require.NoError(t, err)
+	_, err = os.Stat(path)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	idx.Add("foo", dgst, true)
+	idx.Add("foo", otherDgst, false)
+	idx.Add("bar", dgst, true)
+	idx.Prune(func(layerID string) bool {
+		return layerID == "foo"
+	})
+	err = idx.Flush()
+	require.NoError(t, err)
+
+	// Results should be known after a restart.
+	idx = newReproducibilityIndex(path)
+	err = idx.Load()
+	require.NoError(t, err)
+	reproducible, ok := idx.Get("foo", dgst)
+	require.TrueT(t, ok)
+	require.TrueT(t, reproducible)
+	reproducible, ok = idx.Get("foo", otherDgst)
+	require.TrueT(t, ok)
+	require.FalseT(t, reproducible)
+	_, ok = idx.Get("bar", dgst)
+	require.FalseT(t, ok)
+
+	err = os.WriteFile(path, []byte("foobar"), 0o644)
+	require.NoError(t, err)
+	err = newReproducibilityIndex(path).Load()
+	require.Error(t, err)
+}
+
+func TestReproducibilityIndexInMemory(t *testing.T) {
+	t.Parallel()
+
+	idx := newReproducibilityIndex("")
+	err := idx.Load()
+	require.NoError(t, err)
+	dgst := digest.FromString("layer")
+	idx.Add("foo", dgst, true)
+	err = idx.Flush()
+	require.NoError(t, err)
+	reproducible, ok := idx.Get("foo", dgst)
+	require.TrueT(t, ok)
+	require.TrueT(t, reproducible)
+}
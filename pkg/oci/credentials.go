@@ -0,0 +1,210 @@
+package oci
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	dockerHubServerAddress = "https://index.docker.io/v1/"
+	// helperCredentialsTTL is how long credentials returned by credential helpers are reused.
+	helperCredentialsTTL = 5 * time.Minute
+)
+
+// Credentials provides the credentials to use for a registry host.
+type Credentials interface {
+	// Get returns the credentials for the host, nil is returned if none exist.
+	Get(ctx context.Context, host string) (*url.Userinfo, error)
+	// Invalidate discards any cached credentials for the host after they have been rejected.
+	Invalidate(host string)
+}
+
+var _ Credentials = &DockerConfig{}
+
+// DockerConfig reads credentials from a Docker config.json.
+// Credentials returned by credential helpers are cached as helpers are executed as subprocesses.
+type DockerConfig struct {
+	helperCache map[string]helperCredentials
+	Auths       map[string]DockerAuth `json:"auths"`
+	CredHelpers map[string]string     `json:"credHelpers"`
+	CredsStore  string                `json:"credsStore"`
+	helperMx    sync.Mutex
+}
+
+type helperCredentials struct {
+	expiresAt time.Time
+	userinfo  *url.Userinfo
+	helper    string
+}
+
+type DockerAuth struct {
+	Auth          string `json:"auth"`
+	Username      string `json:"username"`
+	Password      string `json:"password"`
+	IdentityToken string `json:"identitytoken"`
+}
+
+// DefaultDockerConfigPath returns the config path used by the Docker CLI.
+func DefaultDockerConfigPath() (string, error) {
+	if dir := os.Getenv("DOCKER_CONFIG"); dir != "" {
+		return filepath.Join(dir, "config.json"), nil
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".docker", "config.json"), nil
+}
+
+// LoadDockerConfig reads the Docker config, a missing file results in an empty config.
+func LoadDockerConfig(path string) (*DockerConfig, error) {
+	cfg := &DockerConfig{}
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return cfg, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(b, cfg)
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+func (d *DockerConfig) Get(ctx context.Context, host string) (*url.Userinfo, error) {
+	host = normalizeCredentialHost(host)
+	if helper, ok := d.CredHelpers[host]; ok {
+		return d.helperCredentials(ctx, helper, host)
+	}
+	for k, auth := range d.Auths {
+		if normalizeCredentialHost(k) != host {
+			continue
+		}
+		userinfo, err := auth.userinfo()
+		if err != nil {
+			return nil, fmt.Errorf("could not decode auth for %s: %w", k, err)
+		}
+		if userinfo != nil {
+			return userinfo, nil
+		}
+	}
+	if d.CredsStore != "" {
+		return d.helperCredentials(ctx, d.CredsStore, host)
+	}
+	return nil, nil
+}
+
+func (d *DockerConfig) Invalidate(host string) {
+	d.helperMx.Lock()
+	defer d.helperMx.Unlock()
+
+	delete(d.helperCache, normalizeCredentialHost(host))
+}
+
+// helperCredentials returns the credentials from the helper, reusing credentials fetched recently.
+func (d *DockerConfig) helperCredentials(ctx context.Context, helper, host string) (*url.Userinfo, error) {
+	d.helperMx.Lock()
+	cached, ok := d.helperCache[host]
+	d.helperMx.Unlock()
+	if ok && cached.helper == helper && time.Now().Before(cached.expiresAt) {
+		return cached.userinfo, nil
+	}
+
+	userinfo, err := getHelperCredentials(ctx, helper, credentialServerAddress(host))
+	if err != nil {
+		return nil, err
+	}
+
+	d.helperMx.Lock()
+	defer d.helperMx.Unlock()
+	if d.helperCache == nil {
+		d.helperCache = map[string]helperCredentials{}
+	}
+	d.helperCache[host] = helperCredentials{
+		expiresAt: time.Now().Add(helperCredentialsTTL),
+		userinfo:  userinfo,
+		helper:    helper,
+	}
+	return userinfo, nil
+}
+
+func (a DockerAuth) userinfo() (*url.Userinfo, error) {
+	if a.IdentityToken != "" {
+		return url.UserPassword("<token>", a.IdentityToken), nil
+	}
+	if a.Username != "" || a.Password != "" {
+		return url.UserPassword(a.Username, a.Password), nil
+	}
+	if a.Auth == "" {
+		return nil, nil
+	}
+	b, err := base64.StdEncoding.DecodeString(a.Auth)
+	if err != nil {
+		return nil, err
+	}
+	username, password, ok := strings.Cut(string(b), ":")
+	if !ok {
+		return nil, errors.New("auth has to be formatted as username:password")
+	}
+	return url.UserPassword(username, password), nil
+}
+
+// getHelperCredentials executes the docker-credential-<helper> binary to get credentials.
+func getHelperCredentials(ctx context.Context, helper, serverAddress string) (*url.Userinfo, error) {
+	stdout := &bytes.Buffer{}
+	cmd := exec.CommandContext(ctx, "docker-credential-"+helper, "get")
+	cmd.Stdin = strings.NewReader(serverAddress)
+	cmd.Stdout = stdout
+	err := cmd.Run()
+	if err != nil {
+		// Helpers write errors to stdout.
+		msg := strings.TrimSpace(stdout.String())
+		if strings.Contains(msg, "credentials not found") {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("credential helper %s failed: %w %s", helper, err, msg)
+	}
+	helperResp := struct {
+		ServerURL string `json:"ServerURL"`
+		Username  string `json:"Username"`
+		Secret    string `json:"Secret"`
+	}{}
+	err = json.Unmarshal(stdout.Bytes(), &helperResp)
+	if err != nil {
+		return nil, err
+	}
+	return url.UserPassword(helperResp.Username, helperResp.Secret), nil
+}
+
+// normalizeCredentialHost returns the host for config keys which may be URLs.
+func normalizeCredentialHost(host string) string {
+	host = strings.TrimPrefix(host, "https://")
+	host = strings.TrimPrefix(host, "http://")
+	host, _, _ = strings.Cut(host, "/")
+	switch host {
+	case "docker.io", "registry-1.docker.io":
+		return "index.docker.io"
+	default:
+		return host
+	}
+}
+
+func credentialServerAddress(host string) string {
+	if host == "index.docker.io" {
+		return dockerHubServerAddress
+	}
+	return host
+}
@@ -0,0 +1,282 @@
+package oci
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-openapi/testify/v2/require"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/spegel-org/spegel/pkg/httpx"
+)
+
+const fakeCredentialHelper = `#!/bin/sh
+read -r server
+case "$server" in
+  https://index.docker.io/v1/) echo '{"ServerURL":"https://index.docker.io/v1/","Username":"hub","Secret":"hub-secret"}' ;;
+  helper.example.com|127.0.0.1:*) echo '{"ServerURL":"'"$server"'","Username":"helper","Secret":"helper-secret"}' ;;
+  broken.example.com) echo 'something went wrong'; exit 1 ;;
+  *) echo 'credentials not found in native keychain'; exit 1 ;;
+esac
+`
+
+// countingCredentialHelper records every execution in the file set by COUNT_FILE.
+const countingCredentialHelper = `#!/bin/sh
+read -r server
+echo "$server" >> "$COUNT_FILE"
+echo '{"ServerURL":"'"$server"'","Username":"counted","Secret":"counted-secret"}'
+`
+
+func TestDefaultDockerConfigPath(t *testing.T) {
+	t.Setenv("DOCKER_CONFIG", "/tmp/docker")
+	p, err := DefaultDockerConfigPath()
+	require.NoError(t, err)
+	require.EqualT(t, "/tmp/docker/config.json", p)
+}
+
+func TestLoadDockerConfig(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	cfg, err := LoadDockerConfig(filepath.Join(dir, "config.json"))
+	require.NoError(t, err)
+	require.Equal(t, &DockerConfig{}, cfg)
+
+	err = os.WriteFile(filepath.Join(dir, "config.json"), []byte("foo"), 0o644)
+	require.NoError(t, err)
+	_, err = LoadDockerConfig(filepath.Join(dir, "config.json"))
+	require.Error(t, err)
+
+	err = os.WriteFile(filepath.Join(dir, "config.json"), []byte(`{"auths":{"example.com":{"auth":"Zm9vOmJhcg=="}},"credHelpers":{"gcr.io":"gcloud"},"credsStore":"desktop"}`), 0o644)
+	require.NoError(t, err)
+	cfg, err = LoadDockerConfig(filepath.Join(dir, "config.json"))
+	require.NoError(t, err)
+	expected := &DockerConfig{
+		Auths:       map[string]DockerAuth{"example.com": {Auth: "Zm9vOmJhcg=="}},
+		CredHelpers: map[string]string{"gcr.io": "gcloud"},
+		CredsStore:  "desktop",
+	}
+	require.Equal(t, expected, cfg)
+}
+
+func TestDockerConfigGet(t *testing.T) {
+	binDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(binDir, "docker-credential-fake"), []byte(fakeCredentialHelper), 0o755)
+	require.NoError(t, err)
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	cfg := &DockerConfig{
+		Auths: map[string]DockerAuth{
+			"https://index.docker.io/v1/": {Auth: base64.StdEncoding.EncodeToString([]byte("foo:bar"))},
+			"https://example.com/v2/":     {Username: "user", Password: "pass"},
+			"token.example.com":           {IdentityToken: "refresh"},
+			"invalid.example.com":         {Auth: "foo"},
+			"empty.example.com":           {},
+		},
+		CredHelpers: map[string]string{
+			"helper.example.com": "fake",
+			"broken.example.com": "fake",
+		},
+	}
+
+	tests := []struct {
+		name        string
+		host        string
+		expected    *url.Userinfo
+		expectedErr string
+	}{
+		{
+			name:     "docker hub auth",
+			host:     "docker.io",
+			expected: url.UserPassword("foo", "bar"),
+		},
+		{
+			name:     "username and password with url key",
+			host:     "example.com",
+			expected: url.UserPassword("user", "pass"),
+		},
+		{
+			name:     "identity token",
+			host:     "token.example.com",
+			expected: url.UserPassword("<token>", "refresh"),
+		},
+		{
+			name:        "invalid auth",
+			host:        "invalid.example.com",
+			expectedErr: "could not decode auth for invalid.example.com: illegal base64 data at input byte 0",
+		},
+		{
+			name:     "empty auth",
+			host:     "empty.example.com",
+			expected: nil,
+		},
+		{
+			name:     "credential helper",
+			host:     "helper.example.com",
+			expected: url.UserPassword("helper", "helper-secret"),
+		},
+		{
+			name:        "failing credential helper",
+			host:        "broken.example.com",
+			expectedErr: "credential helper fake failed: exit status 1 something went wrong",
+		},
+		{
+			name:     "no credentials",
+			host:     "unknown.example.com",
+			expected: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userinfo, err := cfg.Get(t.Context(), tt.host)
+			if tt.expectedErr != "" {
+				require.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, userinfo)
+		})
+	}
+
+	// Credential store is used as fallback for all hosts.
+	cfg.CredsStore = "fake"
+	userinfo, err := cfg.Get(t.Context(), "registry-1.docker.io")
+	require.NoError(t, err)
+	require.Equal(t, url.UserPassword("foo", "bar"), userinfo)
+	cfg.Auths = nil
+	userinfo, err = cfg.Get(t.Context(), "docker.io")
+	require.NoError(t, err)
+	require.Equal(t, url.UserPassword("hub", "hub-secret"), userinfo)
+	userinfo, err = cfg.Get(t.Context(), "unknown.example.com")
+	require.NoError(t, err)
+	require.Nil(t, userinfo)
+}
+
+func TestDockerConfigHelperCache(t *testing.T) {
+	binDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(binDir, "docker-credential-counting"), []byte(countingCredentialHelper), 0o755)
+	require.NoError(t, err)
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	countFile := filepath.Join(t.TempDir(), "count")
+	t.Setenv("COUNT_FILE", countFile)
+	executions := func() int {
+		b, err := os.ReadFile(countFile)
+		require.NoError(t, err)
+		return strings.Count(string(b), "\n")
+	}
+
+	cfg := &DockerConfig{CredsStore: "counting"}
+	for range 3 {
+		userinfo, err := cfg.Get(t.Context(), "example.com")
+		require.NoError(t, err)
+		require.Equal(t, url.UserPassword("counted", "counted-secret"), userinfo)
+	}
+	require.EqualT(t, 1, executions())
+
+	// Other hosts are fetched separately.
+	_, err = cfg.Get(t.Context(), "other.example.com")
+	require.NoError(t, err)
+	require.EqualT(t, 2, executions())
+
+	// Invalidated credentials are fetched again.
+	cfg.Invalidate("example.com")
+	_, err = cfg.Get(t.Context(), "example.com")
+	require.NoError(t, err)
+	require.EqualT(t, 3, executions())
+}
+
+func TestClientCredentials(t *testing.T) {
+	binDir := t.TempDir()
+	err := os.WriteFile(filepath.Join(binDir, "docker-credential-fake"), []byte(fakeCredentialHelper), 0o755)
+	require.NoError(t, err)
+	t.Setenv("PATH", binDir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	blob := []byte("private")
+	dgst := digest.FromBytes(blob)
+	mux := http.NewServeMux()
+	var srvURL string
+	mux.HandleFunc("GET /token", func(rw http.ResponseWriter, req *http.Request) {
+		username, password, ok := req.BasicAuth()
+		if !ok || username != "helper" || password != "helper-secret" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rw.Write([]byte(`{"token":"private-token"}`))
+	})
+	mux.HandleFunc("GET /v2/private/blobs/{digest}", func(rw http.ResponseWriter, req *http.Request) {
+		if req.Header.Get(httpx.HeaderAuthorization) != "Bearer private-token" {
+			rw.Header().Set(httpx.HeaderWWWAuthenticate, `Bearer realm="`+srvURL+`/token",service="test",scope="repository:private:pull"`)
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		rw.Write(blob)
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		srv.Close()
+	})
+	srvURL = srv.URL
+	u, err := url.Parse(srv.URL)
+	require.NoError(t, err)
+
+	ref := Reference{
+		Registry:   u.Host,
+		Repository: "private",
+		Digest:     dgst,
+	}
+	dist, err := NewDistributionPath(ref, DistributionKindBlob, "http", http.MethodGet, nil)
+	require.NoError(t, err)
+
+	ociClient, err := NewClient()
+	require.NoError(t, err)
+	_, _, err = ociClient.Fetch(t.Context(), dist)
+	require.EqualError(t, err, "expected one of the following statuses [200 OK], but received 401 Unauthorized")
+
+	ociClient, err = NewClient(WithCredentials(&DockerConfig{CredHelpers: map[string]string{u.Host: "fake"}}))
+	require.NoError(t, err)
+	rc, desc, err := ociClient.Fetch(t.Context(), dist)
+	require.NoError(t, err)
+	defer rc.Close()
+	require.EqualT(t, dgst, desc.Digest)
+
+	// Credentials of the upstream registry are not sent to mirrors.
+	mirrorRef := Reference{
+		Registry:   "helper.example.com",
+		Repository: "private",
+		Digest:     dgst,
+	}
+	mirrorDist, err := NewDistributionPath(mirrorRef, DistributionKindBlob, "http", http.MethodGet, nil)
+	require.NoError(t, err)
+	ociClient, err = NewClient(WithCredentials(&DockerConfig{CredHelpers: map[string]string{"helper.example.com": "fake"}}))
+	require.NoError(t, err)
+	_, _, err = ociClient.Fetch(t.Context(), mirrorDist, WithFetchMirror(u))
+	require.EqualError(t, err, "expected one of the following statuses [200 OK], but received 401 Unauthorized")
+
+	// Mirrors which are the registry itself over HTTPS receive the credentials.
+	tlsSrv := httptest.NewTLSServer(mux)
+	t.Cleanup(func() {
+		tlsSrv.Close()
+	})
+	tlsURL, err := url.Parse(tlsSrv.URL)
+	require.NoError(t, err)
+	srvURL = tlsSrv.URL
+	tlsRef := Reference{
+		Registry:   tlsURL.Host,
+		Repository: "private",
+		Digest:     dgst,
+	}
+	tlsDist, err := NewDistributionPath(tlsRef, DistributionKindBlob, "https", http.MethodGet, nil)
+	require.NoError(t, err)
+	ociClient, err = NewClient(WithTransport(tlsSrv.Client().Transport), WithCredentials(&DockerConfig{CredHelpers: map[string]string{tlsURL.Host: "fake"}}))
+	require.NoError(t, err)
+	tlsRC, tlsDesc, err := ociClient.Fetch(t.Context(), tlsDist, WithFetchMirror(tlsURL))
+	require.NoError(t, err)
+	defer tlsRC.Close()
+	require.EqualT(t, dgst, tlsDesc.Digest)
+}
@@ -0,0 +1,145 @@
+package crio
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/pelletier/go-toml/v2"
+
+	"github.com/spegel-org/spegel/internal/backup"
+	"github.com/spegel-org/spegel/internal/diff"
+	"github.com/spegel-org/spegel/pkg/oci"
+	"github.com/spegel-org/spegel/pkg/oci/containerd"
+)
+
+// DropInFile is the name of the registries configuration drop-in, it is sorted last so that it takes precedence.
+const DropInFile = "99-spegel.conf"
+
+type registriesConfig struct {
+	Registries []registryConfig `toml:"registry"`
+}
+
+type registryConfig struct {
+	Location string         `toml:"location"`
+	Mirrors  []mirrorConfig `toml:"mirror"`
+}
+
+type mirrorConfig struct {
+	Location       string `toml:"location"`
+	PullFromMirror string `toml:"pull-from-mirror"`
+	Insecure       bool   `toml:"insecure"`
+}
+
+// WriteMirrorConfiguration writes a registries configuration drop-in with mirrors for each registry.
+// An existing drop-in with the same name is backed up so that it can be restored.
+// Mirrors replace the mirrors configured for the same registry in the main configuration file.
+// https://github.com/containers/image/blob/main/docs/containers-registries.conf.d.5.md
+func WriteMirrorConfiguration(ctx context.Context, configPath string, cfg containerd.MirrorConfig, userinfo *url.Userinfo) error {
+	log := logr.FromContextOrDiscard(ctx)
+
+	b, err := renderDropIn(cfg, userinfo)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(configPath, 0o755)
+	if err != nil {
+		return err
+	}
+	fp := filepath.Join(configPath, DropInFile)
+	err = backup.File(fp)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(fp, b, 0o644)
+	if err != nil {
+		return err
+	}
+	log.Info("added crio mirror configuration", "path", fp)
+	return nil
+}
+
+// DiffMirrorConfiguration returns a unified diff between the current drop-in and the drop-in that would be written.
+func DiffMirrorConfiguration(ctx context.Context, configPath string, cfg containerd.MirrorConfig, userinfo *url.Userinfo) (string, error) {
+	expected, err := renderDropIn(cfg, userinfo)
+	if err != nil {
+		return "", err
+	}
+	oldName := "a/" + DropInFile
+	current, err := os.ReadFile(filepath.Join(configPath, DropInFile))
+	if errors.Is(err, os.ErrNotExist) {
+		oldName = ""
+	} else if err != nil {
+		return "", err
+	}
+	return diff.Unified(oldName, "b/"+DropInFile, string(current), string(expected)), nil
+}
+
+// CleanupMirrorConfiguration removes the drop-in and restores any drop-in that existed before mirrors were configured.
+func CleanupMirrorConfiguration(ctx context.Context, configPath string) error {
+	log := logr.FromContextOrDiscard(ctx)
+
+	fp := filepath.Join(configPath, DropInFile)
+	ok, err := backup.Restore(fp)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		log.Info("skipping crio cleanup because backup does not exist", "path", fp)
+		return nil
+	}
+	log.Info("recovering crio registries configuration", "path", fp)
+	return nil
+}
+
+func renderDropIn(cfg containerd.MirrorConfig, userinfo *url.Userinfo) ([]byte, error) {
+	if userinfo != nil {
+		return nil, errors.New("crio registry mirrors do not support basic authentication")
+	}
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	regsCfg := registriesConfig{}
+	for _, regCfg := range cfg.Registries {
+		mr, mts, err := regCfg.Parse()
+		if err != nil {
+			return nil, err
+		}
+		if mr == oci.WildcardRegistryURL {
+			return nil, errors.New("crio does not support mirroring all registries, mirrored registries have to be set")
+		}
+		// The registry is passed to the mirror as the first repository component, which only works for host names without a port.
+		if mr.Port() != "" || (!strings.Contains(mr.Host, ".") && mr.Host != "localhost") {
+			return nil, fmt.Errorf("crio mirroring requires registry %s to be a host name without a port", mr.Host)
+		}
+		pullFromMirror := "digest-only"
+		if slices.Contains(regCfg.Capabilities, "resolve") {
+			pullFromMirror = "all"
+		}
+		mirrors := []mirrorConfig{}
+		for _, mt := range mts {
+			mirrors = append(mirrors, mirrorConfig{
+				Location:       mt.Host + "/" + mr.Host,
+				PullFromMirror: pullFromMirror,
+				Insecure:       mt.Scheme == "http",
+			})
+		}
+		regsCfg.Registries = append(regsCfg.Registries, registryConfig{
+			Location: mr.Host,
+			Mirrors:  mirrors,
+		})
+	}
+	b, err := toml.Marshal(regsCfg)
+	if err != nil {
+		return nil, err
+	}
+	return b, nil
+}
@@ -0,0 +1,116 @@
+package crio
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-openapi/testify/v2/require"
+
+	"github.com/spegel-org/spegel/pkg/oci/containerd"
+)
+
+func TestMirrorConfiguration(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		existing string
+	}{
+		{
+			name: "no existing drop-in",
+		},
+		{
+			name:     "existing drop-in",
+			existing: "[[registry]]\nlocation = 'docker.io'\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			configPath := filepath.Join(t.TempDir(), "registries.conf.d")
+			fp := filepath.Join(configPath, DropInFile)
+			if tt.existing != "" {
+				err := os.MkdirAll(configPath, 0o755)
+				require.NoError(t, err)
+				err = os.WriteFile(fp, []byte(tt.existing), 0o644)
+				require.NoError(t, err)
+			}
+			cfg := containerd.NewMirrorConfig([]string{"https://docker.io", "https://ghcr.io"}, []string{"http://127.0.0.1:5000", "https://spegel.example.com"}, true)
+			cfg.Registries[1].Capabilities = []string{"pull"}
+
+			diff, err := DiffMirrorConfiguration(t.Context(), configPath, cfg, nil)
+			require.NoError(t, err)
+			require.NotEmpty(t, diff)
+
+			err = WriteMirrorConfiguration(t.Context(), configPath, cfg, nil)
+			require.NoError(t, err)
+			err = WriteMirrorConfiguration(t.Context(), configPath, cfg, nil)
+			require.NoError(t, err)
+			b, err := os.ReadFile(fp)
+			require.NoError(t, err)
+			expected := `[[registry]]
+location = 'docker.io'
+
+[[registry.mirror]]
+location = '127.0.0.1:5000/docker.io'
+pull-from-mirror = 'all'
+insecure = true
+
+[[registry.mirror]]
+location = 'spegel.example.com/docker.io'
+pull-from-mirror = 'all'
+insecure = false
+
+[[registry]]
+location = 'ghcr.io'
+
+[[registry.mirror]]
+location = '127.0.0.1:5000/ghcr.io'
+pull-from-mirror = 'digest-only'
+insecure = true
+
+[[registry.mirror]]
+location = 'spegel.example.com/ghcr.io'
+pull-from-mirror = 'digest-only'
+insecure = false
+`
+			require.EqualT(t, expected, string(b))
+			diff, err = DiffMirrorConfiguration(t.Context(), configPath, cfg, nil)
+			require.NoError(t, err)
+			require.Empty(t, diff)
+
+			err = CleanupMirrorConfiguration(t.Context(), configPath)
+			require.NoError(t, err)
+			b, err = os.ReadFile(fp)
+			if tt.existing == "" {
+				require.ErrorIs(t, err, os.ErrNotExist)
+				return
+			}
+			require.NoError(t, err)
+			require.EqualT(t, tt.existing, string(b))
+		})
+	}
+}
+
+func TestMirrorConfigurationErrors(t *testing.T) {
+	t.Parallel()
+
+	configPath := t.TempDir()
+	cfg := containerd.NewMirrorConfig(nil, []string{"http://127.0.0.1:5000"}, true)
+	err := WriteMirrorConfiguration(t.Context(), configPath, cfg, nil)
+	require.EqualError(t, err, "crio does not support mirroring all registries, mirrored registries have to be set")
+
+	cfg = containerd.NewMirrorConfig([]string{"https://docker.io"}, []string{"http://127.0.0.1:5000"}, true)
+	err = WriteMirrorConfiguration(t.Context(), configPath, cfg, url.UserPassword("foo", "bar"))
+	require.EqualError(t, err, "crio registry mirrors do not support basic authentication")
+
+	cfg = containerd.NewMirrorConfig([]string{"http://localhost:5001"}, []string{"http://127.0.0.1:5000"}, true)
+	err = WriteMirrorConfiguration(t.Context(), configPath, cfg, nil)
+	require.EqualError(t, err, "crio mirroring requires registry localhost:5001 to be a host name without a port")
+
+	err = CleanupMirrorConfiguration(t.Context(), configPath)
+	require.NoError(t, err)
+}
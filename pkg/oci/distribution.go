@@ -7,6 +7,7 @@ import (
 	"net/http"
 	"net/url"
 	"regexp"
+	"strings"
 
 	"github.com/opencontainers/go-digest"
 
@@ -21,14 +22,16 @@ var (
 	manifestRegexTag    = regexp.MustCompile(`/v2/` + repoRegexStr + `/manifests/` + tagRegexStr + `$`)
 	manifestRegexDigest = regexp.MustCompile(`/v2/` + repoRegexStr + `/manifests/(.*)`)
 	blobsRegexDigest    = regexp.MustCompile(`/v2/` + repoRegexStr + `/blobs/(.*)`)
+	referrersRegex      = regexp.MustCompile(`/v2/` + repoRegexStr + `/referrers/(.*)`)
 )
 
 // DistributionKind represents the kind of content.
 type DistributionKind string
 
 const (
-	DistributionKindManifest = "manifests"
-	DistributionKindBlob     = "blobs"
+	DistributionKindManifest  = "manifests"
+	DistributionKindBlob      = "blobs"
+	DistributionKindReferrers = "referrers"
 )
 
 // DistributionPath contains the individual parameters from a OCI distribution spec request.
@@ -50,6 +53,9 @@ func NewDistributionPath(ref Reference, kind DistributionKind, scheme, method st
 	if kind == DistributionKindBlob && ref.Tag != "" {
 		return DistributionPath{}, errors.New("tag reference cannot be used for blobs")
 	}
+	if kind == DistributionKindReferrers && ref.Digest == "" {
+		return DistributionPath{}, errors.New("referrers can only be listed for digests")
+	}
 	dist := DistributionPath{
 		Kind:      kind,
 		Reference: ref,
@@ -68,8 +74,8 @@ func (d DistributionPath) Validate() error {
 	if d.Method != http.MethodHead && d.Method != http.MethodGet {
 		return errors.New("fetch only supports HEAD and GET requests")
 	}
-	if d.Kind == DistributionKindManifest && d.Range != nil {
-		return errors.New("cannot make range requests for manifests")
+	if d.Kind != DistributionKindBlob && d.Range != nil {
+		return fmt.Errorf("cannot make range requests for %s", d.Kind)
 	}
 	return nil
 }
@@ -99,6 +105,7 @@ func (d DistributionPath) Clone() DistributionPath {
 
 // ParseDistributionPath gets the parameters from a URL which conforms with the OCI distribution spec.
 // It returns a distribution path which contains all the individual parameters.
+// Requests without the registry parameter, sent by runtimes other than containerd, either prefix the repository with the registry or are for Docker Hub.
 // https://github.com/opencontainers/distribution-spec/blob/main/spec.md
 func ParseDistributionPath(req *http.Request) (DistributionPath, error) {
 	scheme := "http"
@@ -109,12 +116,10 @@ func ParseDistributionPath(req *http.Request) (DistributionPath, error) {
 	registry := req.URL.Query().Get("ns")
 	comps := manifestRegexTag.FindStringSubmatch(req.URL.Path)
 	if len(comps) == 3 {
-		if registry == "" {
-			return DistributionPath{}, errors.New("registry parameter needs to be set for tag references")
-		}
+		registry, repository := registryFromPath(registry, comps[1])
 		ref := Reference{
 			Registry:   registry,
-			Repository: comps[1],
+			Repository: repository,
 			Tag:        comps[2],
 		}
 		dist, err := NewDistributionPath(ref, DistributionKindManifest, scheme, req.Method, nil)
@@ -129,9 +134,10 @@ func ParseDistributionPath(req *http.Request) (DistributionPath, error) {
 		if err != nil {
 			return DistributionPath{}, err
 		}
+		registry, repository := registryFromPath(registry, comps[1])
 		ref := Reference{
 			Registry:   registry,
-			Repository: comps[1],
+			Repository: repository,
 			Digest:     dgst,
 		}
 		dist, err := NewDistributionPath(ref, DistributionKindManifest, scheme, req.Method, nil)
@@ -146,9 +152,10 @@ func ParseDistributionPath(req *http.Request) (DistributionPath, error) {
 		if err != nil {
 			return DistributionPath{}, err
 		}
+		registry, repository := registryFromPath(registry, comps[1])
 		ref := Reference{
 			Registry:   registry,
-			Repository: comps[1],
+			Repository: repository,
 			Digest:     dgst,
 		}
 		rng, err := httpx.ParseRangeHeader(req.Header)
@@ -161,9 +168,42 @@ func ParseDistributionPath(req *http.Request) (DistributionPath, error) {
 		}
 		return dist, nil
 	}
+	comps = referrersRegex.FindStringSubmatch(req.URL.Path)
+	if len(comps) == 3 {
+		dgst, err := digest.Parse(comps[2])
+		if err != nil {
+			return DistributionPath{}, err
+		}
+		registry, repository := registryFromPath(registry, comps[1])
+		ref := Reference{
+			Registry:   registry,
+			Repository: repository,
+			Digest:     dgst,
+		}
+		dist, err := NewDistributionPath(ref, DistributionKindReferrers, scheme, req.Method, nil)
+		if err != nil {
+			return DistributionPath{}, err
+		}
+		return dist, nil
+	}
 	return DistributionPath{}, errors.New("distribution path could not be parsed")
 }
 
+// registryFromPath returns the registry and repository of a request.
+// When the registry parameter is not set the first repository component is used as registry if it is a host name.
+// Mirrors for registries other than Docker Hub are configured with the registry as path, which is how this prefix is set.
+// Remaining requests are assumed to be for Docker Hub, as Docker only mirrors Docker Hub and does not support mirror paths.
+func registryFromPath(registry, repository string) (string, string) {
+	if registry != "" {
+		return registry, repository
+	}
+	host, rest, ok := strings.Cut(repository, "/")
+	if ok && (strings.Contains(host, ".") || host == "localhost") {
+		return host, rest
+	}
+	return DefaultRegistry, repository
+}
+
 var _ httpx.ResponseError = &DistributionError{}
 
 type DistributionErrorCode string
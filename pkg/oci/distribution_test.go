@@ -55,6 +55,16 @@ func TestParseDistributionPath(t *testing.T) {
 			expectedRef:  "sha256:295c7be079025306c4f1d65997fcf7adb411c88f139ad1d34b537164aa060369",
 			expectedKind: DistributionKindBlob,
 		},
+		{
+			name:         "referrers digest",
+			registry:     "docker.io",
+			path:         "/v2/library/nginx/referrers/sha256:0a404ca8e119d061cdb2dceee824c914cdc69b31bc7b5956ef5a520436a80d39",
+			expectedName: "library/nginx",
+			expectedDgst: digest.Digest("sha256:0a404ca8e119d061cdb2dceee824c914cdc69b31bc7b5956ef5a520436a80d39"),
+			expectedTag:  "",
+			expectedRef:  "sha256:0a404ca8e119d061cdb2dceee824c914cdc69b31bc7b5956ef5a520436a80d39",
+			expectedKind: DistributionKindReferrers,
+		},
 		{
 			name:         "manifest with consecutive dashes",
 			registry:     "example.com",
@@ -100,6 +110,54 @@ func TestParseDistributionPath(t *testing.T) {
 	}
 }
 
+func TestParseDistributionPathWithoutRegistry(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name               string
+		path               string
+		expectedRegistry   string
+		expectedRepository string
+	}{
+		{
+			name:               "docker hub tag",
+			path:               "/v2/library/nginx/manifests/latest",
+			expectedRegistry:   "docker.io",
+			expectedRepository: "library/nginx",
+		},
+		{
+			name:               "docker hub blob",
+			path:               "/v2/library/nginx/blobs/sha256:295c7be079025306c4f1d65997fcf7adb411c88f139ad1d34b537164aa060369",
+			expectedRegistry:   "docker.io",
+			expectedRepository: "library/nginx",
+		},
+		{
+			name:               "registry prefix tag",
+			path:               "/v2/ghcr.io/spegel-org/spegel/manifests/v0.0.1",
+			expectedRegistry:   "ghcr.io",
+			expectedRepository: "spegel-org/spegel",
+		},
+		{
+			name:               "registry prefix digest",
+			path:               "/v2/localhost/spegel/manifests/sha256:0a404ca8e119d061cdb2dceee824c914cdc69b31bc7b5956ef5a520436a80d39",
+			expectedRegistry:   "localhost",
+			expectedRepository: "spegel",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, tt.path, nil)
+			require.NoError(t, err)
+			dist, err := ParseDistributionPath(req)
+			require.NoError(t, err)
+			require.EqualT(t, tt.expectedRegistry, dist.Registry)
+			require.EqualT(t, tt.expectedRepository, dist.Repository)
+		})
+	}
+}
+
 func TestParseDistributionPathErrors(t *testing.T) {
 	t.Parallel()
 
@@ -133,11 +191,12 @@ func TestParseDistributionPathErrors(t *testing.T) {
 			expectedError: "invalid checksum digest length",
 		},
 		{
-			name: "manifest tag with missing registry",
+			name: "referrers with tag reference",
 			url: &url.URL{
-				Path: "/v2/spegel-org/spegel/manifests/v0.0.1",
+				Path:     "/v2/spegel-org/spegel/referrers/v0.0.1",
+				RawQuery: "ns=example.com",
 			},
-			expectedError: "registry parameter needs to be set for tag references",
+			expectedError: "invalid checksum digest format",
 		},
 		{
 			name: "manifest with invalid digest",
@@ -0,0 +1,163 @@
+package docker
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/go-logr/logr"
+
+	"github.com/spegel-org/spegel/internal/backup"
+	"github.com/spegel-org/spegel/internal/diff"
+	"github.com/spegel-org/spegel/pkg/oci"
+	"github.com/spegel-org/spegel/pkg/oci/containerd"
+)
+
+const registryMirrorsKey = "registry-mirrors"
+
+// WriteMirrorConfiguration sets the registry mirrors in the Docker daemon configuration.
+// All other configuration is kept, and the original configuration is backed up so that it can be restored.
+// Docker only supports mirroring Docker Hub so configuration for other registries is ignored.
+// https://docs.docker.com/reference/cli/dockerd/#daemon-configuration-file
+func WriteMirrorConfiguration(ctx context.Context, configPath string, cfg containerd.MirrorConfig, prependExisting bool, userinfo *url.Userinfo) error {
+	log := logr.FromContextOrDiscard(ctx)
+
+	b, err := renderDaemonConfig(ctx, configPath, cfg, prependExisting, userinfo)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(configPath), 0o755)
+	if err != nil {
+		return err
+	}
+	err = backup.File(configPath)
+	if err != nil {
+		return err
+	}
+	err = os.WriteFile(configPath, b, 0o644)
+	if err != nil {
+		return err
+	}
+	log.Info("added docker mirror configuration", "path", configPath)
+	return nil
+}
+
+// DiffMirrorConfiguration returns a unified diff between the current configuration and the configuration that would be written.
+func DiffMirrorConfiguration(ctx context.Context, configPath string, cfg containerd.MirrorConfig, prependExisting bool, userinfo *url.Userinfo) (string, error) {
+	expected, err := renderDaemonConfig(ctx, configPath, cfg, prependExisting, userinfo)
+	if err != nil {
+		return "", err
+	}
+	name := filepath.Base(configPath)
+	oldName := "a/" + name
+	current, err := os.ReadFile(configPath)
+	if errors.Is(err, os.ErrNotExist) {
+		oldName = ""
+	} else if err != nil {
+		return "", err
+	}
+	return diff.Unified(oldName, "b/"+name, string(current), string(expected)), nil
+}
+
+// CleanupMirrorConfiguration restores the Docker daemon configuration from before mirrors were configured.
+func CleanupMirrorConfiguration(ctx context.Context, configPath string) error {
+	log := logr.FromContextOrDiscard(ctx)
+
+	ok, err := backup.Restore(configPath)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		log.Info("skipping docker cleanup because backup does not exist", "path", configPath)
+		return nil
+	}
+	log.Info("recovering docker daemon configuration", "path", configPath)
+	return nil
+}
+
+func renderDaemonConfig(ctx context.Context, configPath string, cfg containerd.MirrorConfig, prependExisting bool, userinfo *url.Userinfo) ([]byte, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	if userinfo != nil {
+		return nil, errors.New("docker registry mirrors do not support basic authentication")
+	}
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
+	// Prefer explicit Docker Hub configuration over the wildcard configuration.
+	var mirrorTargets []url.URL
+	for _, regCfg := range cfg.Registries {
+		mr, mts, err := regCfg.Parse()
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case mr.Host == "docker.io":
+			mirrorTargets = mts
+		case mr == oci.WildcardRegistryURL:
+			if mirrorTargets == nil {
+				mirrorTargets = mts
+			}
+		default:
+			log.Info("skipping registry as docker only supports mirroring docker.io", "registry", mr.String())
+		}
+	}
+	if mirrorTargets == nil {
+		return nil, errors.New("docker only supports mirroring docker.io which is not configured")
+	}
+
+	daemonCfg := map[string]json.RawMessage{}
+	existingMirrors := []string{}
+	b, ok, err := backup.Original(configPath)
+	if err != nil {
+		return nil, err
+	}
+	if ok {
+		err = json.Unmarshal(b, &daemonCfg)
+		if err != nil {
+			return nil, fmt.Errorf("could not decode docker daemon configuration %s: %w", configPath, err)
+		}
+		if raw, ok := daemonCfg[registryMirrorsKey]; ok {
+			err = json.Unmarshal(raw, &existingMirrors)
+			if err != nil {
+				return nil, fmt.Errorf("could not decode docker registry mirrors in %s: %w", configPath, err)
+			}
+		}
+	}
+
+	mirrors := []string{}
+	for _, mt := range mirrorTargets {
+		mirrors = append(mirrors, mt.String())
+	}
+	if prependExisting {
+		for _, mirror := range existingMirrors {
+			if slices.Contains(mirrors, mirror) {
+				continue
+			}
+			mirrors = append(mirrors, mirror)
+		}
+	}
+	raw, err := json.Marshal(mirrors)
+	if err != nil {
+		return nil, err
+	}
+	daemonCfg[registryMirrorsKey] = raw
+
+	buf := &bytes.Buffer{}
+	enc := json.NewEncoder(buf)
+	enc.SetEscapeHTML(false)
+	enc.SetIndent("", "  ")
+	err = enc.Encode(daemonCfg)
+	if err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,129 @@
+package docker
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-openapi/testify/v2/require"
+
+	"github.com/spegel-org/spegel/pkg/oci/containerd"
+)
+
+func TestMirrorConfiguration(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name            string
+		existing        string
+		expected        string
+		registries      []string
+		prependExisting bool
+	}{
+		{
+			name:       "no existing configuration",
+			registries: []string{"https://docker.io", "https://ghcr.io"},
+			expected: `{
+  "registry-mirrors": [
+    "http://127.0.0.1:5000",
+    "http://127.0.0.1:5001"
+  ]
+}
+`,
+		},
+		{
+			name:       "wildcard registry",
+			registries: []string{},
+			existing:   `{"debug": true, "registry-mirrors": ["https://mirror.example.com"]}`,
+			expected: `{
+  "debug": true,
+  "registry-mirrors": [
+    "http://127.0.0.1:5000",
+    "http://127.0.0.1:5001"
+  ]
+}
+`,
+		},
+		{
+			name:            "prepend existing",
+			registries:      []string{"https://docker.io"},
+			existing:        `{"log-opts": {"max-size": "10m"}, "registry-mirrors": ["https://mirror.example.com", "http://127.0.0.1:5000"]}`,
+			prependExisting: true,
+			expected: `{
+  "log-opts": {
+    "max-size": "10m"
+  },
+  "registry-mirrors": [
+    "http://127.0.0.1:5000",
+    "http://127.0.0.1:5001",
+    "https://mirror.example.com"
+  ]
+}
+`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			configPath := filepath.Join(t.TempDir(), "docker", "daemon.json")
+			if tt.existing != "" {
+				err := os.MkdirAll(filepath.Dir(configPath), 0o755)
+				require.NoError(t, err)
+				err = os.WriteFile(configPath, []byte(tt.existing), 0o644)
+				require.NoError(t, err)
+			}
+			cfg := containerd.NewMirrorConfig(tt.registries, []string{"http://127.0.0.1:5000", "http://127.0.0.1:5001"}, true)
+
+			diff, err := DiffMirrorConfiguration(t.Context(), configPath, cfg, tt.prependExisting, nil)
+			require.NoError(t, err)
+			require.NotEmpty(t, diff)
+
+			// Writing multiple times should always merge with the original configuration.
+			for range 2 {
+				err = WriteMirrorConfiguration(t.Context(), configPath, cfg, tt.prependExisting, nil)
+				require.NoError(t, err)
+				b, err := os.ReadFile(configPath)
+				require.NoError(t, err)
+				require.EqualT(t, tt.expected, string(b))
+			}
+			diff, err = DiffMirrorConfiguration(t.Context(), configPath, cfg, tt.prependExisting, nil)
+			require.NoError(t, err)
+			require.Empty(t, diff)
+
+			err = CleanupMirrorConfiguration(t.Context(), configPath)
+			require.NoError(t, err)
+			b, err := os.ReadFile(configPath)
+			if tt.existing == "" {
+				require.ErrorIs(t, err, os.ErrNotExist)
+				return
+			}
+			require.NoError(t, err)
+			require.EqualT(t, tt.existing, string(b))
+		})
+	}
+}
+
+func TestMirrorConfigurationErrors(t *testing.T) {
+	t.Parallel()
+
+	configPath := filepath.Join(t.TempDir(), "daemon.json")
+	cfg := containerd.NewMirrorConfig([]string{"https://ghcr.io"}, []string{"http://127.0.0.1:5000"}, true)
+	err := WriteMirrorConfiguration(t.Context(), configPath, cfg, false, nil)
+	require.EqualError(t, err, "docker only supports mirroring docker.io which is not configured")
+
+	cfg = containerd.NewMirrorConfig([]string{"https://docker.io"}, []string{"http://127.0.0.1:5000"}, true)
+	err = WriteMirrorConfiguration(t.Context(), configPath, cfg, false, url.UserPassword("foo", "bar"))
+	require.EqualError(t, err, "docker registry mirrors do not support basic authentication")
+
+	err = os.WriteFile(configPath, []byte("{"), 0o644)
+	require.NoError(t, err)
+	err = WriteMirrorConfiguration(t.Context(), configPath, cfg, false, nil)
+	require.EqualError(t, err, "could not decode docker daemon configuration "+configPath+": unexpected end of JSON input")
+	_, err = os.Stat(configPath + ".spegel-backup")
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	err = CleanupMirrorConfiguration(t.Context(), configPath)
+	require.NoError(t, err)
+}
@@ -0,0 +1,598 @@
+package oci
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+var semverRegex = regexp.MustCompile(`^v?(0|[1-9]\d*)\.(0|[1-9]\d*)\.(0|[1-9]\d*)(?:-[0-9A-Za-z.-]+)?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// DescriptorFilter is a filter which can also match on the descriptor of the content.
+type DescriptorFilter interface {
+	Filter
+	MatchesDescriptor(ref Reference, desc ocispec.Descriptor) bool
+}
+
+// MatchesFilterDescriptor returns true if the reference and descriptor matches any of the filters.
+func MatchesFilterDescriptor(ref Reference, desc ocispec.Descriptor, filters []Filter) bool {
+	for _, f := range filters {
+		if df, ok := f.(DescriptorFilter); ok {
+			if df.MatchesDescriptor(ref, desc) {
+				return true
+			}
+			continue
+		}
+		if f.Matches(ref) {
+			return true
+		}
+	}
+	return false
+}
+
+type FilterAction string
+
+const (
+	FilterActionAllow FilterAction = "allow"
+	FilterActionDeny  FilterAction = "deny"
+)
+
+// ExpressionFilterConfig is the configuration of an expression filter.
+type ExpressionFilterConfig struct {
+	// Default is the action when no rule matches. It defaults to deny if any allow rules exist, and allow otherwise.
+	Default FilterAction           `json:"default"`
+	Rules   []ExpressionRuleConfig `json:"rules"`
+}
+
+type ExpressionRuleConfig struct {
+	Action     FilterAction `json:"action"`
+	Expression string       `json:"expression"`
+}
+
+var _ DescriptorFilter = &ExpressionFilter{}
+
+// ExpressionFilter filters references with allow and deny rule expressions.
+// Deny rules take precedence over allow rules, which take precedence over the default action.
+//
+// Expressions can use the variables registry, repository, name, tag, digest, mediaType and size.
+// The name is unknown for content not known to belong to a repository, the tag is unknown for digest references,
+// the digest is unknown for tag references until resolved, and media type and size are unknown until the
+// descriptor is known. Expressions which depend on unknown variables are not applied, so that they do not
+// decide before the variables are known.
+// Supported operators are ==, !=, <, <=, >, >=, in, !, && and ||, together with the string methods
+// matches, startsWith, endsWith and contains and the function isSemver.
+//
+// The expressions are a small CEL like subset over a fixed set of variables. It is evaluated with three
+// valued logic to handle unknown variables, which a general purpose expression language does not do without
+// partial evaluation and brings in a large dependency tree. The parser is covered by a fuzz test.
+type ExpressionFilter struct {
+	defaultAction FilterAction
+	allow         []exprNode
+	deny          []exprNode
+}
+
+// LoadExpressionFilter reads a JSON encoded expression filter configuration.
+func LoadExpressionFilter(path string) (*ExpressionFilter, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := ExpressionFilterConfig{}
+	err = json.Unmarshal(b, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewExpressionFilter(cfg)
+}
+
+func NewExpressionFilter(cfg ExpressionFilterConfig) (*ExpressionFilter, error) {
+	f := &ExpressionFilter{}
+	for i, rule := range cfg.Rules {
+		node, err := parseExpression(rule.Expression)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse rule %d expression: %w", i, err)
+		}
+		switch rule.Action {
+		case FilterActionAllow:
+			f.allow = append(f.allow, node)
+		case FilterActionDeny:
+			f.deny = append(f.deny, node)
+		default:
+			return nil, fmt.Errorf("unknown filter action %s", rule.Action)
+		}
+	}
+	switch cfg.Default {
+	case FilterActionAllow, FilterActionDeny:
+		f.defaultAction = cfg.Default
+	case "":
+		f.defaultAction = FilterActionAllow
+		if len(f.allow) > 0 {
+			f.defaultAction = FilterActionDeny
+		}
+	default:
+		return nil, fmt.Errorf("unknown filter action %s", cfg.Default)
+	}
+	return f, nil
+}
+
+func (f *ExpressionFilter) Matches(ref Reference) bool {
+	return f.MatchesDescriptor(ref, ocispec.Descriptor{})
+}
+
+func (f *ExpressionFilter) MatchesDescriptor(ref Reference, desc ocispec.Descriptor) bool {
+	vars := map[string]any{
+		"registry":   ref.Registry,
+		"repository": ref.Repository,
+		"name":       ref.Registry + "/" + ref.Repository,
+		"tag":        ref.Tag,
+		"digest":     ref.Digest.String(),
+		"mediaType":  desc.MediaType,
+		"size":       desc.Size,
+	}
+	if ref.Registry == "" && ref.Repository == "" {
+		vars["registry"] = exprUnknown{}
+		vars["repository"] = exprUnknown{}
+		vars["name"] = exprUnknown{}
+	}
+	if ref.Tag == "" {
+		vars["tag"] = exprUnknown{}
+	}
+	if ref.Digest == "" {
+		vars["digest"] = desc.Digest.String()
+		if desc.Digest == "" {
+			vars["digest"] = exprUnknown{}
+		}
+	}
+	if desc.MediaType == "" {
+		vars["mediaType"] = exprUnknown{}
+		vars["size"] = exprUnknown{}
+	}
+	for _, node := range f.deny {
+		if node.eval(vars) == true {
+			return true
+		}
+	}
+	undecided := false
+	for _, node := range f.allow {
+		v := node.eval(vars)
+		if v == true {
+			return false
+		}
+		if isExprUnknown(v) {
+			undecided = true
+		}
+	}
+	// The default only applies when all allow rules could be evaluated.
+	if undecided {
+		return false
+	}
+	return f.defaultAction == FilterActionDeny
+}
+
+type exprType string
+
+const (
+	exprTypeString exprType = "string"
+	exprTypeInt    exprType = "int"
+	exprTypeBool   exprType = "bool"
+	exprTypeList   exprType = "list"
+)
+
+var exprVariables = map[string]exprType{
+	"registry":   exprTypeString,
+	"repository": exprTypeString,
+	"name":       exprTypeString,
+	"tag":        exprTypeString,
+	"digest":     exprTypeString,
+	"mediaType":  exprTypeString,
+	"size":       exprTypeInt,
+}
+
+// exprUnknown is the value of variables which are not known, expressions depending on it evaluate to unknown.
+type exprUnknown struct{}
+
+func isExprUnknown(v any) bool {
+	_, ok := v.(exprUnknown)
+	return ok
+}
+
+// exprNode is a type checked expression which evaluates to a value of its type or unknown.
+type exprNode struct {
+	eval func(vars map[string]any) any
+	typ  exprType
+}
+
+// exprUnary returns a node evaluating fn with the operand value, unless the operand is unknown.
+func exprUnary[T any](typ exprType, operand exprNode, fn func(v T) any) exprNode {
+	return exprNode{typ: typ, eval: func(vars map[string]any) any {
+		v := operand.eval(vars)
+		if isExprUnknown(v) {
+			return exprUnknown{}
+		}
+		//nolint: errcheck // Operand types are checked when parsing.
+		return fn(v.(T))
+	}}
+}
+
+// exprBinary returns a node evaluating fn with the operand values, unless any operand is unknown.
+func exprBinary[L, R any](typ exprType, left, right exprNode, fn func(l L, r R) any) exprNode {
+	return exprNode{typ: typ, eval: func(vars map[string]any) any {
+		l := left.eval(vars)
+		r := right.eval(vars)
+		if isExprUnknown(l) || isExprUnknown(r) {
+			return exprUnknown{}
+		}
+		//nolint: errcheck // Operand types are checked when parsing.
+		return fn(l.(L), r.(R))
+	}}
+}
+
+// exprLogical returns a node which is decided by either operand evaluating to decisive, otherwise unknown operands make it unknown.
+func exprLogical(left, right exprNode, decisive bool) exprNode {
+	return exprNode{typ: exprTypeBool, eval: func(vars map[string]any) any {
+		l := left.eval(vars)
+		if l == decisive {
+			return decisive
+		}
+		r := right.eval(vars)
+		if r == decisive {
+			return decisive
+		}
+		if isExprUnknown(l) || isExprUnknown(r) {
+			return exprUnknown{}
+		}
+		return !decisive
+	}}
+}
+
+type exprTokenKind int
+
+const (
+	exprTokenEOF exprTokenKind = iota
+	exprTokenIdent
+	exprTokenString
+	exprTokenInt
+	exprTokenOperator
+)
+
+type exprToken struct {
+	value string
+	kind  exprTokenKind
+}
+
+func tokenizeExpression(s string) ([]exprToken, error) {
+	tokens := []exprToken{}
+	for i := 0; i < len(s); {
+		c := rune(s[i])
+		switch {
+		case unicode.IsSpace(c):
+			i++
+		case unicode.IsLetter(c) || c == '_':
+			j := i + 1
+			for j < len(s) && (unicode.IsLetter(rune(s[j])) || unicode.IsDigit(rune(s[j])) || s[j] == '_') {
+				j++
+			}
+			tokens = append(tokens, exprToken{kind: exprTokenIdent, value: s[i:j]})
+			i = j
+		case unicode.IsDigit(c):
+			j := i + 1
+			for j < len(s) && unicode.IsDigit(rune(s[j])) {
+				j++
+			}
+			tokens = append(tokens, exprToken{kind: exprTokenInt, value: s[i:j]})
+			i = j
+		case c == '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			if j >= len(s) {
+				return nil, errors.New("unterminated string literal")
+			}
+			value, err := strconv.Unquote(s[i : j+1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid string literal %s: %w", s[i:j+1], err)
+			}
+			tokens = append(tokens, exprToken{kind: exprTokenString, value: value})
+			i = j + 1
+		default:
+			op := ""
+			for _, candidate := range []string{"==", "!=", "<=", ">=", "&&", "||", "<", ">", "!", "(", ")", "[", "]", ",", "."} {
+				if strings.HasPrefix(s[i:], candidate) {
+					op = candidate
+					break
+				}
+			}
+			if op == "" {
+				return nil, fmt.Errorf("unexpected character %q", c)
+			}
+			tokens = append(tokens, exprToken{kind: exprTokenOperator, value: op})
+			i += len(op)
+		}
+	}
+	tokens = append(tokens, exprToken{kind: exprTokenEOF})
+	return tokens, nil
+}
+
+type exprParser struct {
+	tokens []exprToken
+	pos    int
+}
+
+func parseExpression(s string) (exprNode, error) {
+	tokens, err := tokenizeExpression(s)
+	if err != nil {
+		return exprNode{}, err
+	}
+	p := &exprParser{tokens: tokens}
+	node, err := p.parseOr()
+	if err != nil {
+		return exprNode{}, err
+	}
+	if p.peek().kind != exprTokenEOF {
+		return exprNode{}, fmt.Errorf("unexpected token %s", p.peek().value)
+	}
+	if node.typ != exprTypeBool {
+		return exprNode{}, fmt.Errorf("expression has to evaluate to bool not %s", node.typ)
+	}
+	return node, nil
+}
+
+func (p *exprParser) peek() exprToken {
+	return p.tokens[p.pos]
+}
+
+func (p *exprParser) next() exprToken {
+	t := p.tokens[p.pos]
+	if t.kind != exprTokenEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *exprParser) acceptOperator(op string) bool {
+	t := p.peek()
+	if t.kind == exprTokenOperator && t.value == op {
+		p.pos++
+		return true
+	}
+	return false
+}
+
+func (p *exprParser) expectOperator(op string) error {
+	if !p.acceptOperator(op) {
+		return fmt.Errorf("expected %s but got %s", op, p.peek().value)
+	}
+	return nil
+}
+
+func (p *exprParser) parseOr() (exprNode, error) {
+	left, err := p.parseAnd()
+	if err != nil {
+		return exprNode{}, err
+	}
+	for p.acceptOperator("||") {
+		right, err := p.parseAnd()
+		if err != nil {
+			return exprNode{}, err
+		}
+		if left.typ != exprTypeBool || right.typ != exprTypeBool {
+			return exprNode{}, errors.New("operator || requires bool operands")
+		}
+		left = exprLogical(left, right, true)
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseAnd() (exprNode, error) {
+	left, err := p.parseUnary()
+	if err != nil {
+		return exprNode{}, err
+	}
+	for p.acceptOperator("&&") {
+		right, err := p.parseUnary()
+		if err != nil {
+			return exprNode{}, err
+		}
+		if left.typ != exprTypeBool || right.typ != exprTypeBool {
+			return exprNode{}, errors.New("operator && requires bool operands")
+		}
+		left = exprLogical(left, right, false)
+	}
+	return left, nil
+}
+
+func (p *exprParser) parseUnary() (exprNode, error) {
+	if p.acceptOperator("!") {
+		operand, err := p.parseUnary()
+		if err != nil {
+			return exprNode{}, err
+		}
+		if operand.typ != exprTypeBool {
+			return exprNode{}, errors.New("operator ! requires a bool operand")
+		}
+		return exprUnary(exprTypeBool, operand, func(v bool) any { return !v }), nil
+	}
+	return p.parseComparison()
+}
+
+func (p *exprParser) parseComparison() (exprNode, error) {
+	left, err := p.parsePostfix()
+	if err != nil {
+		return exprNode{}, err
+	}
+	t := p.peek()
+	isComparison := t.kind == exprTokenOperator && slices.Contains([]string{"==", "!=", "<", "<=", ">", ">="}, t.value)
+	isIn := t.kind == exprTokenIdent && t.value == "in"
+	if !isComparison && !isIn {
+		return left, nil
+	}
+	p.next()
+	right, err := p.parsePostfix()
+	if err != nil {
+		return exprNode{}, err
+	}
+
+	if isIn {
+		if left.typ != exprTypeString || right.typ != exprTypeList {
+			return exprNode{}, errors.New("operator in requires a string and a list")
+		}
+		return exprBinary(exprTypeBool, left, right, func(l string, r []string) any { return slices.Contains(r, l) }), nil
+	}
+	if left.typ != right.typ {
+		return exprNode{}, fmt.Errorf("operator %s cannot compare %s with %s", t.value, left.typ, right.typ)
+	}
+	if left.typ == exprTypeList {
+		return exprNode{}, fmt.Errorf("operator %s cannot compare lists", t.value)
+	}
+	switch t.value {
+	case "==":
+		return exprBinary(exprTypeBool, left, right, func(l, r any) any { return l == r }), nil
+	case "!=":
+		return exprBinary(exprTypeBool, left, right, func(l, r any) any { return l != r }), nil
+	}
+	if left.typ != exprTypeInt {
+		return exprNode{}, fmt.Errorf("operator %s requires int operands", t.value)
+	}
+	cmp := map[string]func(a, b int64) bool{
+		"<":  func(a, b int64) bool { return a < b },
+		"<=": func(a, b int64) bool { return a <= b },
+		">":  func(a, b int64) bool { return a > b },
+		">=": func(a, b int64) bool { return a >= b },
+	}[t.value]
+	return exprBinary(exprTypeBool, left, right, func(l, r int64) any { return cmp(l, r) }), nil
+}
+
+func (p *exprParser) parsePostfix() (exprNode, error) {
+	node, err := p.parsePrimary()
+	if err != nil {
+		return exprNode{}, err
+	}
+	for p.acceptOperator(".") {
+		method := p.next()
+		if method.kind != exprTokenIdent {
+			return exprNode{}, fmt.Errorf("expected method name but got %s", method.value)
+		}
+		if node.typ != exprTypeString {
+			return exprNode{}, fmt.Errorf("method %s requires a string receiver", method.value)
+		}
+		err := p.expectOperator("(")
+		if err != nil {
+			return exprNode{}, err
+		}
+		arg := p.next()
+		if arg.kind != exprTokenString {
+			return exprNode{}, fmt.Errorf("method %s requires a string literal argument", method.value)
+		}
+		err = p.expectOperator(")")
+		if err != nil {
+			return exprNode{}, err
+		}
+		receiver := node
+		var fn func(s string) bool
+		switch method.value {
+		case "matches":
+			re, err := regexp.Compile(arg.value)
+			if err != nil {
+				return exprNode{}, err
+			}
+			fn = re.MatchString
+		case "startsWith":
+			fn = func(s string) bool { return strings.HasPrefix(s, arg.value) }
+		case "endsWith":
+			fn = func(s string) bool { return strings.HasSuffix(s, arg.value) }
+		case "contains":
+			fn = func(s string) bool { return strings.Contains(s, arg.value) }
+		default:
+			return exprNode{}, fmt.Errorf("unknown method %s", method.value)
+		}
+		node = exprUnary(exprTypeBool, receiver, func(v string) any { return fn(v) })
+	}
+	return node, nil
+}
+
+func (p *exprParser) parsePrimary() (exprNode, error) {
+	t := p.next()
+	switch t.kind {
+	case exprTokenString:
+		return exprNode{typ: exprTypeString, eval: func(map[string]any) any { return t.value }}, nil
+	case exprTokenInt:
+		v, err := strconv.ParseInt(t.value, 10, 64)
+		if err != nil {
+			return exprNode{}, err
+		}
+		return exprNode{typ: exprTypeInt, eval: func(map[string]any) any { return v }}, nil
+	case exprTokenIdent:
+		switch t.value {
+		case "true", "false":
+			v := t.value == "true"
+			return exprNode{typ: exprTypeBool, eval: func(map[string]any) any { return v }}, nil
+		case "isSemver":
+			err := p.expectOperator("(")
+			if err != nil {
+				return exprNode{}, err
+			}
+			arg, err := p.parseOr()
+			if err != nil {
+				return exprNode{}, err
+			}
+			if arg.typ != exprTypeString {
+				return exprNode{}, errors.New("function isSemver requires a string argument")
+			}
+			err = p.expectOperator(")")
+			if err != nil {
+				return exprNode{}, err
+			}
+			return exprUnary(exprTypeBool, arg, func(v string) any { return semverRegex.MatchString(v) }), nil
+		}
+		typ, ok := exprVariables[t.value]
+		if !ok {
+			return exprNode{}, fmt.Errorf("unknown variable %s", t.value)
+		}
+		name := t.value
+		return exprNode{typ: typ, eval: func(vars map[string]any) any { return vars[name] }}, nil
+	case exprTokenOperator:
+		switch t.value {
+		case "(":
+			node, err := p.parseOr()
+			if err != nil {
+				return exprNode{}, err
+			}
+			err = p.expectOperator(")")
+			if err != nil {
+				return exprNode{}, err
+			}
+			return node, nil
+		case "[":
+			values := []string{}
+			for !p.acceptOperator("]") {
+				if len(values) > 0 {
+					err := p.expectOperator(",")
+					if err != nil {
+						return exprNode{}, err
+					}
+				}
+				item := p.next()
+				if item.kind != exprTokenString {
+					return exprNode{}, errors.New("lists can only contain string literals")
+				}
+				values = append(values, item.value)
+			}
+			return exprNode{typ: exprTypeList, eval: func(map[string]any) any { return values }}, nil
+		}
+	case exprTokenEOF:
+		return exprNode{}, errors.New("unexpected end of expression")
+	}
+	return exprNode{}, fmt.Errorf("unexpected token %s", t.value)
+}
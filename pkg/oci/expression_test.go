@@ -0,0 +1,308 @@
+package oci
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-openapi/testify/v2/require"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+func TestExpressionFilter(t *testing.T) {
+	t.Parallel()
+
+	cfg := ExpressionFilterConfig{
+		Rules: []ExpressionRuleConfig{
+			{
+				Action:     FilterActionDeny,
+				Expression: `tag == "latest"`,
+			},
+			{
+				Action:     FilterActionDeny,
+				Expression: `mediaType.startsWith("application/vnd.oci.image.layer") && size > 1000`,
+			},
+			{
+				Action:     FilterActionAllow,
+				Expression: `registry in ["docker.io", "ghcr.io"] && isSemver(tag)`,
+			},
+		},
+	}
+	filter, err := NewExpressionFilter(cfg)
+	require.NoError(t, err)
+
+	dgst := digest.Digest("sha256:b6d6089ca6c395fd563c2084f5dd7bc56a2f5e6a81413558c5be0083287a77e9")
+	tests := []struct {
+		name     string
+		ref      Reference
+		desc     ocispec.Descriptor
+		expected bool
+	}{
+		{
+			name:     "semver tag from allowed registry",
+			ref:      Reference{Registry: "docker.io", Repository: "library/ubuntu", Tag: "v1.2.3"},
+			expected: false,
+		},
+		{
+			name:     "latest tag is denied",
+			ref:      Reference{Registry: "docker.io", Repository: "library/ubuntu", Tag: "latest"},
+			expected: true,
+		},
+		{
+			name:     "non semver tag is not allowed",
+			ref:      Reference{Registry: "ghcr.io", Repository: "foo/bar", Tag: "main"},
+			expected: true,
+		},
+		{
+			name:     "other registry is not allowed",
+			ref:      Reference{Registry: "quay.io", Repository: "foo/bar", Tag: "1.0.0"},
+			expected: true,
+		},
+		{
+			name:     "digest from allowed registry",
+			ref:      Reference{Registry: "ghcr.io", Repository: "foo/bar", Digest: dgst},
+			desc:     ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: dgst, Size: 100},
+			expected: false,
+		},
+		{
+			name:     "digest from allowed registry before descriptor is known",
+			ref:      Reference{Registry: "ghcr.io", Repository: "foo/bar", Digest: dgst},
+			expected: false,
+		},
+		{
+			name:     "digest from other registry is not allowed",
+			ref:      Reference{Registry: "quay.io", Repository: "foo/bar", Digest: dgst},
+			desc:     ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: dgst, Size: 100},
+			expected: true,
+		},
+		{
+			name:     "deny takes precedence over allow",
+			ref:      Reference{Registry: "ghcr.io", Repository: "foo/bar", Digest: dgst},
+			desc:     ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: dgst, Size: 1001},
+			expected: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.EqualT(t, tt.expected, filter.MatchesDescriptor(tt.ref, tt.desc))
+			require.EqualT(t, tt.expected, MatchesFilterDescriptor(tt.ref, tt.desc, []Filter{filter}))
+		})
+	}
+}
+
+func TestExpressionFilterDefault(t *testing.T) {
+	t.Parallel()
+
+	ref := Reference{Registry: "docker.io", Repository: "library/ubuntu", Tag: "24.04"}
+
+	filter, err := NewExpressionFilter(ExpressionFilterConfig{})
+	require.NoError(t, err)
+	require.FalseT(t, filter.Matches(ref))
+
+	filter, err = NewExpressionFilter(ExpressionFilterConfig{Default: FilterActionDeny})
+	require.NoError(t, err)
+	require.TrueT(t, filter.Matches(ref))
+
+	filter, err = NewExpressionFilter(ExpressionFilterConfig{
+		Default: FilterActionAllow,
+		Rules:   []ExpressionRuleConfig{{Action: FilterActionAllow, Expression: `repository.matches("^foo/")`}},
+	})
+	require.NoError(t, err)
+	require.FalseT(t, filter.Matches(ref))
+}
+
+func TestExpressionFilterUnknown(t *testing.T) {
+	t.Parallel()
+
+	filter, err := NewExpressionFilter(ExpressionFilterConfig{
+		Default: FilterActionDeny,
+		Rules:   []ExpressionRuleConfig{{Action: FilterActionAllow, Expression: `mediaType.startsWith("application/vnd.oci.image.layer") && size < 1000`}},
+	})
+	require.NoError(t, err)
+
+	dgst := digest.Digest("sha256:b6d6089ca6c395fd563c2084f5dd7bc56a2f5e6a81413558c5be0083287a77e9")
+	ref := Reference{Registry: "docker.io", Repository: "library/ubuntu", Digest: dgst}
+	require.FalseT(t, filter.Matches(ref))
+	require.FalseT(t, MatchesFilter(ref, []Filter{filter}))
+	require.FalseT(t, filter.MatchesDescriptor(ref, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: dgst, Size: 100}))
+	require.TrueT(t, filter.MatchesDescriptor(ref, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: dgst, Size: 1000}))
+	require.TrueT(t, filter.MatchesDescriptor(ref, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: dgst, Size: 100}))
+
+	// Content not known to belong to a repository is only decided by its descriptor.
+	filter, err = NewExpressionFilter(ExpressionFilterConfig{
+		Rules: []ExpressionRuleConfig{
+			{Action: FilterActionAllow, Expression: `registry == "docker.io"`},
+			{Action: FilterActionDeny, Expression: `size > 1000`},
+		},
+	})
+	require.NoError(t, err)
+	ref = Reference{Digest: dgst}
+	require.FalseT(t, filter.MatchesDescriptor(ref, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: dgst, Size: 100}))
+	require.TrueT(t, filter.MatchesDescriptor(ref, ocispec.Descriptor{MediaType: ocispec.MediaTypeImageLayerGzip, Digest: dgst, Size: 1001}))
+}
+
+func TestParseExpression(t *testing.T) {
+	t.Parallel()
+
+	vars := map[string]any{
+		"registry":   "docker.io",
+		"repository": "library/ubuntu",
+		"name":       "docker.io/library/ubuntu",
+		"tag":        "v1.2.3-rc.1",
+		"digest":     "",
+		"mediaType":  "",
+		"size":       int64(42),
+	}
+	tests := []struct {
+		expression string
+		expected   bool
+	}{
+		{expression: `true`, expected: true},
+		{expression: `!true`, expected: false},
+		{expression: `name == "docker.io/library/ubuntu"`, expected: true},
+		{expression: `registry != "docker.io"`, expected: false},
+		{expression: `size >= 42 && size < 43`, expected: true},
+		{expression: `size <= 41 || size > 42`, expected: false},
+		{expression: `isSemver(tag)`, expected: true},
+		{expression: `tag.endsWith("rc.1") && repository.contains("ubu")`, expected: true},
+		{expression: `!(registry in ["ghcr.io"])`, expected: true},
+		{expression: `tag.matches("^v\\d+\\.")`, expected: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.expression, func(t *testing.T) {
+			t.Parallel()
+
+			node, err := parseExpression(tt.expression)
+			require.NoError(t, err)
+			require.Equal(t, any(tt.expected), node.eval(vars))
+		})
+	}
+
+	unknownVars := map[string]any{
+		"registry":   "docker.io",
+		"repository": "library/ubuntu",
+		"name":       "docker.io/library/ubuntu",
+		"tag":        exprUnknown{},
+		"digest":     exprUnknown{},
+		"mediaType":  exprUnknown{},
+		"size":       exprUnknown{},
+	}
+	unknownTests := []struct {
+		expression string
+		expected   any
+	}{
+		{expression: `isSemver(tag)`, expected: exprUnknown{}},
+		{expression: `!(size > 1)`, expected: exprUnknown{}},
+		{expression: `tag == "latest" || registry == "docker.io"`, expected: true},
+		{expression: `tag == "latest" || registry == "ghcr.io"`, expected: exprUnknown{}},
+		{expression: `registry == "ghcr.io" && mediaType.contains("layer")`, expected: false},
+		{expression: `registry == "docker.io" && digest in ["foo"]`, expected: exprUnknown{}},
+	}
+	for _, tt := range unknownTests {
+		t.Run(tt.expression, func(t *testing.T) {
+			t.Parallel()
+
+			node, err := parseExpression(tt.expression)
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, node.eval(unknownVars))
+		})
+	}
+
+	errTests := []struct {
+		expression string
+		expected   string
+	}{
+		{expression: ``, expected: "unexpected end of expression"},
+		{expression: `tag`, expected: "expression has to evaluate to bool not string"},
+		{expression: `foo == "bar"`, expected: "unknown variable foo"},
+		{expression: `size == "1"`, expected: "operator == cannot compare int with string"},
+		{expression: `tag < "1"`, expected: "operator < requires int operands"},
+		{expression: `["a"] == ["a"]`, expected: "operator == cannot compare lists"},
+		{expression: `tag.matches("(")`, expected: "error parsing regexp: missing closing ): `(`"},
+		{expression: `tag.foo("a")`, expected: "unknown method foo"},
+		{expression: `tag == "latest`, expected: "unterminated string literal"},
+		{expression: `(tag == "a"`, expected: "expected ) but got "},
+		{expression: `tag == "a" tag`, expected: "unexpected token tag"},
+		{expression: `tag ~ "a"`, expected: "unexpected character '~'"},
+	}
+	for _, tt := range errTests {
+		t.Run(tt.expression, func(t *testing.T) {
+			t.Parallel()
+
+			_, err := parseExpression(tt.expression)
+			require.EqualError(t, err, tt.expected)
+		})
+	}
+}
+
+func FuzzParseExpression(f *testing.F) {
+	for _, expression := range []string{
+		`tag == "latest"`,
+		`mediaType.startsWith("application/vnd.oci.image.layer") && size > 1000`,
+		`registry in ["docker.io", "ghcr.io"] && isSemver(tag)`,
+		`!(registry in ["ghcr.io"]) || size <= 41`,
+		`tag.matches("^v\\d+\\.")`,
+		`(tag == "a"`,
+		`["a"] == ["a"]`,
+		`tag == "latest`,
+	} {
+		f.Add(expression)
+	}
+	vars := []map[string]any{
+		{
+			"registry":   "docker.io",
+			"repository": "library/ubuntu",
+			"name":       "docker.io/library/ubuntu",
+			"tag":        "v1.2.3",
+			"digest":     "sha256:b6d6089ca6c395fd563c2084f5dd7bc56a2f5e6a81413558c5be0083287a77e9",
+			"mediaType":  ocispec.MediaTypeImageManifest,
+			"size":       int64(42),
+		},
+		{
+			"registry":   exprUnknown{},
+			"repository": exprUnknown{},
+			"name":       exprUnknown{},
+			"tag":        exprUnknown{},
+			"digest":     exprUnknown{},
+			"mediaType":  exprUnknown{},
+			"size":       exprUnknown{},
+		},
+	}
+	f.Fuzz(func(t *testing.T, expression string) {
+		node, err := parseExpression(expression)
+		if err != nil {
+			return
+		}
+		require.EqualT(t, exprTypeBool, node.typ)
+		for _, v := range vars {
+			switch node.eval(v).(type) {
+			case bool, exprUnknown:
+			default:
+				t.Fatalf("expression %q did not evaluate to bool or unknown", expression)
+			}
+		}
+	})
+}
+
+func TestLoadExpressionFilter(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "filter.json")
+	_, err := LoadExpressionFilter(path)
+	require.Error(t, err)
+
+	err = os.WriteFile(path, []byte(`{"rules":[{"action":"deny","expression":"tag == \"latest\""}]}`), 0o644)
+	require.NoError(t, err)
+	filter, err := LoadExpressionFilter(path)
+	require.NoError(t, err)
+	require.TrueT(t, filter.Matches(Reference{Registry: "docker.io", Repository: "library/ubuntu", Tag: "latest"}))
+	require.FalseT(t, filter.Matches(Reference{Registry: "docker.io", Repository: "library/ubuntu", Tag: "24.04"}))
+
+	err = os.WriteFile(path, []byte(`{"rules":[{"action":"maybe","expression":"true"}]}`), 0o644)
+	require.NoError(t, err)
+	_, err = LoadExpressionFilter(path)
+	require.EqualError(t, err, "unknown filter action maybe")
+}
@@ -7,7 +7,9 @@ import (
 	"net/url"
 	"strings"
 
+	"github.com/containerd/containerd/v2/core/images"
 	digest "github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 
 	"github.com/spegel-org/spegel/internal/option"
 )
@@ -45,6 +47,18 @@ func (i Image) TagName() (string, bool) {
 	return fmt.Sprintf("%s/%s:%s", i.Registry, i.Repository, i.Tag), true
 }
 
+// IndexAnnotations returns the annotations identifying the image in an image layout index.
+func (i Image) IndexAnnotations() map[string]string {
+	annotations := map[string]string{}
+	if tagName, ok := i.TagName(); ok {
+		annotations[images.AnnotationImageName] = tagName
+		annotations[ocispec.AnnotationRefName] = i.Tag
+	} else {
+		annotations[images.AnnotationImageName] = i.String()
+	}
+	return annotations
+}
+
 // DistributionPath returns the distribution path for the images top layer.
 func (i Image) DistributionPath(scheme, method string) (DistributionPath, error) {
 	ref := i.Reference
@@ -55,6 +69,7 @@ func (i Image) DistributionPath(scheme, method string) (DistributionPath, error)
 }
 
 type ParseImageConfig struct {
+	Aliases       RegistryAliases
 	Digest        digest.Digest
 	RequireDigest bool
 	Strict        bool
@@ -70,6 +85,14 @@ func WithDigest(dgst digest.Digest) ParseImageOption {
 	}
 }
 
+// WithRegistryAliases normalises the registry to its canonical name.
+func WithRegistryAliases(aliases RegistryAliases) ParseImageOption {
+	return func(cfg *ParseImageConfig) error {
+		cfg.Aliases = aliases
+		return nil
+	}
+}
+
 // AllowTagOnly disables enforcement of digest in parsed image.
 func AllowTagOnly() ParseImageOption {
 	return func(cfg *ParseImageConfig) error {
@@ -124,6 +147,7 @@ func ParseImage(s string, opts ...ParseImageOption) (Image, error) {
 				tag = DefaultTag
 			}
 		}
+		registry = cfg.Aliases.Canonical(registry)
 		img, err := NewImage(registry, repository, tag, dgst)
 		if err != nil {
 			return Image{}, err
@@ -95,6 +95,7 @@ func TestParseImageStrict(t *testing.T) {
 						require.EqualT(t, registry+"/"+tt.expectedRepository+":"+tt.expectedTag, tagName)
 					}
 					require.EqualT(t, fmt.Sprintf("%s/%s", registry, tt.expectedString), img.String())
+					require.EqualT(t, registry+"/"+tt.expectedRepository, img.Name())
 				}
 			})
 		}
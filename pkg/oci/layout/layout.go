@@ -0,0 +1,396 @@
+package layout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/containerd/containerd/v2/core/images"
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+	lru "github.com/hashicorp/golang-lru/v2"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/spegel-org/spegel/internal/option"
+	"github.com/spegel-org/spegel/pkg/httpx"
+	"github.com/spegel-org/spegel/pkg/oci"
+	"github.com/spegel-org/spegel/pkg/store"
+)
+
+const (
+	// Delay before rescanning the layout after a change, allowing writes to settle.
+	rescanDelay = 200 * time.Millisecond
+)
+
+var _ oci.ImageLister = &Layout{}
+var _ store.Provider = &Layout{}
+var _ store.Watcher = &Layout{}
+
+type LayoutConfig struct {
+	Filters []oci.Filter
+}
+
+type LayoutOption = option.Option[LayoutConfig]
+
+func WithFilters(filters []oci.Filter) LayoutOption {
+	return func(cfg *LayoutConfig) error {
+		cfg.Filters = filters
+		return nil
+	}
+}
+
+// Layout serves content from an OCI image layout directory.
+type Layout struct {
+	mediaTypeIdx *lru.Cache[digest.Digest, string]
+	path         string
+	filters      []oci.Filter
+}
+
+func NewLayout(path string, opts ...LayoutOption) (*Layout, error) {
+	cfg := LayoutConfig{}
+	err := option.Apply(&cfg, opts...)
+	if err != nil {
+		return nil, err
+	}
+
+	b, err := os.ReadFile(filepath.Join(path, ocispec.ImageLayoutFile))
+	if err != nil {
+		return nil, err
+	}
+	imageLayout := ocispec.ImageLayout{}
+	err = json.Unmarshal(b, &imageLayout)
+	if err != nil {
+		return nil, err
+	}
+	if imageLayout.Version != ocispec.ImageLayoutVersion {
+		return nil, fmt.Errorf("unsupported image layout version %s", imageLayout.Version)
+	}
+
+	mediaTypeIdx, err := lru.New[digest.Digest, string](1000)
+	if err != nil {
+		return nil, err
+	}
+
+	l := &Layout{
+		mediaTypeIdx: mediaTypeIdx,
+		path:         path,
+		filters:      cfg.Filters,
+	}
+	return l, nil
+}
+
+func (l *Layout) Name() string {
+	return "oci-layout"
+}
+
+func (l *Layout) ListImages(ctx context.Context) ([]oci.Image, error) {
+	idx, err := l.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	imgs := []oci.Image{}
+	for _, desc := range idx.Manifests {
+		img, ok := imageFromDescriptor(desc)
+		if !ok {
+			continue
+		}
+		if oci.MatchesFilter(img.Reference, l.filters) {
+			continue
+		}
+		imgs = append(imgs, img)
+	}
+	return imgs, nil
+}
+
+func (l *Layout) Resolve(ctx context.Context, ref string) (digest.Digest, error) {
+	idx, err := l.readIndex()
+	if err != nil {
+		return "", err
+	}
+	for _, desc := range idx.Manifests {
+		img, ok := imageFromDescriptor(desc)
+		if !ok {
+			continue
+		}
+		tagName, ok := img.TagName()
+		if !ok || tagName != ref {
+			continue
+		}
+		return img.Digest, nil
+	}
+	return "", errors.Join(store.ErrNotFound, fmt.Errorf("reference %s not found in index", ref))
+}
+
+func (l *Layout) Descriptor(ctx context.Context, dgst digest.Digest) (store.Descriptor, error) {
+	path, err := l.blobPath(dgst)
+	if err != nil {
+		return store.Descriptor{}, err
+	}
+	fi, err := os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return store.Descriptor{}, errors.Join(store.ErrNotFound, err)
+	}
+	if err != nil {
+		return store.Descriptor{}, err
+	}
+
+	mt, ok := l.mediaTypeIdx.Get(dgst)
+	if !ok {
+		mt, err = func() (string, error) {
+			if fi.Size() > oci.ManifestMaxSize {
+				return httpx.ContentTypeBinary, nil
+			}
+			rc, err := l.Open(ctx, dgst)
+			if err != nil {
+				return "", err
+			}
+			defer rc.Close()
+			mt, err := oci.FingerprintMediaType(rc)
+			if err != nil {
+				return "", err
+			}
+			return mt, nil
+		}()
+		if err != nil {
+			return store.Descriptor{}, err
+		}
+		l.mediaTypeIdx.Add(dgst, mt)
+	}
+
+	desc := store.Descriptor{
+		Size:      fi.Size(),
+		Digest:    dgst,
+		MediaType: mt,
+	}
+	return desc, nil
+}
+
+func (l *Layout) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
+	path, err := l.blobPath(dgst)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errors.Join(store.ErrNotFound, err)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (l *Layout) Watch(ctx context.Context) ([]store.Event, <-chan store.Event, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, nil, err
+	}
+	blobsDir := filepath.Join(l.path, ocispec.ImageBlobsDir)
+	watchDirs := []string{l.path, blobsDir}
+	entries, err := os.ReadDir(blobsDir)
+	if err != nil {
+		fsWatcher.Close()
+		return nil, nil, err
+	}
+	for _, entry := range entries {
+		if !entry.IsDir() {
+			continue
+		}
+		watchDirs = append(watchDirs, filepath.Join(blobsDir, entry.Name()))
+	}
+	for _, dir := range watchDirs {
+		err := fsWatcher.Add(dir)
+		if err != nil {
+			fsWatcher.Close()
+			return nil, nil, err
+		}
+	}
+
+	prev, err := l.snapshot()
+	if err != nil {
+		fsWatcher.Close()
+		return nil, nil, err
+	}
+	initial := store.DiffSnapshots(store.Snapshot{}, prev)
+
+	eventCh := make(chan store.Event)
+	go func() {
+		defer close(eventCh)
+		defer fsWatcher.Close()
+
+		var rescanCh <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case fsEvent, ok := <-fsWatcher.Events:
+				if !ok {
+					return
+				}
+				// Watch new digest algorithm directories as they are created.
+				if fsEvent.Has(fsnotify.Create) && filepath.Dir(fsEvent.Name) == blobsDir {
+					fi, err := os.Stat(fsEvent.Name)
+					if err == nil && fi.IsDir() {
+						err := fsWatcher.Add(fsEvent.Name)
+						if err != nil {
+							log.Error(err, "could not watch blobs directory", "path", fsEvent.Name)
+						}
+					}
+				}
+				if rescanCh == nil {
+					rescanCh = time.After(rescanDelay)
+				}
+			case err, ok := <-fsWatcher.Errors:
+				if !ok {
+					return
+				}
+				log.Error(err, "received image layout watch error")
+			case <-rescanCh:
+				rescanCh = nil
+				curr, err := l.snapshot()
+				if err != nil {
+					log.Error(err, "could not scan image layout")
+					continue
+				}
+				for _, event := range store.DiffSnapshots(prev, curr) {
+					select {
+					case <-ctx.Done():
+						return
+					case eventCh <- event:
+					}
+				}
+				prev = curr
+			}
+		}
+	}()
+
+	return initial, eventCh, nil
+}
+
+func (l *Layout) readIndex() (ocispec.Index, error) {
+	b, err := os.ReadFile(filepath.Join(l.path, ocispec.ImageIndexFile))
+	if err != nil {
+		return ocispec.Index{}, err
+	}
+	idx := ocispec.Index{}
+	err = json.Unmarshal(b, &idx)
+	if err != nil {
+		return ocispec.Index{}, err
+	}
+	return idx, nil
+}
+
+func (l *Layout) blobPath(dgst digest.Digest) (string, error) {
+	err := dgst.Validate()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(l.path, ocispec.ImageBlobsDir, dgst.Algorithm().String(), dgst.Encoded()), nil
+}
+
+// walk returns the descriptors of all content referenced by the descriptor which exist in the layout.
+func (l *Layout) walk(desc ocispec.Descriptor) ([]ocispec.Descriptor, error) {
+	path, err := l.blobPath(desc.Digest)
+	if err != nil {
+		return nil, err
+	}
+	_, err = os.Stat(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if desc.MediaType != "" {
+		l.mediaTypeIdx.Add(desc.Digest, desc.MediaType)
+	}
+
+	descs := []ocispec.Descriptor{desc}
+	if !oci.IsManifestsMediatype(desc.MediaType) {
+		return descs, nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	manifest := struct {
+		Config    *ocispec.Descriptor  `json:"config"`
+		Manifests []ocispec.Descriptor `json:"manifests"`
+		Layers    []ocispec.Descriptor `json:"layers"`
+	}{}
+	err = json.Unmarshal(b, &manifest)
+	if err != nil {
+		return nil, err
+	}
+	children := manifest.Manifests
+	if manifest.Config != nil {
+		children = append(children, *manifest.Config)
+	}
+	children = append(children, manifest.Layers...)
+	for _, child := range children {
+		childDescs, err := l.walk(child)
+		if err != nil {
+			return nil, err
+		}
+		descs = append(descs, childDescs...)
+	}
+	return descs, nil
+}
+
+// snapshot returns the tags and content currently referenced by the layout index.
+func (l *Layout) snapshot() (store.Snapshot, error) {
+	idx, err := l.readIndex()
+	if err != nil {
+		return store.Snapshot{}, err
+	}
+	s := store.NewSnapshot()
+	for _, desc := range idx.Manifests {
+		img, named := imageFromDescriptor(desc)
+		if named && oci.MatchesFilter(img.Reference, l.filters) {
+			continue
+		}
+		descs, err := l.walk(desc)
+		if err != nil {
+			return store.Snapshot{}, err
+		}
+		for _, d := range descs {
+			if _, ok := s.Content[d.Digest]; ok {
+				continue
+			}
+			event := store.Event{Type: store.CreateEvent, Digest: d.Digest, MediaType: d.MediaType, Size: d.Size}
+			if named {
+				event.Repository = img.Name()
+			}
+			s.Content[d.Digest] = event
+		}
+		if tagName, ok := img.TagName(); ok {
+			s.Tags[tagName] = desc.Digest
+		}
+	}
+	return s, nil
+}
+
+// imageFromDescriptor returns the image referenced by the index descriptor.
+// Descriptors without a fully qualified image name are not considered images.
+func imageFromDescriptor(desc ocispec.Descriptor) (oci.Image, bool) {
+	name := desc.Annotations[images.AnnotationImageName]
+	if name == "" {
+		name = desc.Annotations[ocispec.AnnotationRefName]
+	}
+	if name == "" {
+		return oci.Image{}, false
+	}
+	img, err := oci.ParseImage(name, oci.WithDigest(desc.Digest))
+	if err != nil {
+		return oci.Image{}, false
+	}
+	return img, true
+}
@@ -0,0 +1,226 @@
+package layout
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/testify/v2/require"
+	"github.com/opencontainers/go-digest"
+	"github.com/opencontainers/image-spec/specs-go"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/spegel-org/spegel/pkg/oci"
+	"github.com/spegel-org/spegel/pkg/store"
+	"github.com/spegel-org/spegel/pkg/store/storetest"
+)
+
+func TestLayout(t *testing.T) {
+	t.Parallel()
+
+	path := t.TempDir()
+	_, err := NewLayout(path)
+	require.ErrorIs(t, err, os.ErrNotExist)
+
+	manifest := writeImageLayout(t, path)
+	l, err := NewLayout(path)
+	require.NoError(t, err)
+
+	cfg := storetest.ProviderConfig{
+		Name:              "oci-layout",
+		NotFoundRef:       "docker.io/library/foo:missing",
+		ExistingRef:       "docker.io/library/foo:1.0",
+		ExistingRefDigest: manifest.Digest,
+		NotFoundDigest:    digest.FromString("missing"),
+		ExistingDescriptor: store.Descriptor{
+			MediaType: manifest.MediaType,
+			Digest:    manifest.Digest,
+			Size:      manifest.Size,
+		},
+	}
+	storetest.ProviderConformance(t, l, cfg)
+
+	imgs, err := l.ListImages(t.Context())
+	require.NoError(t, err)
+	require.Len(t, imgs, 1)
+	require.EqualT(t, "docker.io/library/foo:1.0@"+manifest.Digest.String(), imgs[0].String())
+}
+
+func TestLayoutVersion(t *testing.T) {
+	t.Parallel()
+
+	path := t.TempDir()
+	err := os.WriteFile(filepath.Join(path, ocispec.ImageLayoutFile), []byte(`{"imageLayoutVersion":"2.0.0"}`), 0o644)
+	require.NoError(t, err)
+	_, err = NewLayout(path)
+	require.EqualError(t, err, "unsupported image layout version 2.0.0")
+}
+
+func TestLayoutWatch(t *testing.T) {
+	t.Parallel()
+
+	path := t.TempDir()
+	manifest := writeImageLayout(t, path)
+	l, err := NewLayout(path)
+	require.NoError(t, err)
+
+	initial, eventCh, err := l.Watch(t.Context())
+	require.NoError(t, err)
+	require.Len(t, initial, 4)
+	require.Contains(t, initial, store.Event{Type: store.CreateEvent, Reference: "docker.io/library/foo:1.0"})
+	require.Contains(t, initial, store.Event{Type: store.CreateEvent, Digest: manifest.Digest, MediaType: manifest.MediaType, Size: manifest.Size, Repository: "docker.io/library/foo"})
+
+	// Tagging the image again should only advertise the new tag.
+	idx := readIndex(t, path)
+	tagged := manifest
+	tagged.Annotations = map[string]string{ocispec.AnnotationRefName: "docker.io/library/foo:2.0"}
+	idx.Manifests = append(idx.Manifests, tagged)
+	writeJSON(t, filepath.Join(path, ocispec.ImageIndexFile), idx)
+	event := receiveEvent(t, eventCh)
+	require.Equal(t, store.Event{Type: store.CreateEvent, Reference: "docker.io/library/foo:2.0"}, event)
+
+	// Removing the image from the index should delete all content and tags.
+	writeJSON(t, filepath.Join(path, ocispec.ImageIndexFile), ocispec.Index{})
+	events := []store.Event{}
+	for range 5 {
+		events = append(events, receiveEvent(t, eventCh))
+	}
+	for _, event := range events {
+		require.EqualT(t, store.DeleteEvent, event.Type)
+	}
+	require.Contains(t, events, store.Event{Type: store.DeleteEvent, Reference: "docker.io/library/foo:1.0"})
+	require.Contains(t, events, store.Event{Type: store.DeleteEvent, Reference: "docker.io/library/foo:2.0"})
+	require.Contains(t, events, store.Event{Type: store.DeleteEvent, Digest: manifest.Digest, MediaType: manifest.MediaType})
+}
+
+func TestLayoutWatcherConformance(t *testing.T) {
+	t.Parallel()
+
+	path := t.TempDir()
+	writeJSON(t, filepath.Join(path, ocispec.ImageLayoutFile), ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+	writeJSON(t, filepath.Join(path, ocispec.ImageIndexFile), ocispec.Index{})
+	l, err := NewLayout(path)
+	require.NoError(t, err)
+
+	cfg := storetest.WatcherConfig{
+		CreateImage: func(t *testing.T, ref string) digest.Digest {
+			manifest := writeManifest(t, path, []byte("layer of "+ref))
+			desc := manifest
+			if ref != "" {
+				desc.Annotations = map[string]string{ocispec.AnnotationRefName: ref}
+			}
+			idx := readIndex(t, path)
+			idx.Manifests = append(idx.Manifests, desc)
+			writeJSON(t, filepath.Join(path, ocispec.ImageIndexFile), idx)
+			return manifest.Digest
+		},
+		DeleteImage: func(t *testing.T, ref string, dgst digest.Digest) {
+			idx := readIndex(t, path)
+			idx.Manifests = slices.DeleteFunc(idx.Manifests, func(desc ocispec.Descriptor) bool {
+				return desc.Digest == dgst
+			})
+			writeJSON(t, filepath.Join(path, ocispec.ImageIndexFile), idx)
+		},
+	}
+	storetest.WatcherConformance(t, l, cfg)
+}
+
+func TestLayoutFilters(t *testing.T) {
+	t.Parallel()
+
+	path := t.TempDir()
+	writeImageLayout(t, path)
+	filters, err := oci.FilterForMirroredRegistries([]string{"https://ghcr.io"})
+	require.NoError(t, err)
+	l, err := NewLayout(path, WithFilters([]oci.Filter{*filters}))
+	require.NoError(t, err)
+
+	imgs, err := l.ListImages(t.Context())
+	require.NoError(t, err)
+	require.Empty(t, imgs)
+	initial, _, err := l.Watch(t.Context())
+	require.NoError(t, err)
+	require.Empty(t, initial)
+}
+
+func receiveEvent(t *testing.T, eventCh <-chan store.Event) store.Event {
+	t.Helper()
+
+	select {
+	case event := <-eventCh:
+		return event
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for event")
+		return store.Event{}
+	}
+}
+
+// writeImageLayout writes a single image to the layout and returns the manifest descriptor.
+func writeImageLayout(t *testing.T, path string) ocispec.Descriptor {
+	t.Helper()
+
+	writeJSON(t, filepath.Join(path, ocispec.ImageLayoutFile), ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+	manifest := writeManifest(t, path, []byte("layer"))
+	// Blobs which are not referenced by the index should not be advertised.
+	writeBlob(t, path, ocispec.MediaTypeImageLayerGzip, []byte("dangling"))
+
+	indexed := manifest
+	indexed.Annotations = map[string]string{ocispec.AnnotationRefName: "docker.io/library/foo:1.0"}
+	writeJSON(t, filepath.Join(path, ocispec.ImageIndexFile), ocispec.Index{Manifests: []ocispec.Descriptor{indexed}})
+	return manifest
+}
+
+// writeManifest writes a manifest with a single layer containing the data and returns its descriptor.
+func writeManifest(t *testing.T, path string, data []byte) ocispec.Descriptor {
+	t.Helper()
+
+	config := writeBlob(t, path, ocispec.MediaTypeImageConfig, []byte(`{"architecture":"amd64","os":"linux","rootfs":{"type":"layers","diff_ids":[]}}`))
+	layer := writeBlob(t, path, ocispec.MediaTypeImageLayerGzip, data)
+	b, err := json.Marshal(ocispec.Manifest{
+		Versioned: specs.Versioned{SchemaVersion: 2},
+		MediaType: ocispec.MediaTypeImageManifest,
+		Config:    config,
+		Layers:    []ocispec.Descriptor{layer},
+	})
+	require.NoError(t, err)
+	return writeBlob(t, path, ocispec.MediaTypeImageManifest, b)
+}
+
+func writeBlob(t *testing.T, path, mediaType string, b []byte) ocispec.Descriptor {
+	t.Helper()
+
+	desc := ocispec.Descriptor{
+		MediaType: mediaType,
+		Digest:    digest.FromBytes(b),
+		Size:      int64(len(b)),
+	}
+	blobPath := filepath.Join(path, ocispec.ImageBlobsDir, desc.Digest.Algorithm().String(), desc.Digest.Encoded())
+	err := os.MkdirAll(filepath.Dir(blobPath), 0o755)
+	require.NoError(t, err)
+	err = os.WriteFile(blobPath, b, 0o644)
+	require.NoError(t, err)
+	return desc
+}
+
+func writeJSON(t *testing.T, path string, v any) {
+	t.Helper()
+
+	b, err := json.Marshal(v)
+	require.NoError(t, err)
+	err = os.WriteFile(path, b, 0o644)
+	require.NoError(t, err)
+}
+
+func readIndex(t *testing.T, path string) ocispec.Index {
+	t.Helper()
+
+	b, err := os.ReadFile(filepath.Join(path, ocispec.ImageIndexFile))
+	require.NoError(t, err)
+	idx := ocispec.Index{}
+	err = json.Unmarshal(b, &idx)
+	require.NoError(t, err)
+	return idx
+}
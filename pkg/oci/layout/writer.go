@@ -0,0 +1,135 @@
+package layout
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/containerd/containerd/v2/core/images"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/spegel-org/spegel/pkg/oci"
+)
+
+var _ oci.PullWriter = &Writer{}
+
+// Writer writes pulled images to an OCI image layout directory.
+type Writer struct {
+	path string
+}
+
+// NewWriter returns a writer for the layout, creating the layout if it does not exist.
+func NewWriter(path string) (*Writer, error) {
+	err := os.MkdirAll(filepath.Join(path, ocispec.ImageBlobsDir), 0o755)
+	if err != nil {
+		return nil, err
+	}
+	_, err = os.Stat(filepath.Join(path, ocispec.ImageLayoutFile))
+	if errors.Is(err, os.ErrNotExist) {
+		err = writeJSONFile(filepath.Join(path, ocispec.ImageLayoutFile), ocispec.ImageLayout{Version: ocispec.ImageLayoutVersion})
+	}
+	if err != nil {
+		return nil, err
+	}
+	_, err = os.Stat(filepath.Join(path, ocispec.ImageIndexFile))
+	if errors.Is(err, os.ErrNotExist) {
+		err = writeJSONFile(filepath.Join(path, ocispec.ImageIndexFile), emptyIndex())
+	}
+	if err != nil {
+		return nil, err
+	}
+	// Validate the version of existing layouts.
+	_, err = NewLayout(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Writer{path: path}, nil
+}
+
+func (w *Writer) Write(ctx context.Context, desc ocispec.Descriptor, r io.Reader) error {
+	err := desc.Digest.Validate()
+	if err != nil {
+		return err
+	}
+	blobPath := filepath.Join(w.path, ocispec.ImageBlobsDir, desc.Digest.Algorithm().String(), desc.Digest.Encoded())
+	_, err = os.Stat(blobPath)
+	if err == nil {
+		_, err := io.Copy(io.Discard, r)
+		return err
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(blobPath), 0o755)
+	if err != nil {
+		return err
+	}
+
+	// Content is written to a temporary file so that partial blobs are never part of the layout.
+	file, err := os.CreateTemp(filepath.Dir(blobPath), ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+	verifier := desc.Digest.Verifier()
+	_, copyErr := io.Copy(io.MultiWriter(file, verifier), r)
+	closeErr := file.Close()
+	err = errors.Join(copyErr, closeErr)
+	if err != nil {
+		return err
+	}
+	if !verifier.Verified() {
+		return fmt.Errorf("content does not match digest %s", desc.Digest)
+	}
+	return os.Rename(file.Name(), blobPath)
+}
+
+// Commit adds the image to the index, replacing any previous image with the same name.
+func (w *Writer) Commit(ctx context.Context, img oci.Image, desc ocispec.Descriptor) error {
+	indexPath := filepath.Join(w.path, ocispec.ImageIndexFile)
+	b, err := os.ReadFile(indexPath)
+	if err != nil {
+		return err
+	}
+	idx := ocispec.Index{}
+	err = json.Unmarshal(b, &idx)
+	if err != nil {
+		return err
+	}
+
+	desc.Annotations = img.IndexAnnotations()
+	name := desc.Annotations[images.AnnotationImageName]
+	idx.Manifests = slices.DeleteFunc(idx.Manifests, func(existing ocispec.Descriptor) bool {
+		return existing.Annotations[images.AnnotationImageName] == name
+	})
+	idx.Manifests = append(idx.Manifests, desc)
+
+	tmpPath := indexPath + ".tmp"
+	err = writeJSONFile(tmpPath, idx)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, indexPath)
+}
+
+func emptyIndex() ocispec.Index {
+	idx := ocispec.Index{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{},
+	}
+	idx.SchemaVersion = 2
+	return idx
+}
+
+func writeJSONFile(path string, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, b, 0o644)
+}
@@ -0,0 +1,58 @@
+package layout
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-openapi/testify/v2/require"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/spegel-org/spegel/pkg/oci"
+)
+
+func TestWriter(t *testing.T) {
+	t.Parallel()
+
+	path := filepath.Join(t.TempDir(), "layout")
+	w, err := NewWriter(path)
+	require.NoError(t, err)
+
+	manifestB := []byte(`{"schemaVersion":2,"mediaType":"application/vnd.oci.image.manifest.v1+json","config":{"mediaType":"application/vnd.oci.image.config.v1+json","digest":"sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a","size":2},"layers":[]}`)
+	manifest := ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: digest.FromBytes(manifestB), Size: int64(len(manifestB))}
+	err = w.Write(t.Context(), manifest, bytes.NewReader(manifestB))
+	require.NoError(t, err)
+	// Writing existing content should be a no-op.
+	err = w.Write(t.Context(), manifest, bytes.NewReader(manifestB))
+	require.NoError(t, err)
+	err = w.Write(t.Context(), ocispec.Descriptor{Digest: digest.FromString("other")}, bytes.NewReader([]byte("foo")))
+	require.EqualError(t, err, "content does not match digest "+digest.FromString("other").String())
+	entries, err := os.ReadDir(filepath.Join(path, ocispec.ImageBlobsDir, "sha256"))
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+
+	img, err := oci.ParseImage("docker.io/library/foo:1.0", oci.AllowTagOnly())
+	require.NoError(t, err)
+	err = w.Commit(t.Context(), img, manifest)
+	require.NoError(t, err)
+	// Committing the same name again should replace the image.
+	err = w.Commit(t.Context(), img, manifest)
+	require.NoError(t, err)
+
+	l, err := NewLayout(path)
+	require.NoError(t, err)
+	imgs, err := l.ListImages(t.Context())
+	require.NoError(t, err)
+	require.Len(t, imgs, 1)
+	require.EqualT(t, "docker.io/library/foo:1.0@"+manifest.Digest.String(), imgs[0].String())
+	dgst, err := l.Resolve(t.Context(), "docker.io/library/foo:1.0")
+	require.NoError(t, err)
+	require.EqualT(t, manifest.Digest, dgst)
+
+	// Opening the writer again should keep the existing index.
+	_, err = NewWriter(path)
+	require.NoError(t, err)
+	require.Len(t, readIndex(t, path).Manifests, 1)
+}
@@ -1,6 +1,8 @@
 package oci
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"encoding/json"
 	"errors"
@@ -18,6 +20,21 @@ const (
 	ManifestMaxSize = 4 * 1024 * 1024
 )
 
+const (
+	// MediaTypeArtifactManifest is the artifact manifest which was removed before the release of image spec v1.1.
+	MediaTypeArtifactManifest = "application/vnd.oci.artifact.manifest.v1+json"
+	// MediaTypeDockerSchema1ManifestUnsigned is the legacy Docker schema 1 manifest without signatures.
+	MediaTypeDockerSchema1ManifestUnsigned = "application/vnd.docker.distribution.manifest.v1+json"
+	MediaTypeHelmConfig                    = "application/vnd.cncf.helm.config.v1+json"
+	MediaTypeWasmConfig                    = "application/vnd.wasm.config.v0+json"
+	MediaTypeWasmLayer                     = "application/vnd.wasm.content.layer.v1+wasm"
+	MediaTypeCosignSimpleSigning           = "application/vnd.dev.cosign.simplesigning.v1+json"
+	MediaTypeInToto                        = "application/vnd.in-toto+json"
+	MediaTypeDSSEEnvelope                  = "application/vnd.dsse.envelope.v1+json"
+)
+
+var wasmMagic = []byte{0x00, 0x61, 0x73, 0x6d}
+
 type ImageLister interface {
 	// ListImages returns a list of all local images.
 	ListImages(ctx context.Context) ([]Image, error)
@@ -25,7 +42,13 @@ type ImageLister interface {
 
 // FingerprintMediaType attempts to determine the media type based on the json structure.
 func FingerprintMediaType(r io.Reader) (string, error) {
-	dec := json.NewDecoder(r)
+	br := bufio.NewReader(r)
+	magic, err := br.Peek(len(wasmMagic))
+	if err == nil && bytes.Equal(magic, wasmMagic) {
+		return MediaTypeWasmLayer, nil
+	}
+
+	dec := json.NewDecoder(br)
 	tok, err := dec.Token()
 	//nolint:errcheck // We are only interested in the error type not the error content.
 	if _, ok := errors.AsType[*json.SyntaxError](err); ok {
@@ -50,10 +73,20 @@ func FingerprintMediaType(r io.Reader) (string, error) {
 
 	schemaVersion := 0
 	mediaType := ""
+	architecture := ""
 
 	indexKeys := 0
 	manifestKeys := 0
+	artifactKeys := 0
 	configKeys := 0
+	schema1Keys := 0
+	signed := false
+	helmKeys := 0
+	helmAPIVersion := ""
+	hasKind := false
+	simpleSigningKeys := 0
+	inTotoKeys := 0
+	dsseKeys := 0
 
 	for dec.More() {
 		tok, err := dec.Token()
@@ -88,10 +121,39 @@ func FingerprintMediaType(r io.Reader) (string, error) {
 			if err == nil {
 				manifestKeys += 1
 			}
+		// Artifacts.
+		case "blobs":
+			err = dec.Decode(&[]ocispec.Descriptor{})
+			if err == nil {
+				artifactKeys += 1
+			}
+		case "artifactType":
+			var artifactType string
+			err = dec.Decode(&artifactType)
+			if err == nil {
+				artifactKeys += 1
+			}
+		case "subject":
+			err = dec.Decode(&ocispec.Descriptor{})
+			if err == nil {
+				artifactKeys += 1
+			}
+		// Docker schema 1 manifest.
+		case "fsLayers", "history":
+			var values []json.RawMessage
+			err = dec.Decode(&values)
+			if err == nil {
+				schema1Keys += 1
+			}
+		case "signatures":
+			var values []json.RawMessage
+			err = dec.Decode(&values)
+			if err == nil {
+				signed = true
+			}
 		// Image Config.
 		case "architecture":
-			var arch string
-			err = dec.Decode(&arch)
+			err = dec.Decode(&architecture)
 			if err == nil {
 				configKeys += 1
 			}
@@ -108,6 +170,49 @@ func FingerprintMediaType(r io.Reader) (string, error) {
 			if err != nil {
 				return "", err
 			}
+		// Helm chart config.
+		case "apiVersion":
+			err = dec.Decode(&helmAPIVersion)
+			if err != nil {
+				helmAPIVersion = ""
+			}
+		case "name", "version":
+			var value string
+			err = dec.Decode(&value)
+			if err == nil {
+				helmKeys += 1
+			}
+		// Kubernetes style objects share the apiVersion field with Helm charts.
+		case "kind":
+			hasKind = true
+			var discard any
+			err = dec.Decode(&discard)
+			if err != nil {
+				return "", err
+			}
+		// Cosign simple signing payload.
+		case "critical":
+			var critical struct {
+				Type string `json:"type"`
+			}
+			err = dec.Decode(&critical)
+			if err == nil && critical.Type != "" {
+				simpleSigningKeys += 1
+			}
+		// In-toto statement.
+		case "_type", "predicateType":
+			var value string
+			err = dec.Decode(&value)
+			if err == nil {
+				inTotoKeys += 1
+			}
+		// DSSE envelope.
+		case "payload", "payloadType":
+			var value string
+			err = dec.Decode(&value)
+			if err == nil {
+				dsseKeys += 1
+			}
 		default:
 			var discard any
 			err = dec.Decode(&discard)
@@ -125,12 +230,36 @@ func FingerprintMediaType(r io.Reader) (string, error) {
 	if indexKeys == 1 {
 		return ocispec.MediaTypeImageIndex, nil
 	}
-	if manifestKeys == 2 {
+	if manifestKeys == 2 || (manifestKeys == 1 && artifactKeys > 0) {
 		return ocispec.MediaTypeImageManifest, nil
 	}
+	if artifactKeys > 0 && manifestKeys == 0 && mediaType == MediaTypeArtifactManifest {
+		return MediaTypeArtifactManifest, nil
+	}
+	if schemaVersion == 1 && schema1Keys == 2 {
+		if signed {
+			return images.MediaTypeDockerSchema1Manifest, nil
+		}
+		return MediaTypeDockerSchema1ManifestUnsigned, nil
+	}
 	if configKeys == 3 {
 		return ocispec.MediaTypeImageConfig, nil
 	}
+	if configKeys == 2 && architecture == "wasm" {
+		return MediaTypeWasmConfig, nil
+	}
+	if helmKeys == 2 && (helmAPIVersion == "v1" || helmAPIVersion == "v2") && !hasKind {
+		return MediaTypeHelmConfig, nil
+	}
+	if simpleSigningKeys == 1 {
+		return MediaTypeCosignSimpleSigning, nil
+	}
+	if inTotoKeys == 2 {
+		return MediaTypeInToto, nil
+	}
+	if dsseKeys == 2 && signed {
+		return MediaTypeDSSEEnvelope, nil
+	}
 	return "", errors.New("could not determine media type")
 }
 
@@ -138,8 +267,11 @@ func IsManifestsMediatype(mt string) bool {
 	switch mt {
 	case ocispec.MediaTypeImageIndex,
 		ocispec.MediaTypeImageManifest,
+		MediaTypeArtifactManifest,
 		images.MediaTypeDockerSchema2ManifestList,
-		images.MediaTypeDockerSchema2Manifest:
+		images.MediaTypeDockerSchema2Manifest,
+		images.MediaTypeDockerSchema1Manifest,
+		MediaTypeDockerSchema1ManifestUnsigned:
 		return true
 	default:
 		return false
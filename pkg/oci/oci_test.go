@@ -77,6 +77,88 @@ func TestFingerprintMediaType(t *testing.T) {
 	require.EqualError(t, err, "could not determine media type")
 }
 
+func TestFingerprintArtifactMediaType(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name              string
+		content           string
+		expectedMediaType string
+	}{
+		{
+			name:              "artifact image manifest without media type",
+			content:           `{"schemaVersion":2,"artifactType":"application/vnd.example+type","config":{"mediaType":"application/vnd.oci.empty.v1+json","digest":"sha256:44136fa355b3678a1146ad16f7e8649e94fb4fc21fe77e8310c060f61caaff8a","size":2},"subject":{"mediaType":"application/vnd.oci.image.manifest.v1+json","digest":"sha256:b6d6089ca6c395fd563c2084f5dd7bc56a2f5e6a81413558c5be0083287a77e9","size":100}}`,
+			expectedMediaType: ocispec.MediaTypeImageManifest,
+		},
+		{
+			name:              "artifact manifest",
+			content:           `{"mediaType":"application/vnd.oci.artifact.manifest.v1+json","artifactType":"application/vnd.example+type","blobs":[]}`,
+			expectedMediaType: MediaTypeArtifactManifest,
+		},
+		{
+			name:              "docker schema 1 signed manifest",
+			content:           `{"schemaVersion":1,"name":"library/ubuntu","tag":"latest","architecture":"amd64","fsLayers":[{"blobSum":"sha256:a3ed95caeb02ffe68cdd9fd84406680ae93d633cb16422d00e8a7c22955b46d4"}],"history":[{"v1Compatibility":"{}"}],"signatures":[{"header":{},"signature":"c2ln","protected":"cHJvdGVjdGVk"}]}`,
+			expectedMediaType: images.MediaTypeDockerSchema1Manifest,
+		},
+		{
+			name:              "docker schema 1 manifest",
+			content:           `{"schemaVersion":1,"name":"library/ubuntu","tag":"latest","architecture":"amd64","fsLayers":[],"history":[]}`,
+			expectedMediaType: MediaTypeDockerSchema1ManifestUnsigned,
+		},
+		{
+			name:              "helm chart config",
+			content:           `{"name":"spegel","version":"v0.1.0","description":"Stateless cluster local OCI registry mirror.","apiVersion":"v2","appVersion":"v0.1.0","type":"application"}`,
+			expectedMediaType: MediaTypeHelmConfig,
+		},
+		{
+			name:              "wasm config",
+			content:           `{"created":"2024-01-01T00:00:00Z","architecture":"wasm","os":"wasip1","layerDigests":[]}`,
+			expectedMediaType: MediaTypeWasmConfig,
+		},
+		{
+			name:              "wasm module",
+			content:           "\x00asm\x01\x00\x00\x00",
+			expectedMediaType: MediaTypeWasmLayer,
+		},
+		{
+			name:              "cosign simple signing payload",
+			content:           `{"critical":{"identity":{"docker-reference":"ghcr.io/spegel-org/spegel"},"image":{"docker-manifest-digest":"sha256:b6d6089ca6c395fd563c2084f5dd7bc56a2f5e6a81413558c5be0083287a77e9"},"type":"cosign container image signature"},"optional":null}`,
+			expectedMediaType: MediaTypeCosignSimpleSigning,
+		},
+		{
+			name:              "in-toto statement",
+			content:           `{"_type":"https://in-toto.io/Statement/v1","subject":[],"predicateType":"https://slsa.dev/provenance/v1","predicate":{}}`,
+			expectedMediaType: MediaTypeInToto,
+		},
+		{
+			name:              "dsse envelope",
+			content:           `{"payloadType":"application/vnd.in-toto+json","payload":"e30=","signatures":[{"keyid":"","sig":"c2ln"}]}`,
+			expectedMediaType: MediaTypeDSSEEnvelope,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			mt, err := FingerprintMediaType(strings.NewReader(tt.content))
+			require.NoError(t, err)
+			require.EqualT(t, tt.expectedMediaType, mt)
+		})
+	}
+
+	// Content loosely resembling artifacts should not be guessed.
+	for _, content := range []string{
+		`{"apiVersion":"v1","kind":"ConfigMap","name":"foo","version":"v1"}`,
+		`{"apiVersion":"apps/v1","name":"foo","version":"v1"}`,
+		`{"name":"foo","version":"v1"}`,
+		`{"payloadType":"application/vnd.in-toto+json","payload":"e30="}`,
+		`{"payloadType":"application/vnd.in-toto+json","signatures":[]}`,
+	} {
+		_, err := FingerprintMediaType(strings.NewReader(content))
+		require.EqualError(t, err, "could not determine media type")
+	}
+}
+
 func TestIsManifestMediatype(t *testing.T) {
 	t.Parallel()
 
@@ -100,6 +182,22 @@ func TestIsManifestMediatype(t *testing.T) {
 			mt:       images.MediaTypeDockerSchema2Manifest,
 			expected: true,
 		},
+		{
+			mt:       images.MediaTypeDockerSchema1Manifest,
+			expected: true,
+		},
+		{
+			mt:       MediaTypeDockerSchema1ManifestUnsigned,
+			expected: true,
+		},
+		{
+			mt:       MediaTypeArtifactManifest,
+			expected: true,
+		},
+		{
+			mt:       MediaTypeHelmConfig,
+			expected: false,
+		},
 		{
 			mt:       "foo",
 			expected: false,
@@ -53,3 +53,8 @@ func (r Reference) Identifier() string {
 	}
 	return fmt.Sprintf("%s/%s:%s", r.Registry, r.Repository, r.Tag)
 }
+
+// Name returns the repository name including the registry.
+func (r Reference) Name() string {
+	return fmt.Sprintf("%s/%s", r.Registry, r.Repository)
+}
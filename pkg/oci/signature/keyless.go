@@ -0,0 +1,227 @@
+package signature
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"time"
+)
+
+var (
+	// oidIssuer is the deprecated Fulcio issuer extension containing the raw issuer.
+	oidIssuer = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 1}
+	// oidIssuerV2 is the Fulcio issuer extension containing a DER encoded issuer.
+	oidIssuerV2 = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 57264, 1, 8}
+)
+
+// keylessVerifier verifies signatures made with short lived certificates recorded in a transparency log.
+type keylessVerifier struct {
+	roots   *x509.CertPool
+	tlogKey crypto.PublicKey
+	subject *regexp.Regexp
+	issuer  string
+}
+
+func newKeylessVerifier(cfg KeylessConfig) (*keylessVerifier, error) {
+	if cfg.Issuer == "" {
+		return nil, errors.New("keyless issuer cannot be empty")
+	}
+	subject, err := regexp.Compile(cfg.Subject)
+	if err != nil {
+		return nil, err
+	}
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM([]byte(cfg.RootCertificates)) {
+		return nil, errors.New("keyless root certificates do not contain any valid certificates")
+	}
+	tlogKey, err := parsePublicKey(cfg.TransparencyLogPublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("could not parse transparency log public key: %w", err)
+	}
+	return &keylessVerifier{
+		roots:   roots,
+		tlogKey: tlogKey,
+		subject: subject,
+		issuer:  cfg.Issuer,
+	}, nil
+}
+
+type bundle struct {
+	SignedEntryTimestamp []byte        `json:"SignedEntryTimestamp"`
+	Payload              bundlePayload `json:"Payload"`
+}
+
+// bundlePayload fields are ordered to marshal as canonical JSON.
+type bundlePayload struct {
+	Body           string `json:"body"`
+	IntegratedTime int64  `json:"integratedTime"`
+	LogID          string `json:"logID"`
+	LogIndex       int64  `json:"logIndex"`
+}
+
+type hashedRekord struct {
+	Spec struct {
+		Data struct {
+			Hash struct {
+				Algorithm string `json:"algorithm"`
+				Value     string `json:"value"`
+			} `json:"hash"`
+		} `json:"data"`
+		Signature struct {
+			Content   string `json:"content"`
+			PublicKey struct {
+				Content string `json:"content"`
+			} `json:"publicKey"`
+		} `json:"signature"`
+	} `json:"spec"`
+	Kind string `json:"kind"`
+}
+
+func (k *keylessVerifier) verify(payload, sig []byte, annotations map[string]string) error {
+	certPEM, ok := annotations[AnnotationCertificate]
+	if !ok {
+		return errors.New("signature does not contain a certificate")
+	}
+	bundleJSON, ok := annotations[AnnotationBundle]
+	if !ok {
+		return errors.New("signature does not contain a transparency log bundle")
+	}
+
+	// The transparency log entry proves when the signature was made.
+	b := bundle{}
+	err := json.Unmarshal([]byte(bundleJSON), &b)
+	if err != nil {
+		return err
+	}
+	canonical, err := json.Marshal(b.Payload)
+	if err != nil {
+		return err
+	}
+	err = verifySignature(k.tlogKey, canonical, b.SignedEntryTimestamp)
+	if err != nil {
+		return fmt.Errorf("invalid transparency log entry timestamp: %w", err)
+	}
+	body, err := base64.StdEncoding.DecodeString(b.Payload.Body)
+	if err != nil {
+		return err
+	}
+	rekord := hashedRekord{}
+	err = json.Unmarshal(body, &rekord)
+	if err != nil {
+		return err
+	}
+	payloadHash := sha256.Sum256(payload)
+	if rekord.Kind != "hashedrekord" || rekord.Spec.Data.Hash.Algorithm != "sha256" || rekord.Spec.Data.Hash.Value != hex.EncodeToString(payloadHash[:]) {
+		return errors.New("transparency log entry does not match signature payload")
+	}
+	if rekord.Spec.Signature.Content != base64.StdEncoding.EncodeToString(sig) {
+		return errors.New("transparency log entry does not match signature")
+	}
+	entryCert, err := base64.StdEncoding.DecodeString(rekord.Spec.Signature.PublicKey.Content)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(bytes.TrimSpace(entryCert), bytes.TrimSpace([]byte(certPEM))) {
+		return errors.New("transparency log entry does not match certificate")
+	}
+
+	certs, err := parseCertificates(certPEM)
+	if err != nil {
+		return err
+	}
+	cert := certs[0]
+	intermediates := x509.NewCertPool()
+	if chainPEM, ok := annotations[AnnotationChain]; ok {
+		chain, err := parseCertificates(chainPEM)
+		if err != nil {
+			return err
+		}
+		for _, c := range chain {
+			intermediates.AddCert(c)
+		}
+	}
+	_, err = cert.Verify(x509.VerifyOptions{
+		Roots:         k.roots,
+		Intermediates: intermediates,
+		CurrentTime:   time.Unix(b.Payload.IntegratedTime, 0),
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	if err != nil {
+		return err
+	}
+	err = k.verifyIdentity(cert)
+	if err != nil {
+		return err
+	}
+	return verifySignature(cert.PublicKey, payload, sig)
+}
+
+func (k *keylessVerifier) verifyIdentity(cert *x509.Certificate) error {
+	issuer, err := certificateIssuer(cert)
+	if err != nil {
+		return err
+	}
+	if issuer != k.issuer {
+		return fmt.Errorf("certificate issuer %s does not match %s", issuer, k.issuer)
+	}
+	subjects := slices.Clone(cert.EmailAddresses)
+	for _, u := range cert.URIs {
+		subjects = append(subjects, u.String())
+	}
+	for _, subject := range subjects {
+		if k.subject.MatchString(subject) {
+			return nil
+		}
+	}
+	return fmt.Errorf("certificate subjects %v do not match %s", subjects, k.subject)
+}
+
+func certificateIssuer(cert *x509.Certificate) (string, error) {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidIssuerV2) {
+			var issuer string
+			_, err := asn1.Unmarshal(ext.Value, &issuer)
+			if err != nil {
+				return "", err
+			}
+			return issuer, nil
+		}
+	}
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oidIssuer) {
+			return string(ext.Value), nil
+		}
+	}
+	return "", errors.New("certificate does not contain an issuer")
+}
+
+func parseCertificates(s string) ([]*x509.Certificate, error) {
+	certs := []*x509.Certificate{}
+	rest := []byte(s)
+	for {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, errors.New("could not decode PEM certificate")
+	}
+	return certs, nil
+}
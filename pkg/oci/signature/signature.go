@@ -0,0 +1,367 @@
+package signature
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"regexp"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/spegel-org/spegel/pkg/oci"
+)
+
+const (
+	AnnotationSignature   = "dev.cosignproject.cosign/signature"
+	AnnotationCertificate = "dev.sigstore.cosign/certificate"
+	AnnotationChain       = "dev.sigstore.cosign/chain"
+	AnnotationBundle      = "dev.sigstore.cosign/bundle"
+	// ArtifactTypeSignature is the artifact type of signature manifests referring to the signed manifest.
+	ArtifactTypeSignature = "application/vnd.dev.cosign.artifact.sig.v1+json"
+)
+
+var signatureTagRegex = regexp.MustCompile(`^sha256-[a-f0-9]{64}\.(sig|att|sbom)$`)
+
+// IsSignatureTag returns true if the tag refers to a signature, attestation or SBOM of a manifest.
+func IsSignatureTag(tag string) bool {
+	return signatureTagRegex.MatchString(tag)
+}
+
+// Fetcher fetches the content of signature manifests and payloads.
+type Fetcher interface {
+	Fetch(ctx context.Context, dist oci.DistributionPath) ([]byte, error)
+}
+
+// PolicyConfig is the configuration of a signature policy.
+type PolicyConfig struct {
+	Rules []RuleConfig `json:"rules"`
+}
+
+// RuleConfig requires images in repositories matching the pattern to be signed.
+type RuleConfig struct {
+	// Repository is a regular expression matched against the registry and repository name.
+	Repository string `json:"repository"`
+	// PublicKeys are PEM encoded public keys trusted to sign images.
+	PublicKeys []string        `json:"publicKeys"`
+	Keyless    []KeylessConfig `json:"keyless"`
+}
+
+// KeylessConfig trusts signatures with certificates issued to an identity.
+type KeylessConfig struct {
+	// Issuer is the OIDC issuer which authenticated the identity.
+	Issuer string `json:"issuer"`
+	// Subject is a regular expression matched against the certificate email or URI.
+	Subject string `json:"subject"`
+	// RootCertificates are the PEM encoded certificate authorities issuing signing certificates.
+	RootCertificates string `json:"rootCertificates"`
+	// TransparencyLogPublicKey is the PEM encoded key signing transparency log entries.
+	TransparencyLogPublicKey string `json:"transparencyLogPublicKey"`
+}
+
+type verifier interface {
+	verify(payload, sig []byte, annotations map[string]string) error
+}
+
+type rule struct {
+	repository *regexp.Regexp
+	verifiers  []verifier
+}
+
+// Policy determines which signatures images have to be signed with.
+type Policy struct {
+	rules []rule
+}
+
+// LoadPolicy reads a JSON encoded policy configuration.
+func LoadPolicy(path string) (*Policy, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	cfg := PolicyConfig{}
+	err = json.Unmarshal(b, &cfg)
+	if err != nil {
+		return nil, err
+	}
+	return NewPolicy(cfg)
+}
+
+func NewPolicy(cfg PolicyConfig) (*Policy, error) {
+	p := &Policy{}
+	for _, ruleCfg := range cfg.Rules {
+		repository, err := regexp.Compile(ruleCfg.Repository)
+		if err != nil {
+			return nil, err
+		}
+		r := rule{
+			repository: repository,
+		}
+		for _, key := range ruleCfg.PublicKeys {
+			pub, err := parsePublicKey(key)
+			if err != nil {
+				return nil, err
+			}
+			r.verifiers = append(r.verifiers, &keyVerifier{pub: pub})
+		}
+		for _, keylessCfg := range ruleCfg.Keyless {
+			v, err := newKeylessVerifier(keylessCfg)
+			if err != nil {
+				return nil, err
+			}
+			r.verifiers = append(r.verifiers, v)
+		}
+		if len(r.verifiers) == 0 {
+			return nil, fmt.Errorf("rule for repository %s requires at least one public key or keyless identity", ruleCfg.Repository)
+		}
+		p.rules = append(p.rules, r)
+	}
+	return p, nil
+}
+
+// Matches returns true if images in the repository have to be signed.
+func (p *Policy) Matches(ref oci.Reference) bool {
+	_, ok := p.rule(ref)
+	return ok
+}
+
+func (p *Policy) rule(ref oci.Reference) (rule, bool) {
+	for _, r := range p.rules {
+		if r.repository.MatchString(ref.Name()) {
+			return r, true
+		}
+	}
+	return rule{}, false
+}
+
+// Verify checks that the manifest digest is signed according to the first rule matching the repository.
+// Signatures are looked up through the referrers API, falling back to the cosign signature tag.
+// Repositories not matching any rule do not require signatures.
+func (p *Policy) Verify(ctx context.Context, fetcher Fetcher, ref oci.Reference, dgst digest.Digest) error {
+	r, ok := p.rule(ref)
+	if !ok {
+		return nil
+	}
+
+	referrersErr := r.verifyReferrers(ctx, fetcher, ref, dgst)
+	if referrersErr == nil {
+		return nil
+	}
+	tagErr := r.verifyTag(ctx, fetcher, ref, dgst)
+	if tagErr == nil {
+		return nil
+	}
+	return errors.Join(fmt.Errorf("no valid signature found for %s", dgst), referrersErr, tagErr)
+}
+
+// verifyReferrers verifies the signature manifests which refer to the digest as their subject.
+func (r rule) verifyReferrers(ctx context.Context, fetcher Fetcher, ref oci.Reference, dgst digest.Digest) error {
+	referrersRef := oci.Reference{
+		Registry:   ref.Registry,
+		Repository: ref.Repository,
+		Digest:     dgst,
+	}
+	referrersDist, err := oci.NewDistributionPath(referrersRef, oci.DistributionKindReferrers, "http", http.MethodGet, nil)
+	if err != nil {
+		return err
+	}
+	b, err := fetcher.Fetch(ctx, referrersDist)
+	if err != nil {
+		return fmt.Errorf("could not fetch referrers for %s: %w", dgst, err)
+	}
+	idx := ocispec.Index{}
+	err = json.Unmarshal(b, &idx)
+	if err != nil {
+		return err
+	}
+
+	errs := []error{}
+	for _, desc := range idx.Manifests {
+		if desc.ArtifactType != ArtifactTypeSignature {
+			continue
+		}
+		err := func() error {
+			sigRef := oci.Reference{
+				Registry:   ref.Registry,
+				Repository: ref.Repository,
+				Digest:     desc.Digest,
+			}
+			sigDist, err := oci.NewDistributionPath(sigRef, oci.DistributionKindManifest, "http", http.MethodGet, nil)
+			if err != nil {
+				return err
+			}
+			b, err := fetcher.Fetch(ctx, sigDist)
+			if err != nil {
+				return err
+			}
+			if digest.FromBytes(b) != desc.Digest {
+				return fmt.Errorf("signature manifest does not match digest %s", desc.Digest)
+			}
+			manifest := ocispec.Manifest{}
+			err = json.Unmarshal(b, &manifest)
+			if err != nil {
+				return err
+			}
+			// The referrers response is not signed so the subject has to be checked.
+			if manifest.Subject == nil || manifest.Subject.Digest != dgst {
+				return fmt.Errorf("signature manifest %s does not refer to %s", desc.Digest, dgst)
+			}
+			return r.verifyManifest(ctx, fetcher, ref, dgst, manifest)
+		}()
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return fmt.Errorf("no signature referrers found for %s", dgst)
+	}
+	return errors.Join(errs...)
+}
+
+// verifyTag verifies the signature manifest stored under the cosign signature tag.
+func (r rule) verifyTag(ctx context.Context, fetcher Fetcher, ref oci.Reference, dgst digest.Digest) error {
+	sigRef := oci.Reference{
+		Registry:   ref.Registry,
+		Repository: ref.Repository,
+		Tag:        fmt.Sprintf("%s-%s.sig", dgst.Algorithm(), dgst.Encoded()),
+	}
+	sigDist, err := oci.NewDistributionPath(sigRef, oci.DistributionKindManifest, "http", http.MethodGet, nil)
+	if err != nil {
+		return err
+	}
+	b, err := fetcher.Fetch(ctx, sigDist)
+	if err != nil {
+		return fmt.Errorf("could not fetch signatures for %s: %w", dgst, err)
+	}
+	manifest := ocispec.Manifest{}
+	err = json.Unmarshal(b, &manifest)
+	if err != nil {
+		return err
+	}
+	return r.verifyManifest(ctx, fetcher, ref, dgst, manifest)
+}
+
+// verifyManifest returns nil if any signature layer in the manifest is valid for the digest.
+func (r rule) verifyManifest(ctx context.Context, fetcher Fetcher, ref oci.Reference, dgst digest.Digest, manifest ocispec.Manifest) error {
+	errs := []error{}
+	for _, layer := range manifest.Layers {
+		sigB64, ok := layer.Annotations[AnnotationSignature]
+		if !ok {
+			continue
+		}
+		err := func() error {
+			sig, err := base64.StdEncoding.DecodeString(sigB64)
+			if err != nil {
+				return err
+			}
+			payloadRef := oci.Reference{
+				Registry:   ref.Registry,
+				Repository: ref.Repository,
+				Digest:     layer.Digest,
+			}
+			payloadDist, err := oci.NewDistributionPath(payloadRef, oci.DistributionKindBlob, "http", http.MethodGet, nil)
+			if err != nil {
+				return err
+			}
+			payload, err := fetcher.Fetch(ctx, payloadDist)
+			if err != nil {
+				return err
+			}
+			if digest.FromBytes(payload) != layer.Digest {
+				return fmt.Errorf("signature payload does not match digest %s", layer.Digest)
+			}
+			err = verifyPayload(payload, dgst)
+			if err != nil {
+				return err
+			}
+			verifyErrs := []error{}
+			for _, v := range r.verifiers {
+				err := v.verify(payload, sig, layer.Annotations)
+				if err == nil {
+					return nil
+				}
+				verifyErrs = append(verifyErrs, err)
+			}
+			return errors.Join(verifyErrs...)
+		}()
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	if len(errs) == 0 {
+		return errors.New("signature manifest does not contain any signatures")
+	}
+	return errors.Join(errs...)
+}
+
+// verifyPayload checks that the simple signing payload refers to the manifest digest.
+func verifyPayload(payload []byte, dgst digest.Digest) error {
+	simpleSigning := struct {
+		Critical struct {
+			Image struct {
+				DockerManifestDigest digest.Digest `json:"docker-manifest-digest"`
+			} `json:"image"`
+			Type string `json:"type"`
+		} `json:"critical"`
+	}{}
+	err := json.Unmarshal(payload, &simpleSigning)
+	if err != nil {
+		return err
+	}
+	if simpleSigning.Critical.Type != "cosign container image signature" {
+		return fmt.Errorf("unknown signature payload type %s", simpleSigning.Critical.Type)
+	}
+	if simpleSigning.Critical.Image.DockerManifestDigest != dgst {
+		return fmt.Errorf("signature payload is for digest %s", simpleSigning.Critical.Image.DockerManifestDigest)
+	}
+	return nil
+}
+
+type keyVerifier struct {
+	pub crypto.PublicKey
+}
+
+func (k *keyVerifier) verify(payload, sig []byte, _ map[string]string) error {
+	return verifySignature(k.pub, payload, sig)
+}
+
+func verifySignature(pub crypto.PublicKey, payload, sig []byte) error {
+	hash := sha256.Sum256(payload)
+	switch key := pub.(type) {
+	case *ecdsa.PublicKey:
+		if !ecdsa.VerifyASN1(key, hash[:], sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	case *rsa.PublicKey:
+		return rsa.VerifyPKCS1v15(key, crypto.SHA256, hash[:], sig)
+	case ed25519.PublicKey:
+		if !ed25519.Verify(key, payload, sig) {
+			return errors.New("invalid signature")
+		}
+		return nil
+	default:
+		return fmt.Errorf("unsupported public key type %T", pub)
+	}
+}
+
+func parsePublicKey(s string) (crypto.PublicKey, error) {
+	block, _ := pem.Decode([]byte(s))
+	if block == nil {
+		return nil, errors.New("could not decode PEM public key")
+	}
+	return x509.ParsePKIXPublicKey(block.Bytes)
+}
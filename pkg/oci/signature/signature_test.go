@@ -0,0 +1,457 @@
+package signature
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/testify/v2/require"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/spegel-org/spegel/pkg/oci"
+	"github.com/spegel-org/spegel/pkg/store"
+)
+
+type memoryFetcher map[string][]byte
+
+func (m memoryFetcher) Fetch(ctx context.Context, dist oci.DistributionPath) ([]byte, error) {
+	key := dist.Identifier()
+	if dist.Kind == oci.DistributionKindReferrers {
+		key = referrersKey(dist.Digest)
+	}
+	b, ok := m[key]
+	if !ok {
+		return nil, store.ErrNotFound
+	}
+	return b, nil
+}
+
+// sign adds a signature for the digest to the fetcher.
+func sign(t *testing.T, fetcher memoryFetcher, ref oci.Reference, dgst digest.Digest, signer crypto.Signer, annotations map[string]string) {
+	t.Helper()
+
+	payload := simpleSigningPayload(t, dgst)
+	sig := signPayload(t, signer, payload)
+	if annotations == nil {
+		annotations = map[string]string{}
+	}
+	annotations[AnnotationSignature] = base64.StdEncoding.EncodeToString(sig)
+	addSignature(t, fetcher, ref, dgst, payload, annotations)
+}
+
+func simpleSigningPayload(t *testing.T, dgst digest.Digest) []byte {
+	t.Helper()
+
+	payload := map[string]any{
+		"critical": map[string]any{
+			"identity": map[string]string{"docker-reference": "example.com/foo"},
+			"image":    map[string]string{"docker-manifest-digest": dgst.String()},
+			"type":     "cosign container image signature",
+		},
+		"optional": nil,
+	}
+	b, err := json.Marshal(payload)
+	require.NoError(t, err)
+	return b
+}
+
+func signPayload(t *testing.T, signer crypto.Signer, payload []byte) []byte {
+	t.Helper()
+
+	if _, ok := signer.(ed25519.PrivateKey); ok {
+		sig, err := signer.Sign(rand.Reader, payload, crypto.Hash(0))
+		require.NoError(t, err)
+		return sig
+	}
+	hash := sha256.Sum256(payload)
+	sig, err := signer.Sign(rand.Reader, hash[:], crypto.SHA256)
+	require.NoError(t, err)
+	return sig
+}
+
+func addSignature(t *testing.T, fetcher memoryFetcher, ref oci.Reference, dgst digest.Digest, payload []byte, annotations map[string]string) {
+	t.Helper()
+
+	payloadDgst := digest.FromBytes(payload)
+	fetcher[payloadDgst.String()] = payload
+	manifest := ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Layers: []ocispec.Descriptor{
+			{
+				MediaType:   "application/vnd.dev.cosign.simplesigning.v1+json",
+				Digest:      payloadDgst,
+				Size:        int64(len(payload)),
+				Annotations: annotations,
+			},
+		},
+	}
+	manifest.SchemaVersion = 2
+	b, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	sigRef := oci.Reference{Registry: ref.Registry, Repository: ref.Repository, Tag: dgst.Algorithm().String() + "-" + dgst.Encoded() + ".sig"}
+	fetcher[sigRef.Identifier()] = b
+}
+
+func referrersKey(dgst digest.Digest) string {
+	return "referrers/" + dgst.String()
+}
+
+// addReferrer adds a signature manifest referring to the subject digest to the fetcher.
+func addReferrer(t *testing.T, fetcher memoryFetcher, subject digest.Digest, artifactType string, payload []byte, annotations map[string]string) {
+	t.Helper()
+
+	payloadDgst := digest.FromBytes(payload)
+	fetcher[payloadDgst.String()] = payload
+	manifest := ocispec.Manifest{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: artifactType,
+		Config:       ocispec.DescriptorEmptyJSON,
+		Layers: []ocispec.Descriptor{
+			{
+				MediaType:   "application/vnd.dev.cosign.simplesigning.v1+json",
+				Digest:      payloadDgst,
+				Size:        int64(len(payload)),
+				Annotations: annotations,
+			},
+		},
+		Subject: &ocispec.Descriptor{MediaType: ocispec.MediaTypeImageManifest, Digest: subject, Size: 100},
+	}
+	manifest.SchemaVersion = 2
+	b, err := json.Marshal(manifest)
+	require.NoError(t, err)
+	manifestDgst := digest.FromBytes(b)
+	fetcher[manifestDgst.String()] = b
+
+	idx := ocispec.Index{MediaType: ocispec.MediaTypeImageIndex}
+	idx.SchemaVersion = 2
+	if b, ok := fetcher[referrersKey(subject)]; ok {
+		err := json.Unmarshal(b, &idx)
+		require.NoError(t, err)
+	}
+	idx.Manifests = append(idx.Manifests, ocispec.Descriptor{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: artifactType,
+		Digest:       manifestDgst,
+		Size:         int64(len(b)),
+	})
+	b, err = json.Marshal(idx)
+	require.NoError(t, err)
+	fetcher[referrersKey(subject)] = b
+}
+
+func publicKeyPEM(t *testing.T, pub crypto.PublicKey) string {
+	t.Helper()
+
+	b, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: b}))
+}
+
+func TestNewPolicy(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewPolicy(PolicyConfig{Rules: []RuleConfig{{Repository: "["}}})
+	require.EqualError(t, err, "error parsing regexp: missing closing ]: `[`")
+	_, err = NewPolicy(PolicyConfig{Rules: []RuleConfig{{Repository: "foo"}}})
+	require.EqualError(t, err, "rule for repository foo requires at least one public key or keyless identity")
+	_, err = NewPolicy(PolicyConfig{Rules: []RuleConfig{{Repository: "foo", PublicKeys: []string{"foo"}}}})
+	require.EqualError(t, err, "could not decode PEM public key")
+	_, err = NewPolicy(PolicyConfig{Rules: []RuleConfig{{Repository: "foo", Keyless: []KeylessConfig{{}}}}})
+	require.EqualError(t, err, "keyless issuer cannot be empty")
+	_, err = NewPolicy(PolicyConfig{Rules: []RuleConfig{{Repository: "foo", Keyless: []KeylessConfig{{Issuer: "foo"}}}}})
+	require.EqualError(t, err, "keyless root certificates do not contain any valid certificates")
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	cfg := PolicyConfig{
+		Rules: []RuleConfig{
+			{
+				Repository: `^example\.com/signed$`,
+				PublicKeys: []string{publicKeyPEM(t, key.Public())},
+			},
+		},
+	}
+	b, err := json.Marshal(cfg)
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "policy.json")
+	err = os.WriteFile(path, b, 0o644)
+	require.NoError(t, err)
+	policy, err := LoadPolicy(path)
+	require.NoError(t, err)
+	require.TrueT(t, policy.Matches(oci.Reference{Registry: "example.com", Repository: "signed"}))
+	require.FalseT(t, policy.Matches(oci.Reference{Registry: "example.com", Repository: "unsigned"}))
+}
+
+func TestPolicyVerifyPublicKey(t *testing.T) {
+	t.Parallel()
+
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	_, ed25519Key, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	policy, err := NewPolicy(PolicyConfig{
+		Rules: []RuleConfig{
+			{
+				Repository: `^example\.com/`,
+				PublicKeys: []string{publicKeyPEM(t, ecdsaKey.Public()), publicKeyPEM(t, ed25519Key.Public())},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	ref := oci.Reference{Registry: "example.com", Repository: "foo"}
+	fetcher := memoryFetcher{}
+	ecdsaDgst := digest.FromString("ecdsa")
+	sign(t, fetcher, ref, ecdsaDgst, ecdsaKey, nil)
+	ed25519Dgst := digest.FromString("ed25519")
+	sign(t, fetcher, ref, ed25519Dgst, ed25519Key, nil)
+	otherDgst := digest.FromString("other")
+	sign(t, fetcher, ref, otherDgst, otherKey, nil)
+	// Signature payload for a different digest.
+	movedDgst := digest.FromString("moved")
+	addSignature(t, fetcher, ref, movedDgst, simpleSigningPayload(t, ecdsaDgst), map[string]string{
+		AnnotationSignature: base64.StdEncoding.EncodeToString(signPayload(t, ecdsaKey, simpleSigningPayload(t, ecdsaDgst))),
+	})
+
+	err = policy.Verify(t.Context(), fetcher, ref, ecdsaDgst)
+	require.NoError(t, err)
+	err = policy.Verify(t.Context(), fetcher, ref, ed25519Dgst)
+	require.NoError(t, err)
+	err = policy.Verify(t.Context(), fetcher, ref, otherDgst)
+	require.ErrorContains(t, err, "no valid signature found for "+otherDgst.String())
+	require.ErrorContains(t, err, "invalid signature")
+	err = policy.Verify(t.Context(), fetcher, ref, movedDgst)
+	require.ErrorContains(t, err, "signature payload is for digest "+ecdsaDgst.String())
+	unsignedDgst := digest.FromString("unsigned")
+	err = policy.Verify(t.Context(), fetcher, ref, unsignedDgst)
+	require.ErrorIs(t, err, store.ErrNotFound)
+
+	// Repositories without a rule do not require signatures.
+	err = policy.Verify(t.Context(), fetcher, oci.Reference{Registry: "docker.io", Repository: "library/foo"}, unsignedDgst)
+	require.NoError(t, err)
+}
+
+func TestPolicyVerifyReferrers(t *testing.T) {
+	t.Parallel()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	policy, err := NewPolicy(PolicyConfig{
+		Rules: []RuleConfig{
+			{
+				Repository: `^example\.com/`,
+				PublicKeys: []string{publicKeyPEM(t, key.Public())},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	ref := oci.Reference{Registry: "example.com", Repository: "foo"}
+	fetcher := memoryFetcher{}
+	signatureAnnotations := func(signer crypto.Signer, dgst digest.Digest) map[string]string {
+		return map[string]string{
+			AnnotationSignature: base64.StdEncoding.EncodeToString(signPayload(t, signer, simpleSigningPayload(t, dgst))),
+		}
+	}
+
+	// Signatures referring to the digest are verified.
+	referredDgst := digest.FromString("referred")
+	addReferrer(t, fetcher, referredDgst, "application/vnd.example+type", simpleSigningPayload(t, referredDgst), signatureAnnotations(key, referredDgst))
+	addReferrer(t, fetcher, referredDgst, ArtifactTypeSignature, simpleSigningPayload(t, referredDgst), signatureAnnotations(otherKey, referredDgst))
+	addReferrer(t, fetcher, referredDgst, ArtifactTypeSignature, simpleSigningPayload(t, referredDgst), signatureAnnotations(key, referredDgst))
+	err = policy.Verify(t.Context(), fetcher, ref, referredDgst)
+	require.NoError(t, err)
+
+	// Referrers listed for another digest are rejected.
+	listedDgst := digest.FromString("listed")
+	otherDgst := digest.FromString("other")
+	addReferrer(t, fetcher, otherDgst, ArtifactTypeSignature, simpleSigningPayload(t, listedDgst), signatureAnnotations(key, listedDgst))
+	fetcher[referrersKey(listedDgst)] = fetcher[referrersKey(otherDgst)]
+	err = policy.Verify(t.Context(), fetcher, ref, listedDgst)
+	require.ErrorContains(t, err, "does not refer to "+listedDgst.String())
+
+	// Signature tags are used when no referrer has a valid signature.
+	taggedDgst := digest.FromString("tagged")
+	addReferrer(t, fetcher, taggedDgst, ArtifactTypeSignature, simpleSigningPayload(t, taggedDgst), signatureAnnotations(otherKey, taggedDgst))
+	err = policy.Verify(t.Context(), fetcher, ref, taggedDgst)
+	require.ErrorContains(t, err, "no valid signature found for "+taggedDgst.String())
+	sign(t, fetcher, ref, taggedDgst, key, nil)
+	err = policy.Verify(t.Context(), fetcher, ref, taggedDgst)
+	require.NoError(t, err)
+}
+
+func TestPolicyVerifyKeyless(t *testing.T) {
+	t.Parallel()
+
+	now := time.Now()
+	rootKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	rootTmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "root"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	rootDER, err := x509.CreateCertificate(rand.Reader, rootTmpl, rootTmpl, rootKey.Public(), rootKey)
+	require.NoError(t, err)
+	rootCert, err := x509.ParseCertificate(rootDER)
+	require.NoError(t, err)
+	rootPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: rootDER}))
+
+	issuerExt, err := asn1.Marshal("https://token.actions.githubusercontent.com")
+	require.NoError(t, err)
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	subject, err := url.Parse("https://github.com/spegel-org/spegel/.github/workflows/release.yaml@refs/tags/v1.0.0")
+	require.NoError(t, err)
+	leafTmpl := &x509.Certificate{
+		SerialNumber:    big.NewInt(2),
+		NotBefore:       now.Add(-time.Minute),
+		NotAfter:        now.Add(10 * time.Minute),
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:     []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+		URIs:            []*url.URL{subject},
+		ExtraExtensions: []pkix.Extension{{Id: oidIssuerV2, Value: issuerExt}},
+	}
+	leafDER, err := x509.CreateCertificate(rand.Reader, leafTmpl, rootCert, leafKey.Public(), rootKey)
+	require.NoError(t, err)
+	leafPEM := string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: leafDER}))
+
+	tlogKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	policy, err := NewPolicy(PolicyConfig{
+		Rules: []RuleConfig{
+			{
+				Repository: `^ghcr\.io/spegel-org/`,
+				Keyless: []KeylessConfig{
+					{
+						Issuer:                   "https://token.actions.githubusercontent.com",
+						Subject:                  `^https://github\.com/spegel-org/spegel/`,
+						RootCertificates:         rootPEM,
+						TransparencyLogPublicKey: publicKeyPEM(t, tlogKey.Public()),
+					},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	keylessAnnotations := func(payload, sig []byte, integratedTime time.Time, signer crypto.Signer) map[string]string {
+		payloadHash := sha256.Sum256(payload)
+		rekord := map[string]any{
+			"apiVersion": "0.0.1",
+			"kind":       "hashedrekord",
+			"spec": map[string]any{
+				"data": map[string]any{"hash": map[string]string{"algorithm": "sha256", "value": hex.EncodeToString(payloadHash[:])}},
+				"signature": map[string]any{
+					"content":   base64.StdEncoding.EncodeToString(sig),
+					"publicKey": map[string]string{"content": base64.StdEncoding.EncodeToString([]byte(leafPEM))},
+				},
+			},
+		}
+		body, err := json.Marshal(rekord)
+		require.NoError(t, err)
+		b := bundle{
+			Payload: bundlePayload{
+				Body:           base64.StdEncoding.EncodeToString(body),
+				IntegratedTime: integratedTime.Unix(),
+				LogID:          "log",
+				LogIndex:       1,
+			},
+		}
+		canonical, err := json.Marshal(b.Payload)
+		require.NoError(t, err)
+		b.SignedEntryTimestamp = signPayload(t, signer, canonical)
+		bundleJSON, err := json.Marshal(b)
+		require.NoError(t, err)
+		return map[string]string{
+			AnnotationSignature:   base64.StdEncoding.EncodeToString(sig),
+			AnnotationCertificate: leafPEM,
+			AnnotationBundle:      string(bundleJSON),
+		}
+	}
+
+	ref := oci.Reference{Registry: "ghcr.io", Repository: "spegel-org/spegel"}
+	fetcher := memoryFetcher{}
+
+	validDgst := digest.FromString("valid")
+	payload := simpleSigningPayload(t, validDgst)
+	addSignature(t, fetcher, ref, validDgst, payload, keylessAnnotations(payload, signPayload(t, leafKey, payload), now, tlogKey))
+	err = policy.Verify(t.Context(), fetcher, ref, validDgst)
+	require.NoError(t, err)
+
+	expiredDgst := digest.FromString("expired")
+	payload = simpleSigningPayload(t, expiredDgst)
+	addSignature(t, fetcher, ref, expiredDgst, payload, keylessAnnotations(payload, signPayload(t, leafKey, payload), now.Add(time.Hour), tlogKey))
+	err = policy.Verify(t.Context(), fetcher, ref, expiredDgst)
+	require.ErrorContains(t, err, "certificate has expired or is not yet valid")
+
+	forgedDgst := digest.FromString("forged")
+	payload = simpleSigningPayload(t, forgedDgst)
+	addSignature(t, fetcher, ref, forgedDgst, payload, keylessAnnotations(payload, signPayload(t, leafKey, payload), now, leafKey))
+	err = policy.Verify(t.Context(), fetcher, ref, forgedDgst)
+	require.ErrorContains(t, err, "invalid transparency log entry timestamp")
+
+	mismatchDgst := digest.FromString("mismatch")
+	payload = simpleSigningPayload(t, mismatchDgst)
+	annotations := keylessAnnotations(payload, signPayload(t, leafKey, []byte("other")), now, tlogKey)
+	addSignature(t, fetcher, ref, mismatchDgst, payload, annotations)
+	err = policy.Verify(t.Context(), fetcher, ref, mismatchDgst)
+	require.ErrorContains(t, err, "invalid signature")
+
+	wrongIdentityPolicy, err := NewPolicy(PolicyConfig{
+		Rules: []RuleConfig{
+			{
+				Repository: `^ghcr\.io/spegel-org/`,
+				Keyless: []KeylessConfig{
+					{
+						Issuer:                   "https://token.actions.githubusercontent.com",
+						Subject:                  `^https://github\.com/other/`,
+						RootCertificates:         rootPEM,
+						TransparencyLogPublicKey: publicKeyPEM(t, tlogKey.Public()),
+					},
+				},
+			},
+		},
+	})
+	require.NoError(t, err)
+	err = wrongIdentityPolicy.Verify(t.Context(), fetcher, ref, validDgst)
+	require.ErrorContains(t, err, "do not match ^https://github\\.com/other/")
+}
+
+func TestIsSignatureTag(t *testing.T) {
+	t.Parallel()
+
+	dgst := digest.FromString("foo")
+	require.TrueT(t, IsSignatureTag("sha256-"+dgst.Encoded()+".sig"))
+	require.TrueT(t, IsSignatureTag("sha256-"+dgst.Encoded()+".att"))
+	require.FalseT(t, IsSignatureTag("sha256-"+dgst.Encoded()))
+	require.FalseT(t, IsSignatureTag("latest"))
+}
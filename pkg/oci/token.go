@@ -0,0 +1,341 @@
+package oci
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	lru "github.com/hashicorp/golang-lru/v2"
+	"golang.org/x/sync/singleflight"
+
+	"github.com/spegel-org/spegel/pkg/httpx"
+)
+
+const (
+	// defaultTokenExpiry is used when the token response does not include an expiry.
+	defaultTokenExpiry = 60 * time.Second
+	// tokenExpiryLeeway renews tokens before they expire to avoid using tokens in flight.
+	tokenExpiryLeeway = 5 * time.Second
+	tokenClientID     = "spegel"
+	// tokenCacheSize limits the amount of challenges, tokens and refresh tokens kept, expired tokens are evicted when read.
+	tokenCacheSize = 1000
+)
+
+// bearerChallenge is the parsed Bearer WWW-Authenticate challenge.
+type bearerChallenge struct {
+	Realm   string
+	Service string
+	Scopes  []string
+}
+
+// cacheKey identifies tokens which are valid for the same realm, service and scopes fetched by the same user.
+func (b bearerChallenge) cacheKey(userinfo *url.Userinfo) string {
+	return b.refreshKey(userinfo) + " " + strings.Join(b.Scopes, " ")
+}
+
+// refreshKey identifies refresh tokens which are valid for the same realm and service issued to the same user.
+func (b bearerChallenge) refreshKey(userinfo *url.Userinfo) string {
+	username := ""
+	if userinfo != nil {
+		username = userinfo.Username()
+	}
+	return b.Realm + " " + b.Service + " " + username
+}
+
+type bearerToken struct {
+	expiresAt time.Time
+	token     string
+}
+
+// tokenManager fetches and caches bearer tokens.
+type tokenManager struct {
+	httpClient    *http.Client
+	fetchGroup    *singleflight.Group
+	challenges    *lru.Cache[string, bearerChallenge]
+	tokens        *lru.Cache[string, bearerToken]
+	refreshTokens *lru.Cache[string, string]
+}
+
+func newTokenManager(httpClient *http.Client) *tokenManager {
+	// Creating the caches only fails for sizes less than one.
+	//nolint: errcheck // Ignore error.
+	challenges, _ := lru.New[string, bearerChallenge](tokenCacheSize)
+	//nolint: errcheck // Ignore error.
+	tokens, _ := lru.New[string, bearerToken](tokenCacheSize)
+	//nolint: errcheck // Ignore error.
+	refreshTokens, _ := lru.New[string, string](tokenCacheSize)
+	return &tokenManager{
+		httpClient:    httpClient,
+		fetchGroup:    &singleflight.Group{},
+		challenges:    challenges,
+		tokens:        tokens,
+		refreshTokens: refreshTokens,
+	}
+}
+
+// Token returns a valid token for the key if a challenge has been received for it before.
+func (t *tokenManager) Token(ctx context.Context, key string, userinfo *url.Userinfo) (string, bool, error) {
+	challenge, ok := t.challenges.Get(key)
+	if !ok {
+		return "", false, nil
+	}
+	token, ok := t.tokens.Get(challenge.cacheKey(userinfo))
+	if ok && time.Now().Before(token.expiresAt) {
+		return token.token, true, nil
+	}
+	if ok {
+		t.tokens.Remove(challenge.cacheKey(userinfo))
+	}
+	tokenStr, err := t.fetch(ctx, challenge, userinfo)
+	if err != nil {
+		return "", false, err
+	}
+	return tokenStr, true, nil
+}
+
+// Challenge fetches a new token for the challenge, replacing any cached token.
+func (t *tokenManager) Challenge(ctx context.Context, key, repository, wwwAuth string, userinfo *url.Userinfo) error {
+	challenge, err := parseBearerChallenge(wwwAuth, repository)
+	if err != nil {
+		return err
+	}
+	t.challenges.Add(key, challenge)
+	_, err = t.fetch(ctx, challenge, userinfo)
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// fetch fetches a new token for the challenge, concurrent fetches of the same token share a single token request.
+func (t *tokenManager) fetch(ctx context.Context, challenge bearerChallenge, userinfo *url.Userinfo) (string, error) {
+	v, err, _ := t.fetchGroup.Do(challenge.cacheKey(userinfo), func() (any, error) {
+		return t.fetchToken(ctx, challenge, userinfo)
+	})
+	if err != nil {
+		return "", err
+	}
+	//nolint: errcheck // Result is always a string.
+	return v.(string), nil
+}
+
+func (t *tokenManager) fetchToken(ctx context.Context, challenge bearerChallenge, userinfo *url.Userinfo) (string, error) {
+	refreshKey := challenge.refreshKey(userinfo)
+	refreshToken, stored := t.refreshTokens.Get(refreshKey)
+	// Identity tokens from Docker credentials are refresh tokens.
+	if !stored && userinfo != nil && userinfo.Username() == "<token>" {
+		refreshToken, _ = userinfo.Password()
+	}
+
+	now := time.Now()
+	var tokenResp tokenResponse
+	var err error
+	if refreshToken != "" {
+		var req *http.Request
+		req, err = newRefreshTokenRequest(ctx, challenge, refreshToken)
+		if err != nil {
+			return "", err
+		}
+		tokenResp, err = t.doTokenRequest(req)
+		// Stored refresh tokens may have been revoked, fall back to a new token request.
+		if err != nil && stored {
+			t.refreshTokens.Remove(refreshKey)
+			refreshToken = ""
+		}
+	}
+	if refreshToken == "" {
+		var req *http.Request
+		req, err = newTokenRequest(ctx, challenge, userinfo)
+		if err != nil {
+			return "", err
+		}
+		tokenResp, err = t.doTokenRequest(req)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	expiresIn := defaultTokenExpiry
+	if tokenResp.ExpiresIn > 0 {
+		expiresIn = time.Duration(tokenResp.ExpiresIn) * time.Second
+	}
+	token := bearerToken{
+		token:     tokenResp.token(),
+		expiresAt: now.Add(max(expiresIn-tokenExpiryLeeway, 0)),
+	}
+	t.tokens.Add(challenge.cacheKey(userinfo), token)
+	if tokenResp.RefreshToken != "" {
+		t.refreshTokens.Add(refreshKey, tokenResp.RefreshToken)
+	}
+	return token.token, nil
+}
+
+type tokenResponse struct {
+	Token        string `json:"token"`
+	AccessToken  string `json:"access_token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+}
+
+func (t tokenResponse) token() string {
+	if t.Token != "" {
+		return t.Token
+	}
+	return t.AccessToken
+}
+
+func (t *tokenManager) doTokenRequest(req *http.Request) (tokenResponse, error) {
+	resp, err := t.httpClient.Do(req)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	defer httpx.DrainAndClose(resp.Body)
+	err = httpx.CheckResponseStatus(resp, http.StatusOK)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	tokenResp := tokenResponse{}
+	err = json.Unmarshal(b, &tokenResp)
+	if err != nil {
+		return tokenResponse{}, err
+	}
+	if tokenResp.token() == "" {
+		return tokenResponse{}, errors.New("token response does not contain a token")
+	}
+	return tokenResp, nil
+}
+
+// newTokenRequest returns a token request authenticated with basic credentials if set.
+func newTokenRequest(ctx context.Context, challenge bearerChallenge, userinfo *url.Userinfo) (*http.Request, error) {
+	authURL, err := url.Parse(challenge.Realm)
+	if err != nil {
+		return nil, err
+	}
+	q := authURL.Query()
+	if challenge.Service != "" {
+		q.Set("service", challenge.Service)
+	}
+	for _, scope := range challenge.Scopes {
+		q.Add("scope", scope)
+	}
+	if userinfo != nil {
+		q.Set("offline_token", "true")
+		q.Set("client_id", tokenClientID)
+	}
+	authURL.RawQuery = q.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, authURL.String(), nil)
+	if err != nil {
+		return nil, err
+	}
+	if userinfo != nil {
+		req.Header.Set(httpx.HeaderAuthorization, httpx.UserinfoHeaderValue(*userinfo))
+	}
+	return req, nil
+}
+
+// newRefreshTokenRequest returns an OAuth2 refresh token grant request.
+func newRefreshTokenRequest(ctx context.Context, challenge bearerChallenge, refreshToken string) (*http.Request, error) {
+	form := url.Values{}
+	form.Set("grant_type", "refresh_token")
+	form.Set("refresh_token", refreshToken)
+	form.Set("client_id", tokenClientID)
+	if challenge.Service != "" {
+		form.Set("service", challenge.Service)
+	}
+	if len(challenge.Scopes) > 0 {
+		form.Set("scope", strings.Join(challenge.Scopes, " "))
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, challenge.Realm, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set(httpx.HeaderContentType, "application/x-www-form-urlencoded")
+	return req, nil
+}
+
+// parseBearerChallenge parses the challenge, ensuring that the scopes grant pull access to the repository.
+func parseBearerChallenge(wwwAuth, repository string) (bearerChallenge, error) {
+	scheme, paramsStr, _ := strings.Cut(wwwAuth, " ")
+	if !strings.EqualFold(scheme, "Bearer") {
+		return bearerChallenge{}, errors.New("unsupported auth scheme")
+	}
+	params, err := parseAuthParams(paramsStr)
+	if err != nil {
+		return bearerChallenge{}, err
+	}
+	realm, ok := params["realm"]
+	if !ok {
+		return bearerChallenge{}, errors.New("bearer challenge is missing realm")
+	}
+	challenge := bearerChallenge{
+		Realm:   realm,
+		Service: params["service"],
+	}
+	hasRepository := false
+	for scope := range strings.FieldsSeq(params["scope"]) {
+		// Some registries challenge with placeholder repository scopes, which would not grant access.
+		if name, ok := strings.CutPrefix(scope, "repository:"); ok {
+			if !strings.HasPrefix(name, repository+":") {
+				continue
+			}
+			hasRepository = true
+		}
+		challenge.Scopes = append(challenge.Scopes, scope)
+	}
+	if !hasRepository && repository != "" {
+		challenge.Scopes = append(challenge.Scopes, fmt.Sprintf("repository:%s:pull", repository))
+	}
+	slices.Sort(challenge.Scopes)
+	return challenge, nil
+}
+
+// parseAuthParams parses comma separated auth parameters where values may be quoted.
+func parseAuthParams(s string) (map[string]string, error) {
+	params := map[string]string{}
+	for {
+		s = strings.TrimLeft(s, ", ")
+		if s == "" {
+			return params, nil
+		}
+		key, rest, ok := strings.Cut(s, "=")
+		if !ok {
+			return nil, fmt.Errorf("invalid auth parameter %s", s)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		if !strings.HasPrefix(rest, `"`) {
+			value, remaining, _ := strings.Cut(rest, ",")
+			params[key] = strings.TrimSpace(value)
+			s = remaining
+			continue
+		}
+		value := strings.Builder{}
+		i := 1
+		for ; i < len(rest); i++ {
+			if rest[i] == '\\' && i+1 < len(rest) {
+				i++
+				value.WriteByte(rest[i])
+				continue
+			}
+			if rest[i] == '"' {
+				break
+			}
+			value.WriteByte(rest[i])
+		}
+		if i == len(rest) {
+			return nil, fmt.Errorf("unterminated quoted value for auth parameter %s", key)
+		}
+		params[key] = value.String()
+		s = rest[i+1:]
+	}
+}
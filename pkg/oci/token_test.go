@@ -0,0 +1,268 @@
+package oci
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/testify/v2/require"
+	"golang.org/x/sync/errgroup"
+)
+
+func TestParseBearerChallenge(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name        string
+		wwwAuth     string
+		repository  string
+		expected    bearerChallenge
+		expectedErr string
+	}{
+		{
+			name:       "docker hub",
+			wwwAuth:    `Bearer realm="https://auth.docker.io/token",service="registry.docker.io",scope="repository:library/alpine:pull"`,
+			repository: "library/alpine",
+			expected: bearerChallenge{
+				Realm:   "https://auth.docker.io/token",
+				Service: "registry.docker.io",
+				Scopes:  []string{"repository:library/alpine:pull"},
+			},
+		},
+		{
+			name:       "multiple scopes with commas",
+			wwwAuth:    `Bearer realm="https://example.com/token", service="example.com", scope="repository:foo:pull,push registry:catalog:*"`,
+			repository: "foo",
+			expected: bearerChallenge{
+				Realm:   "https://example.com/token",
+				Service: "example.com",
+				Scopes:  []string{"registry:catalog:*", "repository:foo:pull,push"},
+			},
+		},
+		{
+			name:       "placeholder repository scope",
+			wwwAuth:    `Bearer realm="https://ghcr.io/token",service="ghcr.io",scope="repository:user/image:pull"`,
+			repository: "spegel-org/spegel",
+			expected: bearerChallenge{
+				Realm:   "https://ghcr.io/token",
+				Service: "ghcr.io",
+				Scopes:  []string{"repository:spegel-org/spegel:pull"},
+			},
+		},
+		{
+			name:       "missing scope and unquoted values",
+			wwwAuth:    `bearer realm=https://example.com/token,service=example.com`,
+			repository: "foo",
+			expected: bearerChallenge{
+				Realm:   "https://example.com/token",
+				Service: "example.com",
+				Scopes:  []string{"repository:foo:pull"},
+			},
+		},
+		{
+			name:        "basic scheme",
+			wwwAuth:     `Basic realm="example.com"`,
+			expectedErr: "unsupported auth scheme",
+		},
+		{
+			name:        "missing realm",
+			wwwAuth:     `Bearer service="example.com"`,
+			expectedErr: "bearer challenge is missing realm",
+		},
+		{
+			name:        "unterminated value",
+			wwwAuth:     `Bearer realm="example.com`,
+			expectedErr: "unterminated quoted value for auth parameter realm",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			challenge, err := parseBearerChallenge(tt.wwwAuth, tt.repository)
+			if tt.expectedErr != "" {
+				require.EqualError(t, err, tt.expectedErr)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, tt.expected, challenge)
+		})
+	}
+}
+
+func TestTokenManager(t *testing.T) {
+	t.Parallel()
+
+	var tokenRequests atomic.Int64
+	var refreshRequests atomic.Int64
+	var slowRequests atomic.Int64
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /token", func(rw http.ResponseWriter, req *http.Request) {
+		tokenRequests.Add(1)
+		resp := map[string]any{
+			"access_token": "access-" + req.URL.Query().Get("scope"),
+			"expires_in":   3600,
+		}
+		if req.URL.Query().Get("scope") == "repository:expiring:pull" {
+			resp["expires_in"] = 1
+		}
+		if req.URL.Query().Get("scope") == "repository:slow:pull" {
+			slowRequests.Add(1)
+			time.Sleep(100 * time.Millisecond)
+		}
+		if username, password, ok := req.BasicAuth(); ok {
+			if username != "foo" || password != "bar" {
+				rw.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			if req.URL.Query().Get("offline_token") != "true" {
+				rw.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			resp["refresh_token"] = "refresh"
+		}
+		//nolint: errcheck // Ignore error.
+		json.NewEncoder(rw).Encode(resp)
+	})
+	mux.HandleFunc("POST /token", func(rw http.ResponseWriter, req *http.Request) {
+		refreshRequests.Add(1)
+		err := req.ParseForm()
+		if err != nil || req.PostForm.Get("grant_type") != "refresh_token" {
+			rw.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if req.PostForm.Get("refresh_token") != "refresh" {
+			rw.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		//nolint: errcheck // Ignore error.
+		json.NewEncoder(rw).Encode(map[string]any{"token": "refreshed-" + req.PostForm.Get("scope")})
+	})
+	srv := httptest.NewServer(mux)
+	t.Cleanup(func() {
+		srv.Close()
+	})
+	wwwAuth := `Bearer realm="` + srv.URL + `/token",service="test"`
+
+	t.Run("anonymous", func(t *testing.T) {
+		t.Parallel()
+
+		tm := newTokenManager(srv.Client())
+		_, ok, err := tm.Token(t.Context(), "example.com/foo", nil)
+		require.NoError(t, err)
+		require.FalseT(t, ok)
+
+		err = tm.Challenge(t.Context(), "example.com/foo", "foo", wwwAuth, nil)
+		require.NoError(t, err)
+		token, ok, err := tm.Token(t.Context(), "example.com/foo", nil)
+		require.NoError(t, err)
+		require.TrueT(t, ok)
+		require.EqualT(t, "access-repository:foo:pull", token)
+
+		// Expired tokens are fetched again.
+		err = tm.Challenge(t.Context(), "example.com/expiring", "expiring", wwwAuth, nil)
+		require.NoError(t, err)
+		before := tokenRequests.Load()
+		token, ok, err = tm.Token(t.Context(), "example.com/expiring", nil)
+		require.NoError(t, err)
+		require.TrueT(t, ok)
+		require.EqualT(t, "access-repository:expiring:pull", token)
+		require.EqualT(t, before+1, tokenRequests.Load())
+	})
+
+	t.Run("bounded caches", func(t *testing.T) {
+		t.Parallel()
+
+		tm := newTokenManager(srv.Client())
+		for i := range tokenCacheSize + 10 {
+			key := fmt.Sprintf("example.com/foo-%d", i)
+			tm.challenges.Add(key, bearerChallenge{Realm: srv.URL + "/token"})
+			tm.tokens.Add(key, bearerToken{token: "token", expiresAt: time.Now().Add(time.Hour)})
+			tm.refreshTokens.Add(key, "refresh")
+		}
+		require.EqualT(t, tokenCacheSize, tm.challenges.Len())
+		require.EqualT(t, tokenCacheSize, tm.tokens.Len())
+		require.EqualT(t, tokenCacheSize, tm.refreshTokens.Len())
+
+		// Expired tokens are evicted when read.
+		challenge := bearerChallenge{Realm: srv.URL + "/token", Service: "test", Scopes: []string{"repository:expired:pull"}}
+		tm.challenges.Add("example.com/expired", challenge)
+		tm.tokens.Add(challenge.cacheKey(nil), bearerToken{token: "expired", expiresAt: time.Now().Add(-time.Second)})
+		token, ok, err := tm.Token(t.Context(), "example.com/expired", nil)
+		require.NoError(t, err)
+		require.TrueT(t, ok)
+		require.EqualT(t, "access-repository:expired:pull", token)
+	})
+
+	t.Run("basic credentials and refresh", func(t *testing.T) {
+		t.Parallel()
+
+		tm := newTokenManager(srv.Client())
+		err := tm.Challenge(t.Context(), "example.com/foo", "foo", wwwAuth, url.UserPassword("foo", "wrong"))
+		require.EqualError(t, err, "expected one of the following statuses [200 OK], but received 401 Unauthorized")
+
+		err = tm.Challenge(t.Context(), "example.com/foo", "foo", wwwAuth, url.UserPassword("foo", "bar"))
+		require.NoError(t, err)
+		token, ok, err := tm.Token(t.Context(), "example.com/foo", url.UserPassword("foo", "bar"))
+		require.NoError(t, err)
+		require.TrueT(t, ok)
+		require.EqualT(t, "access-repository:foo:pull", token)
+
+		// New scopes use the refresh token.
+		before := refreshRequests.Load()
+		err = tm.Challenge(t.Context(), "example.com/bar", "bar", wwwAuth, url.UserPassword("foo", "bar"))
+		require.NoError(t, err)
+		token, ok, err = tm.Token(t.Context(), "example.com/bar", url.UserPassword("foo", "bar"))
+		require.NoError(t, err)
+		require.TrueT(t, ok)
+		require.EqualT(t, "refreshed-repository:bar:pull", token)
+		require.EqualT(t, before+1, refreshRequests.Load())
+
+		// Revoked refresh tokens fall back to basic credentials.
+		tm.refreshTokens.Add(srv.URL+"/token test foo", "revoked")
+		err = tm.Challenge(t.Context(), "example.com/baz", "baz", wwwAuth, url.UserPassword("foo", "bar"))
+		require.NoError(t, err)
+		token, _, err = tm.Token(t.Context(), "example.com/baz", url.UserPassword("foo", "bar"))
+		require.NoError(t, err)
+		require.EqualT(t, "access-repository:baz:pull", token)
+		refreshToken, ok := tm.refreshTokens.Get(srv.URL + "/token test foo")
+		require.TrueT(t, ok)
+		require.EqualT(t, "refresh", refreshToken)
+
+		// Refresh tokens are not shared with other users.
+		err = tm.Challenge(t.Context(), "example.com/qux", "qux", wwwAuth, url.UserPassword("other", "bar"))
+		require.EqualError(t, err, "expected one of the following statuses [200 OK], but received 401 Unauthorized")
+	})
+
+	t.Run("concurrent fetches", func(t *testing.T) {
+		t.Parallel()
+
+		tm := newTokenManager(srv.Client())
+		g, gCtx := errgroup.WithContext(t.Context())
+		for range 10 {
+			g.Go(func() error {
+				return tm.Challenge(gCtx, "example.com/slow", "slow", wwwAuth, nil)
+			})
+		}
+		err := g.Wait()
+		require.NoError(t, err)
+		require.EqualT(t, 1, slowRequests.Load())
+	})
+
+	t.Run("identity token", func(t *testing.T) {
+		t.Parallel()
+
+		tm := newTokenManager(srv.Client())
+		err := tm.Challenge(t.Context(), "example.com/foo", "foo", wwwAuth, url.UserPassword("<token>", "refresh"))
+		require.NoError(t, err)
+		token, ok, err := tm.Token(t.Context(), "example.com/foo", url.UserPassword("<token>", "refresh"))
+		require.NoError(t, err)
+		require.TrueT(t, ok)
+		require.EqualT(t, "refreshed-repository:foo:pull", token)
+	})
+}
@@ -0,0 +1,132 @@
+package registry
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/spegel-org/spegel/pkg/httpx"
+	"github.com/spegel-org/spegel/pkg/oci"
+	"github.com/spegel-org/spegel/pkg/store"
+)
+
+// referrers returns the index of local manifests in the repository which refer to the digest as their subject.
+// Referrers can only be listed when the store lists its images, store.ErrNotFound is returned when none exist.
+func (r *Registry) referrers(ctx context.Context, dist oci.DistributionPath) ([]byte, error) {
+	lister, ok := r.provider.(oci.ImageLister)
+	if !ok {
+		return nil, errors.Join(store.ErrNotFound, errors.New("store does not list images"))
+	}
+	imgs, err := lister.ListImages(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	log := logr.FromContextOrDiscard(ctx)
+	repository := r.aliases.Key(dist.Name())
+	idx := ocispec.Index{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{},
+	}
+	idx.SchemaVersion = 2
+	seen := map[digest.Digest]struct{}{}
+	for _, img := range imgs {
+		if r.aliases.Key(img.Name()) != repository {
+			continue
+		}
+		if _, ok := seen[img.Digest]; ok {
+			continue
+		}
+		seen[img.Digest] = struct{}{}
+		desc, ok, err := r.referrer(ctx, img.Digest, dist.Digest)
+		if err != nil {
+			log.Error(err, "could not read manifest when listing referrers", "digest", img.Digest)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if oci.MatchesFilterDescriptor(img.Reference, desc, r.filters) {
+			continue
+		}
+		idx.Manifests = append(idx.Manifests, desc)
+	}
+	if len(idx.Manifests) == 0 {
+		return nil, errors.Join(store.ErrNotFound, fmt.Errorf("no referrers found for %s", dist.Digest))
+	}
+	return json.Marshal(idx)
+}
+
+// referrer returns the descriptor of the manifest if its subject is the digest.
+func (r *Registry) referrer(ctx context.Context, dgst, subject digest.Digest) (ocispec.Descriptor, bool, error) {
+	storeDesc, err := r.provider.Descriptor(ctx, dgst)
+	if err != nil {
+		return ocispec.Descriptor{}, false, err
+	}
+	if storeDesc.MediaType != ocispec.MediaTypeImageManifest || storeDesc.Size > oci.ManifestMaxSize {
+		return ocispec.Descriptor{}, false, nil
+	}
+	rc, err := r.provider.Open(ctx, dgst)
+	if err != nil {
+		return ocispec.Descriptor{}, false, err
+	}
+	defer rc.Close()
+	b, err := io.ReadAll(rc)
+	if err != nil {
+		return ocispec.Descriptor{}, false, err
+	}
+	manifest := ocispec.Manifest{}
+	err = json.Unmarshal(b, &manifest)
+	if err != nil {
+		return ocispec.Descriptor{}, false, err
+	}
+	if manifest.Subject == nil || manifest.Subject.Digest != subject {
+		return ocispec.Descriptor{}, false, nil
+	}
+	// The config media type is the artifact type of manifests without one.
+	artifactType := manifest.ArtifactType
+	if artifactType == "" {
+		artifactType = manifest.Config.MediaType
+	}
+	desc := ocispec.Descriptor{
+		MediaType:    storeDesc.MediaType,
+		ArtifactType: artifactType,
+		Digest:       storeDesc.Digest,
+		Size:         storeDesc.Size,
+		Annotations:  manifest.Annotations,
+	}
+	return desc, true, nil
+}
+
+func (r *Registry) referrersHandler(ctx context.Context, dist oci.DistributionPath, rw httpx.ResponseWriter) {
+	rw.SetAttrs(HandlerAttrKey, "referrers")
+
+	b, err := r.referrers(ctx, dist)
+	if err != nil {
+		respErr := oci.NewDistributionError(oci.ErrCodeManifestUnknown, fmt.Sprintf("could not list referrers for %s", dist.Digest), nil)
+		rw.WriteError(http.StatusNotFound, errors.Join(respErr, err))
+		return
+	}
+
+	rw.Header().Set(httpx.HeaderContentType, ocispec.MediaTypeImageIndex)
+	rw.Header().Set(httpx.HeaderContentLength, strconv.Itoa(len(b)))
+	rw.Header().Set(oci.HeaderDockerDigest, digest.FromBytes(b).String())
+	rw.Header().Set(oci.HeaderNamespace, dist.Registry)
+	rw.WriteHeader(http.StatusOK)
+	if dist.Method == http.MethodHead {
+		return
+	}
+	_, err = rw.Write(b)
+	if err != nil {
+		logr.FromContextOrDiscard(ctx).Error(err, "error occurred when writing referrers")
+		return
+	}
+}
@@ -1,6 +1,7 @@
 package registry
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -9,12 +10,14 @@ import (
 	"net/netip"
 	"net/url"
 	"path"
+	"slices"
 	"strconv"
 	"sync"
 	"sync/atomic"
 	"time"
 
 	"github.com/go-logr/logr"
+	"github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 
 	"github.com/spegel-org/spegel/internal/option"
@@ -22,22 +25,42 @@ import (
 	"github.com/spegel-org/spegel/pkg/httpx"
 	"github.com/spegel-org/spegel/pkg/metrics"
 	"github.com/spegel-org/spegel/pkg/oci"
+	"github.com/spegel-org/spegel/pkg/oci/signature"
 	"github.com/spegel-org/spegel/pkg/routing"
 	"github.com/spegel-org/spegel/pkg/store"
 )
 
 const (
-	HeaderSpegelMirrored = "X-Spegel-Mirrored"
-	HandlerAttrKey       = "handler"
-	RegistryAttrKey      = "registry"
+	HeaderSpegelMirrored        = "X-Spegel-Mirrored"
+	HeaderSpegelProtocolVersion = "X-Spegel-Protocol-Version"
+	HandlerAttrKey              = "handler"
+	RegistryAttrKey             = "registry"
+)
+
+// StreamMode determines how libp2p streams are used when fetching content from peers.
+type StreamMode string
+
+const (
+	// StreamModeDisabled only fetches content over the peers registry port.
+	StreamModeDisabled StreamMode = "disabled"
+	// StreamModeFallback fetches content over streams when the peers registry port is unreachable.
+	StreamModeFallback StreamMode = "fallback"
+	// StreamModePrefer fetches content over streams first and falls back to the peers registry port.
+	StreamModePrefer StreamMode = "prefer"
 )
 
 type RegistryConfig struct {
-	OCIClient      *oci.Client
-	Userinfo       *url.Userinfo
-	Filters        []oci.Filter
-	ResolveTimeout time.Duration
-	ResolveRetries int
+	OCIClient        *oci.Client
+	Userinfo         *url.Userinfo
+	StreamTransport  http.RoundTripper
+	StreamMode       StreamMode
+	Filters          []oci.Filter
+	Aliases          oci.RegistryAliases
+	SignaturePolicy  *signature.Policy
+	ReadinessChecks  []store.ReadinessChecker
+	ResolveTimeout   time.Duration
+	ResolveRetries   int
+	RepositoryLookup bool
 }
 
 type RegistryOption = option.Option[RegistryConfig]
@@ -56,6 +79,22 @@ func WithRegistryFilters(filters []oci.Filter) RegistryOption {
 	}
 }
 
+// WithRegistryAliases serves content stored under any equivalent registry name.
+func WithRegistryAliases(aliases oci.RegistryAliases) RegistryOption {
+	return func(cfg *RegistryConfig) error {
+		cfg.Aliases = aliases
+		return nil
+	}
+}
+
+// WithSignaturePolicy refuses to serve tags which resolve to manifests not satisfying the policy.
+func WithSignaturePolicy(policy *signature.Policy) RegistryOption {
+	return func(cfg *RegistryConfig) error {
+		cfg.SignaturePolicy = policy
+		return nil
+	}
+}
+
 func WithResolveTimeout(resolveTimeout time.Duration) RegistryOption {
 	return func(cfg *RegistryConfig) error {
 		cfg.ResolveTimeout = resolveTimeout
@@ -77,6 +116,37 @@ func WithUserinfo(userinfo *url.Userinfo) RegistryOption {
 	}
 }
 
+// WithReadinessChecks reports the registry as not ready while any of the checks are not ready.
+func WithReadinessChecks(checks []store.ReadinessChecker) RegistryOption {
+	return func(cfg *RegistryConfig) error {
+		cfg.ReadinessChecks = checks
+		return nil
+	}
+}
+
+// WithRepositoryLookup resolves blobs through peers advertising the blobs repository instead of the blob digest.
+// It should be used when only images are advertised.
+func WithRepositoryLookup(repositoryLookup bool) RegistryOption {
+	return func(cfg *RegistryConfig) error {
+		cfg.RepositoryLookup = repositoryLookup
+		return nil
+	}
+}
+
+// WithStreamTransport sets the transport used to fetch content from peers over streams.
+func WithStreamTransport(transport http.RoundTripper, mode StreamMode) RegistryOption {
+	return func(cfg *RegistryConfig) error {
+		switch mode {
+		case StreamModeDisabled, StreamModeFallback, StreamModePrefer:
+		default:
+			return fmt.Errorf("unknown stream mode %s", mode)
+		}
+		cfg.StreamTransport = transport
+		cfg.StreamMode = mode
+		return nil
+	}
+}
+
 type Statistics struct {
 	MirrorLastSuccess atomic.Int64
 }
@@ -86,11 +156,17 @@ type Registry struct {
 	hedger         *resilient.Hedger
 	provider       store.Provider
 	ociClient      *oci.Client
+	streamClient   *oci.Client
 	router         routing.Router
 	userinfo       *url.Userinfo
+	streamMode     StreamMode
 	filters        []oci.Filter
+	aliases        oci.RegistryAliases
+	policy         *signature.Policy
+	readyChecks    []store.ReadinessChecker
 	resolveTimeout time.Duration
 	resolveRetries int
+	repoLookup     bool
 	stats          Statistics
 }
 
@@ -98,6 +174,7 @@ func NewRegistry(provider store.Provider, router routing.Router, opts ...Registr
 	cfg := RegistryConfig{
 		ResolveRetries: 3,
 		ResolveTimeout: 20 * time.Millisecond,
+		StreamMode:     StreamModeDisabled,
 	}
 	err := option.Apply(&cfg, opts...)
 	if err != nil {
@@ -110,6 +187,13 @@ func NewRegistry(provider store.Provider, router routing.Router, opts ...Registr
 		}
 		cfg.OCIClient = ociClient
 	}
+	var streamClient *oci.Client
+	if cfg.StreamTransport != nil && cfg.StreamMode != StreamModeDisabled {
+		streamClient, err = oci.NewClient(oci.WithTransport(cfg.StreamTransport))
+		if err != nil {
+			return nil, err
+		}
+	}
 
 	bufferPool := &sync.Pool{
 		New: func() any {
@@ -122,8 +206,14 @@ func NewRegistry(provider store.Provider, router routing.Router, opts ...Registr
 		provider:       provider,
 		router:         router,
 		ociClient:      cfg.OCIClient,
+		streamClient:   streamClient,
+		streamMode:     cfg.StreamMode,
 		resolveRetries: cfg.ResolveRetries,
+		repoLookup:     cfg.RepositoryLookup,
 		filters:        cfg.Filters,
+		aliases:        cfg.Aliases,
+		policy:         cfg.SignaturePolicy,
+		readyChecks:    cfg.ReadinessChecks,
 		resolveTimeout: cfg.ResolveTimeout,
 		userinfo:       cfg.Userinfo,
 		bufferPool:     bufferPool,
@@ -158,6 +248,17 @@ func (r *Registry) readyHandler(rw httpx.ResponseWriter, req *http.Request) {
 		rw.WriteHeader(http.StatusInternalServerError)
 		return
 	}
+	for _, check := range r.readyChecks {
+		ok, err := check.Ready(req.Context())
+		if err != nil {
+			rw.WriteError(http.StatusInternalServerError, fmt.Errorf("could not determine store readiness: %w", err))
+			return
+		}
+		if !ok {
+			rw.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+	}
 	rw.WriteHeader(http.StatusOK)
 }
 
@@ -199,13 +300,43 @@ func (r *Registry) registryHandler(rw httpx.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// Requests from peers have to use a compatible protocol version.
+	if req.Header.Get(HeaderSpegelMirrored) == "true" {
+		log := logr.FromContextOrDiscard(req.Context())
+		// The local version is returned so that peers can tell when they are rejected for their version.
+		rw.Header().Set(HeaderSpegelProtocolVersion, strconv.Itoa(routing.ProtocolVersion))
+		version, err := peerProtocolVersion(req.Header)
+		if err != nil {
+			log.Info("rejected peer request with invalid protocol version", "peer", req.RemoteAddr, "error", err.Error())
+			rw.WriteError(http.StatusBadRequest, err)
+			return
+		}
+		if !routing.IsCompatibleProtocolVersion(version) {
+			metrics.IncompatiblePeersTotal.WithLabelValues("http", strconv.Itoa(version)).Inc()
+			log.Info("rejected peer request with incompatible protocol version", "peer", req.RemoteAddr, "version", version, "localVersion", routing.ProtocolVersion)
+			rw.WriteError(http.StatusBadRequest, fmt.Errorf("peer protocol version %d is incompatible with local version %d", version, routing.ProtocolVersion))
+			return
+		}
+	}
+
 	// Request with mirror header are proxied.
 	if req.Header.Get(HeaderSpegelMirrored) != "true" {
 		// If content is present locally we should skip the mirroring and just serve it.
 		var ociErr error
-		if dist.Digest == "" {
-			_, ociErr = r.provider.Resolve(req.Context(), dist.Identifier())
-		} else {
+		switch {
+		case dist.Kind == oci.DistributionKindReferrers:
+			_, ociErr = r.referrers(req.Context(), dist)
+		case dist.Digest == "":
+			var dgst digest.Digest
+			dgst, ociErr = r.resolve(req.Context(), dist)
+			if ociErr == nil {
+				err := r.verifySignature(req.Context(), dist, dgst)
+				if err != nil {
+					rw.WriteError(http.StatusNotFound, err)
+					return
+				}
+			}
+		default:
 			_, ociErr = r.provider.Descriptor(req.Context(), dist.Digest)
 		}
 		if ociErr != nil {
@@ -222,6 +353,9 @@ func (r *Registry) registryHandler(rw httpx.ResponseWriter, req *http.Request) {
 	case oci.DistributionKindBlob:
 		r.blobHandler(req.Context(), dist, rw)
 		return
+	case oci.DistributionKindReferrers:
+		r.referrersHandler(req.Context(), dist, rw)
+		return
 	default:
 		// This should never happen as it would be caught when parsing the path.
 		rw.WriteError(http.StatusNotFound, fmt.Errorf("unknown distribution path kind %s", dist.Kind))
@@ -246,14 +380,18 @@ func (r *Registry) mirrorHandler(ctx context.Context, dist oci.DistributionPath,
 	}()
 
 	// Set max duration for non blob requests.
-	if dist.Method == http.MethodHead || dist.Kind == oci.DistributionKindManifest {
+	if dist.Method == http.MethodHead || dist.Kind != oci.DistributionKindBlob {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, 3*time.Second)
 		defer cancel()
 	}
 
 	// Lookup peers for the given key.
-	iter, err := r.router.Lookup(ctx, dist.Identifier(), r.resolveRetries)
+	key := r.aliases.Key(dist.Identifier())
+	if r.repoLookup && dist.Kind == oci.DistributionKindBlob {
+		key = r.aliases.Key(dist.Name())
+	}
+	iter, err := r.router.Lookup(ctx, key, r.resolveRetries)
 	if err != nil {
 		rw.WriteError(http.StatusInternalServerError, err)
 		return
@@ -269,11 +407,32 @@ func (r *Registry) mirrorHandler(ctx context.Context, dist oci.DistributionPath,
 			}
 			defer httpx.DrainAndClose(res.rc)
 
+			if oci.MatchesFilterDescriptor(dist.Reference, res.desc, r.filters) {
+				rw.WriteError(http.StatusNotFound, fmt.Errorf("request %s is filtered out by registry filters", dist.String()))
+				return true
+			}
+			if r.requiresSignature(dist) {
+				err := r.verifySignature(ctx, dist, res.desc.Digest)
+				if err != nil {
+					rw.WriteError(http.StatusNotFound, err)
+					return true
+				}
+				// The manifest is buffered to make sure the peer serves the signed content.
+				if dist.Method != http.MethodHead {
+					b, err := readVerified(res.rc, res.desc.Digest)
+					if err != nil {
+						rw.WriteError(http.StatusNotFound, err)
+						return true
+					}
+					res.rc = io.NopCloser(bytes.NewReader(b))
+				}
+			}
+
 			if !rw.HeadersWritten() {
 				oci.WriteDescriptorToHeader(res.desc, rw.Header())
 
 				switch dist.Kind {
-				case oci.DistributionKindManifest:
+				case oci.DistributionKindManifest, oci.DistributionKindReferrers:
 					rw.WriteHeader(http.StatusOK)
 				case oci.DistributionKindBlob:
 					rw.Header().Set(httpx.HeaderAcceptRanges, httpx.RangeUnit)
@@ -303,7 +462,7 @@ func (r *Registry) mirrorHandler(ctx context.Context, dist oci.DistributionPath,
 			n, err := io.CopyBuffer(rw, res.rc, *buf)
 			if err != nil {
 				switch dist.Kind {
-				case oci.DistributionKindManifest:
+				case oci.DistributionKindManifest, oci.DistributionKindReferrers:
 					log.Error(err, "copying of manifest data failed")
 					return true
 				case oci.DistributionKindBlob:
@@ -349,8 +508,9 @@ func (r *Registry) raceFetch(ctx context.Context, iterator *routing.Iterator, di
 		Attempts: 0,
 	}
 	errCode := map[oci.DistributionKind]oci.DistributionErrorCode{
-		oci.DistributionKindBlob:     oci.ErrCodeBlobUnknown,
-		oci.DistributionKindManifest: oci.ErrCodeManifestUnknown,
+		oci.DistributionKindBlob:      oci.ErrCodeBlobUnknown,
+		oci.DistributionKindManifest:  oci.ErrCodeManifestUnknown,
+		oci.DistributionKindReferrers: oci.ErrCodeManifestUnknown,
 	}[dist.Kind]
 
 	fetchCh, immediateCh := fetchChannel(ctx, r.hedger, iterator)
@@ -384,10 +544,14 @@ func (r *Registry) raceFetch(ctx context.Context, iterator *routing.Iterator, di
 			return fetchResponse{}, ctx.Err()
 		case <-exhaustedCh:
 			if errDetails.Attempts == 0 {
+				metrics.MirrorMissingTotal.WithLabelValues(dist.Registry, dist.Repository).Inc()
 				return fetchResponse{}, oci.NewDistributionError(errCode, fmt.Sprintf("could not find peer for %s", dist.Identifier()), errDetails)
 			}
 			return fetchResponse{}, oci.NewDistributionError(errCode, fmt.Sprintf("all request retries exhausted for %s", dist.Identifier()), errDetails)
 		case <-idleTimeoutCh:
+			if errDetails.Attempts == 0 {
+				metrics.MirrorMissingTotal.WithLabelValues(dist.Registry, dist.Repository).Inc()
+			}
 			return fetchResponse{}, oci.NewDistributionError(errCode, fmt.Sprintf("waited too long for new peer with no inflight fetches for %s", dist.Identifier()), errDetails)
 		case <-raceTimeoutCh:
 			return fetchResponse{}, oci.NewDistributionError(errCode, fmt.Sprintf("waited too long for inflight dials to complete for %s", dist.Identifier()), errDetails)
@@ -406,27 +570,7 @@ func (r *Registry) raceFetch(ctx context.Context, iterator *routing.Iterator, di
 
 			go func() {
 				start := time.Now()
-				res, err := httpx.HappyEyeballs(fetchCtx, peer.Addresses, func(ctx context.Context, ipAddr netip.Addr) (fetchResponse, error) {
-					mirror := &url.URL{
-						Scheme: dist.Scheme,
-						Host:   netip.AddrPortFrom(ipAddr, peer.Metadata.RegistryPort).String(),
-					}
-					fetchOpts := []oci.FetchOption{
-						oci.WithFetchHeader(HeaderSpegelMirrored, "true"),
-						oci.WithFetchMirror(mirror),
-						oci.WithFetchUserinfo(r.userinfo),
-					}
-					rc, desc, err := r.ociClient.Fetch(ctx, dist, fetchOpts...)
-					if err != nil {
-						return fetchResponse{}, err
-					}
-					res := fetchResponse{
-						peer: peer,
-						desc: desc,
-						rc:   rc,
-					}
-					return res, nil
-				})
+				res, err := r.fetchPeer(fetchCtx, peer, dist)
 				if err != nil {
 					if fetchCtx.Err() != nil {
 						iterator.Release(peer)
@@ -477,11 +621,85 @@ func (r *Registry) raceFetch(ctx context.Context, iterator *routing.Iterator, di
 	}
 }
 
+// fetchPeer fetches the content from the peer using the configured transports in order of preference.
+func (r *Registry) fetchPeer(ctx context.Context, peer routing.Peer, dist oci.DistributionPath) (fetchResponse, error) {
+	fetchOpts := []oci.FetchOption{
+		oci.WithFetchHeader(HeaderSpegelMirrored, "true"),
+		oci.WithFetchHeader(HeaderSpegelProtocolVersion, strconv.Itoa(routing.ProtocolVersion)),
+		oci.WithFetchUserinfo(r.userinfo),
+	}
+	fetchAddr := func(ctx context.Context) (fetchResponse, error) {
+		return httpx.HappyEyeballs(ctx, peer.Addresses, func(ctx context.Context, ipAddr netip.Addr) (fetchResponse, error) {
+			mirror := &url.URL{
+				Scheme: dist.Scheme,
+				Host:   netip.AddrPortFrom(ipAddr, peer.Metadata.RegistryPort).String(),
+			}
+			rc, desc, err := r.ociClient.Fetch(ctx, dist, append(fetchOpts, oci.WithFetchMirror(mirror))...)
+			if err != nil {
+				return fetchResponse{}, err
+			}
+			return fetchResponse{peer: peer, desc: desc, rc: rc}, nil
+		})
+	}
+	if r.streamClient == nil {
+		return fetchAddr(ctx)
+	}
+
+	fetchStream := func(ctx context.Context) (fetchResponse, error) {
+		mirror := &url.URL{
+			Scheme: "http",
+			Host:   peer.Host,
+		}
+		rc, desc, err := r.streamClient.Fetch(ctx, dist, append(fetchOpts, oci.WithFetchMirror(mirror))...)
+		if err != nil {
+			return fetchResponse{}, err
+		}
+		return fetchResponse{peer: peer, desc: desc, rc: rc}, nil
+	}
+	fetchFns := []func(context.Context) (fetchResponse, error){fetchAddr, fetchStream}
+	if r.streamMode == StreamModePrefer {
+		slices.Reverse(fetchFns)
+	}
+	errs := []error{}
+	for _, fetchFn := range fetchFns {
+		res, err := fetchFn(ctx)
+		if err == nil {
+			return res, nil
+		}
+		if version, ok := rejectedProtocolVersion(err); ok {
+			metrics.IncompatiblePeersTotal.WithLabelValues("http", strconv.Itoa(version)).Inc()
+			logr.FromContextOrDiscard(ctx).Info("peer rejected request with incompatible protocol version", "peer", peer.Host, "version", version, "localVersion", routing.ProtocolVersion)
+		}
+		errs = append(errs, err)
+		// Only fall back when the peer could not be reached, a status response means the peer was reached.
+		//nolint:errcheck // We are only interested in the error type not the error content.
+		if _, ok := errors.AsType[*httpx.StatusError](err); ok || ctx.Err() != nil {
+			break
+		}
+	}
+	return fetchResponse{}, errors.Join(errs...)
+}
+
+// resolve resolves the tag locally under all equivalent registry names.
+func (r *Registry) resolve(ctx context.Context, dist oci.DistributionPath) (digest.Digest, error) {
+	errs := []error{}
+	for _, registry := range r.aliases.Equivalent(dist.Registry) {
+		ref := dist.Reference
+		ref.Registry = registry
+		dgst, err := r.provider.Resolve(ctx, ref.Identifier())
+		if err == nil {
+			return dgst, nil
+		}
+		errs = append(errs, err)
+	}
+	return "", errors.Join(errs...)
+}
+
 func (r *Registry) manifestHandler(ctx context.Context, dist oci.DistributionPath, rw httpx.ResponseWriter) {
 	rw.SetAttrs(HandlerAttrKey, "manifest")
 
 	if dist.Digest == "" {
-		dgst, err := r.provider.Resolve(ctx, dist.Identifier())
+		dgst, err := r.resolve(ctx, dist)
 		if err != nil {
 			respErr := oci.NewDistributionError(oci.ErrCodeManifestUnknown, fmt.Sprintf("could not get digest for image tag %s", dist.Identifier()), nil)
 			rw.WriteError(http.StatusNotFound, errors.Join(respErr, err))
@@ -500,6 +718,10 @@ func (r *Registry) manifestHandler(ctx context.Context, dist oci.DistributionPat
 		rw.WriteError(http.StatusNotFound, errors.Join(respErr, err))
 		return
 	}
+	if oci.MatchesFilterDescriptor(dist.Reference, ocispec.Descriptor{MediaType: desc.MediaType, Digest: desc.Digest, Size: desc.Size}, r.filters) {
+		rw.WriteError(http.StatusNotFound, fmt.Errorf("manifest %s is filtered out by registry filters", dist.Digest))
+		return
+	}
 
 	rw.Header().Set(httpx.HeaderContentType, desc.MediaType)
 	rw.Header().Set(httpx.HeaderContentLength, strconv.FormatInt(desc.Size, 10))
@@ -539,6 +761,10 @@ func (r *Registry) blobHandler(ctx context.Context, dist oci.DistributionPath, r
 		rw.WriteError(http.StatusNotFound, errors.Join(respErr, err))
 		return
 	}
+	if oci.MatchesFilterDescriptor(dist.Reference, ocispec.Descriptor{MediaType: desc.MediaType, Digest: desc.Digest, Size: desc.Size}, r.filters) {
+		rw.WriteError(http.StatusNotFound, fmt.Errorf("blob %s is filtered out by registry filters", dist.Digest))
+		return
+	}
 
 	crng, err := func() (*httpx.ContentRange, error) {
 		if dist.Range == nil {
@@ -640,3 +866,30 @@ func fetchChannel(ctx context.Context, hedger *resilient.Hedger, iterator *routi
 	}()
 	return fetchCh, immediateCh
 }
+
+// rejectedProtocolVersion returns the version of the peer if it rejected the request because of the protocol version.
+func rejectedProtocolVersion(err error) (int, bool) {
+	statusErr, ok := errors.AsType[*httpx.StatusError](err)
+	if !ok || statusErr.StatusCode != http.StatusBadRequest || statusErr.Header.Get(HeaderSpegelProtocolVersion) == "" {
+		return 0, false
+	}
+	version, err := peerProtocolVersion(statusErr.Header)
+	if err != nil || routing.IsCompatibleProtocolVersion(version) {
+		return 0, false
+	}
+	return version, true
+}
+
+// peerProtocolVersion returns the protocol version announced in the peer request headers.
+// Peers which do not announce a version are assumed to run the legacy version.
+func peerProtocolVersion(header http.Header) (int, error) {
+	v := header.Get(HeaderSpegelProtocolVersion)
+	if v == "" {
+		return routing.LegacyProtocolVersion, nil
+	}
+	version, err := strconv.Atoi(v)
+	if err != nil {
+		return 0, fmt.Errorf("invalid protocol version %s: %w", v, err)
+	}
+	return version, nil
+}
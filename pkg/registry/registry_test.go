@@ -2,6 +2,15 @@ package registry
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
 	"io"
 	"net"
@@ -10,6 +19,7 @@ import (
 	"net/netip"
 	"net/url"
 	"regexp"
+	"strconv"
 	"testing"
 	"testing/synctest"
 	"time"
@@ -17,6 +27,7 @@ import (
 	"github.com/go-logr/logr"
 	"github.com/go-openapi/testify/v2/require"
 	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"go.uber.org/goleak"
 
 	"github.com/spegel-org/spegel/internal/option"
@@ -24,7 +35,9 @@ import (
 	"github.com/spegel-org/spegel/internal/testutil"
 	"github.com/spegel-org/spegel/pkg/httpx"
 	"github.com/spegel-org/spegel/pkg/oci"
+	"github.com/spegel-org/spegel/pkg/oci/signature"
 	"github.com/spegel-org/spegel/pkg/routing"
+	"github.com/spegel-org/spegel/pkg/store"
 	"github.com/spegel-org/spegel/pkg/store/storetest"
 )
 
@@ -48,6 +61,10 @@ func TestRegistryOptions(t *testing.T) {
 		WithResolveTimeout(10 * time.Minute),
 		WithUserinfo(url.UserPassword("foo", "bar")),
 		WithOCIClient(ociClient),
+		WithStreamTransport(http.DefaultTransport, StreamModePrefer),
+		WithRepositoryLookup(true),
+		WithSignaturePolicy(&signature.Policy{}),
+		WithRegistryAliases(oci.RegistryAliases{"mirror.gcr.io": "docker.io"}),
 	}
 	cfg := RegistryConfig{}
 	err = option.Apply(&cfg, opts...)
@@ -57,6 +74,14 @@ func TestRegistryOptions(t *testing.T) {
 	require.EqualT(t, 10*time.Minute, cfg.ResolveTimeout)
 	require.Equal(t, ociClient, cfg.OCIClient)
 	require.EqualT(t, "foo:bar", cfg.Userinfo.String())
+	require.Equal(t, http.DefaultTransport, cfg.StreamTransport)
+	require.EqualT(t, StreamModePrefer, cfg.StreamMode)
+	require.TrueT(t, cfg.RepositoryLookup)
+	require.NotNil(t, cfg.SignaturePolicy)
+	require.EqualT(t, "docker.io", cfg.Aliases.Canonical("mirror.gcr.io"))
+
+	err = option.Apply(&cfg, WithStreamTransport(nil, "foo"))
+	require.EqualError(t, err, "unknown stream mode foo")
 }
 
 func TestProbeHandlers(t *testing.T) {
@@ -94,6 +119,35 @@ func TestProbeHandlers(t *testing.T) {
 	require.EqualT(t, http.StatusOK, rw.Result().StatusCode)
 }
 
+type testReadinessChecker struct {
+	ready bool
+}
+
+func (c *testReadinessChecker) Ready(ctx context.Context) (bool, error) {
+	return c.ready, nil
+}
+
+func TestReadinessChecks(t *testing.T) {
+	t.Parallel()
+
+	router := routing.NewMemoryRouter(map[string][]routing.Peer{}, routing.Peer{})
+	checker := &testReadinessChecker{ready: false}
+	reg, err := NewRegistry(nil, router, WithReadinessChecks([]store.ReadinessChecker{checker}))
+	require.NoError(t, err)
+	handler := reg.Handler(logr.Discard())
+
+	rw := httptest.NewRecorder()
+	req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "http://localhost/readyz", nil)
+	handler.ServeHTTP(rw, req)
+	require.EqualT(t, http.StatusInternalServerError, rw.Result().StatusCode)
+
+	checker.ready = true
+	rw = httptest.NewRecorder()
+	req = httptest.NewRequestWithContext(t.Context(), http.MethodGet, "http://localhost/readyz", nil)
+	handler.ServeHTTP(rw, req)
+	require.EqualT(t, http.StatusOK, rw.Result().StatusCode)
+}
+
 func TestBasicAuth(t *testing.T) {
 	t.Parallel()
 
@@ -515,6 +569,316 @@ func TestRegistryHandler(t *testing.T) {
 	}
 }
 
+func TestStreamTransport(t *testing.T) {
+	t.Parallel()
+
+	contents := []storetest.Content{
+		{MediaType: "dummy", Data: []byte("served over stream")},
+	}
+	peerReg, err := NewRegistry(storetest.NewProvider(contents, nil), routing.NewMemoryRouter(map[string][]routing.Peer{}, routing.Peer{}))
+	require.NoError(t, err)
+	peerSvr := httptest.NewServer(peerReg.Handler(logr.Discard()))
+	t.Cleanup(func() {
+		peerSvr.Close()
+	})
+
+	// Peer registry port is unreachable so content can only be fetched over the stream transport.
+	streamPeer := routing.Peer{
+		Host:      "stream",
+		Addresses: []netip.Addr{netip.MustParseAddr("127.0.0.1")},
+		Metadata: routing.PeerMetadata{
+			RegistryPort: 0,
+		},
+	}
+	resolver := map[string][]routing.Peer{
+		contents[0].Digest().String(): {streamPeer},
+	}
+	streamTransport := roundTripperFunc(func(req *http.Request) (*http.Response, error) {
+		if req.URL.Host != streamPeer.Host {
+			return nil, fmt.Errorf("unexpected stream host %s", req.URL.Host)
+		}
+		req = req.Clone(req.Context())
+		req.URL.Host = peerSvr.Listener.Addr().String()
+		return http.DefaultTransport.RoundTrip(req)
+	})
+
+	tests := []struct {
+		name           string
+		mode           StreamMode
+		expectedStatus int
+	}{
+		{
+			name:           "disabled",
+			mode:           StreamModeDisabled,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "fallback",
+			mode:           StreamModeFallback,
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "prefer",
+			mode:           StreamModePrefer,
+			expectedStatus: http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			router := routing.NewMemoryRouter(resolver, routing.Peer{})
+			reg, err := NewRegistry(storetest.NewProvider(nil, nil), router, WithStreamTransport(streamTransport, tt.mode))
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+			defer cancel()
+			target := fmt.Sprintf("http://example.com/v2/foo/bar/blobs/%s?ns=docker.io", contents[0].Digest())
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+			reg.Handler(logr.Discard()).ServeHTTP(rw, req)
+
+			resp := rw.Result()
+			defer httpx.DrainAndClose(resp.Body)
+			require.EqualT(t, tt.expectedStatus, resp.StatusCode)
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			b, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.SliceEqualT(t, contents[0].Data, b)
+		})
+	}
+}
+
+func TestRepositoryLookup(t *testing.T) {
+	t.Parallel()
+
+	contents := []storetest.Content{
+		{MediaType: "dummy", Data: []byte("resolved by repository")},
+	}
+	peerReg, err := NewRegistry(storetest.NewProvider(contents, nil), routing.NewMemoryRouter(map[string][]routing.Peer{}, routing.Peer{}))
+	require.NoError(t, err)
+	peerSvr := httptest.NewServer(peerReg.Handler(logr.Discard()))
+	t.Cleanup(func() {
+		peerSvr.Close()
+	})
+	addrPort := netip.MustParseAddrPort(peerSvr.Listener.Addr().String())
+	peer := routing.Peer{
+		Host:      "peer",
+		Addresses: []netip.Addr{addrPort.Addr()},
+		Metadata: routing.PeerMetadata{
+			RegistryPort: addrPort.Port(),
+		},
+	}
+	// Only the repository is advertised, the blob digest is not.
+	resolver := map[string][]routing.Peer{
+		"docker.io/foo/bar": {peer},
+	}
+
+	tests := []struct {
+		name             string
+		repositoryLookup bool
+		expectedStatus   int
+	}{
+		{
+			name:             "digest lookup",
+			repositoryLookup: false,
+			expectedStatus:   http.StatusNotFound,
+		},
+		{
+			name:             "repository lookup",
+			repositoryLookup: true,
+			expectedStatus:   http.StatusOK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			router := routing.NewMemoryRouter(resolver, routing.Peer{})
+			reg, err := NewRegistry(storetest.NewProvider(nil, nil), router, WithRepositoryLookup(tt.repositoryLookup))
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+			defer cancel()
+			target := fmt.Sprintf("http://example.com/v2/foo/bar/blobs/%s?ns=docker.io", contents[0].Digest())
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+			reg.Handler(logr.Discard()).ServeHTTP(rw, req)
+
+			resp := rw.Result()
+			defer httpx.DrainAndClose(resp.Body)
+			require.EqualT(t, tt.expectedStatus, resp.StatusCode)
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+			b, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.SliceEqualT(t, contents[0].Data, b)
+		})
+	}
+}
+
+func TestRegistryAliases(t *testing.T) {
+	t.Parallel()
+
+	aliases, err := oci.ParseRegistryAliases([]string{"mirror.gcr.io=docker.io", "index.docker.io=docker.io"})
+	require.NoError(t, err)
+	contents := []storetest.Content{
+		{MediaType: ocispec.MediaTypeImageManifest, Data: []byte(`{"mediaType": "application/vnd.oci.image.manifest.v1+json"}`)},
+	}
+	refs := map[string]digest.Digest{
+		"mirror.gcr.io/library/foo:1.0": contents[0].Digest(),
+	}
+	peerReg, err := NewRegistry(storetest.NewProvider(contents, refs), routing.NewMemoryRouter(map[string][]routing.Peer{}, routing.Peer{}), WithRegistryAliases(aliases))
+	require.NoError(t, err)
+	peerSvr := httptest.NewServer(peerReg.Handler(logr.Discard()))
+	t.Cleanup(func() {
+		peerSvr.Close()
+	})
+	addrPort := netip.MustParseAddrPort(peerSvr.Listener.Addr().String())
+	peer := routing.Peer{
+		Host:      "peer",
+		Addresses: []netip.Addr{addrPort.Addr()},
+		Metadata: routing.PeerMetadata{
+			RegistryPort: addrPort.Port(),
+		},
+	}
+	// Tags are advertised under the canonical registry.
+	resolver := map[string][]routing.Peer{
+		"docker.io/library/foo:1.0": {peer},
+	}
+
+	tests := []struct {
+		name     string
+		provider store.Provider
+		registry string
+		aliases  oci.RegistryAliases
+		expected int
+	}{
+		{
+			name:     "local tag under alias",
+			provider: storetest.NewProvider(contents, refs),
+			registry: "docker.io",
+			aliases:  aliases,
+			expected: http.StatusOK,
+		},
+		{
+			name:     "mirrored tag under other alias",
+			provider: storetest.NewProvider(nil, nil),
+			registry: "index.docker.io",
+			aliases:  aliases,
+			expected: http.StatusOK,
+		},
+		{
+			name:     "without aliases",
+			provider: storetest.NewProvider(contents, refs),
+			registry: "index.docker.io",
+			expected: http.StatusNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			router := routing.NewMemoryRouter(resolver, routing.Peer{})
+			reg, err := NewRegistry(tt.provider, router, WithRegistryAliases(tt.aliases))
+			require.NoError(t, err)
+
+			ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+			defer cancel()
+			target := "http://example.com/v2/library/foo/manifests/1.0?ns=" + tt.registry
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequestWithContext(ctx, http.MethodGet, target, nil)
+			reg.Handler(logr.Discard()).ServeHTTP(rw, req)
+
+			resp := rw.Result()
+			defer httpx.DrainAndClose(resp.Body)
+			require.EqualT(t, tt.expected, resp.StatusCode)
+			if tt.expected != http.StatusOK {
+				return
+			}
+			b, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.SliceEqualT(t, contents[0].Data, b)
+		})
+	}
+}
+
+func TestRegistryWithoutNamespaceParameter(t *testing.T) {
+	t.Parallel()
+
+	contents := []storetest.Content{
+		{MediaType: ocispec.MediaTypeImageManifest, Data: []byte(`{"mediaType": "application/vnd.oci.image.manifest.v1+json"}`)},
+		{MediaType: ocispec.MediaTypeImageManifest, Data: []byte(`{"mediaType": "application/vnd.oci.image.manifest.v1+json", "annotations": {"foo": "bar"}}`)},
+		{MediaType: ocispec.MediaTypeImageLayerGzip, Data: []byte("layer")},
+	}
+	refs := map[string]digest.Digest{
+		"docker.io/library/foo:1.0": contents[0].Digest(),
+		"ghcr.io/org/bar:1.0":       contents[1].Digest(),
+	}
+	filter, err := oci.FilterForMirroredRegistries([]string{"https://docker.io", "https://ghcr.io"})
+	require.NoError(t, err)
+	reg, err := NewRegistry(storetest.NewProvider(contents, refs), routing.NewMemoryRouter(map[string][]routing.Peer{}, routing.Peer{}), WithRegistryFilters([]oci.Filter{filter}))
+	require.NoError(t, err)
+
+	// Docker requests Docker Hub content without registry, CRI-O prefixes the repository with the registry.
+	tests := []struct {
+		name     string
+		path     string
+		expected []byte
+	}{
+		{
+			name:     "docker tag",
+			path:     "/v2/library/foo/manifests/1.0",
+			expected: contents[0].Data,
+		},
+		{
+			name:     "docker blob",
+			path:     "/v2/library/foo/blobs/" + contents[2].Digest().String(),
+			expected: contents[2].Data,
+		},
+		{
+			name:     "crio tag",
+			path:     "/v2/ghcr.io/org/bar/manifests/1.0",
+			expected: contents[1].Data,
+		},
+		{
+			name:     "crio digest",
+			path:     "/v2/ghcr.io/org/bar/manifests/" + contents[1].Digest().String(),
+			expected: contents[1].Data,
+		},
+		{
+			name:     "crio blob",
+			path:     "/v2/ghcr.io/org/bar/blobs/" + contents[2].Digest().String(),
+			expected: contents[2].Data,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, "http://example.com"+tt.path, nil)
+			reg.Handler(logr.Discard()).ServeHTTP(rw, req)
+
+			resp := rw.Result()
+			defer httpx.DrainAndClose(resp.Body)
+			require.EqualT(t, http.StatusOK, resp.StatusCode)
+			b, err := io.ReadAll(resp.Body)
+			require.NoError(t, err)
+			require.SliceEqualT(t, tt.expected, b)
+		})
+	}
+}
+
+type roundTripperFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripperFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
+
 type flakyStore struct {
 	*storetest.Provider
 }
@@ -615,3 +979,299 @@ func TestFetchChannel(t *testing.T) {
 		testutil.RequireChannelOpen(t, fetchCh)
 	})
 }
+
+func TestPeerProtocolVersion(t *testing.T) {
+	t.Parallel()
+
+	contents := []storetest.Content{
+		{MediaType: "dummy", Data: []byte("versioned content")},
+	}
+	reg, err := NewRegistry(storetest.NewProvider(contents, nil), routing.NewMemoryRouter(map[string][]routing.Peer{}, routing.Peer{}))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		version        string
+		expectedStatus int
+	}{
+		{
+			name:           "legacy version",
+			version:        "",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "current version",
+			version:        strconv.Itoa(routing.ProtocolVersion),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "next version",
+			version:        strconv.Itoa(routing.ProtocolVersion + 1),
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "incompatible version",
+			version:        strconv.Itoa(routing.ProtocolVersion + 2),
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid version",
+			version:        "foo",
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			target := fmt.Sprintf("http://example.com/v2/foo/bar/blobs/%s?ns=docker.io", contents[0].Digest())
+			rw := httptest.NewRecorder()
+			req := httptest.NewRequestWithContext(t.Context(), http.MethodGet, target, nil)
+			req.Header.Set(HeaderSpegelMirrored, "true")
+			if tt.version != "" {
+				req.Header.Set(HeaderSpegelProtocolVersion, tt.version)
+			}
+			reg.Handler(logr.Discard()).ServeHTTP(rw, req)
+
+			resp := rw.Result()
+			defer httpx.DrainAndClose(resp.Body)
+			require.EqualT(t, tt.expectedStatus, resp.StatusCode)
+			require.EqualT(t, strconv.Itoa(routing.ProtocolVersion), resp.Header.Get(HeaderSpegelProtocolVersion))
+		})
+	}
+}
+
+func TestRejectedProtocolVersion(t *testing.T) {
+	t.Parallel()
+
+	header := http.Header{}
+	header.Set(HeaderSpegelProtocolVersion, strconv.Itoa(routing.ProtocolVersion+2))
+	version, ok := rejectedProtocolVersion(errors.Join(errors.New("wrapped"), &httpx.StatusError{StatusCode: http.StatusBadRequest, Header: header}))
+	require.TrueT(t, ok)
+	require.EqualT(t, routing.ProtocolVersion+2, version)
+
+	// Bad requests from compatible peers or without a version are not rejections for the version.
+	_, ok = rejectedProtocolVersion(&httpx.StatusError{StatusCode: http.StatusBadRequest, Header: http.Header{}})
+	require.FalseT(t, ok)
+	header.Set(HeaderSpegelProtocolVersion, strconv.Itoa(routing.ProtocolVersion))
+	_, ok = rejectedProtocolVersion(&httpx.StatusError{StatusCode: http.StatusBadRequest, Header: header})
+	require.FalseT(t, ok)
+	_, ok = rejectedProtocolVersion(errors.New("connection refused"))
+	require.FalseT(t, ok)
+}
+
+func TestSignaturePolicy(t *testing.T) {
+	t.Parallel()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	pubDER, err := x509.MarshalPKIXPublicKey(key.Public())
+	require.NoError(t, err)
+	policy, err := signature.NewPolicy(signature.PolicyConfig{
+		Rules: []signature.RuleConfig{
+			{
+				Repository: `^docker\.io/foo/`,
+				PublicKeys: []string{string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: pubDER}))},
+			},
+		},
+	})
+	require.NoError(t, err)
+
+	signed := storetest.Content{MediaType: ocispec.MediaTypeImageManifest, Data: []byte(`{"signed":true}`)}
+	unsigned := storetest.Content{MediaType: ocispec.MediaTypeImageManifest, Data: []byte(`{"signed":false}`)}
+	payload := storetest.Content{
+		MediaType: "application/vnd.dev.cosign.simplesigning.v1+json",
+		Data:      fmt.Appendf(nil, `{"critical":{"identity":{"docker-reference":"docker.io/foo/bar"},"image":{"docker-manifest-digest":"%s"},"type":"cosign container image signature"},"optional":null}`, signed.Digest()),
+	}
+	payloadHash := sha256.Sum256(payload.Data)
+	sig, err := ecdsa.SignASN1(rand.Reader, key, payloadHash[:])
+	require.NoError(t, err)
+	sigManifest := ocispec.Manifest{
+		MediaType: ocispec.MediaTypeImageManifest,
+		Layers: []ocispec.Descriptor{
+			{
+				MediaType:   payload.MediaType,
+				Digest:      payload.Digest(),
+				Size:        payload.Size(),
+				Annotations: map[string]string{signature.AnnotationSignature: base64.StdEncoding.EncodeToString(sig)},
+			},
+		},
+	}
+	sigManifestB, err := json.Marshal(sigManifest)
+	require.NoError(t, err)
+	sigContent := storetest.Content{MediaType: ocispec.MediaTypeImageManifest, Data: sigManifestB}
+	sigTag := "sha256-" + signed.Digest().Encoded() + ".sig"
+
+	// Signatures referring to the manifest are found through the referrers API.
+	referred := storetest.Content{MediaType: ocispec.MediaTypeImageManifest, Data: []byte(`{"referred":true}`)}
+	referredPayload := storetest.Content{
+		MediaType: "application/vnd.dev.cosign.simplesigning.v1+json",
+		Data:      fmt.Appendf(nil, `{"critical":{"identity":{"docker-reference":"docker.io/foo/bar"},"image":{"docker-manifest-digest":"%s"},"type":"cosign container image signature"},"optional":null}`, referred.Digest()),
+	}
+	referredPayloadHash := sha256.Sum256(referredPayload.Data)
+	referredSig, err := ecdsa.SignASN1(rand.Reader, key, referredPayloadHash[:])
+	require.NoError(t, err)
+	referrerManifest := ocispec.Manifest{
+		MediaType:    ocispec.MediaTypeImageManifest,
+		ArtifactType: signature.ArtifactTypeSignature,
+		Config:       ocispec.DescriptorEmptyJSON,
+		Layers: []ocispec.Descriptor{
+			{
+				MediaType:   referredPayload.MediaType,
+				Digest:      referredPayload.Digest(),
+				Size:        referredPayload.Size(),
+				Annotations: map[string]string{signature.AnnotationSignature: base64.StdEncoding.EncodeToString(referredSig)},
+			},
+		},
+		Subject: &ocispec.Descriptor{MediaType: referred.MediaType, Digest: referred.Digest(), Size: referred.Size()},
+	}
+	referrerManifestB, err := json.Marshal(referrerManifest)
+	require.NoError(t, err)
+	referrerContent := storetest.Content{MediaType: ocispec.MediaTypeImageManifest, Data: referrerManifestB}
+	referrersIdx := ocispec.Index{
+		MediaType: ocispec.MediaTypeImageIndex,
+		Manifests: []ocispec.Descriptor{
+			{
+				MediaType:    referrerContent.MediaType,
+				ArtifactType: signature.ArtifactTypeSignature,
+				Digest:       referrerContent.Digest(),
+				Size:         referrerContent.Size(),
+			},
+		},
+	}
+	referrersIdx.SchemaVersion = 2
+	referrersIdxB, err := json.Marshal(referrersIdx)
+	require.NoError(t, err)
+
+	contents := []storetest.Content{signed, unsigned, payload, sigContent, referred, referredPayload, referrerContent}
+	refs := map[string]digest.Digest{
+		"docker.io/foo/bar:signed":    signed.Digest(),
+		"docker.io/foo/bar:unsigned":  unsigned.Digest(),
+		"docker.io/foo/bar:" + sigTag: sigContent.Digest(),
+		"docker.io/baz/qux:unsigned":  unsigned.Digest(),
+		"docker.io/foo/bar:referred":  referred.Digest(),
+	}
+	imgs := []oci.Image{}
+	for _, dgst := range []digest.Digest{signed.Digest(), unsigned.Digest(), sigContent.Digest(), referred.Digest(), referrerContent.Digest()} {
+		img, err := oci.NewImage("docker.io", "foo/bar", "", dgst)
+		require.NoError(t, err)
+		imgs = append(imgs, img)
+	}
+
+	peerReg, err := NewRegistry(&listingProvider{Provider: storetest.NewProvider(contents, refs), imgs: imgs}, routing.NewMemoryRouter(map[string][]routing.Peer{}, routing.Peer{}))
+	require.NoError(t, err)
+	peerSvr := httptest.NewServer(peerReg.Handler(logr.Discard()))
+	t.Cleanup(func() {
+		peerSvr.Close()
+	})
+	addrPort := netip.MustParseAddrPort(peerSvr.Listener.Addr().String())
+	peer := routing.Peer{
+		Host:      "peer",
+		Addresses: []netip.Addr{addrPort.Addr()},
+		Metadata: routing.PeerMetadata{
+			RegistryPort: addrPort.Port(),
+		},
+	}
+	resolver := map[string][]routing.Peer{}
+	for ref := range refs {
+		resolver[ref] = []routing.Peer{peer}
+	}
+	for _, content := range contents {
+		resolver[content.Digest().String()] = []routing.Peer{peer}
+	}
+
+	localReg, err := NewRegistry(&listingProvider{Provider: storetest.NewProvider(contents, refs), imgs: imgs}, routing.NewMemoryRouter(map[string][]routing.Peer{}, routing.Peer{}), WithSignaturePolicy(policy))
+	require.NoError(t, err)
+	mirrorReg, err := NewRegistry(storetest.NewProvider(nil, nil), routing.NewMemoryRouter(resolver, routing.Peer{}), WithSignaturePolicy(policy))
+	require.NoError(t, err)
+
+	tests := []struct {
+		name           string
+		path           string
+		expectedStatus int
+		expectedBody   []byte
+	}{
+		{
+			name:           "signed tag",
+			path:           "/v2/foo/bar/manifests/signed?ns=docker.io",
+			expectedStatus: http.StatusOK,
+			expectedBody:   signed.Data,
+		},
+		{
+			name:           "unsigned tag",
+			path:           "/v2/foo/bar/manifests/unsigned?ns=docker.io",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "unsigned digest",
+			path:           "/v2/foo/bar/manifests/" + unsigned.Digest().String() + "?ns=docker.io",
+			expectedStatus: http.StatusOK,
+			expectedBody:   unsigned.Data,
+		},
+		{
+			name:           "signature tag",
+			path:           "/v2/foo/bar/manifests/" + sigTag + "?ns=docker.io",
+			expectedStatus: http.StatusOK,
+			expectedBody:   sigContent.Data,
+		},
+		{
+			name:           "tag signed by referrer",
+			path:           "/v2/foo/bar/manifests/referred?ns=docker.io",
+			expectedStatus: http.StatusOK,
+			expectedBody:   referred.Data,
+		},
+		{
+			name:           "referrers",
+			path:           "/v2/foo/bar/referrers/" + referred.Digest().String() + "?ns=docker.io",
+			expectedStatus: http.StatusOK,
+			expectedBody:   referrersIdxB,
+		},
+		{
+			name:           "referrers without referrers",
+			path:           "/v2/foo/bar/referrers/" + unsigned.Digest().String() + "?ns=docker.io",
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "unsigned tag without rule",
+			path:           "/v2/baz/qux/manifests/unsigned?ns=docker.io",
+			expectedStatus: http.StatusOK,
+			expectedBody:   unsigned.Data,
+		},
+	}
+	for _, reg := range []struct {
+		name string
+		reg  *Registry
+	}{{name: "local", reg: localReg}, {name: "mirror", reg: mirrorReg}} {
+		for _, tt := range tests {
+			t.Run(reg.name+" "+tt.name, func(t *testing.T) {
+				t.Parallel()
+
+				ctx, cancel := context.WithTimeout(t.Context(), 5*time.Second)
+				defer cancel()
+				rw := httptest.NewRecorder()
+				req := httptest.NewRequestWithContext(ctx, http.MethodGet, "http://example.com"+tt.path, nil)
+				reg.reg.Handler(logr.Discard()).ServeHTTP(rw, req)
+
+				resp := rw.Result()
+				defer httpx.DrainAndClose(resp.Body)
+				require.EqualT(t, tt.expectedStatus, resp.StatusCode)
+				if tt.expectedStatus != http.StatusOK {
+					return
+				}
+				b, err := io.ReadAll(resp.Body)
+				require.NoError(t, err)
+				require.SliceEqualT(t, tt.expectedBody, b)
+			})
+		}
+	}
+}
+
+// listingProvider lists images so that referrers can be listed.
+type listingProvider struct {
+	*storetest.Provider
+	imgs []oci.Image
+}
+
+func (l *listingProvider) ListImages(ctx context.Context) ([]oci.Image, error) {
+	return l.imgs, nil
+}
@@ -0,0 +1,115 @@
+package registry
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/opencontainers/go-digest"
+
+	"github.com/spegel-org/spegel/pkg/httpx"
+	"github.com/spegel-org/spegel/pkg/metrics"
+	"github.com/spegel-org/spegel/pkg/oci"
+	"github.com/spegel-org/spegel/pkg/oci/signature"
+)
+
+// maxSignatureContentSize limits the size of manifests and payloads read when verifying signatures.
+const maxSignatureContentSize = 4 * 1024 * 1024
+
+// requiresSignature returns true if the tag has to resolve to a signed manifest.
+// Manifests and blobs requested by digest are content addressed and verified by the client.
+func (r *Registry) requiresSignature(dist oci.DistributionPath) bool {
+	if r.policy == nil || dist.Kind != oci.DistributionKindManifest || dist.Tag == "" {
+		return false
+	}
+	if signature.IsSignatureTag(dist.Tag) {
+		return false
+	}
+	return r.policy.Matches(dist.Reference)
+}
+
+// verifySignature checks that the digest the tag resolved to satisfies the signature policy.
+func (r *Registry) verifySignature(ctx context.Context, dist oci.DistributionPath, dgst digest.Digest) error {
+	if !r.requiresSignature(dist) {
+		return nil
+	}
+	err := r.policy.Verify(ctx, &signatureFetcher{r: r}, dist.Reference, dgst)
+	if err != nil {
+		metrics.SignatureVerificationsTotal.WithLabelValues(dist.Registry, "rejected").Inc()
+		respErr := oci.NewDistributionError(oci.ErrCodeManifestUnknown, fmt.Sprintf("manifest %s for %s does not satisfy signature policy", dgst, dist.Identifier()), nil)
+		return errors.Join(respErr, err)
+	}
+	metrics.SignatureVerificationsTotal.WithLabelValues(dist.Registry, "accepted").Inc()
+	return nil
+}
+
+// signatureFetcher fetches signature content from the local store, falling back to peers.
+type signatureFetcher struct {
+	r *Registry
+}
+
+func (s *signatureFetcher) Fetch(ctx context.Context, dist oci.DistributionPath) ([]byte, error) {
+	b, localErr := s.fetchLocal(ctx, dist)
+	if localErr == nil {
+		return b, nil
+	}
+	b, peerErr := s.fetchPeers(ctx, dist)
+	if peerErr != nil {
+		return nil, errors.Join(localErr, peerErr)
+	}
+	return b, nil
+}
+
+func (s *signatureFetcher) fetchLocal(ctx context.Context, dist oci.DistributionPath) ([]byte, error) {
+	if dist.Kind == oci.DistributionKindReferrers {
+		return s.r.referrers(ctx, dist)
+	}
+	dgst := dist.Digest
+	if dgst == "" {
+		var err error
+		dgst, err = s.r.resolve(ctx, dist)
+		if err != nil {
+			return nil, err
+		}
+	}
+	rc, err := s.r.provider.Open(ctx, dgst)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return readVerified(rc, dgst)
+}
+
+func (s *signatureFetcher) fetchPeers(ctx context.Context, dist oci.DistributionPath) ([]byte, error) {
+	iter, err := s.r.router.Lookup(ctx, s.r.aliases.Key(dist.Identifier()), s.r.resolveRetries)
+	if err != nil {
+		return nil, err
+	}
+	res, err := s.r.raceFetch(ctx, iter, dist)
+	if err != nil {
+		return nil, err
+	}
+	defer httpx.DrainAndClose(res.rc)
+	// Referrers are addressed by the subject digest, so only the digest of the response can be verified.
+	dgst := dist.Digest
+	if dgst == "" || dist.Kind == oci.DistributionKindReferrers {
+		dgst = res.desc.Digest
+	}
+	return readVerified(res.rc, dgst)
+}
+
+// readVerified reads the content and checks that it matches the digest.
+func readVerified(r io.Reader, dgst digest.Digest) ([]byte, error) {
+	b, err := io.ReadAll(io.LimitReader(r, maxSignatureContentSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > maxSignatureContentSize {
+		return nil, fmt.Errorf("content %s exceeds max size of %d bytes", dgst, maxSignatureContentSize)
+	}
+	if digest.FromBytes(b) != dgst {
+		return nil, fmt.Errorf("content does not match digest %s", dgst)
+	}
+	return b, nil
+}
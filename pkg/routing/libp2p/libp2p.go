@@ -13,6 +13,7 @@ import (
 	"net/netip"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strconv"
 	"time"
 
@@ -28,6 +29,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/crypto"
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
 	"github.com/libp2p/go-libp2p/core/sec"
 	"github.com/libp2p/go-libp2p/p2p/transport/tcp"
 	ma "github.com/multiformats/go-multiaddr"
@@ -47,13 +49,21 @@ import (
 
 const (
 	lookupCacheTTL = 5 * time.Second
+	// lookupTimeout bounds provider lookups, which run independently of the request as results are shared through the lookup cache.
+	lookupTimeout           = 5 * time.Second
+	negativeLookupCacheSize = 10000
+	protocolPrefix          = "/spegel"
 )
 
+var networkRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9-]{0,61}[a-z0-9])?$`)
+
 type RouterConfig struct {
 	DataDir           string
+	Network           string
 	Libp2pOpts        []libp2p.Option
 	AdvertiseTTL      time.Duration
 	MaxReprovideDelay time.Duration
+	NegativeLookupTTL time.Duration
 }
 
 type RouterOption = option.Option[RouterConfig]
@@ -86,6 +96,27 @@ func WithMaxReprovideDelay(delay time.Duration) RouterOption {
 	}
 }
 
+// WithNegativeLookupTTL sets how long lookups which found no peers are cached.
+// Setting the TTL to zero disables negative lookup caching.
+func WithNegativeLookupTTL(ttl time.Duration) RouterOption {
+	return func(cfg *RouterConfig) error {
+		cfg.NegativeLookupTTL = ttl
+		return nil
+	}
+}
+
+// WithNetwork scopes the router to the named network.
+// Routers only exchange keys with routers in the same network, allowing isolated clusters to share a flat network.
+func WithNetwork(network string) RouterOption {
+	return func(cfg *RouterConfig) error {
+		if network != "" && !networkRegex.MatchString(network) {
+			return fmt.Errorf("invalid network name %q", network)
+		}
+		cfg.Network = network
+		return nil
+	}
+}
+
 var _ routing.Router = &Router{}
 
 type Router struct {
@@ -95,8 +126,10 @@ type Router struct {
 	prov             *provider.SweepingProvider
 	lookupGroup      *singleflight.Group
 	lookupCache      *expirable.LRU[string, *routing.Iterator]
+	negativeCache    *expirable.LRU[string, any]
 	connectivityGate *channel.Gate
 	protocols        []ma.Multiaddr
+	network          string
 	registryPort     uint16
 }
 
@@ -104,6 +137,7 @@ func NewRouter(ctx context.Context, addr string, bs Bootstrapper, registryPortSt
 	cfg := RouterConfig{
 		AdvertiseTTL:      15 * time.Minute,
 		MaxReprovideDelay: 2 * time.Minute,
+		NegativeLookupTTL: 10 * time.Second,
 	}
 	err := option.Apply(&cfg, opts...)
 	if err != nil {
@@ -154,7 +188,14 @@ func NewRouter(ctx context.Context, addr string, bs Bootstrapper, registryPortSt
 
 	dhtOpts := []dht.Option{
 		dht.Mode(dht.ModeServer),
-		dht.ProtocolPrefix("/spegel"),
+		dht.ProtocolPrefix(networkProtocolPrefix(cfg.Network)),
+		dht.RoutingTableFilter(func(_ any, id peer.ID) bool {
+			protos, err := host.Peerstore().GetProtocols(id)
+			if err != nil {
+				return true
+			}
+			return routing.IsCompatibleProtocolVersion(peerProtocolVersion(cfg.Network, protos))
+		}),
 		dht.ProviderManagerOpts(
 			records.ProvideValidity(cfg.AdvertiseTTL+(2*cfg.MaxReprovideDelay)),
 			records.ProviderAddrTTL(1*time.Hour),
@@ -195,28 +236,45 @@ func NewRouter(ctx context.Context, addr string, bs Bootstrapper, registryPortSt
 		return nil, err
 	}
 
-	return &Router{
+	var negativeCache *expirable.LRU[string, any]
+	if cfg.NegativeLookupTTL > 0 {
+		negativeCache = expirable.NewLRU[string, any](negativeLookupCacheSize, nil, cfg.NegativeLookupTTL)
+	}
+
+	r := &Router{
 		bootstrapper:     bs,
 		host:             host,
 		kdht:             kdht,
 		prov:             prov,
 		lookupGroup:      &singleflight.Group{},
 		lookupCache:      expirable.NewLRU[string, *routing.Iterator](0, nil, lookupCacheTTL),
+		negativeCache:    negativeCache,
 		connectivityGate: connectivityGate,
 		protocols:        protocols,
+		network:          cfg.Network,
 		registryPort:     uint16(registryPort),
-	}, nil
+	}
+	r.announceProtocolVersion()
+	return r, nil
 }
 
 func (r *Router) Host() host.Host {
 	return r.host
 }
 
+// Network returns the name of the network the router is scoped to.
+func (r *Router) Network() string {
+	return r.network
+}
+
 func (r *Router) Run(ctx context.Context) error {
 	log := logr.FromContextOrDiscard(ctx).WithName("p2p")
 	log.Info("starting p2p router", "id", r.host.ID())
 
 	group := errgroup.WithContext(ctx)
+	group.Go(func(ctx context.Context) error {
+		return r.watchProtocolVersions(logr.NewContext(ctx, log))
+	})
 	group.Go(func(ctx context.Context) error {
 		err := r.bootstrapper.Run(ctx, *host.InfoFromHost(r.host))
 		if err != nil {
@@ -292,11 +350,24 @@ func (r *Router) Ready(ctx context.Context) (bool, error) {
 
 func (r *Router) Lookup(ctx context.Context, key string, count int) (*routing.Iterator, error) {
 	log := logr.FromContextOrDiscard(ctx).WithValues("host", r.host.ID().String(), "key", key)
-	c, err := createCid(key)
+	c, err := createCid(r.network, key)
 	if err != nil {
 		return nil, err
 	}
 
+	// Skip resolving content which recently could not be found, unless a peer has advertised it to us since.
+	if r.negativeCache != nil {
+		if _, ok := r.negativeCache.Get(c.String()); ok {
+			if !r.hasRemoteProvider(ctx, c) {
+				metrics.ResolveNegativeCacheHitsTotal.WithLabelValues("libp2p").Inc()
+				iter := routing.NewIterator()
+				iter.Close()
+				return iter, nil
+			}
+			r.negativeCache.Remove(c.String())
+		}
+	}
+
 	res, err, _ := r.lookupGroup.Do(c.String(), func() (any, error) {
 		iter, ok := r.lookupCache.Get(c.String())
 		if ok {
@@ -312,10 +383,14 @@ func (r *Router) Lookup(ctx context.Context, key string, count int) (*routing.It
 			r.lookupCache.Add(c.String(), iter)
 		}
 
-		addrInfoCh := r.kdht.FindProvidersAsync(ctx, c, count)
+		// Requests give up on lookups quickly, the lookup continues so that the result can be cached.
+		lookupCtx, lookupCancel := context.WithTimeout(context.WithoutCancel(ctx), lookupTimeout)
+		addrInfoCh := r.kdht.FindProvidersAsync(lookupCtx, c, count)
 		go func() {
+			defer lookupCancel()
 			defer iter.Close()
 
+			found := 0
 			lookupTimer := prometheus.NewTimer(metrics.ResolveDurHistogram.WithLabelValues("libp2p"))
 			for addrInfo := range addrInfoCh {
 				lookupTimer.ObserveDuration()
@@ -338,6 +413,12 @@ func (r *Router) Lookup(ctx context.Context, key string, count int) (*routing.It
 					},
 				}
 				iter.Add(peer)
+				found += 1
+			}
+
+			// Only cache lookups which completed without finding any peers.
+			if r.negativeCache != nil && found == 0 && lookupCtx.Err() == nil {
+				r.negativeCache.Add(c.String(), nil)
 			}
 		}()
 		return iter, nil
@@ -349,6 +430,20 @@ func (r *Router) Lookup(ctx context.Context, key string, count int) (*routing.It
 	return res.(*routing.Iterator), nil
 }
 
+// hasRemoteProvider returns true if the local provider store contains a provider other than self.
+func (r *Router) hasRemoteProvider(ctx context.Context, c cid.Cid) bool {
+	addrInfos, err := r.kdht.ProviderStore().GetProviders(ctx, c.Hash())
+	if err != nil {
+		return false
+	}
+	for _, addrInfo := range addrInfos {
+		if addrInfo.ID != r.host.ID() {
+			return true
+		}
+	}
+	return false
+}
+
 type LookupResult struct {
 	Peer     routing.Peer
 	Duration time.Duration
@@ -356,7 +451,7 @@ type LookupResult struct {
 
 // Measure returns a list of time results containing the time it took to find each peer.
 func (r *Router) Measure(ctx context.Context, key string) ([]LookupResult, error) {
-	c, err := createCid(key)
+	c, err := createCid(r.network, key)
 	if err != nil {
 		return nil, err
 	}
@@ -392,10 +487,13 @@ func (r *Router) Advertise(ctx context.Context, keys []string) error {
 	}
 	hs := []mh.Multihash{}
 	for _, key := range keys {
-		c, err := createCid(key)
+		c, err := createCid(r.network, key)
 		if err != nil {
 			return err
 		}
+		if r.negativeCache != nil {
+			r.negativeCache.Remove(c.String())
+		}
 		h := c.Hash()
 		err = r.kdht.ProviderStore().AddProvider(ctx, h, peer.AddrInfo{ID: r.host.ID()})
 		if err != nil {
@@ -416,7 +514,7 @@ func (r *Router) Withdraw(ctx context.Context, keys []string) error {
 	}
 	mhs := []mh.Multihash{}
 	for _, key := range keys {
-		c, err := createCid(key)
+		c, err := createCid(r.network, key)
 		if err != nil {
 			return err
 		}
@@ -535,7 +633,19 @@ func protocolsFromAddrs(addrs []ma.Multiaddr) []ma.Multiaddr {
 	return protocols
 }
 
-func createCid(key string) (cid.Cid, error) {
+// networkProtocolPrefix returns the DHT protocol prefix for the network.
+func networkProtocolPrefix(network string) protocol.ID {
+	if network == "" {
+		return protocolPrefix
+	}
+	return protocol.ID(protocolPrefix + "/" + network)
+}
+
+// createCid derives the content identifier for the key within the network.
+func createCid(network, key string) (cid.Cid, error) {
+	if network != "" {
+		key = "/" + network + "/" + key
+	}
 	pref := cid.Prefix{
 		Version:  1,
 		Codec:    uint64(mc.Raw),
@@ -13,6 +13,7 @@ import (
 	"github.com/libp2p/go-libp2p/core/host"
 	"github.com/libp2p/go-libp2p/core/network"
 	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
 	ma "github.com/multiformats/go-multiaddr"
 
 	"github.com/kvick-org/pkg/errgroup"
@@ -29,12 +30,19 @@ func TestP2PRouterOptions(t *testing.T) {
 	opts := []RouterOption{
 		WithLibP2POptions(libp2pOpts...),
 		WithDataDir("foobar"),
+		WithNegativeLookupTTL(time.Minute),
+		WithNetwork("tenant-a"),
 	}
 	cfg := RouterConfig{}
 	err := option.Apply(&cfg, opts...)
 	require.NoError(t, err)
 	require.Equal(t, libp2pOpts, cfg.Libp2pOpts)
 	require.EqualT(t, "foobar", cfg.DataDir)
+	require.EqualT(t, time.Minute, cfg.NegativeLookupTTL)
+	require.EqualT(t, "tenant-a", cfg.Network)
+
+	err = option.Apply(&cfg, WithNetwork("Tenant/A"))
+	require.EqualError(t, err, `invalid network name "Tenant/A"`)
 }
 
 func TestP2PRouter(t *testing.T) {
@@ -73,7 +81,7 @@ func TestP2PRouter(t *testing.T) {
 	require.NoError(t, err)
 
 	// Provider store should contain self.
-	c, err := createCid(advertisedKey)
+	c, err := createCid("", advertisedKey)
 	require.NoError(t, err)
 	addrInfos, err := primaryRouter.kdht.FindProviders(t.Context(), c)
 	require.NoError(t, err)
@@ -230,6 +238,69 @@ func TestProvideTTL(t *testing.T) {
 	require.NoError(t, err)
 }
 
+func TestNegativeLookupCache(t *testing.T) {
+	t.Parallel()
+
+	router, err := NewRouter(t.Context(), "localhost:0", NewStaticBootstrapper(nil), "9090", WithNegativeLookupTTL(time.Minute))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		router.host.Close()
+	})
+	key := "missing"
+	c, err := createCid("", key)
+	require.NoError(t, err)
+
+	// Lookup without any peers should be cached.
+	iter, err := router.Lookup(t.Context(), key, 3)
+	require.NoError(t, err)
+	<-iter.Exhausted()
+	require.EventuallyWith(t, func(ct *assert.CollectT) {
+		require.TrueT(ct, router.negativeCache.Contains(c.String()))
+	}, 5*time.Second, 10*time.Millisecond)
+	iter, err = router.Lookup(t.Context(), key, 3)
+	require.NoError(t, err)
+	<-iter.Exhausted()
+	require.EqualT(t, 0, iter.Count())
+
+	// Lookups continue after the request is cancelled so that misses are cached.
+	canceledKey := "canceled"
+	canceledCid, err := createCid("", canceledKey)
+	require.NoError(t, err)
+	lookupCtx, lookupCancel := context.WithCancel(t.Context())
+	_, err = router.Lookup(lookupCtx, canceledKey, 3)
+	require.NoError(t, err)
+	lookupCancel()
+	require.EventuallyWith(t, func(ct *assert.CollectT) {
+		require.TrueT(ct, router.negativeCache.Contains(canceledCid.String()))
+	}, 5*time.Second, 10*time.Millisecond)
+
+	// Advertising the key should invalidate the cache.
+	err = router.Advertise(t.Context(), []string{key})
+	require.NoError(t, err)
+	require.FalseT(t, router.negativeCache.Contains(c.String()))
+
+	// Remote provider in the local provider store should invalidate the cache.
+	router.negativeCache.Add(c.String(), nil)
+	remoteRouter, err := NewRouter(t.Context(), "localhost:0", NewStaticBootstrapper(nil), "9090")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		remoteRouter.host.Close()
+	})
+	err = router.kdht.ProviderStore().AddProvider(t.Context(), c.Hash(), *host.InfoFromHost(remoteRouter.host))
+	require.NoError(t, err)
+	_, err = router.Lookup(t.Context(), key, 3)
+	require.NoError(t, err)
+	require.FalseT(t, router.negativeCache.Contains(c.String()))
+
+	// Negative cache is disabled with zero TTL.
+	disabledRouter, err := NewRouter(t.Context(), "localhost:0", NewStaticBootstrapper(nil), "9090", WithNegativeLookupTTL(0))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		disabledRouter.host.Close()
+	})
+	require.Nil(t, disabledRouter.negativeCache)
+}
+
 func TestListenMultiaddrs(t *testing.T) {
 	t.Parallel()
 
@@ -281,9 +352,24 @@ func TestListenMultiaddrs(t *testing.T) {
 func TestCreateCid(t *testing.T) {
 	t.Parallel()
 
-	c, err := createCid("foobar")
+	c, err := createCid("", "foobar")
 	require.NoError(t, err)
 	require.EqualT(t, "bafkreigdvoh7cnza5cwzar65hfdgwpejotszfqx2ha6uuolaofgk54ge6i", c.String())
+
+	// Keys in different networks should not collide.
+	fooCid, err := createCid("foo", "foobar")
+	require.NoError(t, err)
+	require.NotEqual(t, c, fooCid)
+	barCid, err := createCid("bar", "foobar")
+	require.NoError(t, err)
+	require.NotEqual(t, fooCid, barCid)
+}
+
+func TestNetworkProtocolPrefix(t *testing.T) {
+	t.Parallel()
+
+	require.EqualT(t, protocol.ID("/spegel"), networkProtocolPrefix(""))
+	require.EqualT(t, protocol.ID("/spegel/tenant-a"), networkProtocolPrefix("tenant-a"))
 }
 
 func TestAddrsEqual(t *testing.T) {
@@ -0,0 +1,43 @@
+package libp2p
+
+import (
+	"context"
+	"net"
+	"net/http"
+
+	"github.com/libp2p/go-libp2p/core/peer"
+	"github.com/libp2p/go-libp2p/core/protocol"
+	"github.com/libp2p/go-libp2p/p2p/net/gostream"
+
+	"github.com/spegel-org/spegel/pkg/httpx"
+)
+
+// blobProtocolID returns the protocol used to serve registry requests over libp2p streams within the network.
+func blobProtocolID(network string) protocol.ID {
+	return networkProtocolPrefix(network) + "/blob/1.0.0"
+}
+
+// Listen returns a listener which accepts registry requests sent over libp2p streams.
+// It allows content to be served to peers which are only able to reach the router address.
+func (r *Router) Listen() (net.Listener, error) {
+	return gostream.Listen(r.host, blobProtocolID(r.network))
+}
+
+// Transport returns a round tripper which sends requests to peers over libp2p streams.
+// The host of the request URL is expected to be the peer ID of the destination.
+func (r *Router) Transport() http.RoundTripper {
+	transport := httpx.BaseTransport()
+	transport.ForceAttemptHTTP2 = false
+	transport.DialContext = func(ctx context.Context, network, addr string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		id, err := peer.Decode(host)
+		if err != nil {
+			return nil, err
+		}
+		return gostream.Dial(ctx, r.host, id, blobProtocolID(r.network))
+	}
+	return transport
+}
@@ -0,0 +1,92 @@
+package libp2p
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/go-openapi/testify/v2/require"
+	"github.com/libp2p/go-libp2p/core/host"
+
+	"github.com/spegel-org/spegel/pkg/httpx"
+)
+
+func TestStream(t *testing.T) {
+	t.Parallel()
+
+	serverRouter, err := NewRouter(t.Context(), "localhost:0", NewStaticBootstrapper(nil), "9090")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		serverRouter.host.Close()
+	})
+	clientRouter, err := NewRouter(t.Context(), "localhost:0", NewStaticBootstrapper(nil), "9090")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		clientRouter.host.Close()
+	})
+	err = clientRouter.host.Connect(t.Context(), *host.InfoFromHost(serverRouter.host))
+	require.NoError(t, err)
+
+	listener, err := serverRouter.Listen()
+	require.NoError(t, err)
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(rw http.ResponseWriter, req *http.Request) {
+			rw.Header().Set(httpx.HeaderContentType, httpx.ContentTypeText)
+			//nolint: errcheck // Ignore
+			rw.Write([]byte(req.URL.Path))
+		}),
+	}
+	go func() {
+		//nolint: errcheck // Ignore
+		srv.Serve(listener)
+	}()
+	t.Cleanup(func() {
+		srv.Close()
+	})
+
+	client := &http.Client{
+		Transport: clientRouter.Transport(),
+	}
+	u := url.URL{
+		Scheme: "http",
+		Host:   serverRouter.host.ID().String(),
+		Path:   "/v2/foo/blobs/bar",
+	}
+	for range 2 {
+		req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, u.String(), nil)
+		require.NoError(t, err)
+		resp, err := client.Do(req)
+		require.NoError(t, err)
+		b, err := io.ReadAll(resp.Body)
+		require.NoError(t, err)
+		resp.Body.Close()
+		require.EqualT(t, http.StatusOK, resp.StatusCode)
+		require.EqualT(t, u.Path, string(b))
+	}
+
+	// Peers in other networks cannot be dialed.
+	otherRouter, err := NewRouter(t.Context(), "localhost:0", NewStaticBootstrapper(nil), "9090", WithNetwork("tenant-a"))
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		otherRouter.host.Close()
+	})
+	err = otherRouter.host.Connect(t.Context(), *host.InfoFromHost(serverRouter.host))
+	require.NoError(t, err)
+	otherClient := &http.Client{
+		Transport: otherRouter.Transport(),
+	}
+	req, err := http.NewRequestWithContext(t.Context(), http.MethodGet, u.String(), nil)
+	require.NoError(t, err)
+	resp, err := otherClient.Do(req)
+	require.Error(t, err)
+	require.Nil(t, resp)
+
+	// Hosts which are not peer IDs cannot be dialed.
+	u.Host = "foo"
+	req, err = http.NewRequestWithContext(t.Context(), http.MethodGet, u.String(), nil)
+	require.NoError(t, err)
+	resp, err = client.Do(req)
+	require.Error(t, err)
+	require.Nil(t, resp)
+}
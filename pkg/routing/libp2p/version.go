@@ -0,0 +1,85 @@
+package libp2p
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	"github.com/libp2p/go-libp2p/core/event"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	"github.com/spegel-org/spegel/pkg/metrics"
+	"github.com/spegel-org/spegel/pkg/routing"
+)
+
+// versionProtocolPrefix returns the prefix of the protocols announcing protocol versions within the network.
+func versionProtocolPrefix(network string) string {
+	return string(networkProtocolPrefix(network)) + "/version/"
+}
+
+// versionProtocolID returns the protocol announced by peers to signal their protocol version.
+func versionProtocolID(network string, version int) protocol.ID {
+	return protocol.ID(versionProtocolPrefix(network) + strconv.Itoa(version))
+}
+
+// peerProtocolVersion returns the highest protocol version announced in the protocols for the network.
+// Peers which do not announce a version are assumed to run the legacy version.
+func peerProtocolVersion(network string, protos []protocol.ID) int {
+	prefix := versionProtocolPrefix(network)
+	version := routing.LegacyProtocolVersion
+	for _, proto := range protos {
+		v, ok := strings.CutPrefix(string(proto), prefix)
+		if !ok {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			continue
+		}
+		version = max(version, n)
+	}
+	return version
+}
+
+// announceProtocolVersion makes the protocol version visible to peers through identify.
+func (r *Router) announceProtocolVersion() {
+	r.host.SetStreamHandler(versionProtocolID(r.network, routing.ProtocolVersion), func(s network.Stream) {
+		//nolint: errcheck // Nothing is exchanged over the stream.
+		s.Reset()
+	})
+}
+
+// watchProtocolVersions reports peers with incompatible protocol versions as they are identified.
+// Peers are added to the routing table before their protocols are known, so incompatible peers are removed once identified.
+func (r *Router) watchProtocolVersions(ctx context.Context) error {
+	log := logr.FromContextOrDiscard(ctx)
+
+	sub, err := r.host.EventBus().Subscribe(new(event.EvtPeerIdentificationCompleted))
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-sub.Out():
+			if !ok {
+				return nil
+			}
+			evt, ok := e.(event.EvtPeerIdentificationCompleted)
+			if !ok {
+				continue
+			}
+			version := peerProtocolVersion(r.network, evt.Protocols)
+			if routing.IsCompatibleProtocolVersion(version) {
+				continue
+			}
+			r.kdht.RoutingTable().RemovePeer(evt.Peer)
+			metrics.IncompatiblePeersTotal.WithLabelValues("libp2p", strconv.Itoa(version)).Inc()
+			log.Info("ignoring peer with incompatible protocol version", "peer", evt.Peer.String(), "version", version, "localVersion", routing.ProtocolVersion)
+		}
+	}
+}
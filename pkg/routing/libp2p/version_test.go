@@ -0,0 +1,119 @@
+package libp2p
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/testify/v2/require"
+	"github.com/libp2p/go-libp2p/core/host"
+	"github.com/libp2p/go-libp2p/core/network"
+	"github.com/libp2p/go-libp2p/core/protocol"
+
+	"github.com/spegel-org/spegel/pkg/routing"
+)
+
+func TestPeerProtocolVersion(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		network  string
+		protos   []protocol.ID
+		expected int
+	}{
+		{
+			name:     "no version",
+			protos:   []protocol.ID{"/spegel/kad/1.0.0"},
+			expected: routing.LegacyProtocolVersion,
+		},
+		{
+			name:     "single version",
+			protos:   []protocol.ID{"/spegel/kad/1.0.0", "/spegel/version/3"},
+			expected: 3,
+		},
+		{
+			name:     "multiple versions",
+			protos:   []protocol.ID{"/spegel/version/2", "/spegel/version/5", "/spegel/version/4"},
+			expected: 5,
+		},
+		{
+			name:     "invalid version",
+			protos:   []protocol.ID{"/spegel/version/foo"},
+			expected: routing.LegacyProtocolVersion,
+		},
+		{
+			name:     "network version",
+			network:  "tenant-a",
+			protos:   []protocol.ID{"/spegel/version/5", "/spegel/tenant-a/version/3"},
+			expected: 3,
+		},
+		{
+			name:     "other network version",
+			network:  "tenant-a",
+			protos:   []protocol.ID{"/spegel/tenant-b/version/3"},
+			expected: routing.LegacyProtocolVersion,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			require.EqualT(t, tt.expected, peerProtocolVersion(tt.network, tt.protos))
+		})
+	}
+}
+
+func TestAnnounceProtocolVersion(t *testing.T) {
+	t.Parallel()
+
+	router, err := NewRouter(t.Context(), "localhost:0", NewStaticBootstrapper(nil), "9090")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		router.host.Close()
+	})
+	protos := router.host.Mux().Protocols()
+	require.TrueT(t, slices.Contains(protos, versionProtocolID("", routing.ProtocolVersion)))
+	require.EqualT(t, routing.ProtocolVersion, peerProtocolVersion("", protos))
+}
+
+func TestWatchProtocolVersions(t *testing.T) {
+	t.Parallel()
+
+	router, err := NewRouter(t.Context(), "localhost:0", NewStaticBootstrapper(nil), "9090")
+	require.NoError(t, err)
+	t.Cleanup(func() {
+		router.host.Close()
+	})
+	ctx, cancel := context.WithCancel(t.Context())
+	t.Cleanup(cancel)
+	go func() {
+		//nolint: errcheck // Ignore error.
+		router.watchProtocolVersions(ctx)
+	}()
+
+	// Peers are identified after they have been connected, so incompatible peers are removed afterwards.
+	for _, version := range []int{routing.ProtocolVersion, routing.ProtocolVersion + 2} {
+		other, err := NewRouter(t.Context(), "localhost:0", NewStaticBootstrapper(nil), "9090")
+		require.NoError(t, err)
+		t.Cleanup(func() {
+			other.host.Close()
+		})
+		other.host.RemoveStreamHandler(versionProtocolID("", routing.ProtocolVersion))
+		other.host.SetStreamHandler(versionProtocolID("", version), func(s network.Stream) {
+			//nolint: errcheck // Nothing is exchanged over the stream.
+			s.Reset()
+		})
+		err = router.host.Connect(t.Context(), *host.InfoFromHost(other.host))
+		require.NoError(t, err)
+		compatible := routing.IsCompatibleProtocolVersion(version)
+		require.Eventually(t, func() bool {
+			protos, err := router.host.Peerstore().GetProtocols(other.host.ID())
+			if err != nil || peerProtocolVersion("", protos) != version {
+				return false
+			}
+			return (router.kdht.RoutingTable().Find(other.host.ID()) != "") == compatible
+		}, 5*time.Second, 10*time.Millisecond)
+	}
+}
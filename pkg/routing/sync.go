@@ -4,20 +4,81 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"slices"
+	"strings"
 
 	"github.com/go-logr/logr"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 
+	"github.com/spegel-org/spegel/internal/option"
+	"github.com/spegel-org/spegel/pkg/oci"
 	"github.com/spegel-org/spegel/pkg/store"
 )
 
-func Sync(ctx context.Context, router Router, watcher store.Watcher) error {
+type SyncConfig struct {
+	Aliases    oci.RegistryAliases
+	Filters    []oci.Filter
+	ImagesOnly bool
+}
+
+type SyncOption = option.Option[SyncConfig]
+
+// WithImagesOnly limits advertisement to tags, manifests and the repositories they belong to.
+// Blobs are not advertised, instead they are resolved through peers holding the repository.
+func WithImagesOnly(imagesOnly bool) SyncOption {
+	return func(cfg *SyncConfig) error {
+		cfg.ImagesOnly = imagesOnly
+		return nil
+	}
+}
+
+// WithRegistryAliases advertises tags and repositories under their canonical registry name.
+func WithRegistryAliases(aliases oci.RegistryAliases) SyncOption {
+	return func(cfg *SyncConfig) error {
+		cfg.Aliases = aliases
+		return nil
+	}
+}
+
+// WithFilters does not advertise content whose descriptor matches any of the descriptor filters.
+// Stores filter images before the descriptors of their content are known, so rules on media type
+// or size would otherwise only apply when serving content which is still advertised.
+func WithFilters(filters []oci.Filter) SyncOption {
+	return func(cfg *SyncConfig) error {
+		for _, f := range filters {
+			df, ok := f.(oci.DescriptorFilter)
+			if !ok {
+				continue
+			}
+			cfg.Filters = append(cfg.Filters, df)
+		}
+		return nil
+	}
+}
+
+func Sync(ctx context.Context, router Router, watcher store.Watcher, opts ...SyncOption) error {
+	cfg := SyncConfig{}
+	err := option.Apply(&cfg, opts...)
+	if err != nil {
+		return err
+	}
+	var repoIdx *repositoryIndex
+	if cfg.ImagesOnly {
+		repoIdx = newRepositoryIndex()
+	}
+	var refIdx *referenceIndex
+	if len(cfg.Aliases) > 0 {
+		refIdx = newReferenceIndex(cfg.Aliases)
+	}
+
 	events, eventCh, err := watcher.Watch(ctx)
 	if err != nil {
 		return err
 	}
 
 	// Initial advertisement of all content.
-	err = handleEvents(ctx, router, events)
+	err = handleEvents(ctx, router, events, cfg.Filters, repoIdx, refIdx)
 	if err != nil {
 		return err
 	}
@@ -31,7 +92,7 @@ func Sync(ctx context.Context, router Router, watcher store.Watcher) error {
 			if !ok {
 				return errors.New("event channel closed")
 			}
-			err := handleEvents(ctx, router, []store.Event{event})
+			err := handleEvents(ctx, router, []store.Event{event}, cfg.Filters, repoIdx, refIdx)
 			if err != nil {
 				logr.FromContextOrDiscard(ctx).Error(err, "could not handle event")
 				continue
@@ -40,24 +101,62 @@ func Sync(ctx context.Context, router Router, watcher store.Watcher) error {
 	}
 }
 
-func handleEvents(ctx context.Context, router Router, events []store.Event) error {
+// handleEvents advertises and withdraws keys for the events.
+// All digests are advertised unless a repository index is given.
+// References are advertised under their canonical name when a reference index is given.
+// Digests whose descriptor matches any of the filters are not advertised.
+func handleEvents(ctx context.Context, router Router, events []store.Event, filters []oci.Filter, repoIdx *repositoryIndex, refIdx *referenceIndex) error {
 	advertise := []string{}
 	withdraw := []string{}
 	for _, event := range events {
-		if event.Digest == "" {
-			return errors.New("received event with empty digest")
+		if event.Digest == "" && event.Reference == "" {
+			return errors.New("received event with empty digest and reference")
+		}
+		if event.Type == store.CreateEvent && matchesFilterEvent(event, filters) {
+			event.Digest = ""
+		}
+		if event.Repository != "" {
+			event.Repository = refIdx.key(event.Repository)
 		}
 		switch event.Type {
 		case store.CreateEvent:
 			if event.Reference != "" {
-				advertise = append(advertise, event.Reference)
+				advertise = append(advertise, refIdx.add(event.Reference))
+			}
+			if event.Digest == "" {
+				continue
+			}
+			if repoIdx == nil {
+				advertise = append(advertise, event.Digest.String())
+				continue
+			}
+			if !oci.IsManifestsMediatype(event.MediaType) {
+				continue
 			}
 			advertise = append(advertise, event.Digest.String())
+			if repoIdx.add(event.Digest, event.Repository) {
+				advertise = append(advertise, event.Repository)
+			}
 		case store.DeleteEvent:
 			if event.Reference != "" {
-				withdraw = append(withdraw, event.Reference)
+				key, last := refIdx.remove(event.Reference)
+				if last {
+					withdraw = append(withdraw, key)
+				}
+			}
+			if event.Digest == "" {
+				continue
+			}
+			if repoIdx == nil {
+				withdraw = append(withdraw, event.Digest.String())
+				continue
+			}
+			repos, ok := repoIdx.remove(event.Digest)
+			if !ok {
+				continue
 			}
 			withdraw = append(withdraw, event.Digest.String())
+			withdraw = append(withdraw, repos...)
 		default:
 			return fmt.Errorf("unhandled event type %s", event.Type)
 		}
@@ -72,3 +171,120 @@ func handleEvents(ctx context.Context, router Router, events []store.Event) erro
 	}
 	return nil
 }
+
+// matchesFilterEvent returns true if the descriptor of the content created by the event matches any of the filters.
+func matchesFilterEvent(event store.Event, filters []oci.Filter) bool {
+	if len(filters) == 0 || event.Digest == "" || event.MediaType == "" {
+		return false
+	}
+	ref := oci.Reference{
+		Digest: event.Digest,
+	}
+	ref.Registry, ref.Repository, _ = strings.Cut(event.Repository, "/")
+	desc := ocispec.Descriptor{
+		MediaType: event.MediaType,
+		Digest:    event.Digest,
+		Size:      event.Size,
+	}
+	return oci.MatchesFilterDescriptor(ref, desc, filters)
+}
+
+// referenceIndex tracks the equivalent references advertised under the same canonical key.
+type referenceIndex struct {
+	aliases    oci.RegistryAliases
+	references map[string]map[string]struct{}
+}
+
+func newReferenceIndex(aliases oci.RegistryAliases) *referenceIndex {
+	return &referenceIndex{
+		aliases:    aliases,
+		references: map[string]map[string]struct{}{},
+	}
+}
+
+// key returns the canonical key for the reference.
+func (r *referenceIndex) key(ref string) string {
+	if r == nil {
+		return ref
+	}
+	return r.aliases.Key(ref)
+}
+
+// add indexes the reference and returns its canonical key.
+func (r *referenceIndex) add(ref string) string {
+	key := r.key(ref)
+	if r == nil {
+		return key
+	}
+	refs, ok := r.references[key]
+	if !ok {
+		refs = map[string]struct{}{}
+		r.references[key] = refs
+	}
+	refs[ref] = struct{}{}
+	return key
+}
+
+// remove removes the reference and returns its canonical key and true if no equivalent reference remains.
+func (r *referenceIndex) remove(ref string) (string, bool) {
+	key := r.key(ref)
+	if r == nil {
+		return key, true
+	}
+	refs, ok := r.references[key]
+	if !ok {
+		return key, true
+	}
+	delete(refs, ref)
+	if len(refs) > 0 {
+		return key, false
+	}
+	delete(r.references, key)
+	return key, true
+}
+
+// repositoryIndex tracks which repositories advertised manifests belong to.
+type repositoryIndex struct {
+	manifests    map[digest.Digest][]string
+	repositories map[string]int
+}
+
+func newRepositoryIndex() *repositoryIndex {
+	return &repositoryIndex{
+		manifests:    map[digest.Digest][]string{},
+		repositories: map[string]int{},
+	}
+}
+
+// add indexes the manifest and returns true if the repository was not indexed before.
+func (r *repositoryIndex) add(dgst digest.Digest, repo string) bool {
+	repos, ok := r.manifests[dgst]
+	if !ok {
+		r.manifests[dgst] = []string{}
+	}
+	if repo == "" || slices.Contains(repos, repo) {
+		return false
+	}
+	r.manifests[dgst] = append(repos, repo)
+	r.repositories[repo] += 1
+	return r.repositories[repo] == 1
+}
+
+// remove removes the manifest and returns the repositories which no longer contain any manifests.
+func (r *repositoryIndex) remove(dgst digest.Digest) ([]string, bool) {
+	repos, ok := r.manifests[dgst]
+	if !ok {
+		return nil, false
+	}
+	delete(r.manifests, dgst)
+	removed := []string{}
+	for _, repo := range repos {
+		r.repositories[repo] -= 1
+		if r.repositories[repo] > 0 {
+			continue
+		}
+		delete(r.repositories, repo)
+		removed = append(removed, repo)
+	}
+	return removed, true
+}
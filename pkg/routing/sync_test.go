@@ -3,14 +3,17 @@ package routing
 import (
 	"context"
 	"net/netip"
+	"regexp"
 	"testing"
 	"testing/synctest"
 
 	"github.com/go-openapi/testify/v2/require"
 	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 
 	"github.com/kvick-org/pkg/errgroup"
 
+	"github.com/spegel-org/spegel/pkg/oci"
 	"github.com/spegel-org/spegel/pkg/store"
 	"github.com/spegel-org/spegel/pkg/store/storetest"
 )
@@ -82,6 +85,211 @@ func TestSync(t *testing.T) {
 		_, ok := router.Get(incoming[2].Digest.String())
 		require.FalseT(t, ok)
 
+		// Events with only a reference should be advertised.
+		tagName := "docker.io/library/foo:latest"
+		watcher.Add(t.Context(), store.Event{Type: store.CreateEvent, Reference: tagName})
+		synctest.Wait()
+		_, ok = router.Get(tagName)
+		require.TrueT(t, ok)
+		watcher.Add(t.Context(), store.Event{Type: store.DeleteEvent, Reference: tagName})
+		synctest.Wait()
+		_, ok = router.Get(tagName)
+		require.FalseT(t, ok)
+
+		cancel()
+		err := group.Wait()
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestSyncImagesOnly(t *testing.T) {
+	t.Parallel()
+
+	manifestDgst := digest.FromString("manifest")
+	otherDgst := digest.FromString("other")
+	layerDgst := digest.FromString("layer")
+	initial := []store.Event{
+		{
+			Type:       store.CreateEvent,
+			Reference:  "docker.io/library/foo:latest",
+			Digest:     manifestDgst,
+			MediaType:  ocispec.MediaTypeImageManifest,
+			Repository: "docker.io/library/foo",
+		},
+		{
+			Type:       store.CreateEvent,
+			Digest:     otherDgst,
+			MediaType:  ocispec.MediaTypeImageIndex,
+			Repository: "docker.io/library/foo",
+		},
+		{
+			Type:       store.CreateEvent,
+			Digest:     layerDgst,
+			MediaType:  ocispec.MediaTypeImageLayerGzip,
+			Repository: "docker.io/library/foo",
+		},
+	}
+
+	synctest.Test(t, func(t *testing.T) {
+		watcher := storetest.NewWatcher(initial)
+		router := NewMemoryRouter(map[string][]Peer{}, Peer{Host: "test"})
+
+		ctx, cancel := context.WithCancel(t.Context())
+		group := errgroup.WithContext(ctx)
+		group.Go(func(ctx context.Context) error {
+			return Sync(ctx, router, watcher, WithImagesOnly(true))
+		})
+
+		// Only tags, manifests and repositories should be advertised.
+		synctest.Wait()
+		for _, key := range []string{"docker.io/library/foo:latest", manifestDgst.String(), otherDgst.String(), "docker.io/library/foo"} {
+			_, ok := router.Get(key)
+			require.TrueT(t, ok)
+		}
+		_, ok := router.Get(layerDgst.String())
+		require.FalseT(t, ok)
+
+		// Repository should be withdrawn once it no longer contains manifests.
+		watcher.Add(t.Context(), store.Event{Type: store.DeleteEvent, Digest: manifestDgst})
+		synctest.Wait()
+		_, ok = router.Get(manifestDgst.String())
+		require.FalseT(t, ok)
+		_, ok = router.Get("docker.io/library/foo")
+		require.TrueT(t, ok)
+		watcher.Add(t.Context(), store.Event{Type: store.DeleteEvent, Digest: otherDgst})
+		synctest.Wait()
+		_, ok = router.Get(otherDgst.String())
+		require.FalseT(t, ok)
+		_, ok = router.Get("docker.io/library/foo")
+		require.FalseT(t, ok)
+
+		cancel()
+		err := group.Wait()
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestSyncRegistryAliases(t *testing.T) {
+	t.Parallel()
+
+	manifestDgst := digest.FromString("manifest")
+	initial := []store.Event{
+		{
+			Type:       store.CreateEvent,
+			Reference:  "mirror.gcr.io/library/foo:latest",
+			Digest:     manifestDgst,
+			MediaType:  ocispec.MediaTypeImageManifest,
+			Repository: "mirror.gcr.io/library/foo",
+		},
+	}
+	aliases, err := oci.ParseRegistryAliases([]string{"mirror.gcr.io=docker.io"})
+	require.NoError(t, err)
+
+	synctest.Test(t, func(t *testing.T) {
+		watcher := storetest.NewWatcher(initial)
+		router := NewMemoryRouter(map[string][]Peer{}, Peer{Host: "test"})
+
+		ctx, cancel := context.WithCancel(t.Context())
+		group := errgroup.WithContext(ctx)
+		group.Go(func(ctx context.Context) error {
+			return Sync(ctx, router, watcher, WithImagesOnly(true), WithRegistryAliases(aliases))
+		})
+
+		// Tags and repositories should be advertised under the canonical registry.
+		synctest.Wait()
+		for _, key := range []string{"docker.io/library/foo:latest", manifestDgst.String(), "docker.io/library/foo"} {
+			_, ok := router.Get(key)
+			require.TrueT(t, ok)
+		}
+		for _, key := range []string{"mirror.gcr.io/library/foo:latest", "mirror.gcr.io/library/foo"} {
+			_, ok := router.Get(key)
+			require.FalseT(t, ok)
+		}
+
+		// Tags should only be withdrawn when no equivalent reference remains.
+		watcher.Add(t.Context(), store.Event{Type: store.CreateEvent, Reference: "docker.io/library/foo:latest", Digest: manifestDgst, MediaType: ocispec.MediaTypeImageManifest, Repository: "docker.io/library/foo"})
+		synctest.Wait()
+		watcher.Add(t.Context(), store.Event{Type: store.DeleteEvent, Reference: "mirror.gcr.io/library/foo:latest"})
+		synctest.Wait()
+		_, ok := router.Get("docker.io/library/foo:latest")
+		require.TrueT(t, ok)
+		watcher.Add(t.Context(), store.Event{Type: store.DeleteEvent, Reference: "docker.io/library/foo:latest"})
+		synctest.Wait()
+		_, ok = router.Get("docker.io/library/foo:latest")
+		require.FalseT(t, ok)
+
+		cancel()
+		err := group.Wait()
+		require.ErrorIs(t, err, context.Canceled)
+	})
+}
+
+func TestSyncFilters(t *testing.T) {
+	t.Parallel()
+
+	manifestDgst := digest.FromString("manifest")
+	layerDgst := digest.FromString("layer")
+	largeDgst := digest.FromString("large")
+	initial := []store.Event{
+		{
+			Type:       store.CreateEvent,
+			Reference:  "docker.io/library/foo:latest",
+			Digest:     manifestDgst,
+			MediaType:  ocispec.MediaTypeImageManifest,
+			Size:       100,
+			Repository: "docker.io/library/foo",
+		},
+		{
+			Type:       store.CreateEvent,
+			Digest:     layerDgst,
+			MediaType:  ocispec.MediaTypeImageLayerGzip,
+			Size:       100,
+			Repository: "docker.io/library/foo",
+		},
+		{
+			Type:      store.CreateEvent,
+			Digest:    largeDgst,
+			MediaType: ocispec.MediaTypeImageLayerGzip,
+			Size:      2048,
+		},
+	}
+	exprFilter, err := oci.NewExpressionFilter(oci.ExpressionFilterConfig{
+		Rules: []oci.ExpressionRuleConfig{
+			{Action: oci.FilterActionDeny, Expression: `mediaType == "application/vnd.oci.image.manifest.v1+json"`},
+			{Action: oci.FilterActionDeny, Expression: `size > 1024`},
+			{Action: oci.FilterActionAllow, Expression: `registry == "docker.io"`},
+		},
+	})
+	require.NoError(t, err)
+	// Only descriptor filters apply as the registry of blobs outside a repository is unknown.
+	filters := []oci.Filter{exprFilter, oci.RegexFilter{Regex: regexp.MustCompile(`.*`)}}
+
+	synctest.Test(t, func(t *testing.T) {
+		watcher := storetest.NewWatcher(initial)
+		router := NewMemoryRouter(map[string][]Peer{}, Peer{Host: "test"})
+
+		ctx, cancel := context.WithCancel(t.Context())
+		group := errgroup.WithContext(ctx)
+		group.Go(func(ctx context.Context) error {
+			return Sync(ctx, router, watcher, WithFilters(filters))
+		})
+
+		// Content whose descriptor is filtered should not be advertised.
+		synctest.Wait()
+		for _, key := range []string{"docker.io/library/foo:latest", layerDgst.String()} {
+			_, ok := router.Get(key)
+			require.TrueT(t, ok)
+		}
+		for _, key := range []string{manifestDgst.String(), largeDgst.String()} {
+			_, ok := router.Get(key)
+			require.FalseT(t, ok)
+		}
+
+		watcher.Add(t.Context(), store.Event{Type: store.CreateEvent, Digest: manifestDgst, MediaType: ocispec.MediaTypeImageManifest, Size: 100, Repository: "ghcr.io/foo/bar"})
+		synctest.Wait()
+		_, ok := router.Get(manifestDgst.String())
+		require.FalseT(t, ok)
+
 		cancel()
 		err := group.Wait()
 		require.ErrorIs(t, err, context.Canceled)
@@ -0,0 +1,15 @@
+package routing
+
+const (
+	// ProtocolVersion is the version of the protocol spoken between peers.
+	// It has to be incremented when changes are made to key derivation or how content is fetched from peers.
+	ProtocolVersion = 1
+	// LegacyProtocolVersion is the version assumed for peers which do not announce a version.
+	LegacyProtocolVersion = 0
+)
+
+// IsCompatibleProtocolVersion returns true if a peer with the given version can be used.
+// Peers are compatible with the previous and next version to allow rolling upgrades.
+func IsCompatibleProtocolVersion(version int) bool {
+	return version >= ProtocolVersion-1 && version <= ProtocolVersion+1
+}
@@ -0,0 +1,18 @@
+package routing
+
+import (
+	"testing"
+
+	"github.com/go-openapi/testify/v2/require"
+)
+
+func TestIsCompatibleProtocolVersion(t *testing.T) {
+	t.Parallel()
+
+	require.TrueT(t, IsCompatibleProtocolVersion(ProtocolVersion))
+	require.TrueT(t, IsCompatibleProtocolVersion(ProtocolVersion-1))
+	require.TrueT(t, IsCompatibleProtocolVersion(ProtocolVersion+1))
+	require.TrueT(t, IsCompatibleProtocolVersion(LegacyProtocolVersion))
+	require.FalseT(t, IsCompatibleProtocolVersion(ProtocolVersion-2))
+	require.FalseT(t, IsCompatibleProtocolVersion(ProtocolVersion+2))
+}
@@ -0,0 +1,335 @@
+package scrub
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/go-logr/logr"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/spegel-org/spegel/internal/option"
+	"github.com/spegel-org/spegel/pkg/metrics"
+	"github.com/spegel-org/spegel/pkg/oci"
+	"github.com/spegel-org/spegel/pkg/store"
+)
+
+var _ store.Provider = &Scrubber{}
+var _ store.Watcher = &Scrubber{}
+var _ oci.ImageLister = &Scrubber{}
+
+// Store is the store whose content is scrubbed.
+type Store interface {
+	store.Provider
+	store.Watcher
+}
+
+type ScrubberConfig struct {
+	Interval time.Duration
+}
+
+type ScrubberOption = option.Option[ScrubberConfig]
+
+// WithInterval sets the time between verifying two blobs.
+func WithInterval(interval time.Duration) ScrubberOption {
+	return func(cfg *ScrubberConfig) error {
+		if interval <= 0 {
+			return errors.New("scrub interval has to be greater than zero")
+		}
+		cfg.Interval = interval
+		return nil
+	}
+}
+
+// Scrubber periodically verifies that local content matches its digest.
+// Content which does not match is quarantined, it is no longer served and a delete event
+// is emitted so that it is withdrawn from the router. Quarantined content is released once
+// content created again passes verification.
+type Scrubber struct {
+	store       Store
+	created     map[digest.Digest]store.Event
+	verifiedAt  map[digest.Digest]time.Time
+	quarantined map[digest.Digest]struct{}
+	interval    time.Duration
+	mx          sync.Mutex
+}
+
+func NewScrubber(s Store, opts ...ScrubberOption) (*Scrubber, error) {
+	cfg := ScrubberConfig{
+		Interval: 10 * time.Second,
+	}
+	err := option.Apply(&cfg, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return &Scrubber{
+		store:       s,
+		created:     map[digest.Digest]store.Event{},
+		verifiedAt:  map[digest.Digest]time.Time{},
+		quarantined: map[digest.Digest]struct{}{},
+		interval:    cfg.Interval,
+	}, nil
+}
+
+func (s *Scrubber) Name() string {
+	return s.store.Name()
+}
+
+func (s *Scrubber) Resolve(ctx context.Context, ref string) (digest.Digest, error) {
+	return s.store.Resolve(ctx, ref)
+}
+
+func (s *Scrubber) Descriptor(ctx context.Context, dgst digest.Digest) (store.Descriptor, error) {
+	err := s.checkQuarantine(dgst)
+	if err != nil {
+		return store.Descriptor{}, err
+	}
+	return s.store.Descriptor(ctx, dgst)
+}
+
+func (s *Scrubber) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
+	err := s.checkQuarantine(dgst)
+	if err != nil {
+		return nil, err
+	}
+	return s.store.Open(ctx, dgst)
+}
+
+// ListImages lists the images of the scrubbed store if it is able to list them.
+func (s *Scrubber) ListImages(ctx context.Context) ([]oci.Image, error) {
+	lister, ok := s.store.(oci.ImageLister)
+	if !ok {
+		return nil, errors.New("scrubbed store does not list images")
+	}
+	return lister.ListImages(ctx)
+}
+
+func (s *Scrubber) Watch(ctx context.Context) ([]store.Event, <-chan store.Event, error) {
+	log := logr.FromContextOrDiscard(ctx)
+
+	ctx, cancel := context.WithCancel(ctx)
+	initial, storeEventCh, err := s.store.Watch(ctx)
+	if err != nil {
+		cancel()
+		return nil, nil, err
+	}
+	for _, event := range initial {
+		s.track(event)
+	}
+
+	// Verification is done separately so that hashing large blobs does not block events.
+	corruptedCh := make(chan digest.Digest)
+	restoredCh := make(chan digest.Digest)
+	go func() {
+		ticker := time.NewTicker(s.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				dgst, ok := s.next()
+				if !ok {
+					continue
+				}
+				corrupted, restored, err := s.verify(ctx, dgst)
+				if err != nil {
+					log.Error(err, "could not verify content", "digest", dgst)
+					continue
+				}
+				resultCh := corruptedCh
+				switch {
+				case corrupted:
+				case restored:
+					resultCh = restoredCh
+				default:
+					continue
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case resultCh <- dgst:
+				}
+			}
+		}
+	}()
+
+	eventCh := make(chan store.Event)
+	go func() {
+		defer close(eventCh)
+		defer cancel()
+
+		for {
+			var event store.Event
+			select {
+			case <-ctx.Done():
+				return
+			case storeEvent, ok := <-storeEventCh:
+				if !ok {
+					return
+				}
+				if !s.track(storeEvent) {
+					continue
+				}
+				event = storeEvent
+			case dgst := <-corruptedCh:
+				createEvent, ok := s.quarantine(dgst)
+				if !ok {
+					continue
+				}
+				log.Info("quarantined content which does not match its digest", "digest", dgst)
+				event = createEvent
+				event.Type = store.DeleteEvent
+			case dgst := <-restoredCh:
+				createEvent, ok := s.createEvent(dgst)
+				if !ok {
+					continue
+				}
+				log.Info("released quarantined content which has been verified", "digest", dgst)
+				// The original event is emitted again so that the content is advertised as it was created.
+				event = createEvent
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case eventCh <- event:
+			}
+		}
+	}()
+
+	return initial, eventCh, nil
+}
+
+// track updates the scrubbed content based on the store event, returning false if the event should not be emitted.
+func (s *Scrubber) track(event store.Event) bool {
+	if event.Digest == "" {
+		return true
+	}
+
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	_, quarantined := s.quarantined[event.Digest]
+	switch event.Type {
+	case store.CreateEvent:
+		s.created[event.Digest] = event
+		s.verifiedAt[event.Digest] = time.Time{}
+		// Content created again stays quarantined until it has been verified.
+		return !quarantined
+	case store.DeleteEvent:
+		delete(s.created, event.Digest)
+		delete(s.verifiedAt, event.Digest)
+		if quarantined {
+			delete(s.quarantined, event.Digest)
+			metrics.QuarantinedBlobs.Dec()
+		}
+	}
+	return true
+}
+
+// next returns the content which has gone the longest without being verified.
+func (s *Scrubber) next() (digest.Digest, bool) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	var next digest.Digest
+	var nextVerifiedAt time.Time
+	for dgst, verifiedAt := range s.verifiedAt {
+		if next != "" && !verifiedAt.Before(nextVerifiedAt) {
+			continue
+		}
+		next = dgst
+		nextVerifiedAt = verifiedAt
+	}
+	return next, next != ""
+}
+
+// verify hashes the content and returns true if it does not match the digest.
+// Restored is true when quarantined content has been verified and is released.
+func (s *Scrubber) verify(ctx context.Context, dgst digest.Digest) (corrupted bool, restored bool, err error) {
+	rc, err := s.store.Open(store.WithBackgroundRead(ctx), dgst)
+	if errors.Is(err, store.ErrNotFound) {
+		// Content removed before a delete event has been received is not corrupted.
+		s.markVerified(dgst)
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+	defer rc.Close()
+	verifier := dgst.Verifier()
+	_, err = io.Copy(verifier, rc)
+	if err != nil {
+		return false, false, err
+	}
+	if !verifier.Verified() {
+		metrics.ScrubbedBlobsTotal.WithLabelValues("corrupted").Inc()
+		return true, false, nil
+	}
+	metrics.ScrubbedBlobsTotal.WithLabelValues("verified").Inc()
+	s.markVerified(dgst)
+	return false, s.release(dgst), nil
+}
+
+func (s *Scrubber) markVerified(dgst digest.Digest) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if _, ok := s.verifiedAt[dgst]; !ok {
+		return
+	}
+	s.verifiedAt[dgst] = time.Now()
+}
+
+// quarantine stops the content from being served and returns the event it was created with.
+// False is returned if the content is no longer tracked or already quarantined.
+func (s *Scrubber) quarantine(dgst digest.Digest) (store.Event, bool) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if _, ok := s.verifiedAt[dgst]; !ok {
+		return store.Event{}, false
+	}
+	delete(s.verifiedAt, dgst)
+	if _, ok := s.quarantined[dgst]; ok {
+		return store.Event{}, false
+	}
+	s.quarantined[dgst] = struct{}{}
+	metrics.QuarantinedBlobs.Inc()
+	return s.created[dgst], true
+}
+
+// createEvent returns the latest event the tracked content was created with.
+func (s *Scrubber) createEvent(dgst digest.Digest) (store.Event, bool) {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	event, ok := s.created[dgst]
+	return event, ok
+}
+
+// release serves quarantined content again, returning false if the content was not quarantined.
+func (s *Scrubber) release(dgst digest.Digest) bool {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if _, ok := s.quarantined[dgst]; !ok {
+		return false
+	}
+	delete(s.quarantined, dgst)
+	metrics.QuarantinedBlobs.Dec()
+	return true
+}
+
+func (s *Scrubber) checkQuarantine(dgst digest.Digest) error {
+	s.mx.Lock()
+	defer s.mx.Unlock()
+
+	if _, ok := s.quarantined[dgst]; ok {
+		return errors.Join(store.ErrNotFound, fmt.Errorf("content with digest %s is quarantined", dgst))
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package scrub
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"testing"
+	"testing/synctest"
+	"time"
+
+	"github.com/go-openapi/testify/v2/require"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	"github.com/spegel-org/spegel/pkg/store"
+	"github.com/spegel-org/spegel/pkg/store/storetest"
+)
+
+// rottedStore returns altered content for the corrupted digests.
+type rottedStore struct {
+	*storetest.Provider
+	*storetest.Watcher
+	corrupted map[digest.Digest]struct{}
+}
+
+func (r *rottedStore) Open(ctx context.Context, dgst digest.Digest) (io.ReadSeekCloser, error) {
+	if _, ok := r.corrupted[dgst]; ok {
+		return struct {
+			io.ReadSeeker
+			io.Closer
+		}{
+			ReadSeeker: bytes.NewReader([]byte("bit rot")),
+			Closer:     io.NopCloser(nil),
+		}, nil
+	}
+	return r.Provider.Open(ctx, dgst)
+}
+
+func TestScrubberOptions(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewScrubber(&rottedStore{}, WithInterval(0))
+	require.EqualError(t, err, "scrub interval has to be greater than zero")
+	s, err := NewScrubber(&rottedStore{}, WithInterval(time.Minute))
+	require.NoError(t, err)
+	require.EqualT(t, time.Minute, s.interval)
+}
+
+func TestScrubber(t *testing.T) {
+	t.Parallel()
+
+	healthy := storetest.Content{MediaType: "application/octet-stream", Data: []byte("healthy")}
+	rotten := storetest.Content{MediaType: "application/octet-stream", Data: []byte("rotten")}
+
+	rottenCreated := store.Event{Type: store.CreateEvent, Digest: rotten.Digest(), MediaType: ocispec.MediaTypeImageManifest, Repository: "docker.io/library/foo"}
+	verified := func(scrubber *Scrubber, dgst digest.Digest) bool {
+		scrubber.mx.Lock()
+		defer scrubber.mx.Unlock()
+		return !scrubber.verifiedAt[dgst].IsZero()
+	}
+
+	synctest.Test(t, func(t *testing.T) {
+		s := &rottedStore{
+			Provider: storetest.NewProvider([]storetest.Content{healthy, rotten}, nil),
+			Watcher: storetest.NewWatcher([]store.Event{
+				{Type: store.CreateEvent, Digest: healthy.Digest()},
+				rottenCreated,
+			}),
+			corrupted: map[digest.Digest]struct{}{rotten.Digest(): {}},
+		}
+		scrubber, err := NewScrubber(s, WithInterval(time.Second))
+		require.NoError(t, err)
+
+		ctx, cancel := context.WithCancel(t.Context())
+		initial, eventCh, err := scrubber.Watch(ctx)
+		require.NoError(t, err)
+		require.Len(t, initial, 2)
+
+		// Corrupted content should be withdrawn and no longer served.
+		event := <-eventCh
+		require.Equal(t, store.Event{Type: store.DeleteEvent, Digest: rotten.Digest(), MediaType: rottenCreated.MediaType, Repository: rottenCreated.Repository}, event)
+		_, err = scrubber.Descriptor(t.Context(), rotten.Digest())
+		require.ErrorIs(t, err, store.ErrNotFound)
+		_, err = scrubber.Open(t.Context(), rotten.Digest())
+		require.ErrorIs(t, err, store.ErrNotFound)
+		time.Sleep(2 * time.Second)
+		synctest.Wait()
+		require.FalseT(t, verified(scrubber, rotten.Digest()))
+
+		// Healthy content should be verified and served.
+		require.TrueT(t, verified(scrubber, healthy.Digest()))
+		desc, err := scrubber.Descriptor(t.Context(), healthy.Digest())
+		require.NoError(t, err)
+		require.EqualT(t, healthy.Descriptor(), desc)
+
+		// Content created again should stay quarantined while it is still corrupted.
+		go s.Add(t.Context(), rottenCreated)
+		synctest.Wait()
+		_, err = scrubber.Descriptor(t.Context(), rotten.Digest())
+		require.ErrorIs(t, err, store.ErrNotFound)
+		time.Sleep(2 * time.Second)
+		synctest.Wait()
+		_, err = scrubber.Descriptor(t.Context(), rotten.Digest())
+		require.ErrorIs(t, err, store.ErrNotFound)
+
+		// Content created again should only be served once verified.
+		delete(s.corrupted, rotten.Digest())
+		go s.Add(t.Context(), rottenCreated)
+		synctest.Wait()
+		_, err = scrubber.Descriptor(t.Context(), rotten.Digest())
+		require.ErrorIs(t, err, store.ErrNotFound)
+		// The original event is emitted so that the manifest is advertised again.
+		event = <-eventCh
+		require.Equal(t, rottenCreated, event)
+		_, err = scrubber.Descriptor(t.Context(), rotten.Digest())
+		require.NoError(t, err)
+		require.TrueT(t, verified(scrubber, rotten.Digest()))
+
+		cancel()
+		_, ok := <-eventCh
+		require.FalseT(t, ok)
+	})
+}
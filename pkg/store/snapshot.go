@@ -0,0 +1,50 @@
+package store
+
+import (
+	"github.com/opencontainers/go-digest"
+)
+
+// Snapshot represents the tags and content available in a store at a point in time.
+// It is used by stores without native events to compute changes between scans.
+type Snapshot struct {
+	Tags    map[string]digest.Digest
+	Content map[digest.Digest]Event
+}
+
+func NewSnapshot() Snapshot {
+	return Snapshot{
+		Tags:    map[string]digest.Digest{},
+		Content: map[digest.Digest]Event{},
+	}
+}
+
+// DiffSnapshots returns the events required to go from the previous to the current snapshot.
+// Content is created before the tags referencing it and tags are deleted before their content.
+func DiffSnapshots(prev, curr Snapshot) []Event {
+	events := []Event{}
+	for dgst, event := range curr.Content {
+		if _, ok := prev.Content[dgst]; ok {
+			continue
+		}
+		events = append(events, event)
+	}
+	for tagName, dgst := range curr.Tags {
+		if prevDgst, ok := prev.Tags[tagName]; ok && prevDgst == dgst {
+			continue
+		}
+		events = append(events, Event{Type: CreateEvent, Reference: tagName})
+	}
+	for tagName := range prev.Tags {
+		if _, ok := curr.Tags[tagName]; ok {
+			continue
+		}
+		events = append(events, Event{Type: DeleteEvent, Reference: tagName})
+	}
+	for dgst, event := range prev.Content {
+		if _, ok := curr.Content[dgst]; ok {
+			continue
+		}
+		events = append(events, Event{Type: DeleteEvent, Digest: dgst, MediaType: event.MediaType})
+	}
+	return events
+}
@@ -56,6 +56,15 @@ type Event struct {
 
 	// Digest identifies the content by its digest.
 	Digest digest.Digest
+
+	// MediaType describes the format of the content if known.
+	MediaType string
+
+	// Size is the size of the content if known.
+	Size int64
+
+	// Repository is the name of the repository including the registry the content belongs to if known.
+	Repository string
 }
 
 // Watcher watches for changes to the store.
@@ -63,3 +72,22 @@ type Watcher interface {
 	// Watch returns events representing changes in the store.
 	Watch(ctx context.Context) ([]Event, <-chan Event, error)
 }
+
+// ReadinessChecker reports if the store is ready to serve content.
+type ReadinessChecker interface {
+	// Ready returns true when the store is ready.
+	Ready(ctx context.Context) (bool, error)
+}
+
+type backgroundReadKey struct{}
+
+// WithBackgroundRead marks reads which are not served to clients, such as verification, so that stores can skip protecting the content while it is read.
+func WithBackgroundRead(ctx context.Context) context.Context {
+	return context.WithValue(ctx, backgroundReadKey{}, true)
+}
+
+// IsBackgroundRead returns true if the read is not served to clients.
+func IsBackgroundRead(ctx context.Context) bool {
+	ok, _ := ctx.Value(backgroundReadKey{}).(bool)
+	return ok
+}
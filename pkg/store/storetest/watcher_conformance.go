@@ -0,0 +1,139 @@
+package storetest
+
+import (
+	"context"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/go-openapi/testify/v2/require"
+	"github.com/opencontainers/go-digest"
+
+	"github.com/spegel-org/spegel/pkg/store"
+)
+
+const watcherConformanceTimeout = 5 * time.Second
+
+type WatcherConfig struct {
+	// CreateImage adds a new image to the store and returns its manifest digest.
+	// The image is tagged with the reference unless it is empty.
+	CreateImage func(t *testing.T, ref string) digest.Digest
+	// DeleteImage removes the image with the reference and manifest digest from the store.
+	DeleteImage func(t *testing.T, ref string, dgst digest.Digest)
+}
+
+// WatcherConformance drives the store through changes and asserts the events match what routing expects.
+// Content has to be created before any tag referencing it and tags have to be deleted before their content.
+func WatcherConformance(t *testing.T, watcher store.Watcher, cfg WatcherConfig) {
+	t.Helper()
+
+	require.NotNil(t, cfg.CreateImage)
+	require.NotNil(t, cfg.DeleteImage)
+
+	ctx, cancel := context.WithCancel(t.Context())
+	defer cancel()
+
+	// Content existing before watching should be part of the initial events.
+	initialRef := "docker.io/library/initial:1.0"
+	initialDgst := cfg.CreateImage(t, initialRef)
+	initial, eventCh, err := watcher.Watch(ctx)
+	require.NoError(t, err)
+	for _, event := range initial {
+		require.EqualT(t, store.CreateEvent, event.Type)
+	}
+	requireImageEvents(t, initial, store.CreateEvent, initialRef, initialDgst)
+
+	taggedRef := "docker.io/library/tagged:1.0"
+	taggedDgst := cfg.CreateImage(t, taggedRef)
+	events := receiveImageEvents(t, eventCh, store.CreateEvent, taggedRef, taggedDgst)
+	requireImageEvents(t, events, store.CreateEvent, taggedRef, taggedDgst)
+
+	untaggedDgst := cfg.CreateImage(t, "")
+	events = receiveImageEvents(t, eventCh, store.CreateEvent, "", untaggedDgst)
+	requireImageEvents(t, events, store.CreateEvent, "", untaggedDgst)
+
+	cfg.DeleteImage(t, taggedRef, taggedDgst)
+	events = receiveImageEvents(t, eventCh, store.DeleteEvent, taggedRef, taggedDgst)
+	requireImageEvents(t, events, store.DeleteEvent, taggedRef, taggedDgst)
+
+	cfg.DeleteImage(t, "", untaggedDgst)
+	events = receiveImageEvents(t, eventCh, store.DeleteEvent, "", untaggedDgst)
+	requireImageEvents(t, events, store.DeleteEvent, "", untaggedDgst)
+
+	// The event channel has to be closed once the context is cancelled.
+	cancel()
+	timeoutCh := time.After(watcherConformanceTimeout)
+	for {
+		select {
+		case _, ok := <-eventCh:
+			if !ok {
+				return
+			}
+		case <-timeoutCh:
+			t.Fatal("timed out waiting for event channel to close")
+		}
+	}
+}
+
+// receiveImageEvents receives events until the reference and digest have been observed.
+func receiveImageEvents(t *testing.T, eventCh <-chan store.Event, eventType store.EventType, ref string, dgst digest.Digest) []store.Event {
+	t.Helper()
+
+	events := []store.Event{}
+	timeoutCh := time.After(watcherConformanceTimeout)
+	for {
+		refIdx, dgstIdx := imageEventIndexes(events, eventType, ref, dgst)
+		if (ref == "" || refIdx >= 0) && dgstIdx >= 0 {
+			return events
+		}
+		select {
+		case event, ok := <-eventCh:
+			require.TrueT(t, ok, "event channel closed before receiving events")
+			events = append(events, event)
+		case <-timeoutCh:
+			t.Fatalf("timed out waiting for %s events for reference %q and digest %s, received %v", eventType, ref, dgst, events)
+		}
+	}
+}
+
+func requireImageEvents(t *testing.T, events []store.Event, eventType store.EventType, ref string, dgst digest.Digest) {
+	t.Helper()
+
+	// Events may include other content of the image which is not asserted.
+	for _, event := range events {
+		require.TrueT(t, event.Reference != "" || event.Digest != "", "event needs a reference or digest")
+		if event.Digest == dgst && event.Reference != "" {
+			require.EqualT(t, ref, event.Reference, "digest paired with wrong reference")
+		}
+		if ref != "" && event.Reference == ref && event.Digest != "" {
+			require.EqualT(t, dgst, event.Digest, "reference paired with wrong digest")
+		}
+	}
+
+	refIdx, dgstIdx := imageEventIndexes(events, eventType, ref, dgst)
+	require.GreaterOrEqual(t, dgstIdx, 0, "missing event for digest %s", dgst)
+	if ref == "" {
+		return
+	}
+	require.GreaterOrEqual(t, refIdx, 0, "missing event for reference %s", ref)
+	switch eventType {
+	case store.CreateEvent:
+		require.LessOrEqual(t, dgstIdx, refIdx, "digest %s has to be created before reference %s", dgst, ref)
+	case store.DeleteEvent:
+		require.LessOrEqual(t, refIdx, dgstIdx, "reference %s has to be deleted before digest %s", ref, dgst)
+	}
+}
+
+// imageEventIndexes returns the index of the first event for the reference and digest, or -1 if missing.
+func imageEventIndexes(events []store.Event, eventType store.EventType, ref string, dgst digest.Digest) (int, int) {
+	refIdx := -1
+	if ref != "" {
+		refIdx = slices.IndexFunc(events, func(event store.Event) bool {
+			return event.Type == eventType && event.Reference == ref
+		})
+	}
+	dgstIdx := slices.IndexFunc(events, func(event store.Event) bool {
+		return event.Type == eventType && event.Digest == dgst
+	})
+	return refIdx, dgstIdx
+}
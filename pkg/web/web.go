@@ -26,6 +26,7 @@ var templatesFS embed.FS
 
 type WebConfig struct {
 	OCIClient *oci.Client
+	Aliases   oci.RegistryAliases
 }
 
 type WebOption = option.Option[WebConfig]
@@ -37,10 +38,19 @@ func WithOCIClient(ociClient *oci.Client) WebOption {
 	}
 }
 
+// WithRegistryAliases measures images under their canonical registry name.
+func WithRegistryAliases(aliases oci.RegistryAliases) WebOption {
+	return func(cfg *WebConfig) error {
+		cfg.Aliases = aliases
+		return nil
+	}
+}
+
 type Web struct {
 	mirror    *url.URL
 	router    *libp2p.Router
 	ociClient *oci.Client
+	aliases   oci.RegistryAliases
 	imgLister oci.ImageLister
 	tmpls     *template.Template
 	reg       *registry.Registry
@@ -72,6 +82,7 @@ func NewWeb(router *libp2p.Router, imgLister oci.ImageLister, reg *registry.Regi
 	return &Web{
 		router:    router,
 		ociClient: cfg.OCIClient,
+		aliases:   cfg.Aliases,
 		imgLister: imgLister,
 		tmpls:     tmpls,
 		reg:       reg,
@@ -93,7 +104,8 @@ func (w *Web) indexHandler(rw httpx.ResponseWriter, req *http.Request) {
 }
 
 type LibP2P struct {
-	ID string `json:"id"`
+	ID      string `json:"id"`
+	Network string `json:"network,omitempty"`
 }
 
 type Metadata struct {
@@ -103,7 +115,8 @@ type Metadata struct {
 func (w *Web) metaDataHandler(rw httpx.ResponseWriter, req *http.Request) {
 	data := Metadata{
 		LibP2P{
-			ID: w.router.Host().ID().String(),
+			ID:      w.router.Host().ID().String(),
+			Network: w.router.Network(),
 		},
 	}
 	b, err := json.Marshal(&data)
@@ -120,6 +133,7 @@ func (w *Web) metaDataHandler(rw httpx.ResponseWriter, req *http.Request) {
 }
 
 type statsData struct {
+	Network           string
 	LocalAddresses    []netip.Addr
 	Images            []oci.Image
 	Peers             []routing.Peer
@@ -127,7 +141,9 @@ type statsData struct {
 }
 
 func (w *Web) statsHandler(rw httpx.ResponseWriter, req *http.Request) {
-	data := statsData{}
+	data := statsData{
+		Network: w.router.Network(),
+	}
 
 	imgs, err := w.imgLister.ListImages(req.Context())
 	if err != nil {
@@ -180,7 +196,7 @@ func (w *Web) measureHandler(rw httpx.ResponseWriter, req *http.Request) {
 		rw.WriteError(http.StatusBadRequest, NewHTMLResponseError(errors.New("image name cannot be empty")))
 		return
 	}
-	img, err := oci.ParseImage(imgName, oci.AllowDefaults(), oci.AllowTagOnly())
+	img, err := oci.ParseImage(imgName, oci.AllowDefaults(), oci.AllowTagOnly(), oci.WithRegistryAliases(w.aliases))
 	if err != nil {
 		rw.WriteError(http.StatusBadRequest, NewHTMLResponseError(err))
 		return
@@ -56,6 +56,7 @@ func TestWeb(t *testing.T) {
 	require.EqualT(t, http.StatusOK, resp.StatusCode)
 
 	stats := statsData{
+		Network:           "tenant-a",
 		LocalAddresses:    []netip.Addr{{}},
 		Images:            []oci.Image{{}},
 		Peers:             []routing.Peer{{}},